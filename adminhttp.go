@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// adminTLSConfig returns the TLS configuration of the admin and metrics
+// listeners, nil if they serve plain HTTP.
+func adminTLSConfig(o *options) (*tls.Config, error) {
+	if o.adminTLSCert == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(o.adminTLSCert, o.adminTLSKey)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if o.adminClientCA != "" {
+		pem, err := ioutil.ReadFile(o.adminClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("admin-client-ca: no certificate found in " + o.adminClientCA)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		// peers of a cluster are verified with the same CA and present the same certificate
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+func listenAndServeAdmin(addr string, handler http.Handler, config *tls.Config) error {
+	srv := &http.Server{Addr: addr, Handler: handler, TLSConfig: config, ReadHeaderTimeout: 10 * time.Second}
+	if config == nil {
+		return srv.ListenAndServe()
+	}
+	return srv.ListenAndServeTLS("", "")
+}
+
+// listenAndServeHTTP serves the files over HTTP on addr (-http-addr). The
+// clients slow to send their requests or idle are dropped, the responses
+// have no deadline: a large image takes long on a slow link.
+func listenAndServeHTTP(addr string, handler http.Handler) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	return srv.ListenAndServe()
+}
+
+// peerClient returns the HTTP client cluster peers are reached with, it
+// presents the admin certificate when mutual TLS is enabled.
+func peerClient(config *tls.Config) *http.Client {
+	client := &http.Client{Timeout: 30 * time.Second}
+	if config != nil {
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{
+			Certificates: config.Certificates,
+			RootCAs:      config.RootCAs,
+			MinVersion:   tls.VersionTLS12,
+		}}
+	}
+	return client
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// checkCommand implements "tftp-server check": validate the configuration
+// (config file and flags) the server would start with, without starting it.
+func checkCommand(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	o := defineFlags(fs)
+	fs.Parse(args)
+
+	errs := o.configure(fs)
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "%d problem(s) found\n", len(errs))
+		os.Exit(1)
+	}
+	fmt.Println("configuration OK")
+}
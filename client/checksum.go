@@ -0,0 +1,75 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// ErrChecksum is the error of the downloads whose content doesn't match the expected Checksum.
+var ErrChecksum = errors.New("tftp: checksum mismatch")
+
+// Checksum is the expected digest of a download.
+type Checksum struct {
+	Algorithm string // "sha256" or "md5"
+	Sum       []byte
+}
+
+// ParseChecksum parses an algorithm:hex checksum, like sha256:9f86d0...
+func ParseChecksum(s string) (Checksum, error) {
+	algorithm, digest, ok := strings.Cut(s, ":")
+	if !ok {
+		return Checksum{}, fmt.Errorf("checksum %q is not algorithm:hex", s)
+	}
+	sum, err := hex.DecodeString(digest)
+	if err != nil {
+		return Checksum{}, fmt.Errorf("checksum %q: %w", s, err)
+	}
+	c := Checksum{Algorithm: strings.ToLower(algorithm), Sum: sum}
+	h, err := c.hash()
+	if err != nil {
+		return Checksum{}, err
+	}
+	if len(sum) != h.Size() {
+		return Checksum{}, fmt.Errorf("checksum %q: a %s digest is %d bytes long", s, c.Algorithm, h.Size())
+	}
+	return c, nil
+}
+
+func (c Checksum) String() string {
+	return c.Algorithm + ":" + hex.EncodeToString(c.Sum)
+}
+
+func (c Checksum) hash() (hash.Hash, error) {
+	switch c.Algorithm {
+	case "sha256":
+		return sha256.New(), nil
+	case "md5":
+		return md5.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm %q", c.Algorithm)
+}
+
+// GetVerified is Get hashing the content as it is written to w, it fails
+// with an error wrapping ErrChecksum if it doesn't match want. The content
+// is in w by then: callers discard it.
+func (c *Client) GetVerified(ctx context.Context, addr, filename string, w io.Writer, want Checksum) error {
+	h, err := want.hash()
+	if err != nil {
+		return err
+	}
+	if err := c.get(ctx, addr, filename, w, h); err != nil {
+		return err
+	}
+	if got := h.Sum(nil); !bytes.Equal(got, want.Sum) {
+		return fmt.Errorf("get %s: %w: got %s:%x, expected %s", filename, ErrChecksum, want.Algorithm, got, want)
+	}
+	return nil
+}
@@ -0,0 +1,616 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+// Client transfers files from and to TFTP servers. The zero value is ready to use.
+type Client struct {
+	// Timeout is how long to wait for a datagram before retransmitting, 5s if 0.
+	Timeout time.Duration
+
+	// Retries is the number of transmissions of a datagram before giving up, 10 if 0.
+	Retries int
+
+	// Backoff multiplies the timeout at each retransmission of a datagram
+	// (no backoff if 0 or 1), up to MaxTimeout if it is not 0. Lossy links
+	// do better with a short Timeout and a backoff than with a long Timeout.
+	Backoff    float64
+	MaxTimeout time.Duration
+
+	// LocalAddr, if set, is the local address (host:port) the transfers are
+	// sent from, for multihomed hosts and firewall pinholes. A transfer keeps
+	// its source port (its TID) across retransmissions; with a fixed port
+	// the transfers of the Client can't run concurrently, and Get dallies
+	// before returning so that the next transfer can bind the port.
+	LocalAddr string
+
+	// Concurrency is the maximum number of transfers of GetAll at once, 4 if 0.
+	Concurrency int
+
+	// Proxy, if set, is the URL of a SOCKS5 proxy relaying the transfers
+	// (UDP ASSOCIATE), socks5://[user:password@]host:port, to reach servers
+	// behind a jump host. LocalAddr is then the address of the datagrams
+	// sent to the proxy.
+	Proxy string
+
+	// Mode is the transfer mode: "octet" (the default) transfers the content
+	// as is, "netascii" translates the LF line endings of the local content
+	// to CR LF on the wire and back.
+	Mode string
+
+	// BlockSize, if not 0, is requested from the server with the RFC 2348
+	// blksize option (8 to 65464 bytes). Servers without option support
+	// transfer blocks of 512 bytes.
+	BlockSize int
+
+	// WindowSize, if more than 1, is requested from the server with the
+	// RFC 7440 windowsize option (up to 65535 blocks): downloads are then
+	// acknowledged once per window of blocks instead of every block.
+	WindowSize int
+
+	// Progress, if set, is called after every block.
+	Progress func(Progress)
+}
+
+// Progress describes the state of a transfer.
+type Progress struct {
+	Filename string
+	Bytes    int64 // transferred so far (in netascii mode, as on the wire)
+	Blocks   int
+	Size     int64 // of the file, -1 if unknown
+	Elapsed  time.Duration
+
+	// Retransmits counts the datagrams sent again after a timeout.
+	Retransmits int
+
+	// Negotiated tells whether the server acknowledged the options of the
+	// request (RFC 2347 OACK). Servers without option support transfer
+	// with the defaults, 512 bytes blocks, and no size announcement.
+	Negotiated bool
+	BlockSize  int
+}
+
+// Rate returns the average transfer rate in bytes per second.
+func (p Progress) Rate() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Bytes) / p.Elapsed.Seconds()
+}
+
+// ETA returns the estimated time left, ok is false if the size isn't known.
+func (p Progress) ETA() (eta time.Duration, ok bool) {
+	rate := p.Rate()
+	if p.Size < 0 || rate == 0 {
+		return 0, false
+	}
+	left := p.Size - p.Bytes
+	if left < 0 {
+		left = 0
+	}
+	return time.Duration(float64(left) / rate * float64(time.Second)), true
+}
+
+const (
+	defaultTimeout = 5 * time.Second
+	defaultRetries = 10
+)
+
+var errTimeout = errors.New("tftp: no answer from the server")
+
+const (
+	minBlockSize = 8
+	maxBlockSize = 65464
+)
+
+func (c *Client) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return defaultTimeout
+}
+
+// mode returns the canonical name of the transfer mode, empty if it isn't supported.
+func (c *Client) mode() string {
+	switch strings.ToLower(c.Mode) {
+	case "", "octet":
+		return "octet"
+	case "netascii":
+		return "netascii"
+	}
+	return ""
+}
+
+// attemptTimeout returns the timeout of the transmission attempt (0 for the
+// first one) of a datagram.
+func (c *Client) attemptTimeout(attempt int) time.Duration {
+	timeout := c.timeout()
+	for i := 0; i < attempt && c.Backoff > 1; i++ {
+		timeout = time.Duration(float64(timeout) * c.Backoff)
+		if c.MaxTimeout > 0 && timeout >= c.MaxTimeout {
+			break
+		}
+	}
+	if c.MaxTimeout > 0 && timeout > c.MaxTimeout {
+		timeout = c.MaxTimeout
+	}
+	return timeout
+}
+
+// fixedPort tells whether the transfers are sent from a fixed LocalAddr port.
+func (c *Client) fixedPort() bool {
+	if c.LocalAddr == "" || c.Proxy != "" {
+		return false
+	}
+	addr, err := net.ResolveUDPAddr("udp", c.LocalAddr)
+	return err == nil && addr.Port != 0
+}
+
+func (c *Client) retries() int {
+	if c.Retries > 0 {
+		return c.Retries
+	}
+	return defaultRetries
+}
+
+// transfer is the state of a transfer: the socket of the client and the
+// address (TID) of the server once it has answered.
+type transfer struct {
+	c      *Client
+	ctx    context.Context
+	conn   net.PacketConn
+	server *net.UDPAddr // the request is sent there, then the server TID replaces it
+	locked bool         // whether server is the TID of the server
+	buf    []byte
+
+	blockSize int  // negotiated block size
+	plain     bool // the server refused the options, the request has none
+
+	windowSize    int  // negotiated window size (RFC 7440)
+	retransmitted bool // whether the last await retransmitted its packet
+
+	progress Progress
+	started  time.Time
+
+	done chan struct{} // closed with the transfer
+}
+
+func (c *Client) start(ctx context.Context, addr string) (*transfer, error) {
+	if c.mode() == "" {
+		return nil, fmt.Errorf("tftp: unsupported mode %q", c.Mode)
+	}
+	if c.WindowSize < 0 || c.WindowSize > 65535 {
+		return nil, fmt.Errorf("tftp: window size %d out of the 1-65535 range", c.WindowSize)
+	}
+	if c.BlockSize != 0 && (c.BlockSize < minBlockSize || c.BlockSize > maxBlockSize) {
+		return nil, fmt.Errorf("tftp: block size %d out of the %d-%d range", c.BlockSize, minBlockSize, maxBlockSize)
+	}
+	if c.Backoff < 0 || (c.Backoff > 0 && c.Backoff < 1) {
+		return nil, fmt.Errorf("tftp: backoff %g is less than 1", c.Backoff)
+	}
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	network := "udp4"
+	if raddr.IP.To4() == nil {
+		network = "udp6"
+	}
+	var conn net.PacketConn
+	if c.Proxy != "" {
+		conn, err = dialSOCKS5(ctx, c.Proxy, c.LocalAddr)
+		if err != nil {
+			return nil, err
+		}
+	} else if conn, err = c.listen(network, addr); err != nil {
+		return nil, err
+	}
+	size := server.BlockSize
+	if c.BlockSize > size {
+		size = c.BlockSize
+	}
+	t := &transfer{
+		c:          c,
+		ctx:        ctx,
+		conn:       conn,
+		server:     raddr,
+		buf:        make([]byte, 4+size),
+		blockSize:  server.BlockSize,
+		windowSize: 1,
+		progress:   Progress{Size: -1, BlockSize: server.BlockSize},
+		started:    time.Now(),
+		done:       make(chan struct{}),
+	}
+	go t.watch()
+	return t, nil
+}
+
+// listen opens the socket of a transfer with the server addr, of network
+// "udp4" or "udp6", on LocalAddr.
+func (c *Client) listen(network, addr string) (net.PacketConn, error) {
+	local := ":0"
+	if c.LocalAddr != "" {
+		laddr, err := net.ResolveUDPAddr("udp", c.LocalAddr)
+		if err != nil {
+			return nil, err
+		}
+		if laddr.IP != nil && (laddr.IP.To4() == nil) != (network == "udp6") {
+			return nil, fmt.Errorf("tftp: local address %s and server %s aren't of the same IP version", c.LocalAddr, addr)
+		}
+		local = laddr.String()
+	}
+	return net.ListenPacket(network, local)
+}
+
+// watch interrupts the reads of the transfer when its context is done, so
+// that cancellations and deadlines take effect without waiting for a timeout.
+func (t *transfer) watch() {
+	select {
+	case <-t.ctx.Done():
+		t.conn.SetReadDeadline(time.Now())
+	case <-t.done:
+	}
+}
+
+// cancelled tells the server the transfer is given up because its context
+// is done, and returns the error of the context.
+func (t *transfer) cancelled() error {
+	if t.locked {
+		t.abort(server.ErrUnknown, "transfer cancelled")
+	}
+	return t.ctx.Err()
+}
+
+// transferred counts a block of n bytes and reports it to the Progress callback.
+func (t *transfer) transferred(n int) {
+	t.progress.Bytes += int64(n)
+	t.progress.Blocks++
+	if t.c.Progress == nil {
+		return
+	}
+	t.progress.Elapsed = time.Since(t.started)
+	t.c.Progress(t.progress)
+}
+
+// request returns the RRQ or WRQ of filename with the options of the
+// client added to options, or without options if the server refused them.
+func (t *transfer) request(op server.Opcode, filename string, options map[string]string) ([]byte, error) {
+	if t.plain {
+		options = nil
+	} else {
+		if options == nil {
+			options = make(map[string]string)
+		}
+		if t.c.BlockSize != 0 {
+			options["blksize"] = strconv.Itoa(t.c.BlockSize)
+		}
+		if t.c.WindowSize > 1 && op == server.ReadOp {
+			options["windowsize"] = strconv.Itoa(t.c.WindowSize)
+		}
+	}
+	return server.ReadWriteRequest{Op: op, Filename: filename, Mode: t.c.mode(), Options: options}.MarshalBinary()
+}
+
+// optionsRefused tells whether err is the answer of a server refusing the
+// options of the request (RFC 2347), which is then sent again without them.
+func (t *transfer) optionsRefused(err error) bool {
+	var e server.Err
+	if t.locked || t.plain || !errors.As(err, &e) || e.Code != server.ErrOptions {
+		return false
+	}
+	t.plain = true
+	return true
+}
+
+// negotiated applies the options accepted by the server in the OACK b, the
+// transfer is aborted if the server answered an option it wasn't asked or
+// with a value out of bounds.
+func (t *transfer) negotiated(b []byte) error {
+	var oack server.OptionAcknowledgment
+	if err := oack.UnmarshalBinary(b); err != nil {
+		t.abort(server.ErrOptions, err.Error())
+		return err
+	}
+	for name, value := range oack.Options {
+		switch name {
+		case "blksize":
+			n, err := strconv.Atoi(value)
+			if t.c.BlockSize == 0 || err != nil || n < minBlockSize || n > t.c.BlockSize {
+				t.abort(server.ErrOptions, "invalid blksize")
+				return fmt.Errorf("tftp: server answered blksize %q", value)
+			}
+			t.blockSize, t.progress.BlockSize = n, n
+		case "windowsize":
+			n, err := strconv.Atoi(value)
+			if t.c.WindowSize <= 1 || err != nil || n < 1 || n > t.c.WindowSize {
+				t.abort(server.ErrOptions, "invalid windowsize")
+				return fmt.Errorf("tftp: server answered windowsize %q", value)
+			}
+			t.windowSize = n
+		case "tsize":
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil && n >= 0 {
+				t.progress.Size = n
+			}
+		default:
+			t.abort(server.ErrOptions, "unexpected option "+name)
+			return fmt.Errorf("tftp: server answered the unrequested option %q", name)
+		}
+	}
+	t.progress.Negotiated = true
+	return nil
+}
+
+// exchange sends packet, retransmitting it on timeouts, until a datagram
+// accepted by want is received from the server. The first datagram answered
+// to the request fixes the server TID, see fromServer.
+func (t *transfer) exchange(packet []byte, want func(b []byte) bool) ([]byte, error) {
+	return t.await(packet, true, want)
+}
+
+// await is exchange, packet is only sent on timeouts unless send is set.
+// It records whether packet was retransmitted.
+func (t *transfer) await(packet []byte, send bool, want func(b []byte) bool) ([]byte, error) {
+	t.retransmitted = false
+	for i := 0; i < t.c.retries(); i++ {
+		if send || i > 0 {
+			if _, err := t.conn.WriteTo(packet, t.server); err != nil {
+				return nil, err
+			}
+			if i > 0 {
+				t.retransmitted = true
+				t.progress.Retransmits++
+			}
+		}
+		t.conn.SetReadDeadline(time.Now().Add(t.c.attemptTimeout(i)))
+		// checked after setting the deadline, which watch may have set first
+		if t.ctx.Err() != nil {
+			return nil, t.cancelled()
+		}
+		for {
+			n, from, err := t.conn.ReadFrom(t.buf)
+			if err != nil {
+				if t.ctx.Err() != nil {
+					return nil, t.cancelled()
+				}
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			addr, ok := from.(*net.UDPAddr)
+			b := t.buf[:n]
+			if !ok || !t.fromServer(addr, b) || n < 4 {
+				continue
+			}
+			if server.Opcode(binary.BigEndian.Uint16(b)) == server.ErrorOp {
+				var e server.Err
+				if e.UnmarshalBinary(b) != nil {
+					continue
+				}
+				return nil, e
+			}
+			if !want(b) {
+				continue
+			}
+			if !t.locked {
+				t.server, t.locked = addr, true
+			}
+			return b, nil
+		}
+	}
+	return nil, errTimeout
+}
+
+// fromServer tells whether the datagram b from addr comes from the server:
+// from the address of the request until the server has answered, then from
+// the TID of the server only (RFC 1350 section 4). Other datagrams, off-path
+// interference or late answers of a previous transfer, are answered with
+// an unknown transfer ID error, unless they are errors themselves so that
+// two peers never bounce errors at each other.
+func (t *transfer) fromServer(addr *net.UDPAddr, b []byte) bool {
+	if addr.IP.Equal(t.server.IP) && (!t.locked || addr.Port == t.server.Port) {
+		return true
+	}
+	if len(b) < 2 || server.Opcode(binary.BigEndian.Uint16(b)) != server.ErrorOp {
+		e, _ := server.Err{Code: server.ErrUnknownID, Message: "unknown transfer ID"}.MarshalBinary()
+		t.conn.WriteTo(e, addr)
+	}
+	return false
+}
+
+// abort tells the server the transfer is given up.
+func (t *transfer) abort(code server.ErrCode, message string) {
+	b, _ := server.Err{Code: code, Message: message}.MarshalBinary()
+	t.conn.WriteTo(b, t.server)
+}
+
+func (t *transfer) close() error {
+	close(t.done)
+	return t.conn.Close()
+}
+
+// dally keeps the transfer open for a timeout after the final ACK was sent
+// and repeats it if the final block is retransmitted, in case the ACK was
+// lost (RFC 1350 section 6). It closes the transfer.
+func (t *transfer) dally(ack []byte, final uint16) {
+	defer t.close()
+	t.conn.SetReadDeadline(time.Now().Add(t.c.timeout()))
+	for {
+		n, from, err := t.conn.ReadFrom(t.buf)
+		if err != nil {
+			return
+		}
+		b := t.buf[:n]
+		addr, ok := from.(*net.UDPAddr)
+		if !ok || !t.fromServer(addr, b) || n < 4 {
+			continue
+		}
+		if server.Opcode(binary.BigEndian.Uint16(b)) == server.DataOp && binary.BigEndian.Uint16(b[2:4]) == final {
+			t.conn.WriteTo(ack, t.server)
+		}
+	}
+}
+
+// Get downloads filename from the TFTP server at addr (host:port) to w. It
+// gives up, telling the server, as soon as ctx is done. The blocks are
+// written to w as they arrive, the file is never held in memory.
+//
+// In octet mode, Get asks the server for the size of the file (RFC 2349
+// tsize option), the server may ignore it or refuse it (the request is
+// repeated without options then). If it is answered, the download fails if
+// it doesn't have that size and an *os.File w is sized (truncated or
+// extended) and allocated up front from its current offset. The size isn't
+// asked in netascii mode, it would differ from the size on the wire.
+// It returns once the final block is acknowledged, the transfer dallies in
+// the background to acknowledge it again if needed (before returning with a
+// fixed LocalAddr port).
+func (c *Client) Get(ctx context.Context, addr, filename string, w io.Writer) error {
+	return c.get(ctx, addr, filename, w, nil)
+}
+
+// get is Get, the local content is also written to h if it is not nil.
+func (c *Client) get(ctx context.Context, addr, filename string, w io.Writer, h hash.Hash) error {
+	t, err := c.start(ctx, addr)
+	if err != nil {
+		return err
+	}
+	dallying := false
+	defer func() {
+		if !dallying {
+			t.close()
+		}
+	}()
+
+	t.progress.Filename = filename
+	file, _ := w.(*os.File)
+	if h != nil {
+		w = io.MultiWriter(w, h)
+	}
+	options := map[string]string{"tsize": "0"}
+	var netascii *netasciiDecoder
+	if c.mode() == "netascii" {
+		file, options = nil, nil // the local size isn't known
+		netascii = &netasciiDecoder{w: w}
+		w = netascii
+	}
+	packet, err := t.request(server.ReadOp, filename, options)
+	if err != nil {
+		return err
+	}
+	var (
+		block    uint16 // last block received
+		received int    // blocks received since the last ACK
+		send     = true // whether packet is sent before waiting
+		gapAcked bool   // whether a gap in the window was signalled
+		rollover = -1   // the block following 65535, 0 or 1 depending on the server, -1 until seen
+		ack      [4]byte
+	)
+	for {
+		next := block + 1
+		if block == server.MaxBlocks && rollover == 1 {
+			next = 1
+		}
+		expected := func(n uint16) bool {
+			return n == next || block == server.MaxBlocks && rollover < 0 && n == 1
+		}
+		b, err := t.await(packet, send, func(b []byte) bool {
+			switch server.Opcode(binary.BigEndian.Uint16(b)) {
+			case server.DataOp:
+			case server.OptionAckOp:
+				return !t.locked
+			default:
+				return false
+			}
+			// Duplicates of received blocks are dropped, not acknowledged
+			// again: each extra ACK could make a server without the RFC 1123
+			// fix send the next block twice, and so on for the rest of the
+			// transfer (the Sorcerer's Apprentice syndrome). A lost ACK is
+			// retransmitted on timeout instead.
+			n := binary.BigEndian.Uint16(b[2:4])
+			if !expected(n) && t.windowSize > 1 && n-next < 0x8000 && !gapAcked {
+				// a block of the window was lost: have the server send the
+				// window again from it right away, once (RFC 7440 section 4)
+				t.conn.WriteTo(packet, t.server)
+				gapAcked, received = true, 0
+			}
+			return expected(n)
+		})
+		if t.retransmitted {
+			received = 0
+		}
+		if err != nil {
+			if t.optionsRefused(err) {
+				packet, _ = t.request(server.ReadOp, filename, nil)
+				continue
+			}
+			return fmt.Errorf("get %s: block %d: %w", filename, next, err)
+		}
+		if server.Opcode(binary.BigEndian.Uint16(b)) == server.OptionAckOp {
+			if err := t.negotiated(b); err != nil {
+				return fmt.Errorf("get %s: %w", filename, err)
+			}
+			if file != nil && t.progress.Size >= 0 {
+				if err := preallocate(file, t.progress.Size); err != nil {
+					t.abort(server.ErrDiskFull, err.Error())
+					return fmt.Errorf("get %s: %w", filename, err)
+				}
+			}
+			packet, _ = server.Acknowledgment{BlockNum: 0}.AppendBinary(ack[:0])
+			send = true
+			continue
+		}
+		payload := b[4:]
+		if _, err := w.Write(payload); err != nil {
+			t.abort(server.ErrDiskFull, err.Error())
+			return err
+		}
+		n := binary.BigEndian.Uint16(b[2:4])
+		if block == server.MaxBlocks && rollover < 0 {
+			rollover = int(n)
+		}
+		block, gapAcked = n, false
+		t.transferred(len(payload))
+		packet, _ = server.Acknowledgment{BlockNum: block}.AppendBinary(ack[:0])
+		// the last block of a window is acknowledged, the others only on timeouts
+		received++
+		send = received >= t.windowSize
+		if send {
+			received = 0
+		}
+		if len(payload) < t.blockSize {
+			if t.progress.Size >= 0 && t.progress.Bytes != t.progress.Size {
+				t.abort(server.ErrUnknown, "size mismatch")
+				return fmt.Errorf("get %s: received %d bytes, the server announced %d", filename, t.progress.Bytes, t.progress.Size)
+			}
+			if netascii != nil {
+				if err := netascii.flush(); err != nil {
+					t.abort(server.ErrDiskFull, err.Error())
+					return err
+				}
+			}
+			if _, err := t.conn.WriteTo(packet, t.server); err != nil {
+				return err
+			}
+			dallying = true
+			if c.fixedPort() {
+				t.dally(packet, block)
+				return nil
+			}
+			go t.dally(packet, block)
+			return nil
+		}
+	}
+}
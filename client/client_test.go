@@ -0,0 +1,220 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/OmarTariq612/tftp-server/client/tftptest"
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+func TestGet(t *testing.T) {
+	content := make([]byte, 5000)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	// 65537 blocks of 8 bytes, the last one numbered 1 or 0
+	large := make([]byte, (server.MaxBlocks+1)*8+3)
+	for i := range large {
+		large[i] = byte(i / 8)
+	}
+	for _, tt := range []struct {
+		name       string
+		client     Client
+		behavior   tftptest.Behavior
+		file       []byte
+		want       []byte // file if nil
+		negotiated bool
+		retransmit bool              // whether a datagram is sent again after a timeout
+		options    map[string]string // of the last request
+	}{
+		{
+			name:       "options",
+			client:     Client{BlockSize: 1024},
+			file:       content,
+			negotiated: true,
+			options:    map[string]string{"tsize": "0", "blksize": "1024"},
+		},
+		{
+			name:       "retransmissions",
+			client:     Client{Timeout: 50 * time.Millisecond},
+			behavior:   tftptest.Behavior{Drop: []int{1, 3, 6}}, // the OACK, DATA 2, the retransmission of DATA 4
+			file:       content,
+			negotiated: true,
+			retransmit: true,
+			options:    map[string]string{"tsize": "0"},
+		},
+		{
+			name:       "stray TID",
+			behavior:   tftptest.Behavior{BogusTID: true},
+			file:       content,
+			negotiated: true,
+			options:    map[string]string{"tsize": "0"},
+		},
+		{
+			name:       "netascii",
+			client:     Client{Mode: "netascii"},
+			file:       []byte("default menu\r\nlabel \r\x00linux\r\n\r"),
+			want:       []byte("default menu\nlabel \rlinux\n\r"),
+			negotiated: false,
+			options:    map[string]string{},
+		},
+		{
+			name:       "windowsize",
+			client:     Client{WindowSize: 4, Timeout: 50 * time.Millisecond},
+			behavior:   tftptest.Behavior{Drop: []int{3}}, // DATA 2, in the middle of the first window
+			file:       content,
+			negotiated: true,
+			options:    map[string]string{"tsize": "0", "windowsize": "4"},
+		},
+		{
+			name:       "block rollover",
+			client:     Client{BlockSize: 8, WindowSize: 64},
+			file:       large,
+			negotiated: true,
+			options:    map[string]string{"tsize": "0", "blksize": "8", "windowsize": "64"},
+		},
+		{
+			name:       "no OACK",
+			client:     Client{BlockSize: 1024, WindowSize: 4},
+			behavior:   tftptest.Behavior{IgnoreOptions: true},
+			file:       content,
+			negotiated: false,
+			options:    map[string]string{"tsize": "0", "blksize": "1024", "windowsize": "4"},
+		},
+		{
+			name:       "options refused",
+			client:     Client{BlockSize: 1024},
+			behavior:   tftptest.Behavior{RefuseOptions: true},
+			file:       content,
+			negotiated: false,
+			options:    map[string]string{},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tftptest.NewServer(map[string][]byte{"pxelinux.0": tt.file})
+			defer s.Close()
+			s.SetBehavior(tt.behavior)
+			var last Progress
+			c := tt.client
+			c.Progress = func(p Progress) { last = p }
+			var buf bytes.Buffer
+			if err := c.Get(context.Background(), s.Addr, "pxelinux.0", &buf); err != nil {
+				t.Fatal(err)
+			}
+			want := tt.want
+			if want == nil {
+				want = tt.file
+			}
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("got %d bytes, want %d", buf.Len(), len(want))
+			}
+			if last.Negotiated != tt.negotiated {
+				t.Errorf("Negotiated %t, want %t", last.Negotiated, tt.negotiated)
+			}
+			if (last.Retransmits > 0) != tt.retransmit {
+				t.Errorf("%d retransmissions", last.Retransmits)
+			}
+			requests := s.Requests()
+			if options := requests[len(requests)-1].Options; len(options) != len(tt.options) {
+				t.Errorf("options %v, want %v", options, tt.options)
+			} else {
+				for name, value := range tt.options {
+					if options[name] != value {
+						t.Errorf("options %v, want %v", options, tt.options)
+						break
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestGetStrayTID checks that the datagrams from another port than the
+// server TID are discarded and answered with an unknown transfer ID error.
+func TestGetStrayTID(t *testing.T) {
+	tr, err := (&Client{}).start(context.Background(), "127.0.0.1:69")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.close()
+	tr.server, tr.locked = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1069}, true
+	stray, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stray.Close()
+	data, _ := server.Data{BlockNum: 1, Payload: []byte("stray")}.MarshalBinary()
+	if tr.fromServer(stray.LocalAddr().(*net.UDPAddr), data) {
+		t.Fatal("a datagram from another port was accepted")
+	}
+	stray.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 512)
+	n, _, err := stray.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e server.Err
+	if err := e.UnmarshalBinary(buf[:n]); err != nil || e.Code != server.ErrUnknownID {
+		t.Errorf("got %v, want an unknown transfer ID error", buf[:n])
+	}
+}
+
+func TestGetNoAnswer(t *testing.T) {
+	// a socket never answering the requests
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	addr := conn.LocalAddr().String()
+
+	t.Run("timeout", func(t *testing.T) {
+		c := &Client{Timeout: 20 * time.Millisecond, Retries: 3}
+		start := time.Now()
+		if err := c.Get(context.Background(), addr, "pxelinux.0", &bytes.Buffer{}); !errors.Is(err, errTimeout) {
+			t.Fatalf("got %v, want %v", err, errTimeout)
+		}
+		if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+			t.Errorf("gave up after %v, before 3 attempts", elapsed)
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		start := time.Now()
+		if err := (&Client{}).Get(ctx, addr, "pxelinux.0", &bytes.Buffer{}); !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v, want %v", err, context.Canceled)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("returned %v after the cancellation, not right away", elapsed)
+		}
+	})
+}
+
+func TestGetFixedPort(t *testing.T) {
+	s := tftptest.NewServer(map[string][]byte{"pxelinux.0": make([]byte, 1000)})
+	defer s.Close()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := conn.LocalAddr().String()
+	conn.Close()
+
+	c := &Client{LocalAddr: local, Timeout: 100 * time.Millisecond}
+	for i := 0; i < 2; i++ {
+		var buf bytes.Buffer
+		if err := c.Get(context.Background(), s.Addr, "pxelinux.0", &buf); err != nil {
+			t.Fatalf("Get %d: %v", i+1, err)
+		}
+		if buf.Len() != 1000 {
+			t.Fatalf("Get %d: got %d bytes, want 1000", i+1, buf.Len())
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// GetSpec is a download of GetAll.
+type GetSpec struct {
+	Addr     string // host:port of the server
+	Filename string
+	Writer   io.Writer
+	Checksum *Checksum // if set, the download is verified, see GetVerified
+}
+
+// Errors is the error of GetAll, it holds the error of each spec, nil for
+// the successful downloads.
+type Errors []error
+
+func (e Errors) Error() string {
+	var failed []string
+	for _, err := range e {
+		if err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	return fmt.Sprintf("%d of %d transfers failed: %s", len(failed), len(e), strings.Join(failed, "; "))
+}
+
+// Unwrap returns the errors of the failed downloads (Go 1.20 errors.Is and errors.As).
+func (e Errors) Unwrap() []error {
+	var errs []error
+	for _, err := range e {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// GetAll downloads the specs concurrently (a kernel, its initrd and config
+// typically), at most Concurrency at once. The downloads that fail don't
+// stop the others, the error is an Errors then. The Progress callback, if
+// set, must be safe for concurrent use.
+func (c *Client) GetAll(ctx context.Context, specs []GetSpec) error {
+	limit := c.Concurrency
+	if limit <= 0 {
+		limit = 4
+	}
+	var (
+		wg     sync.WaitGroup
+		slots  = make(chan struct{}, limit)
+		errs   = make(Errors, len(specs))
+		failed bool
+		mu     sync.Mutex
+	)
+	for i, spec := range specs {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, spec GetSpec) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			var err error
+			if spec.Checksum != nil {
+				err = c.GetVerified(ctx, spec.Addr, spec.Filename, spec.Writer, *spec.Checksum)
+			} else {
+				err = c.Get(ctx, spec.Addr, spec.Filename, spec.Writer)
+			}
+			if err != nil {
+				mu.Lock()
+				errs[i], failed = err, true
+				mu.Unlock()
+			}
+		}(i, spec)
+	}
+	wg.Wait()
+	if failed {
+		return errs
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package client
+
+import (
+	"bufio"
+	"io"
+)
+
+// netascii mode (RFC 1350 and the Telnet NVT of RFC 764): lines end with
+// CR LF on the wire and a bare CR is sent as CR NUL. Local content uses LF.
+
+// netasciiEncoder reads the netascii encoding of a local content.
+type netasciiEncoder struct {
+	r       *bufio.Reader
+	next    byte // second byte of a translated pair
+	pending bool
+	err     error
+}
+
+func newNetasciiEncoder(r io.Reader) *netasciiEncoder {
+	return &netasciiEncoder{r: bufio.NewReader(r)}
+}
+
+func (e *netasciiEncoder) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if e.pending {
+			p[n], e.pending = e.next, false
+			n++
+			continue
+		}
+		if e.err != nil {
+			break
+		}
+		c, err := e.r.ReadByte()
+		if err != nil {
+			e.err = err
+			break
+		}
+		switch c {
+		case '\n':
+			p[n], e.next, e.pending = '\r', '\n', true
+		case '\r':
+			p[n], e.next, e.pending = '\r', 0, true
+		default:
+			p[n] = c
+		}
+		n++
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, e.err
+}
+
+// netasciiDecoder writes the local content of netascii data to w.
+type netasciiDecoder struct {
+	w   io.Writer
+	cr  bool   // the last byte written was a CR
+	out []byte // reused between the writes
+}
+
+func (d *netasciiDecoder) Write(p []byte) (int, error) {
+	out := d.out[:0]
+	for _, c := range p {
+		if d.cr {
+			d.cr = false
+			switch c {
+			case '\n':
+				out = append(out, '\n')
+				continue
+			case 0:
+				out = append(out, '\r')
+				continue
+			default:
+				out = append(out, '\r') // a bare CR, tolerated
+			}
+		}
+		if c == '\r' {
+			d.cr = true
+			continue
+		}
+		out = append(out, c)
+	}
+	d.out = out
+	if _, err := d.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// flush writes a CR ending the content.
+func (d *netasciiDecoder) flush() error {
+	if !d.cr {
+		return nil
+	}
+	d.cr = false
+	_, err := d.w.Write([]byte{'\r'})
+	return err
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,24 @@
+package client
+
+import (
+	"io"
+	"os"
+)
+
+// preallocate sizes the regular file f for the size bytes to be written at
+// its current offset, and reserves their disk blocks.
+func preallocate(f *os.File, size int64) error {
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return nil // pipes, devices...
+	}
+	off, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil
+	}
+	if err := f.Truncate(off + size); err != nil {
+		return err
+	}
+	reserve(f, off, size)
+	return nil
+}
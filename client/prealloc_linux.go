@@ -0,0 +1,12 @@
+package client
+
+import (
+	"os"
+	"syscall"
+)
+
+// reserve allocates the disk blocks of the n bytes of f from off, best
+// effort, so that large images aren't fragmented.
+func reserve(f *os.File, off, n int64) {
+	syscall.Fallocate(int(f.Fd()), 0, off, n)
+}
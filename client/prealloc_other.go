@@ -0,0 +1,8 @@
+//go:build !linux
+
+package client
+
+import "os"
+
+// reserve is a no-op, the file has its final size already.
+func reserve(f *os.File, off, n int64) {}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+// Put uploads the content of r to the TFTP server at addr (host:port) as
+// filename, r is read one block at a time as the transfer progresses so
+// that contents of any size can be streamed. If size is not negative r must
+// provide exactly size bytes, it
+// is announced to the server (the RFC 2349 tsize option) in octet mode
+// (the netascii size on the wire isn't known in advance).
+//
+// Every block but the last is full, the last one is empty if the content
+// is a multiple of the block size. Put returns once the server has
+// acknowledged the last block; dallying for a retransmission is the
+// server's business, since it sends the final ACK. Put gives up, telling
+// the server, as soon as ctx is done.
+func (c *Client) Put(ctx context.Context, addr, filename string, r io.Reader, size int64) error {
+	t, err := c.start(ctx, addr)
+	if err != nil {
+		return err
+	}
+	defer t.close()
+
+	t.progress.Filename = filename
+	counted := &countingReader{r: r}
+	r = counted
+	var options map[string]string
+	if c.mode() == "netascii" {
+		r = newNetasciiEncoder(counted)
+	} else if size >= 0 {
+		options = map[string]string{"tsize": strconv.FormatInt(size, 10)}
+		t.progress.Size = size
+	}
+	packet, err := t.request(server.WriteOp, filename, options)
+	if err != nil {
+		return err
+	}
+
+	var (
+		block uint16 // last block sent
+		sent  int    // blocks sent, block is its number modulo 16 bits
+		data  []byte
+	)
+	for {
+		b, err := t.exchange(packet, func(b []byte) bool {
+			switch server.Opcode(binary.BigEndian.Uint16(b)) {
+			case server.AcknowledgmentOp:
+				// duplicate ACKs don't trigger retransmissions (RFC 1123 4.2.3.1)
+				return binary.BigEndian.Uint16(b[2:4]) == block
+			case server.OptionAckOp:
+				// the OACK acknowledges the request
+				return sent == 0 && !t.locked
+			}
+			return false
+		})
+		if err != nil {
+			if t.optionsRefused(err) {
+				packet, _ = t.request(server.WriteOp, filename, nil)
+				continue
+			}
+			return fmt.Errorf("put %s: block %d: %w", filename, block, err)
+		}
+		if sent == 0 {
+			if server.Opcode(binary.BigEndian.Uint16(b)) == server.OptionAckOp {
+				if err := t.negotiated(b); err != nil {
+					return fmt.Errorf("put %s: %w", filename, err)
+				}
+			}
+			data = make([]byte, 4+t.blockSize)
+			binary.BigEndian.PutUint16(data, uint16(server.DataOp))
+		} else {
+			t.transferred(len(packet) - 4)
+			if len(packet) < len(data) {
+				break // the last block is acknowledged
+			}
+		}
+
+		n, err := io.ReadFull(r, data[4:])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			t.abort(server.ErrUnknown, err.Error())
+			return err
+		}
+		if size >= 0 && (counted.n > size || (n < t.blockSize && counted.n != size)) {
+			t.abort(server.ErrUnknown, "size mismatch")
+			return fmt.Errorf("put %s: read %d bytes, expected %d", filename, counted.n, size)
+		}
+		block++ // rolls over to 0 after 65535
+		sent++
+		binary.BigEndian.PutUint16(data[2:], block)
+		packet = data[:4+n]
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+// SOCKS5 (RFC 1928 and RFC 1929 for the username/password authentication)
+const (
+	socksVersion      = 5
+	socksNoAuth       = 0
+	socksUserPass     = 2
+	socksNoAcceptable = 0xff
+	socksUDPAssociate = 3
+	socksIPv4         = 1
+	socksDomain       = 3
+	socksIPv6         = 4
+)
+
+// socksPacketConn is a UDP association of a SOCKS5 proxy: the datagrams are
+// relayed by the proxy, encapsulated with the address of their peer. The
+// association lasts as long as the control connection.
+type socksPacketConn struct {
+	net.PacketConn // to the relay
+	control        net.Conn
+	relay          *net.UDPAddr
+	buf            []byte
+}
+
+// dialSOCKS5 opens a UDP association with the proxy of the URL
+// socks5://[user:password@]host:port, the datagrams are sent from local.
+func dialSOCKS5(ctx context.Context, proxy, local string) (*socksPacketConn, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "socks5" || u.Host == "" {
+		return nil, fmt.Errorf("tftp: proxy %q is not a socks5://host:port URL", proxy)
+	}
+	var d net.Dialer
+	control, err := d.DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	relay, err := socksAssociate(control, u.User)
+	if err != nil {
+		control.Close()
+		return nil, fmt.Errorf("tftp: proxy %s: %w", u.Host, err)
+	}
+	if relay.IP.IsUnspecified() {
+		// the relay listens on the address of the proxy
+		relay.IP = control.RemoteAddr().(*net.TCPAddr).IP
+	}
+	network := "udp4"
+	if relay.IP.To4() == nil {
+		network = "udp6"
+	}
+	if local == "" {
+		local = ":0"
+	}
+	conn, err := net.ListenPacket(network, local)
+	if err != nil {
+		control.Close()
+		return nil, err
+	}
+	return &socksPacketConn{PacketConn: conn, control: control, relay: relay, buf: make([]byte, 65536)}, nil
+}
+
+// socksAssociate negotiates the authentication and requests a UDP
+// association on control, it returns the address of the relay.
+func socksAssociate(control net.Conn, user *url.Userinfo) (*net.UDPAddr, error) {
+	greeting := []byte{socksVersion, 1, socksNoAuth}
+	if user != nil {
+		greeting = []byte{socksVersion, 2, socksNoAuth, socksUserPass}
+	}
+	if _, err := control.Write(greeting); err != nil {
+		return nil, err
+	}
+	var reply [2]byte
+	if _, err := io.ReadFull(control, reply[:]); err != nil {
+		return nil, err
+	}
+	if reply[0] != socksVersion {
+		return nil, errors.New("not a SOCKS5 proxy")
+	}
+	switch reply[1] {
+	case socksNoAuth:
+	case socksUserPass:
+		if user == nil {
+			return nil, errors.New("authentication required")
+		}
+		password, _ := user.Password()
+		if len(user.Username()) > 255 || len(password) > 255 {
+			return nil, errors.New("credentials too long")
+		}
+		auth := []byte{1, byte(len(user.Username()))}
+		auth = append(auth, user.Username()...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := control.Write(auth); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(control, reply[:]); err != nil {
+			return nil, err
+		}
+		if reply[1] != 0 {
+			return nil, errors.New("authentication failed")
+		}
+	case socksNoAcceptable:
+		return nil, errors.New("no acceptable authentication method")
+	default:
+		return nil, fmt.Errorf("unsupported authentication method %d", reply[1])
+	}
+
+	// the client address isn't known before the first datagram: zeros
+	request := []byte{socksVersion, socksUDPAssociate, 0, socksIPv4, 0, 0, 0, 0, 0, 0}
+	if _, err := control.Write(request); err != nil {
+		return nil, err
+	}
+	var header [4]byte
+	if _, err := io.ReadFull(control, header[:]); err != nil {
+		return nil, err
+	}
+	if header[1] != 0 {
+		return nil, fmt.Errorf("UDP associate refused (reply %d)", header[1])
+	}
+	return readSOCKSAddr(control, header[3])
+}
+
+// readSOCKSAddr reads an address of type atyp from r.
+func readSOCKSAddr(r io.Reader, atyp byte) (*net.UDPAddr, error) {
+	var ip []byte
+	switch atyp {
+	case socksIPv4:
+		ip = make([]byte, net.IPv4len)
+	case socksIPv6:
+		ip = make([]byte, net.IPv6len)
+	case socksDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return nil, err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return nil, err
+		}
+		var port [2]byte
+		if _, err := io.ReadFull(r, port[:]); err != nil {
+			return nil, err
+		}
+		return net.ResolveUDPAddr("udp", net.JoinHostPort(string(name), strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))))
+	default:
+		return nil, fmt.Errorf("unknown address type %d", atyp)
+	}
+	b := make([]byte, len(ip)+2)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	copy(ip, b)
+	return &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(b[len(ip):]))}, nil
+}
+
+func (c *socksPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	to, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, fmt.Errorf("tftp: %v is not a UDP address", addr)
+	}
+	packet := make([]byte, 0, 22+len(b))
+	packet = append(packet, 0, 0, 0) // reserved, fragment number
+	if ip := to.IP.To4(); ip != nil {
+		packet = append(append(packet, socksIPv4), ip...)
+	} else {
+		packet = append(append(packet, socksIPv6), to.IP.To16()...)
+	}
+	packet = append(packet, byte(to.Port>>8), byte(to.Port))
+	packet = append(packet, b...)
+	if _, err := c.PacketConn.WriteTo(packet, c.relay); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// ReadFrom returns the next datagram relayed by the proxy with the address
+// of the peer that sent it. Fragmented datagrams are dropped.
+func (c *socksPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, from, err := c.PacketConn.ReadFrom(c.buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		if addr, ok := from.(*net.UDPAddr); !ok || !addr.IP.Equal(c.relay.IP) || n < 4 || c.buf[2] != 0 {
+			continue
+		}
+		var ipLen int
+		switch c.buf[3] {
+		case socksIPv4:
+			ipLen = net.IPv4len
+		case socksIPv6:
+			ipLen = net.IPv6len
+		default:
+			continue
+		}
+		if n < 4+ipLen+2 {
+			continue
+		}
+		ip := make(net.IP, ipLen)
+		copy(ip, c.buf[4:])
+		peer := &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(c.buf[4+ipLen:]))}
+		return copy(b, c.buf[4+ipLen+2:n]), peer, nil
+	}
+}
+
+func (c *socksPacketConn) Close() error {
+	c.control.Close()
+	return c.PacketConn.Close()
+}
@@ -0,0 +1,242 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/OmarTariq612/tftp-server/client/tftptest"
+)
+
+// socksProxy is a minimal SOCKS5 proxy supporting UDP ASSOCIATE only, with
+// the username/password authentication if user is set.
+type socksProxy struct {
+	url            string
+	relay          *net.UDPConn
+	user, password string
+	relayed        int64 // datagrams relayed to the servers
+}
+
+// startSOCKSProxy runs a proxy until the end of the test, its relay forwards
+// the datagrams if forward is set, the test handles them otherwise.
+func startSOCKSProxy(t *testing.T, user, password string, forward bool) *socksProxy {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ln.Close()
+		relay.Close()
+	})
+	p := &socksProxy{url: "socks5://" + ln.Addr().String(), relay: relay, user: user, password: password}
+	go func() {
+		for {
+			control, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(control)
+		}
+	}()
+	if forward {
+		go p.forward()
+	}
+	return p
+}
+
+// serve negotiates the association on control and holds it until the
+// client closes control.
+func (p *socksProxy) serve(control net.Conn) {
+	defer control.Close()
+	var b [262]byte
+	if _, err := io.ReadFull(control, b[:2]); err != nil || b[0] != socksVersion {
+		return
+	}
+	methods := b[2 : 2+b[1]]
+	if _, err := io.ReadFull(control, methods); err != nil {
+		return
+	}
+	method := byte(socksNoAuth)
+	if p.user != "" {
+		method = socksUserPass
+	}
+	if !bytes.Contains(methods, []byte{method}) {
+		control.Write([]byte{socksVersion, socksNoAcceptable})
+		return
+	}
+	control.Write([]byte{socksVersion, method})
+	if method == socksUserPass {
+		user, password, ok := readUserPass(control)
+		if !ok || user != p.user || password != p.password {
+			control.Write([]byte{1, 1})
+			return
+		}
+		control.Write([]byte{1, 0})
+	}
+	// VER CMD RSV ATYP, an IPv4 address and a port
+	if _, err := io.ReadFull(control, b[:10]); err != nil || b[1] != socksUDPAssociate || b[3] != socksIPv4 {
+		control.Write([]byte{socksVersion, 7, 0, socksIPv4, 0, 0, 0, 0, 0, 0}) // command not supported
+		return
+	}
+	// the relay on the address of the proxy: 0.0.0.0
+	port := p.relay.LocalAddr().(*net.UDPAddr).Port
+	control.Write([]byte{socksVersion, 0, 0, socksIPv4, 0, 0, 0, 0, byte(port >> 8), byte(port)})
+	io.Copy(io.Discard, control)
+}
+
+// readUserPass reads a username/password request (RFC 1929).
+func readUserPass(r io.Reader) (user, password string, ok bool) {
+	var version [1]byte
+	if _, err := io.ReadFull(r, version[:]); err != nil || version[0] != 1 {
+		return "", "", false
+	}
+	var fields [2]string
+	for i := range fields {
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return "", "", false
+		}
+		b := make([]byte, n[0])
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", "", false
+		}
+		fields[i] = string(b)
+	}
+	return fields[0], fields[1], true
+}
+
+// forward relays the datagrams between the client, the first to send one,
+// and the servers.
+func (p *socksProxy) forward() {
+	var client *net.UDPAddr
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := p.relay.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if client == nil {
+			client = from
+		}
+		if from.String() == client.String() {
+			if n < 10 || buf[2] != 0 || buf[3] != socksIPv4 {
+				continue
+			}
+			to := &net.UDPAddr{IP: net.IP(buf[4:8]), Port: int(buf[8])<<8 | int(buf[9])}
+			atomic.AddInt64(&p.relayed, 1)
+			p.relay.WriteToUDP(buf[10:n], to)
+			continue
+		}
+		packet := append([]byte{0, 0, 0, socksIPv4}, from.IP.To4()...)
+		packet = append(packet, byte(from.Port>>8), byte(from.Port))
+		p.relay.WriteToUDP(append(packet, buf[:n]...), client)
+	}
+}
+
+func TestSOCKS5Get(t *testing.T) {
+	content := make([]byte, 3000)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	s := tftptest.NewServer(map[string][]byte{"pxelinux.0": content})
+	defer s.Close()
+
+	for _, tt := range []struct {
+		name           string
+		user, password string // of the proxy
+		credentials    string // of the proxy URL
+		fails          bool
+	}{
+		{name: "no authentication"},
+		{name: "password", user: "pxe", password: "secret", credentials: "pxe:secret@"},
+		{name: "wrong password", user: "pxe", password: "secret", credentials: "pxe:guess@", fails: true},
+		{name: "no credentials", user: "pxe", password: "secret", fails: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p := startSOCKSProxy(t, tt.user, tt.password, true)
+			c := &Client{Proxy: "socks5://" + tt.credentials + p.url[len("socks5://"):], Timeout: time.Second}
+			var buf bytes.Buffer
+			err := c.Get(context.Background(), s.Addr, "pxelinux.0", &buf)
+			if tt.fails {
+				if err == nil {
+					t.Fatal("Get through the proxy succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), content) {
+				t.Errorf("got %d bytes differing from the %d bytes served", buf.Len(), len(content))
+			}
+			if atomic.LoadInt64(&p.relayed) == 0 {
+				t.Error("nothing relayed by the proxy")
+			}
+		})
+	}
+}
+
+func TestSOCKS5Datagrams(t *testing.T) {
+	p := startSOCKSProxy(t, "", "", false)
+	conn, err := dialSOCKS5(context.Background(), p.url, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the datagrams sent are wrapped with the address of the server
+	var client *net.UDPAddr
+	for _, tt := range []struct {
+		to     *net.UDPAddr
+		header []byte
+	}{
+		{&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 69}, []byte{0, 0, 0, socksIPv4, 192, 0, 2, 1, 0, 69}},
+		{&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1069}, []byte{0, 0, 0, socksIPv6, 0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 4, 45}},
+	} {
+		if n, err := conn.WriteTo([]byte("request"), tt.to); err != nil || n != len("request") {
+			t.Fatalf("WriteTo %v: %d, %v", tt.to, n, err)
+		}
+		p.relay.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 100)
+		n, from, err := p.relay.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client = from
+		if want := append(tt.header, "request"...); !bytes.Equal(buf[:n], want) {
+			t.Errorf("relay got %v, want %v", buf[:n], want)
+		}
+	}
+
+	// the datagrams received are unwrapped, the malformed or fragmented ones dropped
+	for _, b := range [][]byte{
+		{0, 0, 0},                   // short header
+		{0, 0, 0, socksIPv4, 10, 0}, // truncated address
+		{0, 0, 0, socksIPv6, 0x20, 0x01, 0, 0, 69}, // truncated IPv6 address
+		append([]byte{0, 0, 1, socksIPv4, 10, 0, 0, 9, 0, 69}, "fragment"...),
+		append([]byte{0, 0, 0, socksDomain, 4, 'h', 'o', 's', 't', 0, 69}, "domain"...),
+		append([]byte{0, 0, 0, socksIPv4, 10, 0, 0, 9, 0, 69}, "data"...),
+	} {
+		if _, err := p.relay.WriteToUDP(b, client); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 100)
+	n, from, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "data" || from.String() != "10.0.0.9:69" {
+		t.Errorf("got %q from %v, want %q from 10.0.0.9:69", got, from, "data")
+	}
+}
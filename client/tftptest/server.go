@@ -0,0 +1,358 @@
+// Package tftptest provides a TFTP server for the tests of applications
+// using the client package, like net/http/httptest does for HTTP.
+package tftptest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+// Behavior scripts the misbehaviors of a Server, to test the handling of
+// failures. The zero value is a well-behaved server.
+type Behavior struct {
+	// Drop lists the datagrams of each transfer (1 for the first one sent
+	// by the server, retransmissions included) that are not sent.
+	Drop []int
+
+	// Delay is waited before sending each datagram, slow ACKs or DATA.
+	Delay time.Duration
+
+	// BogusTID follows every datagram with a copy, its payload corrupted,
+	// from another port: clients must discard it.
+	BogusTID bool
+
+	// IgnoreOptions answers the requests as if their options weren't
+	// there, RefuseOptions answers them with an option negotiation error.
+	IgnoreOptions bool
+	RefuseOptions bool
+
+	// Error, if set, answers every request.
+	Error *server.Err
+}
+
+// Server serves in-memory files on a random local port. Uploaded files
+// are added to them. The files are transferred as is in netascii mode, in
+// their form on the wire.
+type Server struct {
+	// Addr is the address of the server, host:port.
+	Addr string
+
+	// Timeout is the retransmission timeout, 200ms by default. Set it before
+	// the first request.
+	Timeout time.Duration
+
+	conn     net.PacketConn
+	mu       sync.Mutex
+	files    map[string][]byte
+	behavior Behavior
+	requests []server.ReadWriteRequest
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a Server serving files on the loopback interface, call
+// Close when done with it.
+func NewServer(files map[string][]byte) *Server {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		panic("tftptest: failed to listen: " + err.Error())
+	}
+	s := &Server{Addr: conn.LocalAddr().String(), Timeout: 200 * time.Millisecond, conn: conn, files: make(map[string][]byte)}
+	for name, content := range files {
+		s.files[name] = content
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+// SetBehavior scripts the transfers started from now on.
+func (s *Server) SetBehavior(b Behavior) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.behavior = b
+}
+
+// File returns the content of the file name, uploaded or given to NewServer.
+func (s *Server) File(name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	content, ok := s.files[name]
+	return content, ok
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []server.ReadWriteRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]server.ReadWriteRequest(nil), s.requests...)
+}
+
+// Close stops the server and waits for its transfers to end.
+func (s *Server) Close() {
+	s.conn.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	buf := make([]byte, server.DatagramSize)
+	for {
+		n, client, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		req, err := parseRequest(buf[:n])
+		if err != nil {
+			continue
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, req)
+		t := &transfer{s: s, behavior: s.behavior, client: client}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			t.run(req)
+		}()
+	}
+}
+
+// parseRequest parses the request b, in octet or netascii mode.
+func parseRequest(b []byte) (server.ReadWriteRequest, error) {
+	var req server.ReadWriteRequest
+	err := req.UnmarshalBinary(b)
+	var modeErr *server.ModeError
+	if !errors.As(err, &modeErr) || modeErr.Mode != "netascii" {
+		return req, err
+	}
+	// server.ReadWriteRequest only parses the octet requests
+	i := bytes.IndexByte(b[2:], 0) + 2
+	octet := append(append(append([]byte(nil), b[:i+1]...), "octet\x00"...), b[i+1+len("netascii")+1:]...)
+	if err := req.UnmarshalBinary(octet); err != nil {
+		return req, err
+	}
+	req.Mode = "netascii"
+	return req, nil
+}
+
+// transfer is a transfer of the Server, from its own port.
+type transfer struct {
+	s        *Server
+	behavior Behavior
+	client   net.Addr
+	conn     net.PacketConn
+	bogus    net.PacketConn
+	sent     int
+	buf      []byte
+}
+
+func (t *transfer) run(req server.ReadWriteRequest) {
+	var err error
+	if t.conn, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+		return
+	}
+	defer t.conn.Close()
+	if t.behavior.BogusTID {
+		if t.bogus, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+			return
+		}
+		defer t.bogus.Close()
+	}
+
+	if t.behavior.Error != nil {
+		t.fail(*t.behavior.Error)
+		return
+	}
+	options := req.Options
+	if t.behavior.RefuseOptions && len(options) > 0 {
+		t.fail(server.Err{Code: server.ErrOptions, Message: "options refused"})
+		return
+	}
+	if t.behavior.IgnoreOptions {
+		options = nil
+	}
+
+	blockSize := server.BlockSize
+	oack := make(map[string]string)
+	if v, ok := options["blksize"]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 8 {
+			if n > 65464 {
+				n = 65464
+			}
+			blockSize = n
+			oack["blksize"] = strconv.Itoa(n)
+		}
+	}
+	t.buf = make([]byte, 4+blockSize)
+	windowSize := 1
+	if v, ok := options["windowsize"]; ok && req.Op == server.ReadOp {
+		if n, err := strconv.Atoi(v); err == nil && n >= 1 && n <= 65535 {
+			windowSize = n
+			oack["windowsize"] = v
+		}
+	}
+
+	if req.Op == server.WriteOp {
+		if v, ok := options["tsize"]; ok {
+			oack["tsize"] = v
+		}
+		t.receive(req.Filename, blockSize, oack)
+		return
+	}
+	content, ok := t.s.File(req.Filename)
+	if !ok {
+		t.fail(server.Err{Code: server.ErrNotFound, Message: "file not found"})
+		return
+	}
+	if _, ok := options["tsize"]; ok {
+		oack["tsize"] = strconv.Itoa(len(content))
+	}
+	t.send(content, blockSize, windowSize, oack)
+}
+
+// write sends b to the client, unless the behavior drops it.
+func (t *transfer) write(b []byte) {
+	t.sent++
+	for _, n := range t.behavior.Drop {
+		if n == t.sent {
+			return
+		}
+	}
+	time.Sleep(t.behavior.Delay)
+	t.conn.WriteTo(b, t.client)
+	if t.bogus != nil {
+		corrupted := append([]byte(nil), b...)
+		for i := 4; i < len(corrupted); i++ {
+			corrupted[i] ^= 0xff
+		}
+		t.bogus.WriteTo(corrupted, t.client)
+	}
+}
+
+func (t *transfer) fail(e server.Err) {
+	b, _ := e.MarshalBinary()
+	t.write(b)
+}
+
+// exchange writes packet until the client answers with a datagram accepted by want.
+func (t *transfer) exchange(packet []byte, want func(b []byte) bool) ([]byte, bool) {
+	for i := 0; i < 5; i++ {
+		t.write(packet)
+		if b, aborted := t.await(want); b != nil || aborted {
+			return b, b != nil
+		}
+	}
+	return nil, false
+}
+
+// await reads the datagrams of the client until one is accepted by want. It
+// returns nil on timeouts, aborted is set if the client sent an error.
+func (t *transfer) await(want func(b []byte) bool) (b []byte, aborted bool) {
+	t.conn.SetReadDeadline(time.Now().Add(t.s.Timeout))
+	for {
+		n, from, err := t.conn.ReadFrom(t.buf)
+		if err != nil {
+			return nil, false
+		}
+		if from.String() != t.client.String() || n < 4 {
+			continue
+		}
+		b := t.buf[:n]
+		if server.Opcode(binary.BigEndian.Uint16(b)) == server.ErrorOp {
+			return nil, true
+		}
+		if want(b) {
+			return b, false
+		}
+	}
+}
+
+// send sends content in windows of windowSize blocks, each window starts
+// after the last block acknowledged (RFC 7440).
+func (t *transfer) send(content []byte, blockSize, windowSize int, oack map[string]string) {
+	if len(oack) > 0 {
+		b, _ := server.OptionAcknowledgment{Options: oack}.MarshalBinary()
+		if _, ok := t.exchange(b, isAck(0)); !ok {
+			return
+		}
+	}
+	blocks := len(content)/blockSize + 1
+	data := func(block int) []byte {
+		start := (block - 1) * blockSize
+		end := start + blockSize
+		if end > len(content) {
+			end = len(content)
+		}
+		packet := make([]byte, 4, 4+end-start)
+		binary.BigEndian.PutUint16(packet, uint16(server.DataOp))
+		binary.BigEndian.PutUint16(packet[2:], uint16(block))
+		return append(packet, content[start:end]...)
+	}
+	for acked, attempts := 0, 0; acked < blocks; {
+		if attempts == 5 {
+			return
+		}
+		last := acked + windowSize
+		if last > blocks {
+			last = blocks
+		}
+		for block := acked + 1; block <= last; block++ {
+			t.write(data(block))
+		}
+		// an ACK of a block of the window moves it, the block numbers wrap
+		var n int
+		b, aborted := t.await(func(b []byte) bool {
+			if server.Opcode(binary.BigEndian.Uint16(b)) != server.AcknowledgmentOp {
+				return false
+			}
+			n = int(binary.BigEndian.Uint16(b[2:4]) - uint16(acked))
+			return n >= 1 && n <= last-acked
+		})
+		if aborted {
+			return
+		}
+		if b == nil {
+			attempts++
+			continue
+		}
+		acked, attempts = acked+n, 0
+	}
+}
+
+func (t *transfer) receive(filename string, blockSize int, oack map[string]string) {
+	packet, _ := server.Acknowledgment{BlockNum: 0}.MarshalBinary()
+	if len(oack) > 0 {
+		packet, _ = server.OptionAcknowledgment{Options: oack}.MarshalBinary()
+	}
+	var content bytes.Buffer
+	for block := uint16(1); ; block++ {
+		b, ok := t.exchange(packet, func(b []byte) bool {
+			return server.Opcode(binary.BigEndian.Uint16(b)) == server.DataOp && binary.BigEndian.Uint16(b[2:4]) == block
+		})
+		if !ok {
+			return
+		}
+		content.Write(b[4:])
+		packet, _ = server.Acknowledgment{BlockNum: block}.MarshalBinary()
+		if len(b)-4 < blockSize {
+			t.s.mu.Lock()
+			t.s.files[filename] = content.Bytes()
+			t.s.mu.Unlock()
+			t.write(packet)
+			return
+		}
+	}
+}
+
+func isAck(block uint16) func(b []byte) bool {
+	return func(b []byte) bool {
+		return server.Opcode(binary.BigEndian.Uint16(b)) == server.AcknowledgmentOp && binary.BigEndian.Uint16(b[2:4]) == block
+	}
+}
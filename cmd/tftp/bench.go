@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/OmarTariq612/tftp-server/client"
+)
+
+// benchResult is the outcome of a transfer of the benchmark.
+type benchResult struct {
+	duration    time.Duration
+	bytes       int64
+	retransmits int
+	err         error
+}
+
+// benchCommand implements "tftp bench": download file from concurrent
+// simulated clients and report the throughput, the error rate and the
+// distributions of the transfer durations and retransmissions. With -slow,
+// the clients hold their sessions open to load the server with many
+// concurrent transfers.
+func benchCommand(args []string) {
+	var c client.Client
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	flags := defineFlags(fs, &c)
+	clients := fs.Int("clients", 10, "number of concurrent clients")
+	transfers := fs.Int("transfers", 100, "number of downloads, unless -duration is set")
+	duration := fs.Duration("duration", 0, "download for this long instead of -transfers")
+	slow := fs.Duration("slow", 0, "wait this long before acknowledging each block, to hold many sessions open like slow clients (keep it under the server timeout)")
+	addr, file, _ := parseArgs(fs, args)
+	flags.progress = false
+	if *clients < 1 {
+		fmt.Fprintln(os.Stderr, "bench: -clients must be at least 1")
+		os.Exit(2)
+	}
+
+	var (
+		mu      sync.Mutex
+		results []benchResult
+		started = time.Now()
+	)
+	flags.run(&c, func(ctx context.Context) error {
+		if *duration > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *duration)
+			defer cancel()
+		}
+		jobs := make(chan struct{})
+		go func() {
+			defer close(jobs)
+			for i := 0; *duration > 0 || i < *transfers; i++ {
+				select {
+				case jobs <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		var wg sync.WaitGroup
+		for i := 0; i < *clients; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range jobs {
+					var last client.Progress
+					cc := c
+					cc.Progress = func(p client.Progress) {
+						last = p
+						time.Sleep(*slow) // the ACK is sent once Progress returns
+					}
+					start := time.Now()
+					err := cc.Get(ctx, addr, file, io.Discard)
+					if err != nil && ctx.Err() != nil && *duration > 0 {
+						return // interrupted by the end of the benchmark
+					}
+					mu.Lock()
+					results = append(results, benchResult{time.Since(start), last.Bytes, last.Retransmits, err})
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		return nil
+	})
+	report(os.Stdout, results, time.Since(started))
+}
+
+func report(w io.Writer, results []benchResult, elapsed time.Duration) {
+	var (
+		bytes       int64
+		durations   []time.Duration
+		retransmits []int
+		errs        = make(map[string]int)
+	)
+	for _, r := range results {
+		bytes += r.bytes
+		if r.err != nil {
+			errs[r.err.Error()]++
+			continue
+		}
+		durations = append(durations, r.duration)
+		retransmits = append(retransmits, r.retransmits)
+	}
+	failed := len(results) - len(durations)
+	rate := 0.0
+	if len(results) > 0 {
+		rate = float64(failed) * 100 / float64(len(results))
+	}
+	fmt.Fprintf(w, "transfers:   %d (%d failed, %.1f%%)\n", len(results), failed, rate)
+	fmt.Fprintf(w, "throughput:  %s in %s, %s/s\n", formatBytes(float64(bytes)), elapsed.Round(time.Millisecond), formatBytes(float64(bytes)/elapsed.Seconds()))
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	if len(durations) > 0 {
+		n := len(durations)
+		fmt.Fprintf(w, "duration:    p50 %s  p90 %s  p99 %s  max %s\n",
+			durations[rank(n, 50)].Round(time.Millisecond), durations[rank(n, 90)].Round(time.Millisecond),
+			durations[rank(n, 99)].Round(time.Millisecond), durations[n-1].Round(time.Millisecond))
+	}
+	sort.Ints(retransmits)
+	if len(retransmits) > 0 {
+		n := len(retransmits)
+		fmt.Fprintf(w, "retransmits: p50 %d  p90 %d  p99 %d  max %d (per successful transfer)\n",
+			retransmits[rank(n, 50)], retransmits[rank(n, 90)], retransmits[rank(n, 99)], retransmits[n-1])
+	}
+
+	if len(errs) > 0 {
+		messages := make([]string, 0, len(errs))
+		for m := range errs {
+			messages = append(messages, m)
+		}
+		sort.Slice(messages, func(i, j int) bool { return errs[messages[i]] > errs[messages[j]] })
+		fmt.Fprintln(w, "errors:")
+		for _, m := range messages {
+			fmt.Fprintf(w, "  %6d  %s\n", errs[m], m)
+		}
+	}
+}
+
+// rank returns the index of the p-th percentile of n sorted values (nearest rank).
+func rank(n, p int) int {
+	i := (n*p+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return i
+}
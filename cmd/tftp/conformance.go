@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/OmarTariq612/tftp-server/tftpconformance"
+)
+
+// conformanceCommand implements "tftp conformance": run the scenarios of
+// the tftpconformance package against the server, which serves file, and
+// exit with status 1 if any of them fails.
+func conformanceCommand(args []string) {
+	var target tftpconformance.Target
+	fs := flag.NewFlagSet("conformance", flag.ExitOnError)
+	content := fs.String("content", "", "local copy of the file, the downloads are compared to it")
+	fs.StringVar(&target.Missing, "missing", "", "a file the server doesn't have (default \"tftpconformance-missing\")")
+	fs.StringVar(&target.Large, "large", "", "a file of the server over 65535 blocks of 512 bytes, for the rollover scenario")
+	fs.StringVar(&target.Upload, "upload", "", "name to upload to, more than once, for the upload scenarios (the server must let it be replaced)")
+	fs.DurationVar(&target.Timeout, "timeout", 0, "time to wait for a datagram (default 2s)")
+	fs.DurationVar(&target.RetransmitTimeout, "retransmit-timeout", 0, "retransmission timeout of the server (default 5s)")
+	run := fs.String("run", "", "comma separated names of the scenarios to run (default all)")
+	addr, file, _ := parseArgs(fs, args)
+	target.Addr, target.File = addr, file
+	if *content != "" {
+		b, err := os.ReadFile(*content)
+		if err != nil {
+			log.Fatal(err)
+		}
+		target.Content = b
+	}
+	scenarios := tftpconformance.Scenarios()
+	if *run != "" {
+		names := make(map[string]bool)
+		for _, name := range strings.Split(*run, ",") {
+			names[strings.TrimSpace(name)] = true
+		}
+		selected := scenarios[:0]
+		for _, s := range scenarios {
+			if names[s.Name] {
+				selected = append(selected, s)
+				delete(names, s.Name)
+			}
+		}
+		for name := range names {
+			log.Fatalf("conformance: unknown scenario %q", name)
+		}
+		scenarios = selected
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	var failed, skipped int
+	results := tftpconformance.Run(ctx, target, scenarios)
+	for _, r := range results {
+		fmt.Println(r)
+		if r.Skipped() {
+			skipped++
+		} else if !r.Passed() {
+			failed++
+		}
+	}
+	fmt.Printf("%d passed, %d failed, %d skipped\n", len(results)-failed-skipped, failed, skipped)
+	if failed > 0 || len(results) < len(scenarios) {
+		os.Exit(1)
+	}
+}
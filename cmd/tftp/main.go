@@ -0,0 +1,243 @@
+// Command tftp is a TFTP client built on the client package:
+//
+//	tftp get [flags] host[:port] file [out]
+//	tftp put [flags] host[:port] file|- [remote]
+//	tftp put -r [flags] host[:port] dir [prefix]
+//	tftp bench [flags] host[:port] file
+//	tftp conformance [flags] host[:port] file
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/OmarTariq612/tftp-server/client"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "get":
+		getCommand(os.Args[2:])
+	case "put":
+		putCommand(os.Args[2:])
+	case "bench":
+		benchCommand(os.Args[2:])
+	case "conformance":
+		conformanceCommand(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: tftp get [flags] host[:port] file [out]")
+	fmt.Fprintln(os.Stderr, "       tftp put [flags] host[:port] file|- [remote]")
+	fmt.Fprintln(os.Stderr, "       tftp put -r [flags] host[:port] dir [prefix]")
+	fmt.Fprintln(os.Stderr, "       tftp bench [flags] host[:port] file")
+	fmt.Fprintln(os.Stderr, "       tftp conformance [flags] host[:port] file")
+	fmt.Fprintln(os.Stderr, "run tftp get -h, tftp put -h, tftp bench -h or tftp conformance -h for the flags")
+	os.Exit(2)
+}
+
+// transferFlags are the flags common to the subcommands that don't configure the Client.
+type transferFlags struct {
+	progress bool
+	deadline time.Duration
+}
+
+// defineFlags defines the flags common to the subcommands on fs.
+func defineFlags(fs *flag.FlagSet, c *client.Client) *transferFlags {
+	f := new(transferFlags)
+	fs.StringVar(&c.Mode, "mode", "octet", "transfer mode: octet, or netascii to translate the line endings")
+	fs.IntVar(&c.BlockSize, "blksize", 0, "block size requested from the server (RFC 2348), 0 for the default 512 bytes")
+	fs.IntVar(&c.WindowSize, "windowsize", 0, "window of blocks acknowledged at once requested from the server for downloads (RFC 7440)")
+	fs.DurationVar(&c.Timeout, "timeout", 5*time.Second, "time to wait for an answer before retransmitting")
+	fs.IntVar(&c.Retries, "retries", 10, "transmissions of a datagram before giving up")
+	fs.Float64Var(&c.Backoff, "backoff", 1, "multiply the timeout by this factor at each retransmission")
+	fs.DurationVar(&c.MaxTimeout, "max-timeout", 0, "upper bound of the timeout grown by -backoff (0 for none)")
+	fs.StringVar(&c.LocalAddr, "local-addr", "", "local address (host:port, port 0 for any) to send from")
+	fs.StringVar(&c.Proxy, "proxy", os.Getenv("TFTP_PROXY"), "SOCKS5 proxy relaying the transfer, socks5://[user:password@]host:port (defaults to $TFTP_PROXY)")
+	fs.BoolVar(&f.progress, "progress", isTerminal(os.Stderr), "show a progress bar on the standard error (the default on terminals)")
+	fs.DurationVar(&f.deadline, "deadline", 0, "give up the transfer after this long (0 for no limit)")
+	return f
+}
+
+// run runs transfer with the progress bar and the deadline of the flags,
+// an interrupt cancels it (the server is told).
+func (f *transferFlags) run(c *client.Client, transfer func(ctx context.Context) error) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if f.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.deadline)
+		defer cancel()
+	}
+	if !f.progress {
+		return transfer(ctx)
+	}
+	bar := &progressBar{w: os.Stderr}
+	c.Progress = bar.update
+	err := transfer(ctx)
+	bar.done()
+	return err
+}
+
+// parseArgs parses the flags and the host and file arguments of a subcommand.
+func parseArgs(fs *flag.FlagSet, args []string) (addr, file, dest string) {
+	fs.Parse(args)
+	if fs.NArg() < 2 || fs.NArg() > 3 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	addr, file = fs.Arg(0), fs.Arg(1)
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "69")
+	}
+	return addr, file, fs.Arg(2)
+}
+
+// getCommand implements "tftp get": download file to out, its base name by
+// default or the standard output if out is "-".
+func getCommand(args []string) {
+	var c client.Client
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	flags := defineFlags(fs, &c)
+	checksum := fs.String("checksum", "", "expected sha256:hex or md5:hex digest of the file, the download is deleted if it doesn't match")
+	addr, file, out := parseArgs(fs, args)
+	var sum *client.Checksum
+	if *checksum != "" {
+		s, err := client.ParseChecksum(*checksum)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sum = &s
+	}
+	if out == "" {
+		out = path.Base(file)
+	}
+
+	w := os.Stdout
+	if out != "-" {
+		f, err := os.Create(out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		w = f
+	}
+	err := flags.run(&c, func(ctx context.Context) error {
+		if sum != nil {
+			return c.GetVerified(ctx, addr, file, w, *sum)
+		}
+		return c.Get(ctx, addr, file, w)
+	})
+	if out != "-" {
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		if info, serr := os.Stat(out); err != nil && serr == nil && info.Mode().IsRegular() {
+			os.Remove(out) // not devices like /dev/null
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// putCommand implements "tftp put": upload file as remote, its base name by
+// default. The file "-" streams the standard input, remote is required then.
+// With -r, file is a directory whose files are uploaded under their path
+// relative to it, prefixed by remote if set.
+func putCommand(args []string) {
+	var c client.Client
+	fs := flag.NewFlagSet("put", flag.ExitOnError)
+	flags := defineFlags(fs, &c)
+	recursive := fs.Bool("r", false, "upload the files of the directory recursively")
+	addr, file, remote := parseArgs(fs, args)
+	if *recursive {
+		err := flags.run(&c, func(ctx context.Context) error {
+			return putDir(ctx, &c, addr, file, remote)
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if remote == "" {
+		remote = filepath.Base(file)
+	}
+
+	f, size := os.Stdin, int64(-1)
+	if file == "-" {
+		if remote == "-" {
+			log.Fatal("put: the remote name is required with the standard input")
+		}
+	} else {
+		var err error
+		if f, err = os.Open(file); err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if info.Mode().IsRegular() {
+			size = info.Size()
+		}
+	}
+	err := flags.run(&c, func(ctx context.Context) error {
+		return c.Put(ctx, addr, remote, f, size)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// putDir uploads the regular files under dir, named by their slash
+// separated path relative to dir joined to prefix. It goes on after a
+// failed upload and reports the number of failures.
+func putDir(ctx context.Context, c *client.Client, addr, dir, prefix string) error {
+	failed := 0
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		remote := path.Join(prefix, filepath.ToSlash(rel))
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := c.Put(ctx, addr, remote, f, info.Size()); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			failed++
+			log.Print(err)
+		}
+		return nil
+	})
+	if err == nil && failed > 0 {
+		err = fmt.Errorf("put: %d upload(s) failed", failed)
+	}
+	return err
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/OmarTariq612/tftp-server/client"
+)
+
+// progressBar renders the progress of a transfer on a terminal line.
+type progressBar struct {
+	w      io.Writer
+	last   time.Time
+	latest client.Progress
+}
+
+const (
+	progressWidth    = 30
+	progressInterval = 100 * time.Millisecond
+)
+
+// isTerminal tells whether f is a character device, a terminal presumably.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (b *progressBar) update(p client.Progress) {
+	if p.Filename != b.latest.Filename {
+		b.done() // the previous file of a recursive upload
+		b.last = time.Time{}
+	}
+	b.latest = p
+	if time.Since(b.last) < progressInterval {
+		return
+	}
+	b.last = time.Now()
+	b.render(p)
+}
+
+func (b *progressBar) render(p client.Progress) {
+	var line strings.Builder
+	fmt.Fprintf(&line, "%s ", p.Filename)
+	if p.Size > 0 {
+		done := p.Bytes
+		if done > p.Size {
+			done = p.Size
+		}
+		filled := int(done * progressWidth / p.Size)
+		fmt.Fprintf(&line, "[%s%s] %3d%% %s/%s", strings.Repeat("=", filled), strings.Repeat(" ", progressWidth-filled),
+			done*100/p.Size, formatBytes(float64(p.Bytes)), formatBytes(float64(p.Size)))
+	} else {
+		line.WriteString(formatBytes(float64(p.Bytes)))
+	}
+	fmt.Fprintf(&line, "  %s/s", formatBytes(p.Rate()))
+	if eta, ok := p.ETA(); ok {
+		fmt.Fprintf(&line, "  ETA %s", eta.Round(time.Second))
+	}
+	fmt.Fprintf(b.w, "\r%-80s", line.String())
+}
+
+// done renders the final state and ends the progress line.
+func (b *progressBar) done() {
+	if b.latest.Blocks > 0 {
+		b.render(b.latest)
+		fmt.Fprintln(b.w)
+	}
+}
+
+func formatBytes(n float64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	exp := 0
+	for n >= unit*unit && exp < 4 {
+		n /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", n/unit, "KMGTP"[exp])
+}
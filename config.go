@@ -0,0 +1,167 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configSetting is a flag value read from the config.
+type configSetting struct {
+	name  string
+	value string
+	line  int
+}
+
+// loadConfig applies the YAML config at source (a file or a remote source, see
+// fetchConfig) to fs. The config is a mapping of flag names to values, for
+// example:
+//
+//	port: 69
+//	file: /srv/tftp/pxelinux.0
+//	log-level: debug
+//	webhook:
+//	  - http://inventory.example/tftp
+//	  - http://backup.example/tftp
+//	listen:
+//	  - 0.0.0.0:69
+//	  - address: "[::]:69"
+//	    timeout: 2s
+//	    retries: 5
+//	alias:
+//	  pxelinux.0: /srv/tftp/syslinux/pxelinux.0
+//
+// Lists are joined with commas for the flags taking comma separated values,
+// mappings are turned into key=value pairs, joined with commas or, in a
+// list, slashes.
+// Flags already set (on the command line or by loadEnv) take precedence over
+// the config. All the problems found are returned, prefixed by their location
+// in the config.
+func loadConfig(fs *flag.FlagSet, source string) []error {
+	b, err := fetchConfig(source)
+	if err != nil {
+		return []error{err}
+	}
+	settings, errs := parseConfig(fs, source, b)
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for _, setting := range settings {
+		if explicit[setting.name] {
+			continue
+		}
+		if err := fs.Set(setting.name, setting.value); err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %s: invalid value %q: %v", source, setting.line, setting.name, setting.value, err))
+		}
+	}
+	return errs
+}
+
+// parseConfig returns the settings of the YAML config b for flags of fs.
+func parseConfig(fs *flag.FlagSet, source string, b []byte) ([]configSetting, []error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, []error{fmt.Errorf("%s: %w", source, err)}
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil // empty config
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, []error{fmt.Errorf("%s:%d: the config must be a mapping of flag names to values", source, root.Line)}
+	}
+
+	var (
+		settings []configSetting
+		errs     []error
+	)
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Value == "config" || fs.Lookup(key.Value) == nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %s: unknown setting", source, key.Line, key.Value))
+			continue
+		}
+		v, err := configValue(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %s: %v", source, key.Line, key.Value, err))
+			continue
+		}
+		settings = append(settings, configSetting{name: key.Value, value: v, line: key.Line})
+	}
+	return settings, errs
+}
+
+// envPrefix prefixes the environment variables of the flags, see envName.
+const envPrefix = "TFTPD_"
+
+// envName returns the environment variable of a flag: TFTPD_ followed by the
+// flag name in upper case with dashes replaced by underscores (-log-level is
+// TFTPD_LOG_LEVEL).
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// loadEnv sets the flags of fs not given on the command line from their
+// environment variable, if present.
+func loadEnv(fs *flag.FlagSet) []error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		v, ok := os.LookupEnv(envName(f.Name))
+		if !ok || explicit[f.Name] {
+			return
+		}
+		if err := fs.Set(f.Name, v); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid value %q: %v", envName(f.Name), v, err))
+		}
+	})
+	return errs
+}
+
+// configValue returns the flag value of a scalar, a mapping or a list of
+// them, see loadConfig.
+func configValue(n *yaml.Node) (string, error) {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		return n.Value, nil
+	case yaml.MappingNode:
+		return configPairs(n, ",")
+	case yaml.SequenceNode:
+		values := make([]string, 0, len(n.Content))
+		for _, item := range n.Content {
+			switch item.Kind {
+			case yaml.ScalarNode:
+				values = append(values, item.Value)
+			case yaml.MappingNode:
+				v, err := configPairs(item, "/")
+				if err != nil {
+					return "", err
+				}
+				values = append(values, v)
+			default:
+				return "", fmt.Errorf("line %d: lists may only contain plain values and mappings", item.Line)
+			}
+		}
+		return strings.Join(values, ","), nil
+	default:
+		return "", fmt.Errorf("expected a value, a list or a mapping")
+	}
+}
+
+// configPairs returns the key=value pairs of the mapping n joined with sep.
+func configPairs(n *yaml.Node, sep string) (string, error) {
+	pairs := make([]string, 0, len(n.Content)/2)
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		if value.Kind != yaml.ScalarNode {
+			return "", fmt.Errorf("line %d: %s: mappings may only contain plain values", value.Line, key.Value)
+		}
+		pairs = append(pairs, key.Value+"="+value.Value)
+	}
+	return strings.Join(pairs, sep), nil
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+var configClient = &http.Client{Timeout: 10 * time.Second}
+
+// fetchConfig returns the content of a config source:
+//
+//	path/to/tftpd.yaml                    a local file
+//	http://host/tftpd.yaml (or https)     a GET request
+//	consul://host:8500/path/to/key        a Consul KV key ($CONSUL_HTTP_TOKEN is sent if set)
+//	etcd://host:2379/path/to/key          an etcd v3 key, through its JSON gateway
+//
+// consul+https:// and etcd+https:// use TLS.
+func fetchConfig(source string) ([]byte, error) {
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return ioutil.ReadFile(source)
+	}
+
+	scheme := "http"
+	if strings.HasSuffix(u.Scheme, "+https") {
+		scheme = "https"
+	}
+	switch strings.TrimSuffix(u.Scheme, "+https") {
+	case "http", "https":
+		return fetchURL(source, nil)
+	case "consul":
+		key := strings.TrimPrefix(u.Path, "/")
+		header := http.Header{}
+		if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" {
+			header.Set("X-Consul-Token", token)
+		}
+		return fetchURL(scheme+"://"+u.Host+"/v1/kv/"+key+"?raw", header)
+	case "etcd":
+		return fetchEtcd(scheme+"://"+u.Host, u.Path)
+	default:
+		return nil, fmt.Errorf("%s: unsupported config source scheme %q", source, u.Scheme)
+	}
+}
+
+func fetchURL(rawurl string, header http.Header) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := configClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", rawurl, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func fetchEtcd(endpoint, key string) ([]byte, error) {
+	body, _ := json.Marshal(map[string]string{"key": base64.StdEncoding.EncodeToString([]byte(key))})
+	resp, err := configClient.Post(endpoint+"/v3/kv/range", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching etcd key %s: %s", key, resp.Status)
+	}
+	var r struct {
+		KVs []struct {
+			Value string `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("fetching etcd key %s: %w", key, err)
+	}
+	if len(r.KVs) == 0 {
+		return nil, fmt.Errorf("etcd key %s not found", key)
+	}
+	return base64.StdEncoding.DecodeString(r.KVs[0].Value)
+}
+
+// configWatcher applies the changes of the config source to the settings
+// that can be changed at runtime: file, file-b, canary-percent and the
+// reconfigurable ones, the others are reported as needing a restart.
+// Settings given on the command line or through the environment (explicit)
+// keep precedence.
+type configWatcher struct {
+	s        *server.TFTPServer
+	source   string
+	explicit map[string]bool
+
+	mu       sync.Mutex
+	o        *options      // the settings in effect
+	fs       *flag.FlagSet // the flags of o
+	last     []byte
+	previous map[string]string
+}
+
+// reconfigurable are the settings applied together with
+// TFTPServer.Reconfigure, see serverSettings.
+var reconfigurable = map[string]bool{
+	"acl":                  true,
+	"alias":                true,
+	"max-transfers":        true,
+	"max-client-transfers": true,
+	"queue-size":           true,
+	"queue-timeout":        true,
+}
+
+func newConfigWatcher(s *server.TFTPServer, o *options, fs *flag.FlagSet) *configWatcher {
+	w := &configWatcher{s: s, source: o.config, explicit: o.explicit, o: o, fs: fs}
+	if b, err := fetchConfig(w.source); err == nil {
+		fs := flag.NewFlagSet("config", flag.ContinueOnError)
+		defineFlags(fs)
+		settings, _ := parseConfig(fs, w.source, b) // the errors were reported at startup
+		w.last, w.previous = b, settingValues(settings)
+	}
+	return w
+}
+
+// watch checks the config source every interval.
+func (w *configWatcher) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		w.check()
+	}
+}
+
+// check fetches the config source and applies its changes.
+func (w *configWatcher) check() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	b, err := fetchConfig(w.source)
+	if err != nil {
+		w.s.Logger.Warn("fetching config", "source", w.source, "error", err)
+		return
+	}
+	if bytes.Equal(b, w.last) {
+		return
+	}
+	w.last = b
+
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	defineFlags(fs)
+	settings, errs := parseConfig(fs, w.source, b)
+	if len(errs) > 0 {
+		for _, err := range errs {
+			w.s.Logger.Warn("invalid config, ignoring the change", "error", err)
+		}
+		return
+	}
+	values := settingValues(settings)
+	changed := make(map[string]bool)
+	for name, v := range values {
+		if w.previous[name] != v {
+			changed[name] = true
+		}
+	}
+	for name := range w.previous {
+		if _, ok := values[name]; !ok {
+			changed[name] = true
+		}
+	}
+	w.previous = values
+
+	// the reconfigurable settings are all applied or none is
+	var reconfigure []string
+	for name := range changed {
+		if w.explicit[name] || !reconfigurable[name] {
+			continue
+		}
+		if err := validateSetting(fs, name, values[name]); err != nil {
+			w.s.Logger.Warn("invalid config value, ignoring the change", "setting", name, "value", values[name], "error", err)
+			return
+		}
+		reconfigure = append(reconfigure, name)
+	}
+	for _, name := range reconfigure {
+		v := values[name]
+		if v == "" {
+			v = w.fs.Lookup(name).DefValue // removed from the config
+		}
+		w.fs.Set(name, v)
+	}
+	if len(reconfigure) > 0 {
+		w.s.Reconfigure(serverSettings(w.o))
+	}
+
+	for name := range changed {
+		if w.explicit[name] || reconfigurable[name] {
+			continue
+		}
+		v := values[name]
+		if err := fs.Set(name, v); err != nil && v != "" {
+			w.s.Logger.Warn("invalid config value", "setting", name, "value", v, "error", err)
+			continue
+		}
+		if err := applySetting(w.s, name, v); err != nil {
+			w.s.Logger.Warn("applying config change", "setting", name, "value", v, "error", err)
+		}
+	}
+}
+
+// validateSetting checks the value of a reconfigurable setting, "" for a
+// setting removed from the config.
+func validateSetting(fs *flag.FlagSet, name, value string) error {
+	if value == "" {
+		return nil
+	}
+	if err := fs.Set(name, value); err != nil {
+		return err
+	}
+	switch name {
+	case "acl":
+		_, err := parseACL(value)
+		return err
+	case "alias":
+		_, err := parseAliases(value)
+		return err
+	case "max-transfers", "max-client-transfers", "queue-size":
+		if n, _ := strconv.Atoi(value); n < 0 {
+			return fmt.Errorf("%d is negative", n)
+		}
+	}
+	return nil
+}
+
+// serverSettings returns the settings of o that TFTPServer.Reconfigure changes.
+func serverSettings(o *options) server.Settings {
+	c := server.Settings{
+		MaxTransfers:          o.maxTransfers,
+		MaxTransfersPerClient: o.maxClientTransfers,
+		QueueSize:             o.queueSize,
+		QueueTimeout:          o.queueTimeout,
+	}
+	c.Aliases, _ = parseAliases(o.alias)
+	if acl, _ := parseACL(o.acl); len(acl) > 0 {
+		c.Authorizer = acl
+	}
+	return c
+}
+
+func settingValues(settings []configSetting) map[string]string {
+	values := make(map[string]string, len(settings))
+	for _, setting := range settings {
+		values[setting.name] = setting.value
+	}
+	return values
+}
+
+// applySetting applies a setting changed at runtime.
+func applySetting(s *server.TFTPServer, name, value string) error {
+	switch name {
+	case "file":
+		return s.PublishSlot("A", value)
+	case "file-b":
+		return s.PublishSlot("B", value)
+	case "canary-percent":
+		percent := 0
+		if value != "" {
+			var err error
+			if percent, err = strconv.Atoi(value); err != nil {
+				return err
+			}
+		}
+		return s.SetCanary(percent)
+	default:
+		s.Logger.Warn("config setting changed, restart to apply it", "setting", name, "value", value)
+		return nil
+	}
+}
@@ -0,0 +1,16 @@
+//go:build windows || plan9
+
+package main
+
+import "errors"
+
+func daemonize(output string) error {
+	return errors.New("detached mode is not supported on this platform, run it as a service instead")
+}
+
+func setUmask(mask string) error {
+	return errors.New("umask is not supported on this platform")
+}
+
+// processAlive can't tell, an existing PID file is considered stale.
+func processAlive(pid int) bool { return false }
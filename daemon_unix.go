@@ -0,0 +1,69 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// detachedEnv marks the detached copy of the process started by daemonize.
+const detachedEnv = "_TFTPD_DETACHED"
+
+// startDir is the initial working directory, the detached copy starts there
+// so that it applies -chdir the same way.
+var startDir, _ = os.Getwd()
+
+// daemonize starts a copy of the process in a new session with its standard
+// streams redirected to output (/dev/null if empty) and exits, it returns in
+// the detached copy.
+func daemonize(output string) error {
+	if os.Getenv(detachedEnv) == "1" {
+		os.Unsetenv(detachedEnv)
+		return nil
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		output = os.DevNull
+	}
+	out, err := os.OpenFile(output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), detachedEnv+"=1")
+	cmd.Dir = startDir
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = null, out, out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	fmt.Println(cmd.Process.Pid)
+	os.Exit(0)
+	return nil
+}
+
+// setUmask sets the file mode creation mask from an octal string like "022".
+func setUmask(mask string) error {
+	m, err := strconv.ParseUint(mask, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid umask %q", mask)
+	}
+	syscall.Umask(int(m))
+	return nil
+}
+
+func processAlive(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
@@ -1,3 +1,8 @@
 module github.com/OmarTariq612/tftp-server
 
 go 1.18
+
+require (
+	golang.org/x/sys v0.15.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -1,20 +1,747 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"flag"
+	"fmt"
 	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/OmarTariq612/tftp-server/server"
 )
 
 func main() {
-	host := flag.String("host", "", "socks server host")
-	port := flag.Int("port", 69, "socks server port")
-	file := flag.String("file", "", "the file shared")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check":
+			checkCommand(os.Args[2:])
+			return
+		case "service":
+			serviceCommand(os.Args[2:])
+			return
+		}
+	}
+	if runService(serve) {
+		return
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), shutdownSignals...)
+	defer stop()
+	serve(ctx)
+}
+
+// serve runs the server configured by the command line flags until ctx is
+// done, it returns once the transfers in progress are over then.
+func serve(ctx context.Context) {
+	o := defineFlags(flag.CommandLine)
 	flag.Parse()
-	s := server.NewTFTPServer(*host, *port, *file)
-	err := s.ListenAndServe()
+	if o.version {
+		fmt.Println(server.ReadBuildInfo())
+		return
+	}
+	if o.chdir != "" {
+		if err := os.Chdir(o.chdir); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if o.umask != "" {
+		if err := setUmask(o.umask); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if errs := o.configure(flag.CommandLine); len(errs) > 0 {
+		for _, err := range errs {
+			log.Println(err)
+		}
+		log.Fatal("invalid configuration, see tftp-server check")
+	}
+	if o.daemon {
+		if err := daemonize(o.daemonOutput); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if o.pidFile != "" {
+		if err := writePIDFile(o.pidFile); err != nil {
+			log.Fatal(err)
+		}
+		// deferred first, so removed last: after the shutdown
+		defer os.Remove(o.pidFile)
+	}
+
+	level, err := server.ParseLogLevel(o.logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if o.quiet {
+		level = server.LevelWarn
+	}
+	format, err := server.ParseLogFormat(o.logFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s, err := server.NewTFTPServer(o.host, o.port, o.file)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if o.logFile != "" {
+		f := &server.RotatingFile{
+			Filename:   o.logFile,
+			MaxSize:    o.logMaxSize << 20,
+			MaxAge:     o.logMaxAge,
+			MaxBackups: o.logMaxBackups,
+		}
+		defer f.Close()
+		s.Logger = server.NewLogger(f, level)
+	}
+	s.Logger.Level = level
+	s.Logger.Format = format
+	if o.journald && o.syslogAddr == "" && o.logFile == "" {
+		sink, err := server.NewJournaldSink("tftpd")
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.Logger.Sink = sink
+	}
+	if o.eventLog {
+		sink, err := server.NewEventLogSink("tftpd")
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.Logger.Sink = sink
+	}
+	if o.syslogAddr != "" {
+		sink, err := server.NewSyslogSink(o.syslogAddr, o.syslogFacility, "tftpd")
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.Logger.Sink = sink
+	}
+
+	if o.logSampleRate < 1 {
+		s.LogSampling = &server.LogSampling{Rate: o.logSampleRate, Threshold: o.logSampleThreshold}
+	}
+	s.DetailedTraceEvery = o.traceEvery
+	s.MaxTransfers = o.maxTransfers
+	s.QueueSize = o.queueSize
+	s.MaxTransfersPerClient = o.maxClientTransfers
+	s.TransferRate = o.transferRate
+	if o.maxRate != 0 {
+		s.TransferRate = o.maxRate
+	}
+	s.EgressRate = o.egressRate
+	s.ReadBatch = o.readBatch
+	s.Listeners = o.listeners
+	s.Listen, _ = parseListen(o.listen)
+	s.Aliases, _ = parseAliases(o.alias)
+	s.Timeout = o.timeout
+	s.Retries = o.retries
+	s.AdaptiveTimeout = o.adaptiveTimeout
+	s.ReloadChanged = o.reloadChanged
+	s.SinglePort = o.singlePort
+	if o.pacing.Gap > 0 {
+		pacing := o.pacing
+		s.Pacing = &pacing
+	}
+	s.MaxWindowSize = o.maxWindowSize
+	s.BlockRollover = o.blockRollover
+	if o.defaultBlockSize != server.BlockSize {
+		s.DefaultBlockSize = o.defaultBlockSize
+		s.Logger.Warn("default block size changed, clients that don't negotiate a block size expect the standard one", "blksize", o.defaultBlockSize, "standard", server.BlockSize)
+	}
+	if o.faults != (server.Faults{}) {
+		faults := o.faults
+		s.Faults = &faults
+		s.Logger.Warn("fault injection enabled, for testing only", "drop", faults.DropRate, "duplicate", faults.DuplicateRate, "delay_rate", faults.DelayRate, "delay", faults.Delay)
+	}
+	s.MaxTransferDuration = o.maxTransferDuration
+	s.MemoryBudget = o.memoryBudget << 20
+	if o.streamThreshold != server.DefaultStreamThreshold>>20 {
+		s.StreamThreshold = o.streamThreshold << 20
+		if o.file != "" {
+			// loaded by NewTFTPServer with the default threshold
+			if err := s.Reload(); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	s.QueueTimeout = o.queueTimeout
+	s.PriorityClasses, _ = parsePriorityClasses(o.priorityClasses)
+	if acl, _ := parseACL(o.acl); len(acl) > 0 {
+		s.Authorizer = acl
+	}
+	s.DebugPackets = o.debugPackets
+	s.DebugPacketsClients = parseIPs(o.debugPacketsClients)
+
+	if o.pcapFile != "" {
+		f, err := os.Create(o.pcapFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		s.Capture, err = server.NewPcapWriter(f)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.CaptureFilter = captureFilter(parseIPs(o.pcapClients), o.pcapFilename)
+	}
+
+	switch o.accessLog {
+	case "":
+	case "-":
+		s.AccessLog = os.Stdout
+	default:
+		f, err := os.OpenFile(o.accessLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		s.AccessLog = f
+	}
+
+	switch o.securityLog {
+	case "":
+	case "-":
+		s.SecurityLog = os.Stdout
+	default:
+		f, err := os.OpenFile(o.securityLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		s.SecurityLog = f
+	}
+
+	s.AdminToken = o.adminToken
+	adminTLS, err := adminTLSConfig(o)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if o.metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", s.Metrics)
+		s.Metrics.Publish("tftp")
+		// only the tftp variable, the cmdline one of expvar.Handler holds the tokens
+		mux.HandleFunc("/debug/vars", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			fmt.Fprintf(w, "{\n%q: %s\n}\n", "tftp", expvar.Get("tftp"))
+		})
+		health := s.HealthHandler()
+		mux.Handle("/healthz", health)
+		mux.Handle("/readyz", health)
+		// the metrics are read-only, they may be scraped without a credential
+		var metrics http.Handler = mux
+		if o.adminToken != "" || o.adminClientCA != "" {
+			metrics = s.AdminAuth(mux)
+		}
+		go func() {
+			log.Fatal(listenAndServeAdmin(o.metricsAddr, metrics, adminTLS))
+		}()
+	}
+
+	if o.httpAddr != "" {
+		go func() {
+			log.Fatal(listenAndServeHTTP(o.httpAddr, s.HTTPHandler()))
+		}()
+	}
+
+	if o.snmpAddr != "" {
+		agent := &server.SNMPAgent{Community: o.snmpCommunity, BaseOID: o.snmpBaseOID, Metrics: s.Metrics, Logger: s.Logger}
+		go func() {
+			log.Fatal(agent.ListenAndServe(o.snmpAddr))
+		}()
+	}
+
+	if o.proxyDHCP != "" {
+		file, files, _ := parseBootFiles(o.proxyDHCPBoot)
+		d := &server.ProxyDHCP{ServerIP: net.ParseIP(o.proxyDHCP), BootFile: file, BootFiles: files, Logger: s.Logger}
+		go func() {
+			log.Fatal(d.ListenAndServe(o.host))
+		}()
+	}
+
+	if o.peers != "" {
+		s.JoinCluster(&server.Cluster{Peers: strings.Split(o.peers, ","), MaxBytes: o.clusterCacheSize << 20, Client: peerClient(adminTLS)})
+	}
+
+	if o.adminAddr != "" {
+		go func() {
+			log.Fatal(listenAndServeAdmin(o.adminAddr, s.AdminHandler(), adminTLS))
+		}()
+	}
+
+	if o.webhooks != "" {
+		w := server.NewWebhooks(strings.Split(o.webhooks, ","), o.webhookTimeout, s.Logger)
+		defer w.Close()
+		s.Webhooks = w
+	}
+
+	if o.replicate != "" {
+		s.Replicator = &server.Replicator{Targets: strings.Split(o.replicate, ","), Timeout: o.replicateTimeout}
+	}
+
+	s.Root = o.root
+	s.UploadSync, _ = server.ParseSyncPolicy(o.uploadSync)
+	s.SparseUploads = o.sparseUploads
+	s.UploadOverwrite = o.uploadOverwrite
+	if o.allowUploads {
+		s.UploadDir = o.uploadDir
+		if s.UploadDir == "" {
+			s.UploadDir = o.root
+		}
+	}
+	if o.postUploadExec != "" {
+		s.PostUploadHook = &server.ExecHook{Command: strings.Fields(o.postUploadExec), Timeout: o.postUploadTimeout}
+	}
+
+	if o.rewrites != "" {
+		s.Rewrites, err = server.LoadRewrites(o.rewrites)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	s.ArchRoots, _ = parseArchRoots(o.archRoots)
+	s.NormalizeBackslashes = o.windowsPaths
+	s.Latest, _ = parseLatest(o.latest)
+	s.Fallbacks, _ = parseFallbacks(o.fallbacks)
+
+	if o.inventoryURL != "" {
+		s.Inventory = &server.HTTPInventory{URL: o.inventoryURL, Token: o.inventoryToken, TTL: o.inventoryTTL}
+	}
+
+	if o.errorMessages != "" {
+		s.ErrorMessages, err = server.LoadErrorMessages(o.errorMessages)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if o.profiles != "" {
+		s.Profiles, err = server.LoadProfiles(o.profiles)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if o.bootMenu != "" {
+		s.BootMenu, err = server.LoadBootMenu(o.bootMenu)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if o.pxeMap != "" {
+		table, err := server.LoadPXETable(o.pxeMap)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.PXEMapper = table.Lookup
+	}
+
+	if o.ipxeTemplate != "" {
+		tmpl, err := server.ParseIPXETemplate(o.ipxeTemplate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.IPXE = &server.IPXEScript{Pattern: o.ipxePattern, Template: tmpl}
+	}
+
+	s.Templates, err = parseTemplates(o.templates)
 	if err != nil {
-		log.Println(err)
+		log.Fatal(err)
+	}
+
+	if o.fileB != "" {
+		if err := s.PublishSlot("B", o.fileB); err != nil {
+			log.Fatal(err)
+		}
+		if o.canary != 0 {
+			if err := s.SetCanary(o.canary); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	var watcher *configWatcher
+	if o.config != "" {
+		watcher = newConfigWatcher(s, o, flag.CommandLine)
+		if o.configWatch > 0 {
+			go watcher.watch(o.configWatch)
+		}
+	}
+
+	if o.selfTest != "" {
+		s.SelfTest = selfTest(o.selfTest)
+	}
+
+	handleStatsSignal(s)
+	handleReloadSignal(func() { reload(s, watcher) })
+
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), o.shutdownTimeout)
+		defer cancel()
+		if err := s.Shutdown(sctx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+	}()
+	err = s.ListenAndServe()
+	if err != server.ErrServerClosed {
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	<-shutdown
+}
+
+// parseIPs parses a comma separated list of IP addresses.
+func parseIPs(list string) []net.IP {
+	var ips []net.IP
+	if list == "" {
+		return ips
+	}
+	for _, s := range strings.Split(list, ",") {
+		ip := net.ParseIP(strings.TrimSpace(s))
+		if ip == nil {
+			log.Fatalf("invalid IP address: %q", s)
+		}
+		ips = append(ips, ip)
+	}
+	return ips
+}
+
+// parsePriorityClasses parses a comma separated list of name:priority:match[@rate]
+// entries, where match is a client CIDR or a filename glob. The entries of a
+// name make up a single class.
+func parsePriorityClasses(list string) ([]server.PriorityClass, error) {
+	var classes []server.PriorityClass
+	if list == "" {
+		return classes, nil
+	}
+	index := make(map[string]int)
+	for _, entry := range strings.Split(list, ",") {
+		fields := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+		if len(fields) == 3 {
+			if i := strings.LastIndex(fields[2], "@"); i >= 0 {
+				fields = append(fields, fields[2][i+1:])
+				fields[2] = fields[2][:i]
+			}
+		}
+		if len(fields) < 3 || fields[0] == "" || fields[2] == "" {
+			return nil, fmt.Errorf("invalid entry %q, expected name:priority:match[@rate]", entry)
+		}
+		priority, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid priority in %q", entry)
+		}
+		i, ok := index[fields[0]]
+		if !ok {
+			i = len(classes)
+			index[fields[0]] = i
+			classes = append(classes, server.PriorityClass{Name: fields[0], Priority: priority})
+		} else if classes[i].Priority != priority {
+			return nil, fmt.Errorf("class %s has two priorities", fields[0])
+		}
+		if len(fields) == 4 {
+			rate, err := strconv.ParseInt(fields[3], 10, 64)
+			if err != nil || rate < 0 {
+				return nil, fmt.Errorf("invalid rate in %q", entry)
+			}
+			classes[i].Rate = rate
+		}
+		if _, network, err := net.ParseCIDR(fields[2]); err == nil {
+			classes[i].Networks = append(classes[i].Networks, network)
+		} else if _, err := path.Match(fields[2], ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern in %q", entry)
+		} else {
+			classes[i].Files = append(classes[i].Files, fields[2])
+		}
+	}
+	return classes, nil
+}
+
+// parseACL parses a comma separated list of allow|deny:read|write|rw:pattern[@client]
+// rules, where pattern is a filename glob, * for any file, and client a CIDR
+// or an IP address.
+func parseACL(list string) (server.ACL, error) {
+	var acl server.ACL
+	if list == "" {
+		return acl, nil
+	}
+	for _, entry := range strings.Split(list, ",") {
+		fields := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+		if len(fields) < 3 || fields[2] == "" {
+			return nil, fmt.Errorf("invalid rule %q, expected allow|deny:read|write|rw:pattern[@client]", entry)
+		}
+		var rule server.ACLRule
+		switch fields[0] {
+		case "allow":
+			rule.Allow = true
+		case "deny":
+		default:
+			return nil, fmt.Errorf("invalid action in %q, expected allow or deny", entry)
+		}
+		switch fields[1] {
+		case "read":
+			rule.Read = true
+		case "write":
+			rule.Write = true
+		case "rw":
+			rule.Read, rule.Write = true, true
+		default:
+			return nil, fmt.Errorf("invalid operations in %q, expected read, write or rw", entry)
+		}
+		pattern, client, hasClient := strings.Cut(fields[2], "@")
+		if hasClient {
+			network, err := parseNetwork(client)
+			if err != nil {
+				return nil, fmt.Errorf("invalid client in %q", entry)
+			}
+			rule.Networks = []*net.IPNet{network}
+		}
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return nil, fmt.Errorf("invalid pattern in %q", entry)
+		}
+		if pattern != "*" {
+			rule.Files = []string{strings.TrimLeft(pattern, "/")}
+		}
+		acl = append(acl, rule)
+	}
+	return acl, nil
+}
+
+// parseNetwork parses a CIDR or an IP address, the network of that address alone.
+func parseNetwork(s string) (*net.IPNet, error) {
+	if _, network, err := net.ParseCIDR(s); err == nil {
+		return network, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid CIDR or IP address %q", s)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// parseTemplates parses a comma separated list of pattern=file pairs.
+func parseTemplates(list string) ([]server.TemplateFile, error) {
+	var templates []server.TemplateFile
+	if list == "" {
+		return templates, nil
+	}
+	for _, pair := range strings.Split(list, ",") {
+		pattern, file, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || pattern == "" || file == "" {
+			return nil, fmt.Errorf("invalid pair %q, expected pattern=file", pair)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern in %q", pair)
+		}
+		tmpl, err := server.ParseTemplateFile(file)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, server.TemplateFile{Pattern: pattern, Template: tmpl})
+	}
+	return templates, nil
+}
+
+// parseListen parses a comma separated list of host:port[/timeout=D][/retries=N]
+// entries, the address may also be given as address=host:port.
+func parseListen(list string) ([]server.ListenConfig, error) {
+	var configs []server.ListenConfig
+	if list == "" {
+		return configs, nil
+	}
+	for _, entry := range strings.Split(list, ",") {
+		var c server.ListenConfig
+		for i, field := range strings.Split(strings.TrimSpace(entry), "/") {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok && i == 0 {
+				key, value = "address", field
+			}
+			switch key {
+			case "address":
+				if _, _, err := net.SplitHostPort(value); err != nil {
+					return nil, fmt.Errorf("invalid address in %q: %v", entry, err)
+				}
+				c.Address = value
+			case "timeout":
+				d, err := time.ParseDuration(value)
+				if err != nil || d <= 0 {
+					return nil, fmt.Errorf("invalid timeout in %q", entry)
+				}
+				c.Timeout = d
+			case "retries":
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 1 {
+					return nil, fmt.Errorf("invalid retries in %q", entry)
+				}
+				c.Retries = n
+			default:
+				return nil, fmt.Errorf("invalid entry %q, expected host:port[/timeout=D][/retries=N]", entry)
+			}
+		}
+		if c.Address == "" {
+			return nil, fmt.Errorf("no address in %q", entry)
+		}
+		configs = append(configs, c)
+	}
+	return configs, nil
+}
+
+// parseAliases parses a comma separated list of filename=file pairs.
+func parseAliases(list string) (map[string]string, error) {
+	aliases := make(map[string]string)
+	if list == "" {
+		return aliases, nil
+	}
+	for _, pair := range strings.Split(list, ",") {
+		name, file, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		name = strings.TrimPrefix(path.Clean("/"+name), "/")
+		if !ok || name == "" || file == "" {
+			return nil, fmt.Errorf("invalid pair %q, expected filename=file", pair)
+		}
+		aliases[name] = file
+	}
+	return aliases, nil
+}
+
+// parseArchRoots parses a comma separated list of arch=dir pairs.
+func parseArchRoots(list string) (map[string]string, error) {
+	roots := make(map[string]string)
+	if list == "" {
+		return roots, nil
+	}
+	known := map[string]bool{"bios": true, "i386-efi": true, "x86_64-efi": true, "arm64-efi": true, "riscv64-efi": true}
+	for _, pair := range strings.Split(list, ",") {
+		arch, dir, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || dir == "" {
+			return nil, fmt.Errorf("invalid pair %q, expected arch=dir", pair)
+		}
+		if !known[arch] {
+			return nil, fmt.Errorf("unknown architecture %q", arch)
+		}
+		roots[arch] = dir
+	}
+	return roots, nil
+}
+
+// parseLatest parses a comma separated list of prefix=dir pairs.
+func parseLatest(list string) ([]server.LatestRule, error) {
+	var rules []server.LatestRule
+	if list == "" {
+		return rules, nil
+	}
+	for _, pair := range strings.Split(list, ",") {
+		prefix, dir, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || dir == "" {
+			return nil, fmt.Errorf("invalid pair %q, expected prefix=dir", pair)
+		}
+		if info, err := os.Stat(dir); err != nil {
+			return nil, err
+		} else if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", dir)
+		}
+		rules = append(rules, server.LatestRule{Prefix: strings.TrimLeft(prefix, "/"), Dir: dir})
+	}
+	return rules, nil
+}
+
+// parseFallbacks parses a comma separated list of pattern=candidate|candidate|... chains.
+func parseFallbacks(list string) ([]server.FallbackChain, error) {
+	var chains []server.FallbackChain
+	if list == "" {
+		return chains, nil
+	}
+	for _, entry := range strings.Split(list, ",") {
+		pattern, candidates, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || pattern == "" || candidates == "" {
+			return nil, fmt.Errorf("invalid chain %q, expected pattern=candidate|candidate|...", entry)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern in %q", entry)
+		}
+		chains = append(chains, server.FallbackChain{Pattern: strings.TrimLeft(pattern, "/"), Candidates: strings.Split(candidates, "|")})
+	}
+	return chains, nil
+}
+
+// parseBootFiles parses a comma separated list of a default boot file and
+// arch=file overrides.
+func parseBootFiles(list string) (string, map[uint16]string, error) {
+	var file string
+	files := make(map[uint16]string)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		arch, name, ok := strings.Cut(entry, "=")
+		if !ok {
+			if file != "" {
+				return "", nil, fmt.Errorf("two default boot files: %q and %q", file, entry)
+			}
+			file = entry
+			continue
+		}
+		n, err := strconv.ParseUint(arch, 10, 16)
+		if err != nil || name == "" {
+			return "", nil, fmt.Errorf("invalid entry %q, expected arch=file", entry)
+		}
+		files[uint16(n)] = name
+	}
+	return file, files, nil
+}
+
+// captureFilter matches transfers from one of clients (any if empty) for a filename
+// matching the glob pattern (any if empty).
+func captureFilter(clients []net.IP, pattern string) func(info server.TransferInfo) bool {
+	return func(info server.TransferInfo) bool {
+		if pattern != "" {
+			if ok, _ := path.Match(pattern, info.Filename); !ok {
+				return false
+			}
+		}
+		if len(clients) == 0 {
+			return true
+		}
+		host, _, _ := net.SplitHostPort(info.Client.String())
+		ip := net.ParseIP(host)
+		for _, c := range clients {
+			if c.Equal(ip) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// reload loads the published files again and applies the changes of the
+// config source if there is one, on SIGHUP: the ACL, the aliases and the
+// admission limits among them (see configWatcher). The transfers in
+// progress keep the content they started with.
+func reload(s *server.TFTPServer, watcher *configWatcher) {
+	s.Logger.Info("reloading")
+	for _, slot := range s.Slots() {
+		if slot.File == "" {
+			continue
+		}
+		if err := s.PublishSlot(slot.Name, ""); err != nil {
+			s.Logger.Warn("reloading file", "slot", slot.Name, "file", slot.File, "error", err)
+		}
+	}
+	if watcher != nil {
+		watcher.check()
 	}
 }
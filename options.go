@@ -0,0 +1,603 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+// options are the settings of the server command, see defineFlags.
+type options struct {
+	config      string
+	configWatch time.Duration
+	explicit    map[string]bool // flags set on the command line or in the environment
+	set         map[string]bool // flags set anywhere, the config included
+	version     bool
+
+	daemon       bool
+	daemonOutput string
+	pidFile      string
+	umask        string
+	chdir        string
+
+	host                string
+	port                int
+	file                string
+	logLevel            string
+	quiet               bool
+	logFormat           string
+	syslogAddr          string
+	syslogFacility      string
+	journald            bool
+	eventLog            bool
+	logSampleRate       float64
+	logSampleThreshold  int
+	traceEvery          int
+	logFile             string
+	logMaxSize          int64
+	logMaxAge           time.Duration
+	logMaxBackups       int
+	snmpAddr            string
+	snmpCommunity       string
+	snmpBaseOID         string
+	httpAddr            string
+	peers               string
+	clusterCacheSize    int64
+	replicate           string
+	replicateTimeout    time.Duration
+	maxTransfers        int
+	queueSize           int
+	maxClientTransfers  int
+	queueTimeout        time.Duration
+	priorityClasses     string
+	acl                 string
+	transferRate        int64
+	maxRate             int64
+	egressRate          int64
+	faults              server.Faults
+	readBatch           int
+	listeners           int
+	singlePort          bool
+	defaultBlockSize    int
+	maxWindowSize       int
+	blockRollover       int
+	pacing              server.Pacing
+	maxTransferDuration time.Duration
+	shutdownTimeout     time.Duration
+	memoryBudget        int64
+	streamThreshold     int64
+	adminTLSCert        string
+	adminTLSKey         string
+	adminClientCA       string
+	metricsAddr         string
+	debugPackets        bool
+	debugPacketsClients string
+	pcapFile            string
+	pcapClients         string
+	pcapFilename        string
+	adminAddr           string
+	adminToken          string
+	securityLog         string
+	webhooks            string
+	webhookTimeout      time.Duration
+	uploadDir           string
+	root                string
+	listen              string
+	alias               string
+	timeout             time.Duration
+	retries             int
+	adaptiveTimeout     bool
+	reloadChanged       bool
+	uploadSync          string
+	sparseUploads       bool
+	allowUploads        bool
+	uploadOverwrite     bool
+	postUploadExec      string
+	postUploadTimeout   time.Duration
+	fileB               string
+	pxeMap              string
+	bootMenu            string
+	rewrites            string
+	windowsPaths        bool
+	archRoots           string
+	latest              string
+	fallbacks           string
+	profiles            string
+	errorMessages       string
+	inventoryURL        string
+	inventoryToken      string
+	inventoryTTL        time.Duration
+	proxyDHCP           string
+	proxyDHCPBoot       string
+	ipxeTemplate        string
+	ipxePattern         string
+	templates           string
+	canary              int
+	accessLog           string
+	selfTest            string
+}
+
+// defineFlags defines the server flags on fs, shared by the server and the check command.
+func defineFlags(fs *flag.FlagSet) *options {
+	o := new(options)
+	fs.BoolVar(&o.version, "version", false, "print the version and exit")
+	fs.BoolVar(&o.daemon, "daemon", false, "detach from the terminal and run in the background (prints the PID of the daemon)")
+	fs.StringVar(&o.daemonOutput, "daemon-output", "", "file receiving the standard output and error of -daemon (default /dev/null)")
+	fs.StringVar(&o.pidFile, "pidfile", "", "write the process ID to this file")
+	fs.StringVar(&o.umask, "umask", "", "file mode creation mask, in octal (e.g. 022)")
+	fs.StringVar(&o.chdir, "chdir", "", "change to this directory first, relative paths are resolved from it")
+	fs.DurationVar(&o.configWatch, "config-watch", 0, "poll -config this often and apply the changes to file, file-b, canary-percent, acl, alias and the admission limits (max-transfers, max-client-transfers, queue-size, queue-timeout) (0 disables)")
+	fs.StringVar(&o.config, "config", "", "YAML config keyed by flag name: a file, an http(s):// URL, consul://host:port/key or etcd://host:port/key; each flag can also be set with a TFTPD_<FLAG_NAME> environment variable (precedence: flags > environment > file)")
+	fs.StringVar(&o.host, "host", "", "socks server host")
+	fs.IntVar(&o.port, "port", 69, "socks server port")
+	fs.StringVar(&o.listen, "listen", "", "comma separated addresses to listen on instead of -host and -port, each host:port[/timeout=D][/retries=N] overriding -timeout and -retries for its transfers, e.g. 0.0.0.0:69,[::]:69/timeout=2s/retries=5")
+	fs.StringVar(&o.file, "file", "", "the file shared")
+	fs.StringVar(&o.alias, "alias", "", "comma separated filename=file pairs serving file, a path or a URL, for the requested filename")
+	fs.DurationVar(&o.timeout, "timeout", 5*time.Second, "retransmission timeout of the transfers, the clients may negotiate another one")
+	fs.IntVar(&o.retries, "retries", 10, "transmissions of a packet before a transfer is given up")
+	fs.BoolVar(&o.adaptiveTimeout, "adaptive-timeout", false, "adapt the retransmission timeout of each transfer to the round trip times measured, starting from -timeout, unless the client negotiates one")
+	fs.StringVar(&o.root, "root", "", "serve the files of this directory tree, resolving the requested filenames against it, instead of -file")
+	fs.StringVar(&o.logLevel, "log-level", "info", "log level (debug, info, warn, error)")
+	fs.BoolVar(&o.quiet, "quiet", false, "only log warnings and errors")
+	fs.StringVar(&o.logFormat, "log-format", "text", "log output format (text, json)")
+	fs.StringVar(&o.syslogAddr, "syslog", "", "send logs to syslog instead of stderr: local or network://host:port (e.g. udp://10.0.0.1:514)")
+	fs.StringVar(&o.syslogFacility, "syslog-facility", "daemon", "syslog facility (daemon, local0 ... local7, ...)")
+	fs.BoolVar(&o.journald, "journald", server.JournaldAvailable(), "send logs to the systemd journal with structured fields (default when started by systemd)")
+	fs.BoolVar(&o.eventLog, "eventlog", false, "send warnings, errors and start/stop events to the Windows Event Log")
+	fs.Float64Var(&o.logSampleRate, "log-sample-rate", 1, "fraction of transfers whose info lines are logged once -log-sample-threshold is reached")
+	fs.IntVar(&o.logSampleThreshold, "log-sample-threshold", 0, "number of active transfers from which -log-sample-rate applies (0 for always)")
+	fs.IntVar(&o.traceEvery, "trace-one-in", 0, "log debug messages and packet dumps for one in every N transfers (0 disables)")
+	fs.StringVar(&o.logFile, "log-file", "", "write logs to this file instead of stderr")
+	fs.Int64Var(&o.logMaxSize, "log-max-size", 100, "rotate the log file once it exceeds this many megabytes (0 disables)")
+	fs.DurationVar(&o.logMaxAge, "log-max-age", 0, "rotate the log file once it is older than this (0 disables)")
+	fs.IntVar(&o.logMaxBackups, "log-max-backups", 7, "number of rotated log files to keep (0 keeps all)")
+	fs.StringVar(&o.snmpAddr, "snmp-addr", "", "answer SNMP v1/v2c requests for the server counters on this UDP address, e.g. :161 (disabled if empty)")
+	fs.StringVar(&o.snmpCommunity, "snmp-community", "", "SNMP community of -snmp-addr, required with it")
+	fs.StringVar(&o.snmpBaseOID, "snmp-base-oid", server.DefaultSNMPBaseOID, "OID under which -snmp-addr exposes the counters")
+	fs.StringVar(&o.httpAddr, "http-addr", "", "also serve the files over HTTP on this address, for UEFI HTTP boot: each path is resolved like the filename of a TFTP request (disabled if empty)")
+	fs.StringVar(&o.peers, "peers", "", "comma separated admin API URLs of peer instances sharing the files fetched from http(s) origins (requires -admin-addr)")
+	fs.Int64Var(&o.clusterCacheSize, "cluster-cache-size", 1024, "size of the cache of files shared with -peers, in megabytes")
+	fs.StringVar(&o.replicate, "replicate", "", "comma separated tftp://host[:port][/dir] or http(s)://host/dir targets every successful upload is copied to")
+	fs.DurationVar(&o.replicateTimeout, "replicate-timeout", 5*time.Second, "timeout of each -replicate reply or request")
+	fs.IntVar(&o.maxTransfers, "max-transfers", 0, "maximum number of TFTP and HTTP transfers running at once (0 for no limit)")
+	fs.IntVar(&o.maxClientTransfers, "max-client-transfers", 0, "maximum number of transfers running or queued for a single client IP (0 for no limit)")
+	fs.IntVar(&o.queueSize, "queue-size", 0, "number of requests over -max-transfers waiting for a transfer to finish, the others are refused")
+	fs.DurationVar(&o.queueTimeout, "queue-timeout", 2*time.Second, "refuse the requests queued for longer than this")
+	fs.Int64Var(&o.transferRate, "transfer-rate", 0, "limit each TFTP transfer to this many bytes per second (0 for no limit)")
+	fs.Int64Var(&o.maxRate, "max-rate", 0, "alias of -transfer-rate")
+	fs.Int64Var(&o.egressRate, "egress-rate", 0, "limit all the TFTP and HTTP transfers together to this many bytes per second (0 for no limit)")
+	fs.IntVar(&o.defaultBlockSize, "default-blksize", 512, "size of the DATA blocks; over 512 only for controlled (jumbo frame) networks, clients that don't negotiate a block size expect 512")
+	fs.IntVar(&o.maxWindowSize, "max-windowsize", 64, "largest windowsize option (RFC 7440) accepted, the blocks sent or received per ACK; 1 keeps every transfer lock-step")
+	fs.IntVar(&o.blockRollover, "block-rollover", 0, "block number following 65535 in the transfers of more than 65535 blocks (0 or 1), -1 refuses them")
+	fs.IntVar(&o.listeners, "listeners", 1, "sockets sharing the TFTP port with SO_REUSEPORT (Linux only), each read by its own goroutine")
+	fs.BoolVar(&o.singlePort, "single-port", false, "send the packets of the transfers from the TFTP port instead of an ephemeral port per transfer, for the firewalls and NATs only letting the answers from port 69 through")
+	fs.IntVar(&o.readBatch, "read-batch", 16, "requests read by a single system call on Linux (recvmmsg), 1 to read them one at a time")
+	fs.DurationVar(&o.pacing.Gap, "pacing-gap", 0, "minimum time between two bursts of DATA packets of a transfer (0 for no pacing)")
+	fs.IntVar(&o.pacing.MaxBurst, "pacing-burst", 1, "DATA packets sent back to back before waiting for -pacing-gap")
+	fs.Float64Var(&o.faults.DropRate, "fault-drop", 0, "testing only: drop this fraction (0 to 1) of the DATA packets sent")
+	fs.Float64Var(&o.faults.DuplicateRate, "fault-duplicate", 0, "testing only: send this fraction (0 to 1) of the DATA packets twice")
+	fs.Float64Var(&o.faults.DelayRate, "fault-delay-rate", 0, "testing only: delay this fraction (0 to 1) of the DATA packets by -fault-delay")
+	fs.DurationVar(&o.faults.Delay, "fault-delay", 0, "testing only: delay of the packets selected by -fault-delay-rate")
+	fs.DurationVar(&o.maxTransferDuration, "max-transfer-duration", 0, "abort the TFTP transfers running longer than this (0 for no limit)")
+	fs.DurationVar(&o.shutdownTimeout, "shutdown-timeout", 30*time.Second, "on SIGINT or SIGTERM, wait this long for the transfers in progress before cancelling them")
+	fs.Int64Var(&o.streamThreshold, "stream-threshold", server.DefaultStreamThreshold>>20, "stream the local files of at least this many megabytes from disk by each transfer instead of reading them in memory (negative to read every file in memory)")
+	fs.BoolVar(&o.reloadChanged, "reload-changed", false, "check the served file before each transfer and load it again if it changed on disk, replace it with a rename (SIGHUP reloads it and -config in any case)")
+	fs.Int64Var(&o.memoryBudget, "memory-budget", 0, "refuse new transfers while the estimated memory held by the files, caches and transfers is over this many megabytes (0 for no limit)")
+	fs.StringVar(&o.acl, "acl", "", "comma separated allow|deny:read|write|rw:pattern[@client] rules, the first one matching a request decides whether it is served (any is if none matches), pattern is a filename glob (* for any file) and client a CIDR or an IP (any client if omitted), e.g. allow:read:pxe/*@10.0.0.0/8,allow:rw:*@10.1.0.0/16,deny:rw:*")
+	fs.StringVar(&o.priorityClasses, "priority-class", "", "comma separated name:priority:match[@rate] entries ranking the queued requests (higher first), match is a client CIDR or a filename glob and rate overrides -transfer-rate for the class, e.g. prod:10:10.1.0.0/16,lab:-5:lab-*@100000")
+	fs.StringVar(&o.adminTLSCert, "admin-tls-cert", "", "serve the admin API and the metrics over HTTPS with this PEM certificate (needs -admin-tls-key)")
+	fs.StringVar(&o.adminTLSKey, "admin-tls-key", "", "PEM private key of -admin-tls-cert")
+	fs.StringVar(&o.adminClientCA, "admin-client-ca", "", "require client certificates signed by this PEM CA bundle on the admin API and the metrics (mutual TLS)")
+	fs.StringVar(&o.metricsAddr, "metrics-addr", "", "serve Prometheus metrics on http://<addr>/metrics and expvar on /debug/vars (disabled if empty)")
+	fs.BoolVar(&o.debugPackets, "debug-packets", false, "log a decoded summary and a hexdump of every datagram")
+	fs.StringVar(&o.debugPacketsClients, "debug-packets-client", "", "comma separated client IPs to restrict -debug-packets to")
+	fs.StringVar(&o.pcapFile, "pcap", "", "write the datagrams of matching transfers to this pcap file")
+	fs.StringVar(&o.pcapClients, "pcap-client", "", "comma separated client IPs to restrict -pcap to")
+	fs.StringVar(&o.pcapFilename, "pcap-filename", "", "glob pattern of requested filenames to restrict -pcap to")
+	fs.StringVar(&o.adminAddr, "admin-addr", "", "serve the HTTP/JSON admin API on this address (disabled if empty)")
+	fs.StringVar(&o.adminToken, "admin-token", "", "bearer token required by the admin API and the metrics (set $TFTPD_ADMIN_TOKEN to keep it off the command line)")
+	fs.StringVar(&o.securityLog, "security-log", "", "file to append one JSON line per security violation to (- for stdout)")
+	fs.StringVar(&o.webhooks, "webhook", "", "comma separated URLs to POST a JSON event to when a transfer finishes")
+	fs.DurationVar(&o.webhookTimeout, "webhook-timeout", 5*time.Second, "timeout of each webhook request")
+	fs.StringVar(&o.uploadDir, "upload-dir", "", "directory the uploads (WRQ) accepted by -allow-uploads are stored in, -root if empty")
+	fs.StringVar(&o.uploadSync, "upload-sync", "never", "when uploads are synced to disk: never, close (before replacing the destination, and its directory after) or a number N of blocks (every N blocks and on close)")
+	fs.BoolVar(&o.sparseUploads, "sparse-uploads", false, "write the blocks of zeros of the uploads as holes (sparse files)")
+	fs.BoolVar(&o.allowUploads, "allow-uploads", false, "accept uploads (WRQ) into the -root directory, or -upload-dir if set")
+	fs.BoolVar(&o.uploadOverwrite, "upload-overwrite", false, "let the uploads replace existing files, they are refused (file already exists) otherwise")
+	fs.StringVar(&o.postUploadExec, "post-upload-exec", "", "command (and space separated arguments) to run after each successful upload, with the requested name in $TFTP_PATH and the stored file in $TFTP_FILE (see server.ExecHook)")
+	fs.DurationVar(&o.postUploadTimeout, "post-upload-timeout", time.Minute, "kill the -post-upload-exec command after this long (0 for no limit)")
+	fs.StringVar(&o.fileB, "file-b", "", "file to publish in slot B, switch to it with the admin API")
+	fs.IntVar(&o.canary, "canary-percent", 0, "percentage of clients (by a hash of their IP) served the -file-b slot")
+	fs.StringVar(&o.pxeMap, "pxe-map", "", "table of \"<mac|uuid|default> <file>\" lines choosing the file of each pxelinux.cfg/ request, unlisted machines get file not found")
+	fs.BoolVar(&o.windowsPaths, "windows-paths", false, "turn the backslashes of requested filenames into slashes (Boot\\x64\\wdsmgfw.efi becomes Boot/x64/wdsmgfw.efi)")
+	fs.StringVar(&o.rewrites, "rewrite-rules", "", "file of \"<regexp> <replacement>\" lines renaming the requested files before they are looked up ($1 expands to the first group)")
+	fs.StringVar(&o.archRoots, "arch-root", "", "comma separated arch=dir pairs serving the well-known bootloaders (grubx64.efi, undionly.kpxe, ...) from a directory per architecture: bios, i386-efi, x86_64-efi, arm64-efi, riscv64-efi")
+	fs.StringVar(&o.latest, "latest", "", "comma separated prefix=dir pairs serving prefix<model>.bin with the highest semantic version <model>-<version>.bin of dir, e.g. firmware/latest/=/srv/firmware")
+	fs.StringVar(&o.fallbacks, "fallback", "", "comma separated pattern=candidate|candidate|... chains serving the first existing candidate to the requests matching the glob pattern, see server.FallbackChain for the placeholders, e.g. configs/*=configs/{mac}|configs/{subnet}|configs/default")
+	fs.StringVar(&o.profiles, "profiles", "", "YAML file of provisioning profiles (served file, PXE map, templates, transfer rate, error messages) selected by client subnet, see server.LoadProfiles")
+	fs.StringVar(&o.errorMessages, "error-messages", "", "YAML file mapping TFTP error codes to the message sent in their ERROR packets, see server.LoadErrorMessages")
+	fs.StringVar(&o.inventoryURL, "inventory-url", "", "HTTP endpoint queried with the ip, mac and file of each request, answering a JSON {\"file\": ..., \"vars\": {...}} record or 404, see server.HTTPInventory")
+	fs.StringVar(&o.inventoryToken, "inventory-token", "", "bearer token of -inventory-url (set $TFTPD_INVENTORY_TOKEN to keep it off the command line)")
+	fs.DurationVar(&o.inventoryTTL, "inventory-ttl", time.Minute, "cache the -inventory-url answers this long")
+	fs.StringVar(&o.bootMenu, "boot-menu", "", "YAML description of boot entries served as pxelinux.cfg/default and grub/grub.cfg menus, see server.BootMenu")
+	fs.StringVar(&o.proxyDHCP, "proxy-dhcp", "", "answer the DHCP requests of PXE clients (UDP ports 67 and 4011) with this IPv4 address as the boot server, leaving the address assignment to the DHCP server of the network (disabled if empty)")
+	fs.StringVar(&o.proxyDHCPBoot, "proxy-dhcp-boot", "pxelinux.0", "boot filename sent by -proxy-dhcp: a default file and comma separated arch=file overrides by client architecture (DHCP option 93), e.g. pxelinux.0,7=ipxe.efi,9=ipxe.efi")
+	fs.StringVar(&o.ipxeTemplate, "ipxe-template", "", "Go text/template rendering an iPXE script for each request matching -ipxe-pattern, see server.IPXEVars for its fields")
+	fs.StringVar(&o.ipxePattern, "ipxe-pattern", "*.ipxe", "glob pattern of the filenames rendered with -ipxe-template (e.g. */*.ipxe for arch/name.ipxe)")
+	fs.StringVar(&o.templates, "template", "", "comma separated pattern=file pairs rendering the requests matching the glob pattern with the Go text/template file, see server.TemplateVars for its fields")
+	fs.StringVar(&o.accessLog, "access-log", "", "file to append one line per finished transfer to (- for stdout)")
+	fs.StringVar(&o.selfTest, "self-test", "", "file to download from the server itself once it listens, it isn't ready (/readyz) until that succeeds")
+	return o
+}
+
+// validate checks the options without side effects (nothing is opened for
+// writing, no socket is bound) and returns every problem found.
+func (o *options) validate() []error {
+	var errs []error
+	errorf := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if o.root != "" {
+		if fi, err := os.Stat(o.root); err != nil {
+			errorf("root: %v", err)
+		} else if !fi.IsDir() {
+			errorf("root: %s is not a directory", o.root)
+		}
+	}
+	if o.file == "" {
+		if !o.servesContent() {
+			errorf("file: no file to serve, set -file, -root, -alias or another content source")
+		}
+	} else if !isURL(o.file) {
+		checkReadable(&errs, "file", o.file)
+	}
+	if o.fileB != "" && !isURL(o.fileB) {
+		checkReadable(&errs, "file-b", o.fileB)
+	}
+	if o.canary < 0 || o.canary > 100 {
+		errorf("canary-percent: %d out of range [0, 100]", o.canary)
+	} else if o.canary > 0 && o.fileB == "" {
+		errorf("canary-percent: requires file-b")
+	}
+	if o.port < 0 || o.port > 65535 {
+		errorf("port: %d out of range", o.port)
+	}
+	if _, err := parseListen(o.listen); err != nil {
+		errorf("listen: %v", err)
+	}
+	if aliases, err := parseAliases(o.alias); err != nil {
+		errorf("alias: %v", err)
+	} else {
+		for _, file := range aliases {
+			if !isURL(file) {
+				checkReadable(&errs, "alias", file)
+			}
+		}
+	}
+	if o.timeout <= 0 {
+		errorf("timeout: %v is not positive", o.timeout)
+	}
+	if o.blockRollover < -1 || o.blockRollover > 1 {
+		errorf("block-rollover: %d is not 0, 1 or -1", o.blockRollover)
+	}
+	if o.retries < 1 {
+		errorf("retries: %d is below 1", o.retries)
+	}
+
+	if _, err := server.ParseLogLevel(o.logLevel); err != nil {
+		errorf("log-level: %v", err)
+	}
+	if _, err := server.ParseLogFormat(o.logFormat); err != nil {
+		errorf("log-format: %v", err)
+	}
+	if o.logSampleRate < 0 || o.logSampleRate > 1 {
+		errorf("log-sample-rate: %v out of range [0, 1]", o.logSampleRate)
+	}
+	// the logs go to a single output, journald only counts if it was asked
+	// for: it is the default under systemd
+	var outputs []string
+	for _, output := range []struct {
+		flag string
+		on   bool
+	}{
+		{"log-file", o.logFile != ""},
+		{"syslog", o.syslogAddr != ""},
+		{"journald", o.journald && o.set["journald"]},
+		{"eventlog", o.eventLog},
+	} {
+		if output.on {
+			outputs = append(outputs, output.flag)
+		}
+	}
+	if len(outputs) > 1 {
+		errorf("%s: conflicts with -%s, the logs go to a single output", outputs[1], outputs[0])
+	}
+	if o.syslogAddr != "" && o.syslogAddr != "local" {
+		if u, err := url.Parse(o.syslogAddr); err != nil || u.Host == "" {
+			errorf("syslog: %q is neither local nor network://host:port", o.syslogAddr)
+		}
+	}
+
+	checkAddr(&errs, "metrics-addr", o.metricsAddr)
+	checkAddr(&errs, "admin-addr", o.adminAddr)
+	checkAddr(&errs, "snmp-addr", o.snmpAddr)
+	checkAddr(&errs, "http-addr", o.httpAddr)
+	if o.maxTransfers < 0 {
+		errorf("max-transfers: %d is negative", o.maxTransfers)
+	}
+	if o.maxClientTransfers < 0 {
+		errorf("max-client-transfers: %d is negative", o.maxClientTransfers)
+	}
+	if o.transferRate < 0 {
+		errorf("transfer-rate: %d is negative", o.transferRate)
+	}
+	if o.maxRate < 0 {
+		errorf("max-rate: %d is negative", o.maxRate)
+	} else if o.maxRate != 0 && o.transferRate != 0 && o.maxRate != o.transferRate {
+		errorf("max-rate: %d differs from transfer-rate %d, set only one", o.maxRate, o.transferRate)
+	}
+	if o.egressRate < 0 {
+		errorf("egress-rate: %d is negative", o.egressRate)
+	}
+	if o.maxWindowSize < 1 || o.maxWindowSize > 65535 {
+		errorf("max-windowsize: %d is not between 1 and 65535", o.maxWindowSize)
+	}
+	if o.defaultBlockSize < 8 || o.defaultBlockSize > server.MaxBlockSize {
+		errorf("default-blksize: %d is not between 8 and %d", o.defaultBlockSize, server.MaxBlockSize)
+	}
+	if o.pacing.Gap < 0 {
+		errorf("pacing-gap: %s is negative", o.pacing.Gap)
+	}
+	if o.pacing.MaxBurst < 1 {
+		errorf("pacing-burst: %d is below 1", o.pacing.MaxBurst)
+	}
+	if o.listeners < 1 {
+		errorf("listeners: %d is below 1", o.listeners)
+	}
+	if o.readBatch < 1 {
+		errorf("read-batch: %d is below 1", o.readBatch)
+	}
+	if err := o.faults.Validate(); err != nil {
+		errorf("%v", err)
+	}
+	if o.memoryBudget < 0 {
+		errorf("memory-budget: %d is negative", o.memoryBudget)
+	}
+	if o.queueSize < 0 {
+		errorf("queue-size: %d is negative", o.queueSize)
+	} else if o.queueSize > 0 && o.maxTransfers == 0 {
+		errorf("queue-size: requires max-transfers")
+	}
+	if _, err := parsePriorityClasses(o.priorityClasses); err != nil {
+		errorf("priority-class: %v", err)
+	}
+	if _, err := parseACL(o.acl); err != nil {
+		errorf("acl: %v", err)
+	}
+	if o.pxeMap != "" {
+		if _, err := server.LoadPXETable(o.pxeMap); err != nil {
+			errorf("pxe-map: %v", err)
+		}
+	}
+	if o.rewrites != "" {
+		if _, err := server.LoadRewrites(o.rewrites); err != nil {
+			errorf("rewrite-rules: %v", err)
+		}
+	}
+	if _, err := parseArchRoots(o.archRoots); err != nil {
+		errorf("arch-root: %v", err)
+	}
+	if _, err := parseLatest(o.latest); err != nil {
+		errorf("latest: %v", err)
+	}
+	if _, err := parseFallbacks(o.fallbacks); err != nil {
+		errorf("fallback: %v", err)
+	}
+	if o.inventoryURL != "" {
+		if u, err := url.Parse(o.inventoryURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			errorf("inventory-url: %q is not an http(s) URL", o.inventoryURL)
+		}
+	}
+	if o.errorMessages != "" {
+		if _, err := server.LoadErrorMessages(o.errorMessages); err != nil {
+			errorf("error-messages: %v", err)
+		}
+	}
+	if o.profiles != "" {
+		if _, err := server.LoadProfiles(o.profiles); err != nil {
+			errorf("profiles: %v", err)
+		}
+	}
+	if o.bootMenu != "" {
+		if _, err := server.LoadBootMenu(o.bootMenu); err != nil {
+			errorf("boot-menu: %v", err)
+		}
+	}
+	if o.proxyDHCP != "" {
+		ip := net.ParseIP(o.proxyDHCP)
+		if ip == nil || ip.To4() == nil {
+			errorf("proxy-dhcp: %q is not an IPv4 address", o.proxyDHCP)
+		}
+		if file, files, err := parseBootFiles(o.proxyDHCPBoot); err != nil {
+			errorf("proxy-dhcp-boot: %v", err)
+		} else if ip.To4() != nil {
+			// the boot file names must fit in the replies
+			if err := (&server.ProxyDHCP{ServerIP: ip, BootFile: file, BootFiles: files}).Validate(); err != nil {
+				errorf("proxy-dhcp-boot: %v", err)
+			}
+		}
+	}
+	if o.ipxeTemplate != "" {
+		if _, err := server.ParseIPXETemplate(o.ipxeTemplate); err != nil {
+			errorf("ipxe-template: %v", err)
+		}
+	}
+	if _, err := path.Match(o.ipxePattern, ""); err != nil {
+		errorf("ipxe-pattern: %v", err)
+	}
+	if _, err := parseTemplates(o.templates); err != nil {
+		errorf("template: %v", err)
+	}
+	if (o.adminTLSCert == "") != (o.adminTLSKey == "") {
+		errorf("admin-tls-cert: -admin-tls-cert and -admin-tls-key go together")
+	}
+	if o.adminClientCA != "" && o.adminTLSCert == "" {
+		errorf("admin-client-ca: mutual TLS needs -admin-tls-cert and -admin-tls-key")
+	}
+	for name, file := range map[string]string{"admin-tls-cert": o.adminTLSCert, "admin-tls-key": o.adminTLSKey, "admin-client-ca": o.adminClientCA} {
+		if file != "" {
+			checkReadable(&errs, name, file)
+		}
+	}
+	if o.adminAddr != "" && o.adminToken == "" && o.adminClientCA == "" {
+		errorf("admin-addr: the admin API needs a credential, -admin-token or mutual TLS (-admin-client-ca)")
+	}
+	if o.snmpAddr != "" && o.snmpCommunity == "" {
+		errorf("snmp-addr: -snmp-community must be set, no default community is guessable")
+	}
+	if o.peers != "" && o.adminAddr == "" {
+		errorf("peers: the admin API (-admin-addr) must be enabled for the peers to reach this instance")
+	}
+	checkIPs(&errs, "debug-packets-client", o.debugPacketsClients)
+	checkIPs(&errs, "pcap-client", o.pcapClients)
+	if _, err := path.Match(o.pcapFilename, ""); err != nil {
+		errorf("pcap-filename: %v", err)
+	}
+
+	checkWritable(&errs, "log-file", o.logFile)
+	checkWritable(&errs, "pidfile", o.pidFile)
+	checkWritable(&errs, "daemon-output", o.daemonOutput)
+	checkWritable(&errs, "pcap", o.pcapFile)
+	if o.accessLog != "-" {
+		checkWritable(&errs, "access-log", o.accessLog)
+	}
+	if o.securityLog != "-" {
+		checkWritable(&errs, "security-log", o.securityLog)
+	}
+
+	if o.webhooks != "" {
+		for _, w := range strings.Split(o.webhooks, ",") {
+			if u, err := url.Parse(w); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				errorf("webhook: %q is not an http(s) URL", w)
+			}
+		}
+	}
+	if o.replicate != "" {
+		for _, t := range strings.Split(o.replicate, ",") {
+			if u, err := url.Parse(t); err != nil || (u.Scheme != "tftp" && u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				errorf("replicate: %q is not a tftp:// or http(s):// URL", t)
+			}
+		}
+	}
+	if o.uploadDir != "" {
+		if fi, err := os.Stat(o.uploadDir); err != nil {
+			errorf("upload-dir: %v", err)
+		} else if !fi.IsDir() {
+			errorf("upload-dir: %s is not a directory", o.uploadDir)
+		}
+	}
+	if o.allowUploads && o.root == "" && o.uploadDir == "" {
+		errorf("allow-uploads: requires root or upload-dir")
+	}
+	if o.uploadDir != "" && !o.allowUploads {
+		errorf("upload-dir: uploads are refused without allow-uploads")
+	}
+	if _, err := server.ParseSyncPolicy(o.uploadSync); err != nil {
+		errorf("upload-sync: %v", err)
+	}
+	if args := strings.Fields(o.postUploadExec); len(args) > 0 {
+		if _, err := exec.LookPath(args[0]); err != nil {
+			errorf("post-upload-exec: %v", err)
+		}
+	}
+	return errs
+}
+
+// servesContent tells whether a content source other than -file is set.
+func (o *options) servesContent() bool {
+	for _, source := range []string{
+		o.root, o.alias, o.pxeMap, o.bootMenu, o.ipxeTemplate, o.templates,
+		o.inventoryURL, o.latest, o.fallbacks, o.archRoots, o.profiles,
+	} {
+		if source != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func isURL(file string) bool {
+	return strings.HasPrefix(file, "http://") || strings.HasPrefix(file, "https://")
+}
+
+func checkReadable(errs *[]error, name, file string) {
+	f, err := os.Open(file)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %w", name, err))
+		return
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err == nil && fi.IsDir() {
+		*errs = append(*errs, fmt.Errorf("%s: %s is a directory", name, file))
+	}
+}
+
+// checkWritable checks that file can be created, that is its directory exists.
+func checkWritable(errs *[]error, name, file string) {
+	if file == "" {
+		return
+	}
+	dir := filepath.Dir(file)
+	fi, err := os.Stat(dir)
+	switch {
+	case err != nil:
+		*errs = append(*errs, fmt.Errorf("%s: %w", name, err))
+	case !fi.IsDir():
+		*errs = append(*errs, fmt.Errorf("%s: %s is not a directory", name, dir))
+	}
+}
+
+func checkAddr(errs *[]error, name, addr string) {
+	if addr == "" {
+		return
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %w", name, err))
+	}
+}
+
+func checkIPs(errs *[]error, name, list string) {
+	if list == "" {
+		return
+	}
+	for _, s := range strings.Split(list, ",") {
+		if net.ParseIP(strings.TrimSpace(s)) == nil {
+			*errs = append(*errs, fmt.Errorf("%s: invalid IP address %q", name, s))
+		}
+	}
+}
+
+// configure completes the flags parsed by fs from the environment and the config
+// file, if any, and validates the result. The precedence is: command line
+// flags, then TFTPD_* environment variables, then the config file.
+func (o *options) configure(fs *flag.FlagSet) []error {
+	errs := loadEnv(fs)
+	o.explicit = make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { o.explicit[f.Name] = true })
+	if o.config != "" {
+		errs = append(errs, loadConfig(fs, o.config)...)
+	}
+	o.set = make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { o.set[f.Name] = true })
+	return append(errs, o.validate()...)
+}
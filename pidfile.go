@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// writePIDFile records the process ID to path, refusing to overwrite the PID
+// file of a running process. The caller removes it once the server is shut
+// down.
+func writePIDFile(path string) error {
+	if b, err := ioutil.ReadFile(path); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil && pid != os.Getpid() && processAlive(pid) {
+			return fmt.Errorf("%s: already running with PID %d", path, pid)
+		}
+	}
+	return ioutil.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
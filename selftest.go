@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/OmarTariq612/tftp-server/client"
+)
+
+// selfTest returns the server SelfTest downloading file from the server
+// through its own client, over the loopback interface.
+func selfTest(file string) func(addr net.Addr) error {
+	return func(addr net.Addr) error {
+		c := client.Client{Timeout: time.Second, Retries: 3}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		return c.Get(ctx, loopbackAddr(addr), file, ioutil.Discard)
+	}
+}
+
+// loopbackAddr returns the host:port reaching the listening address addr
+// from the local host.
+func loopbackAddr(addr net.Addr) string {
+	udp, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return addr.String()
+	}
+	ip := udp.IP
+	if ip == nil || ip.IsUnspecified() {
+		// the wildcard addresses, [::] included, accept IPv4
+		ip = net.IPv4(127, 0, 0, 1)
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(udp.Port))
+}
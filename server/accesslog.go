@@ -0,0 +1,89 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Direction tells whether a transfer is a download (RRQ) or an upload (WRQ).
+type Direction string
+
+const (
+	DirectionRead  Direction = "read"
+	DirectionWrite Direction = "write"
+)
+
+// TransferResult is the outcome of a finished transfer.
+type TransferResult string
+
+const (
+	ResultOK        TransferResult = "ok"
+	ResultTimeout   TransferResult = "timeout"   // retries exhausted
+	ResultAborted   TransferResult = "aborted"   // the client sent an ERROR packet
+	ResultError     TransferResult = "error"     // local failure (dial, write, ...)
+	ResultCancelled TransferResult = "cancelled" // aborted with CancelTransfer
+	ResultDeadline  TransferResult = "deadline"  // ran longer than MaxTransferDuration
+
+	ResultUnreachable TransferResult = "unreachable" // ICMP port unreachable, the client went away
+)
+
+// TransferInfo identifies a transfer.
+type TransferInfo struct {
+	ID        string // short random identifier, included in every log line of the transfer
+	Client    net.Addr
+	Filename  string
+	Direction Direction
+	Start     time.Time
+}
+
+// TransferSummary describes a finished transfer, one summary is produced per transfer.
+type TransferSummary struct {
+	TransferInfo
+	Bytes       int64 // payload bytes acknowledged by the peer
+	Blocks      int   // DATA blocks acknowledged by the peer
+	Retransmits int
+	Timeouts    int // expired waits for a reply from the peer
+	Duration    time.Duration
+	Result      TransferResult
+	Err         error
+	Path        string // local file written by an upload
+}
+
+// String formats the summary as an access log line:
+//
+//	client id [time] "direction filename" result bytes blocks retransmits timeouts duration
+func (t TransferSummary) String() string {
+	return fmt.Sprintf("%s %s [%s] %q %s %d %d %d %d %s",
+		t.Client,
+		t.ID,
+		t.Start.Format("02/Jan/2006:15:04:05 -0700"),
+		string(t.Direction)+" "+t.Filename,
+		t.Result,
+		t.Bytes,
+		t.Blocks,
+		t.Retransmits,
+		t.Timeouts,
+		t.Duration.Round(time.Microsecond),
+	)
+}
+
+func (s *TFTPServer) logAccess(summary *TransferSummary) {
+	if s.AccessLog == nil {
+		return
+	}
+	s.accessMu.Lock()
+	defer s.accessMu.Unlock()
+	fmt.Fprintln(s.AccessLog, summary.String())
+}
+
+// newTransferID returns a short random hex identifier.
+func newTransferID() string {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%08x", time.Now().UnixNano()&0xffffffff)
+	}
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+)
+
+// Authorizer decides which requests are served, the ones it returns an
+// error for are refused with an access violation and reported as a
+// SecurityACLDenied event, the error being the detail.
+type Authorizer interface {
+	Authorize(client net.Addr, op Opcode, filename string) error
+}
+
+// AuthorizerFunc adapts a function to the Authorizer interface.
+type AuthorizerFunc func(client net.Addr, op Opcode, filename string) error
+
+func (f AuthorizerFunc) Authorize(client net.Addr, op Opcode, filename string) error {
+	return f(client, op, filename)
+}
+
+// authorize checks a request of op for filename with the Authorizer, if
+// any. The reads are checked under the name the Rewrites turn filename
+// into, the file served, which is returned with the error.
+func (s *TFTPServer) authorize(client net.Addr, op Opcode, filename string) (string, error) {
+	authorizer := s.settings().Authorizer
+	if op != WriteOp {
+		filename = s.rewrite(filename)
+	}
+	if authorizer == nil {
+		return filename, nil
+	}
+	return filename, authorizer.Authorize(client, op, filename)
+}
+
+// ACLRule allows or denies the requests matching it.
+type ACLRule struct {
+	Allow    bool
+	Read     bool         // the rule applies to the read requests
+	Write    bool         // the rule applies to the write requests
+	Networks []*net.IPNet // client networks, any client if empty
+	Files    []string     // path.Match patterns of the requested filename, any file if empty
+}
+
+// matches reports whether the rule applies to a request of op for filename,
+// cleaned and without the leading slashes, by the client at ip.
+func (r *ACLRule) matches(ip net.IP, op Opcode, filename string) bool {
+	if op == WriteOp && !r.Write || op != WriteOp && !r.Read {
+		return false
+	}
+	if len(r.Networks) > 0 {
+		in := false
+		for _, n := range r.Networks {
+			if n.Contains(ip) {
+				in = true
+				break
+			}
+		}
+		if !in {
+			return false
+		}
+	}
+	if len(r.Files) == 0 {
+		return true
+	}
+	for _, pattern := range r.Files {
+		if ok, _ := path.Match(pattern, filename); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ACL is an Authorizer applying the first of its rules matching the
+// request, the requests matching none are allowed.
+type ACL []ACLRule
+
+func (a ACL) Authorize(client net.Addr, op Opcode, filename string) error {
+	ip := net.ParseIP(clientHost(client))
+	// "pxe/../secret" must not match "pxe/*"
+	name := strings.TrimPrefix(path.Clean("/"+filename), "/")
+	for i := range a {
+		if a[i].matches(ip, op, name) {
+			if a[i].Allow {
+				return nil
+			}
+			return fmt.Errorf("denied by ACL rule %d", i+1)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package server_test
+
+import (
+	"encoding/binary"
+	"regexp"
+	"testing"
+	"testing/fstest"
+
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+func TestAuthorizeRewritten(t *testing.T) {
+	addr := startServer(t, func(s *server.TFTPServer) {
+		s.FS = fstest.MapFS{
+			"pxelinux.0": {Data: []byte("boot")},
+			"secret.key": {Data: []byte("key")},
+		}
+		s.Rewrites = []server.Rewrite{{Pattern: regexp.MustCompile(`^boot/(.*)$`), Replacement: "$1"}}
+		s.Authorizer = server.ACL{{Allow: false, Read: true, Files: []string{"secret.*"}}}
+	})
+	for _, tt := range []struct {
+		file   string
+		denied bool
+	}{
+		{"pxelinux.0", false},
+		{"boot/pxelinux.0", false},
+		{"secret.key", true},
+		{"boot/secret.key", true},
+	} {
+		_, reply, _ := sendRequest(t, addr, server.ReadOp, tt.file, nil)
+		if !tt.denied {
+			if server.Opcode(binary.BigEndian.Uint16(reply)) != server.DataOp {
+				t.Errorf("%s: got %v, want DATA 1", tt.file, reply)
+			}
+			continue
+		}
+		if code := errorCode(t, reply); code != server.ErrAccessViolation {
+			t.Errorf("%s: error code %d, want %d", tt.file, code, server.ErrAccessViolation)
+		}
+	}
+}
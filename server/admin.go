@@ -0,0 +1,357 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// AdminHandler returns the HTTP/JSON admin API:
+//
+//	GET  /status                  uptime, listening address, maintenance mode, transfer counts
+//	GET  /config                  the effective server configuration
+//	GET  /transfers               in-flight transfers (client, file, progress, throughput, retransmits)
+//	POST /transfers/cancel?id=ID  abort a transfer, the client receives an ERROR packet
+//	GET  /files/top?n=10&by=bytes most requested files (by=requests is the default)
+//	POST /files/reload            read the served file from disk again
+//	POST /cache/purge             drop the in-memory copy of the served file (same as /files/reload)
+//	GET  /slots                   the published file slots (A and B)
+//	POST /slots/publish?slot=B&file=PATH  load a file into a slot (PATH defaults to the slot's file,
+//	                              must be the file of a slot or under Root)
+//	POST /slots/activate?slot=B   atomically switch the served file to a slot
+//	POST /slots/canary?percent=10 serve the inactive slot to a share of the clients
+//	GET  /maintenance             whether the maintenance mode is on
+//	POST /maintenance?enabled=B   turn the maintenance mode on or off
+//	POST /trace?ip=IP&traceparent=TP&ttl=10m  parent the transfers of a client to a remote trace
+//	GET  /cluster/file?source=URL cached copy of a remote file, for the peers (in a cluster)
+//	PUT  /cluster/file?source=URL store a remote file fetched by a peer (in a cluster)
+//
+// Every request must be authenticated, see AdminAuth, except for the
+// HealthHandler probes which are served too.
+func (s *TFTPServer) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	health := s.HealthHandler()
+	mux.Handle("/healthz", health)
+	mux.Handle("/readyz", health)
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/config", s.handleConfig)
+	mux.HandleFunc("/transfers", s.handleTransfers)
+	mux.HandleFunc("/transfers/cancel", s.handleCancelTransfer)
+	mux.HandleFunc("/files/top", s.handleTopFiles)
+	mux.HandleFunc("/files/reload", s.handleReload)
+	mux.HandleFunc("/cache/purge", s.handleReload)
+	mux.HandleFunc("/slots", s.handleSlots)
+	mux.HandleFunc("/slots/publish", s.handleSlotAction)
+	mux.HandleFunc("/slots/activate", s.handleSlotAction)
+	mux.HandleFunc("/slots/canary", s.handleCanary)
+	mux.HandleFunc("/maintenance", s.handleMaintenance)
+	mux.HandleFunc("/trace", s.handleTrace)
+	if s.cluster != nil {
+		mux.HandleFunc("/cluster/file", s.handleClusterFile)
+	}
+	return s.AdminAuth(mux)
+}
+
+// AdminAuth requires a credential for the requests handled by next, but
+// for the /healthz and /readyz probes: the AdminToken bearer token if it
+// is set, a client certificate verified by the TLS configuration (mutual
+// TLS) otherwise. Without either every request is refused, the admin API
+// can't be left open by mistake.
+func (s *TFTPServer) AdminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" && r.URL.Path != "/readyz" && !s.adminAuthenticated(r) {
+			if s.AdminToken != "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="tftp-server"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *TFTPServer) adminAuthenticated(r *http.Request) bool {
+	if s.AdminToken != "" {
+		got := []byte(r.Header.Get("Authorization"))
+		want := []byte("Bearer " + s.AdminToken)
+		return subtle.ConstantTimeCompare(got, want) == 1
+	}
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+}
+
+// HealthHandler returns the liveness and readiness probes:
+//
+//	GET /healthz  200 as long as the process serves HTTP
+//	GET /readyz   200 once listening, self-tested and not in maintenance mode, 503 otherwise
+func (s *TFTPServer) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case s.Maintenance():
+			http.Error(w, "maintenance", http.StatusServiceUnavailable)
+		case !s.Ready():
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte("ok\n"))
+		}
+	})
+	return mux
+}
+
+// ServerStatus is the response of GET /status.
+type ServerStatus struct {
+	Build           BuildInfo        `json:"build"`
+	Started         time.Time        `json:"started"`
+	Uptime          float64          `json:"uptime_seconds"`
+	ListenAddr      string           `json:"listen_addr"`
+	Maintenance     bool             `json:"maintenance"`
+	ActiveTransfers int64            `json:"active_transfers"`
+	ActiveHandlers  int64            `json:"active_handlers"` // goroutines serving requests, see TFTPServer.ActiveHandlers
+	Transfers       map[string]int64 `json:"transfers"`
+}
+
+// ServerConfig is the response of GET /config.
+type ServerConfig struct {
+	Address            string  `json:"address"`
+	File               string  `json:"file"`
+	FileSize           int64   `json:"file_size"`
+	Retries            int     `json:"retries"`
+	Timeout            float64 `json:"timeout_seconds"`
+	LogLevel           string  `json:"log_level"`
+	DebugPackets       bool    `json:"debug_packets"`
+	DetailedTraceEvery int     `json:"detailed_trace_every"`
+	Capture            bool    `json:"capture"`
+	AccessLog          bool    `json:"access_log"`
+	SecurityLog        bool    `json:"security_log"`
+	AdminAuth          bool    `json:"admin_auth"` // by AdminToken, by client certificates otherwise
+}
+
+func (s *TFTPServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status := ServerStatus{
+		Build:           ReadBuildInfo(),
+		Maintenance:     s.Maintenance(),
+		ActiveTransfers: s.Metrics.activeTransfers.Load(),
+		ActiveHandlers:  s.ActiveHandlers(),
+		Transfers:       s.Metrics.transfers.Values(),
+	}
+	if atomic.LoadInt32(&s.serving) == 1 {
+		status.Started = s.started
+		status.Uptime = time.Since(s.started).Seconds()
+		status.ListenAddr = s.listenAddr.String()
+	}
+	writeJSONResponse(w, status)
+}
+
+func (s *TFTPServer) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSONResponse(w, ServerConfig{
+		Address:            s.address,
+		File:               s.currentFile(),
+		FileSize:           s.currentSize(),
+		Retries:            s.Retries,
+		Timeout:            s.Timeout.Seconds(),
+		LogLevel:           s.Logger.Level.String(),
+		DebugPackets:       s.DebugPackets,
+		DetailedTraceEvery: s.DetailedTraceEvery,
+		Capture:            s.Capture != nil,
+		AccessLog:          s.AccessLog != nil,
+		SecurityLog:        s.SecurityLog != nil,
+		AdminAuth:          s.AdminToken != "",
+	})
+}
+
+func (s *TFTPServer) handleTransfers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSONResponse(w, s.ActiveTransfers())
+}
+
+func (s *TFTPServer) handleCancelTransfer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.FormValue("id")
+	if id == "" {
+		http.Error(w, "missing transfer id", http.StatusBadRequest)
+		return
+	}
+	if !s.CancelTransfer(id) {
+		http.Error(w, "no such transfer", http.StatusNotFound)
+		return
+	}
+	writeJSONResponse(w, map[string]string{"cancelled": id})
+}
+
+func (s *TFTPServer) handleTopFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	n := 10
+	if v := r.FormValue("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 0 {
+			http.Error(w, "invalid n", http.StatusBadRequest)
+			return
+		}
+	}
+	by := r.FormValue("by")
+	if by != "" && by != "requests" && by != "bytes" {
+		http.Error(w, "by must be requests or bytes", http.StatusBadRequest)
+		return
+	}
+	writeJSONResponse(w, s.Metrics.TopFiles(n, by == "bytes"))
+}
+
+func (s *TFTPServer) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.Reload(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSONResponse(w, map[string]interface{}{"file": s.currentFile(), "size": s.currentSize()})
+}
+
+func (s *TFTPServer) handleSlots(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSONResponse(w, s.Slots())
+}
+
+func (s *TFTPServer) handleSlotAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.FormValue("slot")
+	if name == "" {
+		http.Error(w, "missing slot", http.StatusBadRequest)
+		return
+	}
+	var err error
+	if r.URL.Path == "/slots/activate" {
+		err = s.ActivateSlot(name)
+	} else if file := r.FormValue("file"); !s.publishable(file) {
+		http.Error(w, "file must be one of the slot files or under the root directory", http.StatusForbidden)
+		return
+	} else {
+		err = s.PublishSlot(name, file)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSONResponse(w, s.Slots())
+}
+
+// publishable reports whether the admin API may publish file in a slot:
+// the file already published in one of them (empty for the slot's own) or
+// a file of the Root directory tree, so that the API can't serve any file
+// the process can read.
+func (s *TFTPServer) publishable(file string) bool {
+	if file == "" {
+		return true
+	}
+	s.slots.mu.RLock()
+	for _, sl := range s.slots.s {
+		if sl.file == file {
+			s.slots.mu.RUnlock()
+			return true
+		}
+	}
+	s.slots.mu.RUnlock()
+	return s.Root != "" && !isRemoteSource(file) && !escapes(s.Root, file)
+}
+
+func (s *TFTPServer) handleCanary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	percent, err := strconv.Atoi(r.FormValue("percent"))
+	if err != nil {
+		http.Error(w, "invalid percent", http.StatusBadRequest)
+		return
+	}
+	if err := s.SetCanary(percent); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSONResponse(w, s.Slots())
+}
+
+func (s *TFTPServer) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		on, err := strconv.ParseBool(r.FormValue("enabled"))
+		if err != nil {
+			http.Error(w, "enabled must be a boolean", http.StatusBadRequest)
+			return
+		}
+		s.SetMaintenance(on)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSONResponse(w, map[string]bool{"maintenance": s.Maintenance()})
+}
+
+// defaultTraceTTL is how long a traceparent set through the admin API
+// applies if the request doesn't give a ttl.
+const defaultTraceTTL = 10 * time.Minute
+
+func (s *TFTPServer) handleTrace(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ip := net.ParseIP(r.FormValue("ip"))
+	if ip == nil {
+		http.Error(w, "invalid ip", http.StatusBadRequest)
+		return
+	}
+	ttl := defaultTraceTTL
+	if v := r.FormValue("ttl"); v != "" {
+		var err error
+		if ttl, err = time.ParseDuration(v); err != nil || ttl <= 0 {
+			http.Error(w, "invalid ttl", http.StatusBadRequest)
+			return
+		}
+	}
+	traceparent := r.FormValue("traceparent")
+	if err := s.SetTraceParent(ip.String(), traceparent, ttl); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSONResponse(w, map[string]interface{}{"ip": ip.String(), "traceparent": traceparent, "ttl_seconds": ttl.Seconds()})
+}
+
+func writeJSONResponse(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errBusy         = errors.New("server busy")
+	errClientLimit  = errors.New("too many transfers for this client")
+	errMemory       = errors.New("memory budget exhausted")
+	errInProgress   = errors.New("request already in progress")
+	errQueueTimeout = errors.New("queued for too long")
+)
+
+// rejectReason is the label of the rejections metric for an admission error.
+func rejectReason(err error) string {
+	switch err {
+	case errQueueTimeout:
+		return "queue_timeout"
+	case errClientLimit:
+		return "client_limit"
+	case errMemory:
+		return "memory"
+	}
+	return "busy"
+}
+
+// admission bounds the number of transfers running at once. Requests over
+// the limit wait in a bounded queue for a transfer to finish, which smooths
+// out boot storms where hundreds of clients ask at the same time. The queue
+// is ordered by priority (see PriorityClass), then by arrival.
+type admission struct {
+	mu      sync.Mutex
+	running int
+	queue   []*ticket
+	clients map[string]bool // client addresses queued or transferring, their retransmitted requests are dropped
+	hosts   map[string]int  // transfers running or queued by client IP
+}
+
+// admissionLimits are the limits a request is admitted with, see TFTPServer.MaxTransfers.
+type admissionLimits struct {
+	transfers, perClient, queue int
+}
+
+// ticket is a queued request, ready is closed once it was granted a slot.
+type ticket struct {
+	client   string
+	host     string
+	priority int
+	ready    chan struct{}
+	queued   time.Time
+}
+
+// enter reserves a transfer slot for client, whose IP is host. It returns a
+// nil ticket if the transfer can start right away, a ticket to wait on if it
+// was queued, errBusy if the queue is full, errClientLimit if host holds too
+// many transfers already and errInProgress for a request retransmitted
+// while the first one is queued or transferring: the client address, its
+// TID, is the same.
+func (a *admission) enter(client, host string, priority int, limits admissionLimits) (*ticket, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.clients[client] {
+		return nil, errInProgress
+	}
+	if limits.perClient > 0 && a.hosts[host] >= limits.perClient {
+		return nil, errClientLimit
+	}
+	if limits.transfers > 0 && a.running >= limits.transfers {
+		if len(a.queue) >= limits.queue {
+			return nil, errBusy
+		}
+		t := &ticket{client: client, host: host, priority: priority, ready: make(chan struct{}), queued: time.Now()}
+		i := len(a.queue)
+		for i > 0 && a.queue[i-1].priority < priority {
+			i--
+		}
+		a.queue = append(a.queue, nil)
+		copy(a.queue[i+1:], a.queue[i:])
+		a.queue[i] = t
+		a.addClient(client, host)
+		return t, nil
+	}
+	a.running++
+	a.addClient(client, host)
+	return nil, nil
+}
+
+func (a *admission) addClient(client, host string) {
+	if a.clients == nil {
+		a.clients = make(map[string]bool)
+	}
+	a.clients[client] = true
+	a.addHost(host, 1)
+}
+
+func (a *admission) addHost(host string, n int) {
+	if a.hosts == nil {
+		a.hosts = make(map[string]int)
+	}
+	a.hosts[host] += n
+	if a.hosts[host] == 0 {
+		delete(a.hosts, host)
+	}
+}
+
+// wait blocks until t is granted a slot or timeout elapses, in which case it
+// leaves the queue and returns false.
+func (a *admission) wait(t *ticket, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-t.ready:
+		return true
+	case <-timer.C:
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i, q := range a.queue {
+		if q == t {
+			a.queue = append(a.queue[:i], a.queue[i+1:]...)
+			delete(a.clients, t.client)
+			a.addHost(t.host, -1)
+			return false
+		}
+	}
+	// granted while the timer fired
+	return true
+}
+
+// leave releases the slot of a finished transfer of client, whose IP is
+// host, handing it to the first queued request.
+func (a *admission) leave(client, host string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.clients, client)
+	a.addHost(host, -1)
+	if len(a.queue) == 0 {
+		a.running--
+		return
+	}
+	t := a.queue[0]
+	a.queue = a.queue[1:]
+	close(t.ready)
+}
+
+// admit grants their slot to the queued requests while fewer than
+// transfers are running (all of them if transfers is 0), for a limit raised
+// while they wait.
+func (a *admission) admit(transfers int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for len(a.queue) > 0 && (transfers <= 0 || a.running < transfers) {
+		t := a.queue[0]
+		a.queue = a.queue[1:]
+		a.running++
+		close(t.ready)
+	}
+}
+
+// enterAdmission reserves a transfer slot for a request of client for
+// filename with the server limits, key identifies the request among the
+// queued ones (see admission.enter). Once admitted, the transfer must call
+// s.admission.leave(key, clientHost(client)) when it is done. Requests are
+// refused with errMemory while the server is over its MemoryBudget.
+func (s *TFTPServer) enterAdmission(client net.Addr, key, filename string) (*ticket, error) {
+	if s.overMemoryBudget() {
+		return nil, errMemory
+	}
+	priority := 0
+	if class := s.classify(client, filename); class != nil {
+		priority = class.Priority
+	}
+	settings := s.settings()
+	limits := admissionLimits{transfers: settings.MaxTransfers, perClient: settings.MaxTransfersPerClient, queue: settings.QueueSize}
+	return s.admission.enter(key, clientHost(client), priority, limits)
+}
+
+// waitAdmission waits for the queued ticket t to be granted a transfer slot,
+// it returns false if it wasn't within QueueTimeout.
+func (s *TFTPServer) waitAdmission(t *ticket) bool {
+	s.Metrics.queuedRequests.Inc()
+	defer s.Metrics.queuedRequests.Dec()
+	admitted := s.admission.wait(t, s.settings().QueueTimeout)
+	s.Metrics.queueWait.Observe(time.Since(t.queued).Seconds())
+	return admitted
+}
+
+// PriorityClass ranks the requests of some clients or filenames in the
+// admission queue, so that production devices aren't starved by a lab
+// reimaging job. A request belongs to the class if its client is in one of
+// Networks or its filename matches one of Files.
+type PriorityClass struct {
+	Name     string
+	Priority int          // higher priorities are served first, requests out of any class have 0
+	Networks []*net.IPNet // client networks
+	Files    []string     // path.Match patterns of the requested filename
+	Rate     int64        // bytes per second of each transfer, overriding TFTPServer.TransferRate if not 0
+}
+
+func (c *PriorityClass) matches(ip net.IP, filename string) bool {
+	for _, n := range c.Networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	for _, pattern := range c.Files {
+		if ok, _ := path.Match(pattern, strings.TrimLeft(filename, "/")); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// classify returns the matching PriorityClass of highest priority, nil if none matches.
+func (s *TFTPServer) classify(client net.Addr, filename string) *PriorityClass {
+	ip := net.ParseIP(clientHost(client))
+	var class *PriorityClass
+	for i := range s.PriorityClasses {
+		c := &s.PriorityClasses[i]
+		if (class == nil || c.Priority > class.Priority) && c.matches(ip, filename) {
+			class = c
+		}
+	}
+	return class
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// bootArchs maps the well-known network bootloader filenames to the
+// architecture they are built for, named like the GRUB platforms.
+var bootArchs = map[string]string{
+	"pxelinux.0":      "bios",
+	"lpxelinux.0":     "bios",
+	"gpxelinux.0":     "bios",
+	"undionly.kpxe":   "bios",
+	"ipxe.pxe":        "bios",
+	"ipxe.kpxe":       "bios",
+	"bootia32.efi":    "i386-efi",
+	"grubia32.efi":    "i386-efi",
+	"shimia32.efi":    "i386-efi",
+	"bootx64.efi":     "x86_64-efi",
+	"grubx64.efi":     "x86_64-efi",
+	"shimx64.efi":     "x86_64-efi",
+	"mmx64.efi":       "x86_64-efi",
+	"syslinux.efi":    "x86_64-efi",
+	"snponly.efi":     "x86_64-efi",
+	"ipxe.efi":        "x86_64-efi",
+	"bootaa64.efi":    "arm64-efi",
+	"grubaa64.efi":    "arm64-efi",
+	"shimaa64.efi":    "arm64-efi",
+	"mmaa64.efi":      "arm64-efi",
+	"bootriscv64.efi": "riscv64-efi",
+	"grubriscv64.efi": "riscv64-efi",
+}
+
+// BootArch returns the architecture of a well-known bootloader filename
+// (bios, i386-efi, x86_64-efi, arm64-efi or riscv64-efi), ok is false for
+// other files.
+func BootArch(filename string) (arch string, ok bool) {
+	arch, ok = bootArchs[bootName(filename)]
+	return arch, ok
+}
+
+func bootName(filename string) string {
+	return strings.ToLower(path.Base(strings.ReplaceAll(filename, "\\", "/")))
+}
+
+// archFile returns the file serving a well-known bootloader from the
+// ArchRoots directory of its architecture, under its lowercase name.
+func (s *TFTPServer) archFile(filename string) (string, bool) {
+	arch, ok := BootArch(filename)
+	if !ok {
+		return "", false
+	}
+	root, ok := s.ArchRoots[arch]
+	if !ok {
+		return "", false
+	}
+	return filepath.Join(root, bootName(filename)), true
+}
+
+// Rewrite replaces the requested filenames matching Pattern, expanding the
+// $1 style references of Replacement (see regexp.Regexp.ReplaceAllString).
+type Rewrite struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// LoadRewrites reads rewrite rules from name, one "pattern replacement"
+// rule per line. Empty lines and lines starting with # are ignored.
+func LoadRewrites(name string) ([]Rewrite, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []Rewrite
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"pattern replacement\"", name, line)
+		}
+		re, err := regexp.Compile(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, line, err)
+		}
+		rules = append(rules, Rewrite{Pattern: re, Replacement: fields[1]})
+	}
+	return rules, scanner.Err()
+}
+
+// rewrite applies the first matching Rewrites rule to filename.
+func (s *TFTPServer) rewrite(filename string) string {
+	for _, r := range s.Rewrites {
+		if r.Pattern.MatchString(filename) {
+			return r.Pattern.ReplaceAllString(filename, r.Replacement)
+		}
+	}
+	return filename
+}
@@ -0,0 +1,56 @@
+package server
+
+import "net"
+
+// datagram is a datagram read by a batchReader.
+type datagram struct {
+	buf  [readBufferSize]byte
+	n    int
+	addr net.Addr
+}
+
+// batchReader reads the requests sent to the listener, several at once
+// where the platform can (recvmmsg on Linux).
+type batchReader interface {
+	read() ([]datagram, error)
+}
+
+// singleReader reads one datagram per call.
+type singleReader struct {
+	conn net.PacketConn
+	d    [1]datagram
+}
+
+func (r *singleReader) read() ([]datagram, error) {
+	n, addr, err := r.conn.ReadFrom(r.d[0].buf[:])
+	if err != nil {
+		return nil, err
+	}
+	r.d[0].n, r.d[0].addr = n, addr
+	return r.d[:], nil
+}
+
+// sendBatch is the number of DATA packets of a window sent per call by a
+// batchWriter.
+const sendBatch = 16
+
+// batchWriter sends the DATA packets of a window on the connected socket
+// of a transfer, several per system call where the platform can
+// (sendmmsg on Linux).
+type batchWriter interface {
+	write(packets [][]byte) error
+}
+
+// singleWriter sends one datagram per system call.
+type singleWriter struct {
+	conn net.Conn
+}
+
+func (w singleWriter) write(packets [][]byte) error {
+	for _, p := range packets {
+		if _, err := w.conn.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
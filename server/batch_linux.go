@@ -0,0 +1,169 @@
+//go:build linux
+
+package server
+
+import (
+	"net"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// mmsghdr is the struct mmsghdr of recvmmsg(2).
+type mmsghdr struct {
+	hdr unix.Msghdr
+	len uint32
+}
+
+// mmsgReader reads up to len(hdrs) datagrams per recvmmsg system call.
+type mmsgReader struct {
+	conn  syscall.RawConn
+	d     []datagram
+	hdrs  []mmsghdr
+	iovs  []unix.Iovec
+	names []unix.RawSockaddrAny
+}
+
+// newBatchReader returns a reader of size datagrams at once, or one at a
+// time if size is below 2 or conn isn't a UDP socket.
+func newBatchReader(conn net.PacketConn, size int) batchReader {
+	udp, ok := conn.(*net.UDPConn)
+	if !ok || size < 2 {
+		return &singleReader{conn: conn}
+	}
+	raw, err := udp.SyscallConn()
+	if err != nil {
+		return &singleReader{conn: conn}
+	}
+	r := &mmsgReader{
+		conn:  raw,
+		d:     make([]datagram, size),
+		hdrs:  make([]mmsghdr, size),
+		iovs:  make([]unix.Iovec, size),
+		names: make([]unix.RawSockaddrAny, size),
+	}
+	for i := range r.hdrs {
+		r.iovs[i].Base = &r.d[i].buf[0]
+		r.iovs[i].SetLen(readBufferSize)
+		r.hdrs[i].hdr.Iov = &r.iovs[i]
+		r.hdrs[i].hdr.SetIovlen(1)
+		r.hdrs[i].hdr.Name = (*byte)(unsafe.Pointer(&r.names[i]))
+	}
+	return r
+}
+
+func (r *mmsgReader) read() ([]datagram, error) {
+	for i := range r.hdrs {
+		r.hdrs[i].hdr.Namelen = unix.SizeofSockaddrAny
+	}
+	var (
+		n     uintptr
+		errno syscall.Errno
+	)
+	err := r.conn.Read(func(fd uintptr) bool {
+		n, _, errno = unix.Syscall6(unix.SYS_RECVMMSG, fd, uintptr(unsafe.Pointer(&r.hdrs[0])), uintptr(len(r.hdrs)), unix.MSG_DONTWAIT, 0, 0)
+		return errno != unix.EAGAIN && errno != unix.EWOULDBLOCK // wait for the socket to be readable
+	})
+	if err != nil {
+		return nil, err
+	}
+	if errno != 0 {
+		return nil, &net.OpError{Op: "recvmmsg", Net: "udp", Err: errno}
+	}
+	d := r.d[:n]
+	for i := range d {
+		d[i].n = int(r.hdrs[i].len)
+		d[i].addr = sockaddrUDP(&r.names[i])
+	}
+	return d, nil
+}
+
+// mmsgWriter sends up to len(hdrs) datagrams per sendmmsg system call on
+// a connected socket.
+type mmsgWriter struct {
+	conn syscall.RawConn
+	hdrs []mmsghdr
+	iovs []unix.Iovec
+}
+
+// newBatchWriter returns a writer of size datagrams at once, or one at a
+// time if size is below 2 or conn isn't a UDP socket (the connections of
+// the single port transfers).
+func newBatchWriter(conn net.Conn, size int) batchWriter {
+	udp, ok := conn.(*net.UDPConn)
+	if !ok || size < 2 {
+		return singleWriter{conn: conn}
+	}
+	raw, err := udp.SyscallConn()
+	if err != nil {
+		return singleWriter{conn: conn}
+	}
+	w := &mmsgWriter{
+		conn: raw,
+		hdrs: make([]mmsghdr, size),
+		iovs: make([]unix.Iovec, size),
+	}
+	for i := range w.hdrs {
+		w.hdrs[i].hdr.Iov = &w.iovs[i]
+		w.hdrs[i].hdr.SetIovlen(1)
+	}
+	return w
+}
+
+func (w *mmsgWriter) write(packets [][]byte) error {
+	for len(packets) > 0 {
+		n := len(packets)
+		if n > len(w.hdrs) {
+			n = len(w.hdrs)
+		}
+		for i, p := range packets[:n] {
+			w.iovs[i].Base = &p[0]
+			w.iovs[i].SetLen(len(p))
+		}
+		var (
+			sent  uintptr
+			errno syscall.Errno
+		)
+		err := w.conn.Write(func(fd uintptr) bool {
+			sent, _, errno = unix.Syscall6(unix.SYS_SENDMMSG, fd, uintptr(unsafe.Pointer(&w.hdrs[0])), uintptr(n), unix.MSG_DONTWAIT, 0, 0)
+			return errno != unix.EAGAIN && errno != unix.EWOULDBLOCK // wait for the socket to be writable
+		})
+		if err != nil {
+			return err
+		}
+		if errno != 0 {
+			return &net.OpError{Op: "sendmmsg", Net: "udp", Err: errno}
+		}
+		packets = packets[sent:]
+	}
+	return nil
+}
+
+// sockaddrUDP converts the address of a received datagram.
+func sockaddrUDP(rsa *unix.RawSockaddrAny) *net.UDPAddr {
+	switch rsa.Addr.Family {
+	case unix.AF_INET:
+		sa := (*unix.RawSockaddrInet4)(unsafe.Pointer(rsa))
+		ip := make(net.IP, net.IPv4len)
+		copy(ip, sa.Addr[:])
+		return &net.UDPAddr{IP: ip, Port: networkPort(sa.Port)}
+	case unix.AF_INET6:
+		sa := (*unix.RawSockaddrInet6)(unsafe.Pointer(rsa))
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, sa.Addr[:])
+		addr := &net.UDPAddr{IP: ip, Port: networkPort(sa.Port)}
+		if sa.Scope_id != 0 {
+			addr.Zone = strconv.Itoa(int(sa.Scope_id))
+		}
+		return addr
+	}
+	return &net.UDPAddr{}
+}
+
+// networkPort returns the port p stored in network byte order.
+func networkPort(p uint16) int {
+	b := (*[2]byte)(unsafe.Pointer(&p))
+	return int(b[0])<<8 | int(b[1])
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package server
+
+import "net"
+
+func newBatchReader(conn net.PacketConn, size int) batchReader {
+	return &singleReader{conn: conn}
+}
+
+func newBatchWriter(conn net.Conn, size int) batchWriter {
+	return singleWriter{conn: conn}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// BootMenu is a high level description of boot entries, rendered as
+// pxelinux and GRUB menus served under PXELinuxPath and GRUBPath. Users
+// maintain a single readable file instead of syntax-fragile menu configs:
+//
+//	title: Lab boot menu
+//	timeout: 10
+//	default: debian
+//	entries:
+//	  - label: debian
+//	    name: Debian 12 installer
+//	    kernel: debian/linux
+//	    initrd: debian/initrd.gz
+//	    args: auto=true priority=critical
+type BootMenu struct {
+	Title        string      `yaml:"title"`
+	Timeout      int         `yaml:"timeout"` // seconds before booting Default, 0 waits forever
+	Default      string      `yaml:"default"` // label of the default entry
+	PXELinuxPath string      `yaml:"pxelinux_path"`
+	GRUBPath     string      `yaml:"grub_path"`
+	Entries      []BootEntry `yaml:"entries"`
+
+	pxelinux, grub []byte
+	loaded         time.Time
+}
+
+// BootEntry is a kernel with its initrd and command line.
+type BootEntry struct {
+	Label  string `yaml:"label"` // short identifier, made of letters, digits, - and _
+	Name   string `yaml:"name"`  // shown in the menu, Label if empty
+	Kernel string `yaml:"kernel"`
+	Initrd string `yaml:"initrd"`
+	Args   string `yaml:"args"`
+}
+
+// LoadBootMenu reads and renders the YAML boot menu file name. The menus
+// are served as pxelinux.cfg/default and grub/grub.cfg unless the file sets
+// other paths.
+func LoadBootMenu(name string) (*BootMenu, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	m := &BootMenu{PXELinuxPath: "pxelinux.cfg/default", GRUBPath: "grub/grub.cfg"}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(m); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	m.pxelinux = m.renderPXELinux()
+	m.grub = m.renderGRUB()
+	m.loaded = time.Now()
+	return m, nil
+}
+
+func (m *BootMenu) validate() error {
+	if len(m.Entries) == 0 {
+		return fmt.Errorf("no boot entries")
+	}
+	labels := make(map[string]bool)
+	for i, e := range m.Entries {
+		if e.Label == "" || strings.Trim(e.Label, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_") != "" {
+			return fmt.Errorf("entry %d: invalid label %q", i+1, e.Label)
+		}
+		if labels[e.Label] {
+			return fmt.Errorf("entry %d: duplicate label %q", i+1, e.Label)
+		}
+		labels[e.Label] = true
+		if e.Kernel == "" {
+			return fmt.Errorf("entry %s: no kernel", e.Label)
+		}
+		if strings.ContainsAny(e.Name+e.Kernel+e.Initrd+e.Args, "\r\n") {
+			return fmt.Errorf("entry %s: values must fit on one line", e.Label)
+		}
+	}
+	if m.Default != "" && !labels[m.Default] {
+		return fmt.Errorf("default: no entry labelled %q", m.Default)
+	}
+	if m.Timeout < 0 {
+		return fmt.Errorf("timeout: %d is negative", m.Timeout)
+	}
+	return nil
+}
+
+func (e BootEntry) name() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return e.Label
+}
+
+func (m *BootMenu) renderPXELinux() []byte {
+	var b bytes.Buffer
+	b.WriteString("# generated by tftp-server from the boot menu, do not edit\n")
+	b.WriteString("UI menu.c32\nPROMPT 0\n")
+	fmt.Fprintf(&b, "TIMEOUT %d\n", m.Timeout*10)
+	if m.Title != "" {
+		fmt.Fprintf(&b, "MENU TITLE %s\n", m.Title)
+	}
+	if m.Default != "" {
+		fmt.Fprintf(&b, "ONTIMEOUT %s\n", m.Default)
+	}
+	for _, e := range m.Entries {
+		fmt.Fprintf(&b, "\nLABEL %s\n  MENU LABEL %s\n", e.Label, e.name())
+		if e.Label == m.Default {
+			b.WriteString("  MENU DEFAULT\n")
+		}
+		fmt.Fprintf(&b, "  KERNEL %s\n", e.Kernel)
+		if e.Initrd != "" {
+			fmt.Fprintf(&b, "  INITRD %s\n", e.Initrd)
+		}
+		if e.Args != "" {
+			fmt.Fprintf(&b, "  APPEND %s\n", e.Args)
+		}
+	}
+	return b.Bytes()
+}
+
+func (m *BootMenu) renderGRUB() []byte {
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`)
+	var b bytes.Buffer
+	b.WriteString("# generated by tftp-server from the boot menu, do not edit\n")
+	if m.Timeout > 0 {
+		fmt.Fprintf(&b, "set timeout=%d\n", m.Timeout)
+	} else {
+		b.WriteString("set timeout=-1\n")
+	}
+	if m.Default != "" {
+		fmt.Fprintf(&b, "set default=\"%s\"\n", m.Default)
+	}
+	for _, e := range m.Entries {
+		fmt.Fprintf(&b, "\nmenuentry \"%s\" --id %s {\n", quote.Replace(e.name()), e.Label)
+		fmt.Fprintf(&b, "  linux %s\n", strings.TrimSpace(e.Kernel+" "+e.Args))
+		if e.Initrd != "" {
+			fmt.Fprintf(&b, "  initrd %s\n", e.Initrd)
+		}
+		b.WriteString("}\n")
+	}
+	return b.Bytes()
+}
+
+// menu returns the rendered menu served as filename, if any.
+func (m *BootMenu) menu(filename string) ([]byte, bool) {
+	switch strings.TrimLeft(filename, "/") {
+	case m.PXELinuxPath:
+		return m.pxelinux, true
+	case m.GRUBPath:
+		return m.grub, true
+	}
+	return nil, false
+}
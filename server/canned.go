@@ -0,0 +1,102 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The messages of the ERROR packets sent the most, refused requests during
+// boot storms in particular.
+const (
+	msgNotFound    = "file not found"
+	msgAccess      = "access violation"
+	msgBusy        = "server busy, try again later"
+	msgMaintenance = "server in maintenance, try again later"
+	msgTooLong     = "transfer took too long"
+	msgCancelled   = "transfer cancelled by the server"
+	msgNoUploads   = "uploads are not allowed"
+	msgDiskFull    = "disk full or quota exceeded"
+	msgWriteFailed = "could not write the file"
+	msgFileExists  = "file already exists"
+	msgReadFailed  = "could not read the file"
+	msgBadMode     = "unsupported transfer mode"
+	msgChanged     = "file changed, try again later"
+)
+
+// cannedErrors holds these ERROR packets encoded once, they are written as
+// is instead of being encoded for every send. They must not be modified.
+var cannedErrors = func() map[Err][]byte {
+	canned := make(map[Err][]byte)
+	for _, e := range []Err{
+		{Code: ErrIllegalOp},
+		{Code: ErrIllegalOp, Message: msgBadMode},
+		{Code: ErrNotFound, Message: msgNotFound},
+		{Code: ErrAccessViolation, Message: msgAccess},
+		{Code: ErrAccessViolation, Message: msgNoUploads},
+		{Code: ErrDiskFull, Message: msgDiskFull},
+		{Code: ErrUnknown, Message: msgWriteFailed},
+		{Code: ErrFileExists, Message: msgFileExists},
+		{Code: ErrUnknown, Message: msgReadFailed},
+		{Code: ErrUnknown, Message: msgChanged},
+		{Code: ErrUnknown, Message: msgBusy},
+		{Code: ErrUnknown, Message: msgMaintenance},
+		{Code: ErrUnknown, Message: msgTooLong},
+		{Code: ErrUnknown, Message: msgCancelled},
+	} {
+		canned[e], _ = e.MarshalBinary()
+	}
+	return canned
+}()
+
+// LoadErrorMessages reads a catalog of ERROR messages by code from the
+// YAML file name, for TFTPServer.ErrorMessages:
+//
+//	1: "Datei nicht gefunden"
+//	2: "Zugriff verweigert"
+func LoadErrorMessages(name string) (map[ErrCode]string, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var messages map[ErrCode]string
+	if err := yaml.Unmarshal(b, &messages); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	for code := range messages {
+		if code > ErrOptions {
+			return nil, fmt.Errorf("%s: unknown error code %d", name, code)
+		}
+	}
+	return messages, nil
+}
+
+// errorMessage returns the message sent to client with an ERROR of code,
+// message unless the profile of the client or the server ErrorMessages
+// override it.
+func (s *TFTPServer) errorMessage(client net.Addr, code ErrCode, message string) string {
+	if len(s.Profiles) > 0 {
+		if p := s.profileFor(client); p != nil {
+			if m, ok := p.ErrorMessages[code]; ok {
+				return m
+			}
+		}
+	}
+	if m, ok := s.ErrorMessages[code]; ok {
+		return m
+	}
+	return message
+}
+
+// errorPacket returns the ERROR packet of code and message, canned if it
+// is a common one.
+func errorPacket(code ErrCode, message string) []byte {
+	e := Err{Code: code, Message: message}
+	if b, ok := cannedErrors[e]; ok {
+		return b
+	}
+	b, _ := e.MarshalBinary()
+	return b
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cluster shares the files fetched from remote origins (http(s) URLs given as
+// the file of a slot) between server instances: a file is looked up in the
+// local cache, then on the peers, and only then fetched from its origin, after
+// what it is pushed to the peers so they are warm when they need it.
+//
+// Peers talk to each other through the admin API (AdminHandler serves the
+// cluster endpoints once the server joined a cluster), authenticated with
+// AdminToken.
+type Cluster struct {
+	// Peers are the base URLs of the admin API of the other instances.
+	Peers []string
+
+	// MaxBytes bounds the size of the cache, the least recently used files are evicted.
+	MaxBytes int64
+
+	// Client is used for the peers and the origins, it defaults to a client with a 30s timeout.
+	Client *http.Client
+
+	mu    sync.Mutex
+	size  int64
+	lru   *list.List // of *cachedFile, most recently used first
+	files map[string]*list.Element
+}
+
+type cachedFile struct {
+	source  string
+	content []byte
+}
+
+func (c *Cluster) client() *http.Client {
+	if c != nil && c.Client != nil {
+		return c.Client
+	}
+	return &http.Client{Timeout: 30 * time.Second}
+}
+
+func (c *Cluster) get(source string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.files[source]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*cachedFile).content, true
+}
+
+func (c *Cluster) put(source string, content []byte) {
+	if int64(len(content)) > c.MaxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.files == nil {
+		c.files = make(map[string]*list.Element)
+		c.lru = list.New()
+	}
+	if e, ok := c.files[source]; ok {
+		c.size -= int64(len(e.Value.(*cachedFile).content))
+		c.lru.Remove(e)
+	}
+	c.files[source] = c.lru.PushFront(&cachedFile{source: source, content: content})
+	c.size += int64(len(content))
+	for c.size > c.MaxBytes {
+		oldest := c.lru.Back()
+		f := oldest.Value.(*cachedFile)
+		c.lru.Remove(oldest)
+		delete(c.files, f.source)
+		c.size -= int64(len(f.content))
+	}
+}
+
+// JoinCluster makes the server share its remote files with the cluster c,
+// the ones already loaded in the slots are cached right away.
+func (s *TFTPServer) JoinCluster(c *Cluster) {
+	s.slots.mu.RLock()
+	for _, sl := range s.slots.s {
+		if isRemoteSource(sl.file) {
+			c.put(sl.file, sl.payload)
+		}
+	}
+	s.slots.mu.RUnlock()
+	s.cluster = c
+}
+
+func isRemoteSource(file string) bool {
+	return strings.HasPrefix(file, "http://") || strings.HasPrefix(file, "https://")
+}
+
+// readSource returns the content of a slot file: a local path or an http(s)
+// origin URL, looked up in the cluster first if there is one.
+func (s *TFTPServer) readSource(file string) ([]byte, error) {
+	if !isRemoteSource(file) {
+		return ioutil.ReadFile(file)
+	}
+	c := s.cluster
+	if c != nil {
+		if p, ok := c.get(file); ok {
+			return p, nil
+		}
+		for _, peer := range c.Peers {
+			p, err := s.peerRequest(http.MethodGet, peer, file, nil)
+			if err == nil {
+				s.Logger.Debug("file fetched from peer", "source", file, "peer", peer, "size", len(p))
+				c.put(file, p)
+				return p, nil
+			}
+		}
+	}
+
+	p, err := fetchOrigin(c.client(), file)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		c.put(file, p)
+		go func() {
+			for _, peer := range c.Peers {
+				if _, err := s.peerRequest(http.MethodPut, peer, file, p); err != nil {
+					s.Logger.Warn("pushing file to peer", "source", file, "peer", peer, "error", err)
+				}
+			}
+		}()
+	}
+	return p, nil
+}
+
+func fetchOrigin(client *http.Client, source string) ([]byte, error) {
+	resp, err := client.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", source, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// peerRequest gets (body nil) or pushes a cached file on a peer. A file
+// larger than the cache is refused, as the peers would have.
+func (s *TFTPServer) peerRequest(method, peer, source string, body []byte) ([]byte, error) {
+	u := strings.TrimSuffix(peer, "/") + "/cluster/file?source=" + url.QueryEscape(source)
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if s.AdminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.AdminToken)
+	}
+	resp, err := s.cluster.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s: %s", method, peer, resp.Status)
+	}
+	p, err := ioutil.ReadAll(io.LimitReader(resp.Body, s.cluster.MaxBytes+1))
+	if err == nil && int64(len(p)) > s.cluster.MaxBytes {
+		return nil, fmt.Errorf("%s %s: file over %d bytes", method, peer, s.cluster.MaxBytes)
+	}
+	return p, err
+}
+
+func (s *TFTPServer) handleClusterFile(w http.ResponseWriter, r *http.Request) {
+	source := r.FormValue("source")
+	if source == "" {
+		http.Error(w, "missing source", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		p, ok := s.cluster.get(source)
+		if !ok {
+			http.Error(w, "not cached", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(p)
+	case http.MethodPut:
+		p, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, s.cluster.MaxBytes))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		s.cluster.put(source, p)
+		s.Logger.Debug("file pushed by peer", "source", source, "peer", r.RemoteAddr, "size", len(p))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
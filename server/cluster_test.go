@@ -0,0 +1,153 @@
+package server_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+const clusterCacheSize = 64 << 10
+
+// clusterNode returns a server of a cluster with peers, and its admin API.
+func clusterNode(t *testing.T, token string, peers ...string) (*server.TFTPServer, *httptest.Server) {
+	t.Helper()
+	s, err := server.NewTFTPServer("127.0.0.1", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Logger = server.NewLogger(io.Discard, server.LevelError)
+	s.AdminToken = token
+	s.JoinCluster(&server.Cluster{Peers: peers, MaxBytes: clusterCacheSize})
+	admin := httptest.NewServer(s.AdminHandler())
+	t.Cleanup(admin.Close)
+	return s, admin
+}
+
+// clusterFile requests the cached copy of source from the admin API at
+// base, body nil, or pushes body.
+func clusterFile(t *testing.T, base, source string, body []byte) (int, []byte) {
+	t.Helper()
+	method := http.MethodGet
+	if body != nil {
+		method = http.MethodPut
+	}
+	req, _ := http.NewRequest(method, base+"/cluster/file?source="+url.QueryEscape(source), bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer token")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	p, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, p
+}
+
+// origin serves content for every path and counts the requests.
+type origin struct {
+	*httptest.Server
+	requests int64
+}
+
+func newOrigin(t *testing.T, content []byte) *origin {
+	o := &origin{}
+	o.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&o.requests, 1)
+		w.Write(content)
+	}))
+	t.Cleanup(o.Close)
+	return o
+}
+
+func TestCluster(t *testing.T) {
+	content := randomContent(10 * server.BlockSize)
+	o := newOrigin(t, content)
+	source := o.URL + "/images/pxelinux.0"
+	_, peer := clusterNode(t, "token")
+
+	// missing everywhere: fetched from the origin and pushed to the peer
+	s, _ := clusterNode(t, "token", peer.URL)
+	if err := s.PublishSlot("A", source); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&o.requests); n != 1 {
+		t.Fatalf("%d requests to the origin, want 1", n)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		code, p := clusterFile(t, peer.URL, source, nil)
+		if code == http.StatusOK {
+			if !bytes.Equal(p, content) {
+				t.Fatalf("the peer cached %d bytes differing from the %d bytes of the origin", len(p), len(content))
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the file wasn't pushed to the peer: %d", code)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// cached on the peer: the origin isn't requested again
+	s, admin := clusterNode(t, "token", peer.URL)
+	if err := s.PublishSlot("A", source); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt64(&o.requests); n != 1 {
+		t.Errorf("%d requests to the origin, want 1", n)
+	}
+	if code, p := clusterFile(t, admin.URL, source, nil); code != http.StatusOK || !bytes.Equal(p, content) {
+		t.Errorf("status %d, %d bytes cached, want the %d bytes of the origin", code, len(p), len(content))
+	}
+
+	// a file larger than the cache isn't taken
+	other := o.URL + "/images/big.iso"
+	if code, _ := clusterFile(t, peer.URL, other, make([]byte, clusterCacheSize+1)); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("PUT of a file over the cache size: status %d, want %d", code, http.StatusRequestEntityTooLarge)
+	}
+	if code, _ := clusterFile(t, peer.URL, other, nil); code != http.StatusNotFound {
+		t.Errorf("GET of a file refused: status %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestClusterPeerFailures(t *testing.T) {
+	content := randomContent(10 * server.BlockSize)
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "failing", http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	oversized := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 2*clusterCacheSize))
+	}))
+	defer oversized.Close()
+	// a peer of another cluster
+	_, locked := clusterNode(t, "other-token")
+
+	for name, peer := range map[string]string{
+		"error":        failing.URL,
+		"oversized":    oversized.URL,
+		"unauthorized": locked.URL,
+		"unreachable":  "http://127.0.0.1:1",
+	} {
+		t.Run(name, func(t *testing.T) {
+			o := newOrigin(t, content)
+			source := o.URL + "/pxelinux.0"
+			s, admin := clusterNode(t, "token", peer)
+			if err := s.PublishSlot("A", source); err != nil {
+				t.Fatal(err)
+			}
+			if n := atomic.LoadInt64(&o.requests); n != 1 {
+				t.Errorf("%d requests to the origin, want 1", n)
+			}
+			if code, p := clusterFile(t, admin.URL, source, nil); code != http.StatusOK || !bytes.Equal(p, content) {
+				t.Errorf("status %d, %d bytes cached, want the %d bytes of the origin", code, len(p), len(content))
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"path"
+	"strings"
+	"time"
+)
+
+var errNoSuchFile = errors.New("no such file")
+
+// contentFor returns the content served to client for filename, after the
+// Rewrites, and the name of its slot if it comes from one. The errors
+// wrapping an Err are sent as is to the client, the others as file not found.
+func (s *TFTPServer) contentFor(logger *Logger, client net.Addr, filename string, options map[string]string) (slot, string, error) {
+	filename = s.rewrite(filename)
+	var vars map[string]string
+	record, err := s.inventoryRecord(logger, client, filename)
+	if err != nil {
+		return slot{}, "", err
+	}
+	if record != nil {
+		if record.File != "" {
+			return s.sourceContent(record.File)
+		}
+		vars = record.Vars
+	}
+	profile := s.profileFor(client)
+	pxeMapper, templates := s.PXEMapper, s.Templates
+	if profile != nil {
+		if profile.PXEMapper != nil {
+			pxeMapper = profile.PXEMapper
+		}
+		templates = append(profile.Templates[:len(profile.Templates):len(profile.Templates)], templates...)
+	}
+	if s.BootMenu != nil {
+		if p, ok := s.BootMenu.menu(filename); ok {
+			return slot{file: "boot menu", payload: p, loaded: s.BootMenu.loaded}, "", nil
+		}
+	}
+	if pxeMapper != nil {
+		if req, ok := ParsePXERequest(filename); ok {
+			file, ok := pxeMapper(req, client)
+			if !ok {
+				return slot{}, "", errNoSuchFile
+			}
+			return s.sourceContent(file)
+		}
+	}
+	if s.IPXE != nil && s.IPXE.matches(filename) {
+		p, err := s.IPXE.render(client, filename, options, vars)
+		if err != nil {
+			return slot{}, "", err
+		}
+		return slot{file: "ipxe:" + s.IPXE.Template.Name(), payload: p, loaded: time.Now()}, "", nil
+	}
+	for i := range templates {
+		if t := &templates[i]; t.matches(filename) {
+			p, err := t.render(client, filename, options, vars)
+			if err != nil {
+				return slot{}, "", err
+			}
+			return slot{file: "template:" + t.Template.Name(), payload: p, loaded: time.Now()}, "", nil
+		}
+	}
+	if file, ok := s.settings().Aliases[strings.TrimPrefix(path.Clean("/"+filename), "/")]; ok {
+		return s.sourceContent(file)
+	}
+	if file, ok := s.archFile(filename); ok {
+		return s.sourceContent(file)
+	}
+	if file, ok, err := s.latestFile(filename); ok {
+		if err != nil {
+			return slot{}, "", err
+		}
+		return s.sourceContent(file)
+	}
+	if file, ok, err := s.fallbackFile(client, filename); ok {
+		if err != nil {
+			return slot{}, "", err
+		}
+		return s.sourceContent(file)
+	}
+	if profile != nil && profile.File != "" {
+		return profile.content, "", nil
+	}
+	if s.ReadHandler != nil {
+		return s.handlerContent(client, filename)
+	}
+	if s.FS != nil {
+		return s.fsContent(filename)
+	}
+	if s.Root != "" {
+		file, err := s.rootFile(logger, client, filename)
+		if err != nil {
+			return slot{}, "", err
+		}
+		return s.sourceContent(file)
+	}
+	served, name := s.payloadFor(client)
+	if served.file == "" {
+		return slot{}, "", errNoSuchFile // no file, only the other sources
+	}
+	return served, name, nil
+}
+
+// sourceContent reads file, a path or a URL (see readSource), unless it is
+// streamed (see StreamThreshold). Its payload is shared with the other
+// transfers of file, the caller must call done.
+func (s *TFTPServer) sourceContent(file string) (slot, string, error) {
+	if sl, ok, err := s.streamed(file); ok {
+		return sl, "", err
+	}
+	p, release, err := s.shared.load(file, s.readSource)
+	if err != nil {
+		return slot{}, "", err
+	}
+	return slot{file: file, payload: p, loaded: time.Now(), release: release}, "", nil
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+)
+
+// describePacket returns a one line summary of a raw TFTP datagram.
+func describePacket(b []byte) string {
+	if len(b) < 2 {
+		return fmt.Sprintf("short packet (%d bytes)", len(b))
+	}
+
+	code := Opcode(binary.BigEndian.Uint16(b[:2]))
+	switch code {
+	case ReadOp, WriteOp:
+		name := "RRQ"
+		if code == WriteOp {
+			name = "WRQ"
+		}
+		fields := strings.Split(strings.TrimRight(string(b[2:]), "\x00"), "\x00")
+		return fmt.Sprintf("%s %q", name, fields)
+	case DataOp:
+		if len(b) < 4 {
+			break
+		}
+		return fmt.Sprintf("DATA block=%d len=%d", binary.BigEndian.Uint16(b[2:4]), len(b)-4)
+	case AcknowledgmentOp:
+		if len(b) < 4 {
+			break
+		}
+		return fmt.Sprintf("ACK block=%d", binary.BigEndian.Uint16(b[2:4]))
+	case ErrorOp:
+		if len(b) < 4 {
+			break
+		}
+		return fmt.Sprintf("ERROR code=%d message=%q", binary.BigEndian.Uint16(b[2:4]), strings.TrimRight(string(b[4:]), "\x00"))
+	case OptionAckOp:
+		return fmt.Sprintf("OACK %q", strings.Split(strings.TrimRight(string(b[2:]), "\x00"), "\x00"))
+	default:
+		return fmt.Sprintf("unknown opcode %d (%d bytes)", code, len(b))
+	}
+	return fmt.Sprintf("malformed %d packet (%d bytes)", code, len(b))
+}
+
+// packetKind classifies a raw datagram for the per-opcode counters: the
+// lowercase opcode name, "malformed" when it is too short or not terminated
+// as its opcode requires, or "unknown".
+func packetKind(b []byte) string {
+	if len(b) < 2 {
+		return "malformed"
+	}
+	var ok bool
+	kind := "unknown"
+	switch Opcode(binary.BigEndian.Uint16(b[:2])) {
+	case ReadOp, WriteOp:
+		kind = "rrq"
+		if Opcode(b[1]) == WriteOp {
+			kind = "wrq"
+		}
+		ok = len(b) > 2 && b[len(b)-1] == 0 && bytes.Count(b[2:], []byte{0}) >= 2
+	case DataOp:
+		kind, ok = "data", len(b) >= 4 && len(b) <= 4+MaxBlockSize
+	case AcknowledgmentOp:
+		kind, ok = "ack", len(b) >= 4
+	case ErrorOp:
+		kind, ok = "error", len(b) >= 5 && b[len(b)-1] == 0
+	default:
+		return kind
+	}
+	if !ok {
+		return "malformed"
+	}
+	return kind
+}
+
+// debugPacket logs a summary and a hexdump of a datagram sent to (or received from) peer
+// when packet debugging is enabled for that peer or forced for a detailed transfer.
+func (s *TFTPServer) debugPacket(logger *Logger, direction string, peer net.Addr, b []byte, force bool) {
+	if !force && (!s.DebugPackets || !s.debugPacketsFor(peer)) {
+		return
+	}
+	logger.Info("packet "+direction, "peer", peer, "packet", describePacket(b), "hexdump", hex.Dump(b))
+}
+
+// detailedTrace reports whether the next transfer should be traced in full,
+// one in every DetailedTraceEvery transfers is.
+func (s *TFTPServer) detailedTrace() bool {
+	if s.DetailedTraceEvery <= 0 {
+		return false
+	}
+	return atomic.AddUint64(&s.tracedTransfers, 1)%uint64(s.DetailedTraceEvery) == 0
+}
+
+func (s *TFTPServer) debugPacketsFor(peer net.Addr) bool {
+	if len(s.DebugPacketsClients) == 0 {
+		return true
+	}
+	ip := net.ParseIP(clientHost(peer))
+	for _, c := range s.DebugPacketsClients {
+		if c.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
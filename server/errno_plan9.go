@@ -0,0 +1,11 @@
+//go:build plan9
+
+package server
+
+// Plan 9 reports these conditions as strings, they are not told apart.
+
+func isUnreachable(err error) bool { return false }
+
+func isDiskFull(err error) bool { return false }
+
+func isReadOnly(err error) bool { return false }
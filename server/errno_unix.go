@@ -0,0 +1,24 @@
+//go:build !windows && !plan9
+
+package server
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isUnreachable reports whether err is an ICMP port unreachable reported
+// by a connected UDP socket.
+func isUnreachable(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// isDiskFull reports whether err is a write failing for lack of space or quota.
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
+
+// isReadOnly reports whether err is a write to a read-only file system.
+func isReadOnly(err error) bool {
+	return errors.Is(err, syscall.EROFS)
+}
@@ -0,0 +1,58 @@
+//go:build !windows && !plan9
+
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/OmarTariq612/tftp-server/client"
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+// failingUpload is an upload file whose writes fail with err.
+type failingUpload struct {
+	server.UploadFile
+	err error
+}
+
+func (f failingUpload) Write(b []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.Name(), Err: f.err}
+}
+
+func TestUploadWriteErrors(t *testing.T) {
+	for _, tt := range []struct {
+		err  syscall.Errno
+		code server.ErrCode
+	}{
+		{syscall.ENOSPC, server.ErrDiskFull},
+		{syscall.EDQUOT, server.ErrDiskFull},
+		{syscall.EROFS, server.ErrAccessViolation},
+		{syscall.EPERM, server.ErrAccessViolation},
+		{syscall.EACCES, server.ErrAccessViolation},
+		{syscall.EIO, server.ErrUnknown},
+	} {
+		tt := tt
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			dir := t.TempDir()
+			addr := startServer(t, func(s *server.TFTPServer) {
+				s.UploadDir = dir
+				server.WrapUploads(s, func(f *os.File) server.UploadFile {
+					return failingUpload{f, tt.err}
+				})
+			})
+			err := (&client.Client{}).Put(context.Background(), addr, "disk.img", bytes.NewReader(make([]byte, 100)), 100)
+			var e server.Err
+			if !errors.As(err, &e) || e.Code != tt.code {
+				t.Errorf("got %v, want the error code %d", err, tt.code)
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) > 0 {
+				t.Errorf("%s left in the upload directory", entries[0].Name())
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+//go:build windows
+
+package server
+
+import (
+	"errors"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// isUnreachable reports whether err is an ICMP port unreachable reported
+// by a connected UDP socket.
+func isUnreachable(err error) bool {
+	return errors.Is(err, syscall.WSAECONNRESET) || errors.Is(err, windows.WSAECONNREFUSED)
+}
+
+// isDiskFull reports whether err is a write failing for lack of space or quota.
+func isDiskFull(err error) bool {
+	return errors.Is(err, windows.ERROR_DISK_FULL) || errors.Is(err, windows.ERROR_HANDLE_DISK_FULL)
+}
+
+// isReadOnly reports whether err is a write to a read-only file system.
+func isReadOnly(err error) bool {
+	return errors.Is(err, windows.ERROR_WRITE_PROTECT)
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+)
+
+// The errors returned by the pluggable parts of the server (Inventory,
+// Templates functions, ...) are sent to the client as ERROR packets, with
+// the code and message of the Err they wrap, or as "file not found" if
+// they wrap none (access violation for fs.ErrPermission). These helpers
+// build them.
+
+// Errorf returns an error sent to the client as an ERROR of code, with the
+// message formatted like fmt.Sprintf.
+func Errorf(code ErrCode, format string, args ...interface{}) error {
+	return Err{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// ErrorNotFound returns an error sent to the client as a "file not found" ERROR.
+func ErrorNotFound(message string) error {
+	return Err{Code: ErrNotFound, Message: message}
+}
+
+// ErrorAccessViolation returns an error sent to the client as an "access violation" ERROR.
+func ErrorAccessViolation(message string) error {
+	return Err{Code: ErrAccessViolation, Message: message}
+}
+
+// ErrorDiskFull returns an error sent to the client as a "disk full" ERROR.
+func ErrorDiskFull(message string) error {
+	return Err{Code: ErrDiskFull, Message: message}
+}
+
+// errorReply returns the code and message of the ERROR answering err.
+func errorReply(err error) (ErrCode, string) {
+	var e Err
+	if errors.As(err, &e) {
+		return e.Code, e.Message
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		return ErrAccessViolation, msgAccess
+	}
+	return ErrNotFound, msgNotFound
+}
+
+// httpStatus returns the HTTP status answering err, the counterpart of
+// errorReply for the HTTP transfers.
+func httpStatus(err error) (int, string) {
+	code, message := errorReply(err)
+	switch code {
+	case ErrNotFound:
+		return http.StatusNotFound, message
+	case ErrAccessViolation, ErrNoUser:
+		return http.StatusForbidden, message
+	case ErrDiskFull:
+		return http.StatusInsufficientStorage, message
+	case ErrIllegalOp, ErrOptions:
+		return http.StatusBadRequest, message
+	}
+	return http.StatusInternalServerError, message
+}
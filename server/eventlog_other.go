@@ -0,0 +1,10 @@
+//go:build !windows
+
+package server
+
+import "errors"
+
+// NewEventLogSink is only supported on windows.
+func NewEventLogSink(source string) (LogSink, error) {
+	return nil, errors.New("the event log is only supported on windows")
+}
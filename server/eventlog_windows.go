@@ -0,0 +1,56 @@
+//go:build windows
+
+package server
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// event ids used in the Windows Event Log
+const (
+	eventIDInfo    = 1
+	eventIDWarning = 2
+	eventIDError   = 3
+)
+
+// lifecycleMessages are the informational events worth an Event Log entry,
+// everything else below LevelWarn is too chatty for it.
+var lifecycleMessages = map[string]bool{
+	"listening": true,
+}
+
+type eventLogSink struct {
+	log *eventlog.Log
+}
+
+// NewEventLogSink returns a LogSink writing warnings, errors and server
+// start/stop events to the Windows Event Log under the given source name.
+func NewEventLogSink(source string) (LogSink, error) {
+	l, err := eventlog.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	return &eventLogSink{log: l}, nil
+}
+
+func (s *eventLogSink) WriteEvent(e *LogEvent) error {
+	if e.Level < LevelWarn && !lifecycleMessages[e.Message] {
+		return nil
+	}
+
+	buf := new(bytes.Buffer)
+	appendText(buf, e.Level, e.Message, e.Fields)
+	msg := strings.TrimSuffix(buf.String(), "\n")
+
+	switch e.Level {
+	case LevelDebug, LevelInfo:
+		return s.log.Info(eventIDInfo, msg)
+	case LevelWarn:
+		return s.log.Warning(eventIDWarning, msg)
+	default:
+		return s.log.Error(eventIDError, msg)
+	}
+}
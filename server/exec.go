@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// ExecHook runs a command describing a finished transfer through environment
+// variables:
+//
+//	TFTP_ID         transfer ID
+//	TFTP_PATH       filename requested by the client
+//	TFTP_FILE       local file the upload was stored as
+//	TFTP_CLIENT     client address (ip:port)
+//	TFTP_CLIENT_IP  client IP
+//	TFTP_DIRECTION  read or write
+//	TFTP_SIZE       payload bytes transferred
+//	TFTP_DURATION   duration in seconds
+//
+// The command is run directly, not through a shell, on its own goroutine.
+type ExecHook struct {
+	// Command is the program followed by its arguments.
+	Command []string
+
+	// Timeout kills the command if it runs longer, zero means no limit.
+	Timeout time.Duration
+}
+
+// run starts the command for summary, its failures are logged to logger.
+func (h *ExecHook) run(summary TransferSummary, logger *Logger) {
+	if len(h.Command) == 0 {
+		return
+	}
+	go func() {
+		ctx := context.Background()
+		if h.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+			defer cancel()
+		}
+		cmd := exec.CommandContext(ctx, h.Command[0], h.Command[1:]...)
+		cmd.Env = append(os.Environ(),
+			"TFTP_ID="+summary.ID,
+			"TFTP_PATH="+summary.Filename,
+			"TFTP_FILE="+summary.Path,
+			"TFTP_CLIENT="+summary.Client.String(),
+			"TFTP_CLIENT_IP="+clientHost(summary.Client),
+			"TFTP_DIRECTION="+string(summary.Direction),
+			"TFTP_SIZE="+strconv.FormatInt(summary.Bytes, 10),
+			"TFTP_DURATION="+strconv.FormatFloat(summary.Duration.Seconds(), 'f', 3, 64),
+		)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			logger.Warn("exec hook failed", "command", h.Command[0], "error", err, "output", string(out))
+			return
+		}
+		logger.Debug("exec hook done", "command", h.Command[0], "output", string(out))
+	}()
+}
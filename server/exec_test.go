@@ -0,0 +1,54 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/OmarTariq612/tftp-server/client"
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+func TestPostUploadHook(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no shell to run the hook")
+	}
+	dir, out := t.TempDir(), t.TempDir()
+	addr := startServer(t, func(s *server.TFTPServer) {
+		s.UploadDir = dir
+		s.PostUploadHook = &server.ExecHook{Command: []string{sh, "-c",
+			// the copy is renamed into place once complete
+			`echo "$TFTP_PATH" > "$0/path" && cp "$TFTP_FILE" "$0/file.tmp" && mv "$0/file.tmp" "$0/file"`, out}}
+	})
+	content := randomContent(3*server.BlockSize + 10)
+	if err := (&client.Client{}).Put(context.Background(), addr, "configs/switch1.cfg", bytes.NewReader(content), int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+
+	var copied []byte
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if copied, err = ioutil.ReadFile(filepath.Join(out, "file")); err == nil {
+			break
+		}
+		if !os.IsNotExist(err) || time.Now().After(deadline) {
+			t.Fatal("the hook didn't copy the upload: ", err)
+		}
+	}
+	if !bytes.Equal(copied, content) {
+		t.Errorf("the hook read %d bytes from $TFTP_FILE, want the %d bytes uploaded", len(copied), len(content))
+	}
+	path, err := ioutil.ReadFile(filepath.Join(out, "path"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(path)); got != "configs/switch1.cfg" {
+		t.Errorf("$TFTP_PATH %q, want %q", got, "configs/switch1.cfg")
+	}
+}
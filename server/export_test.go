@@ -0,0 +1,11 @@
+package server
+
+import "os"
+
+// UploadFile is the temporary file an upload is written to.
+type UploadFile = uploadFile
+
+// WrapUploads makes s write the uploads through wrap, to inject failures.
+func WrapUploads(s *TFTPServer, wrap func(f *os.File) UploadFile) {
+	s.wrapUpload = wrap
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net"
+	"os"
+	"path"
+	"strings"
+)
+
+// FallbackChain serves the requests matching Pattern with the first
+// existing file of Candidates, so the server tries the per-machine, then
+// per-subnet, then default files in a single request instead of relying
+// on clients to iterate. Candidates may contain placeholders:
+//
+//	{name}   base name of the requested file
+//	{path}   requested file, without leading slash
+//	{ip}     client IP
+//	{subnet} client network address (/24 for IPv4, /64 for IPv6)
+//	{mac}    MAC address found in the requested name, as aa-bb-cc-dd-ee-ff
+//
+// Candidates using {mac} are skipped when the name contains no MAC address.
+type FallbackChain struct {
+	Pattern    string // path.Match pattern of the requested filenames
+	Candidates []string
+}
+
+// fallbackFile returns the first existing candidate of the chain matching
+// filename, ok is false if no chain matches.
+func (s *TFTPServer) fallbackFile(client net.Addr, filename string) (file string, ok bool, err error) {
+	name := strings.TrimLeft(filename, "/")
+	for _, chain := range s.Fallbacks {
+		if match, _ := path.Match(chain.Pattern, name); !match {
+			continue
+		}
+		vars := []string{"{name}", path.Base(name), "{path}", name}
+		ip := net.ParseIP(clientHost(client))
+		if ip != nil {
+			vars = append(vars, "{ip}", ip.String(), "{subnet}", subnet(ip).String())
+		}
+		mac := ""
+		if m := macInName.FindString(name); m != "" {
+			if hw, err := net.ParseMAC(strings.ReplaceAll(m, "-", ":")); err == nil {
+				mac = strings.ReplaceAll(hw.String(), ":", "-")
+			}
+		}
+		vars = append(vars, "{mac}", mac)
+		replacer := strings.NewReplacer(vars...)
+
+		for _, candidate := range chain.Candidates {
+			if (mac == "" && strings.Contains(candidate, "{mac}")) || (ip == nil && (strings.Contains(candidate, "{ip}") || strings.Contains(candidate, "{subnet}"))) {
+				continue
+			}
+			file := replacer.Replace(candidate)
+			if isRemoteSource(file) {
+				return file, true, nil
+			}
+			if _, err := os.Stat(file); err == nil {
+				return file, true, nil
+			} else if !os.IsNotExist(err) {
+				return "", true, err
+			}
+		}
+		return "", true, errNoSuchFile
+	}
+	return "", false, nil
+}
+
+// subnet returns the /24 network of an IPv4 address, the /64 of an IPv6 one.
+func subnet(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32))
+	}
+	return ip.Mask(net.CIDRMask(64, 128))
+}
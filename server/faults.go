@@ -0,0 +1,56 @@
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// Faults injects network failures in the DATA packets sent by the server,
+// to validate clients against adverse conditions without tc/netem. The
+// rates are probabilities between 0 and 1. Never enable it in production.
+type Faults struct {
+	DropRate      float64 // the packet isn't sent
+	DuplicateRate float64 // the packet is sent twice
+	DelayRate     float64 // the packet is sent after Delay
+	Delay         time.Duration
+}
+
+// Validate checks that the rates are probabilities.
+func (f *Faults) Validate() error {
+	for _, r := range []struct {
+		name string
+		rate float64
+	}{{"drop", f.DropRate}, {"duplicate", f.DuplicateRate}, {"delay", f.DelayRate}} {
+		if r.rate < 0 || r.rate > 1 {
+			return fmt.Errorf("fault %s rate %g is not between 0 and 1", r.name, r.rate)
+		}
+	}
+	if f.DelayRate > 0 && f.Delay <= 0 {
+		return fmt.Errorf("fault delay rate %g without a delay", f.DelayRate)
+	}
+	return nil
+}
+
+// write sends b on conn with the faults drawn for it, a dropped packet is
+// reported as sent. A nil Faults sends b as is.
+func (f *Faults) write(conn net.Conn, b []byte, logger *Logger) (int, error) {
+	if f == nil {
+		return conn.Write(b)
+	}
+	if f.DropRate > 0 && rand.Float64() < f.DropRate {
+		logger.Debug("fault injection: packet dropped", "packet", describePacket(b))
+		return len(b), nil
+	}
+	if f.DelayRate > 0 && rand.Float64() < f.DelayRate {
+		logger.Debug("fault injection: packet delayed", "packet", describePacket(b), "delay", f.Delay)
+		time.Sleep(f.Delay)
+	}
+	n, err := conn.Write(b)
+	if err == nil && f.DuplicateRate > 0 && rand.Float64() < f.DuplicateRate {
+		logger.Debug("fault injection: packet duplicated", "packet", describePacket(b))
+		conn.Write(b)
+	}
+	return n, err
+}
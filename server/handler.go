@@ -0,0 +1,78 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net"
+	"path"
+	"strings"
+	"time"
+)
+
+// ReadHandler returns the content served to client for filename and its
+// size, -1 if unknown, for the programs embedding the server to serve
+// generated content (per-MAC iPXE scripts, files from a database, ...).
+// The reader is closed once the transfer is done. Readers implementing
+// io.Seeker are read as the transfer goes, the others are read in memory
+// first since the retransmissions need the blocks again. The errors are
+// answered like the ones of the other content sources: the code and
+// message of the Err they wrap (see Errorf), access violation for the
+// ones wrapping fs.ErrPermission, file not found otherwise.
+type ReadHandler func(filename string, client net.Addr) (io.ReadCloser, int64, error)
+
+// handlerContent returns the content of filename from ReadHandler.
+func (s *TFTPServer) handlerContent(client net.Addr, filename string) (slot, string, error) {
+	r, size, err := s.ReadHandler(filename, client)
+	if err != nil {
+		return slot{}, "", err
+	}
+	sl, err := readerSlot("handler:"+filename, r, size)
+	return sl, "", err
+}
+
+// fsContent returns the content of filename from FS, the filenames are
+// relative to its root whatever their form.
+func (s *TFTPServer) fsContent(filename string) (slot, string, error) {
+	name := strings.TrimPrefix(path.Clean("/"+filename), "/")
+	if name == "" || !fs.ValidPath(name) {
+		return slot{}, "", errNoSuchFile
+	}
+	f, err := s.FS.Open(name)
+	if err != nil {
+		return slot{}, "", err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return slot{}, "", err
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return slot{}, "", errNoSuchFile
+	}
+	sl, err := readerSlot("fs:"+name, f, info.Size())
+	return sl, "", err
+}
+
+// readerSlot returns the slot of the content of r, the slot closes r when
+// done. r is read in memory if it can't seek or its size is unknown.
+func readerSlot(name string, r io.ReadCloser, size int64) (slot, error) {
+	sl := slot{file: name, loaded: time.Now(), release: func() { r.Close() }}
+	if rs, ok := r.(io.ReadSeeker); ok && size >= 0 {
+		sl.reader, sl.size = rs, size
+		return sl, nil
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		r.Close()
+		return slot{}, err
+	}
+	if size >= 0 && int64(len(p)) != size {
+		r.Close()
+		return slot{}, fmt.Errorf("%s: %d bytes read, the size is %d", name, len(p), size)
+	}
+	sl.payload = p
+	return sl, nil
+}
@@ -0,0 +1,68 @@
+package server
+
+// Hooks are optional callbacks invoked during transfers, they run on the
+// transfer goroutine so they must be fast (or hand the work off) and safe for
+// concurrent use since transfers run in parallel.
+type Hooks struct {
+	// OnTransferStart is called once the request is accepted, before the first packet.
+	OnTransferStart func(info TransferInfo)
+
+	// OnBlockSent is called when the client acknowledges a DATA block,
+	// total is the number of payload bytes delivered so far.
+	OnBlockSent func(info TransferInfo, block uint16, total int64)
+
+	// OnBlockReceived is called when a DATA block is received from the client,
+	// total is the number of payload bytes received so far.
+	OnBlockReceived func(info TransferInfo, block uint16, total int64)
+
+	// OnTransferProgress is called with the blocks of both directions,
+	// after OnBlockSent or OnBlockReceived, total is the number of payload
+	// bytes transferred so far.
+	OnTransferProgress func(info TransferInfo, total int64)
+
+	// OnTransferEnd is called once per transfer whatever its result.
+	OnTransferEnd func(summary TransferSummary)
+
+	// OnTransferComplete and OnTransferError are called after OnTransferEnd,
+	// the former for the transfers with the ResultOK result and the latter
+	// for the others, err being the cause (summary.Err).
+	OnTransferComplete func(summary TransferSummary)
+	OnTransferError    func(summary TransferSummary, err error)
+}
+
+func (h *Hooks) transferStart(info TransferInfo) {
+	if h.OnTransferStart != nil {
+		h.OnTransferStart(info)
+	}
+}
+
+func (h *Hooks) blockSent(info TransferInfo, block uint16, total int64) {
+	if h.OnBlockSent != nil {
+		h.OnBlockSent(info, block, total)
+	}
+	if h.OnTransferProgress != nil {
+		h.OnTransferProgress(info, total)
+	}
+}
+
+func (h *Hooks) blockReceived(info TransferInfo, block uint16, total int64) {
+	if h.OnBlockReceived != nil {
+		h.OnBlockReceived(info, block, total)
+	}
+	if h.OnTransferProgress != nil {
+		h.OnTransferProgress(info, total)
+	}
+}
+
+func (h *Hooks) transferEnd(summary TransferSummary) {
+	if h.OnTransferEnd != nil {
+		h.OnTransferEnd(summary)
+	}
+	if summary.Result == ResultOK {
+		if h.OnTransferComplete != nil {
+			h.OnTransferComplete(summary)
+		}
+	} else if h.OnTransferError != nil {
+		h.OnTransferError(summary, summary.Err)
+	}
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// HTTPHandler serves the same content as the TFTP listener over HTTP (GET and
+// HEAD, with range requests), for clients booting with UEFI HTTP boot. Each
+// path is resolved like the filename of an RRQ (rewrites, aliases, Root and
+// the other sources, the served file and its slots otherwise), with the same
+// traversal checks, Authorizer, maintenance mode, logging, metrics and
+// access log as TFTP transfers.
+func (s *TFTPServer) HTTPHandler() http.Handler {
+	return http.HandlerFunc(s.serveHTTP)
+}
+
+func (s *TFTPServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	client := httpClientAddr(r)
+	filename := strings.TrimPrefix(r.URL.Path, "/")
+	if s.NormalizeBackslashes {
+		filename = strings.ReplaceAll(filename, "\\", "/")
+	}
+	if traversesPath(filename) {
+		http.Error(w, "access violation", http.StatusForbidden)
+		s.securityEvent(s.Logger, SecurityPathTraversal, client, filename, "filename escapes the served directory")
+		return
+	}
+	if name, err := s.authorize(client, ReadOp, filename); err != nil {
+		http.Error(w, "access violation", http.StatusForbidden)
+		s.securityEvent(s.Logger, SecurityACLDenied, client, name, err.Error())
+		s.Metrics.rejected.With("acl").Inc()
+		return
+	}
+	if s.Maintenance() {
+		http.Error(w, "server in maintenance, try again later", http.StatusServiceUnavailable)
+		s.Logger.Info("request rejected during maintenance", "client", client, "file", filename, "protocol", "http")
+		s.Metrics.rejected.With("maintenance").Inc()
+		return
+	}
+	t, err := s.enterAdmission(client, r.RemoteAddr, filename)
+	if err == nil && t != nil && !s.waitAdmission(t) {
+		err = errQueueTimeout
+	}
+	if err != nil {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "server busy, try again later", http.StatusServiceUnavailable)
+		s.Logger.Warn("request rejected", "client", client, "file", filename, "protocol", "http", "reason", err)
+		s.Metrics.rejected.With(rejectReason(err)).Inc()
+		if err == errClientLimit {
+			s.securityEvent(s.Logger, SecurityQuotaExceeded, client, filename, err.Error())
+		}
+		return
+	}
+	defer s.admission.leave(r.RemoteAddr, clientHost(client))
+
+	summary := &TransferSummary{
+		TransferInfo: TransferInfo{
+			ID:        newTransferID(),
+			Client:    client,
+			Filename:  filename,
+			Direction: DirectionRead,
+			Start:     time.Now(),
+		},
+		Result: ResultError,
+	}
+	logger := s.Logger.With("id", summary.ID, "client", client, "protocol", "http")
+	if !s.LogSampling.sampled(s.Metrics.activeTransfers.Load()) && logger.Enabled(LevelInfo) {
+		logger = logger.WithLevel(LevelWarn)
+	}
+	served, slotName, err := s.contentFor(logger, client, filename, queryOptions(r))
+	if err != nil {
+		status, message := httpStatus(err)
+		http.Error(w, message, status)
+		if status == http.StatusNotFound {
+			logger.Warn("file not found", "file", filename, "error", err)
+		} else {
+			logger.Warn("request refused", "file", filename, "status", status, "error", err)
+		}
+		return
+	}
+	defer served.done()
+	content, err := served.open()
+	if err != nil {
+		logger.Warn("opening file", "file", served.file, "error", err)
+		if errors.Is(err, errFileChanged) {
+			http.Error(w, msgChanged, http.StatusServiceUnavailable)
+		} else {
+			http.Error(w, "file not found", http.StatusNotFound)
+		}
+		return
+	}
+	defer content.Close()
+	s.Metrics.fileServed(filename)
+	if slotName != "" {
+		logger.Info("requested file", "file", filename, "slot", slotName)
+	} else {
+		logger.Info("requested file", "file", filename, "source", served.file)
+	}
+
+	s.Metrics.transferStarted(summary.TransferInfo)
+	s.Hooks.transferStart(summary.TransferInfo)
+	cw := &countingResponseWriter{ResponseWriter: w, ctx: r.Context(), egress: s.egressBucket()}
+	http.ServeContent(cw, r, path.Base(r.URL.Path), served.loaded, content)
+
+	summary.Bytes = cw.n
+	if cw.status < 400 {
+		summary.Result = ResultOK
+		logger.Info("transfer complete", "file", filename, "bytes", cw.n, "status", cw.status)
+	} else {
+		logger.Warn("request failed", "file", filename, "status", cw.status)
+	}
+	if cw.err != nil {
+		summary.Result = ResultAborted
+		summary.Err = cw.err
+	}
+	s.Metrics.bytesSent.Add(cw.n)
+	s.transferFinished(summary, logger)
+}
+
+// queryOptions returns the first value of each query parameter, the HTTP
+// counterpart of the options of a TFTP request.
+func queryOptions(r *http.Request) map[string]string {
+	options := make(map[string]string)
+	for name, values := range r.URL.Query() {
+		options[strings.ToLower(name)] = values[0]
+	}
+	return options
+}
+
+func httpClientAddr(r *http.Request) net.Addr {
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		return addr
+	}
+	return &net.TCPAddr{}
+}
+
+// countingResponseWriter records the status and the body bytes written,
+// paced by the egress bucket if it is not nil.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+	err    error
+	ctx    context.Context
+	egress *tokenBucket
+	sl     sleeper
+}
+
+func (w *countingResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *countingResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if err := w.egress.wait(w.ctx, len(b), &w.sl); err != nil {
+		return 0, err
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
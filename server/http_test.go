@@ -0,0 +1,43 @@
+package server_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+func TestHTTPAuthorizer(t *testing.T) {
+	s, err := server.NewTFTPServer("127.0.0.1", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Logger = server.NewLogger(io.Discard, server.LevelError)
+	events := &securityLog{}
+	s.SecurityLog = events
+	s.FS = fstest.MapFS{
+		"boot/shim.efi": {Data: []byte("shim")},
+		"secret.key":    {Data: []byte("key")},
+	}
+	s.Authorizer = server.ACL{{Allow: false, Read: true, Files: []string{"secret.*"}}}
+	handler := s.HTTPHandler()
+
+	for _, tt := range []struct {
+		path string
+		code int
+	}{
+		{"/boot/shim.efi", http.StatusOK},
+		{"/secret.key", http.StatusForbidden},
+		{"/boot/../secret.key", http.StatusForbidden},
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.code {
+			t.Errorf("GET %s: status %d, want %d", tt.path, rec.Code, tt.code)
+		}
+	}
+	events.wait(t, server.SecurityACLDenied)
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Inventory resolves the clients against an external inventory system
+// (IPAM, NetBox, a CMDB, ...) for data-driven provisioning. It is queried
+// for every request, implementations are expected to cache. A Lookup
+// error wrapping an Err (see Errorf) refuses the request with it.
+type Inventory interface {
+	Lookup(ctx context.Context, q InventoryQuery) (*InventoryRecord, error)
+}
+
+// InventoryQuery identifies the client of a request.
+type InventoryQuery struct {
+	IP       net.IP
+	MAC      net.HardwareAddr // found in the requested filename, nil if none
+	Filename string
+}
+
+// InventoryRecord is what the inventory knows about a client, a nil record
+// means the client is unknown.
+type InventoryRecord struct {
+	File string            `json:"file"` // served instead of the requested file, if set
+	Vars map[string]string `json:"vars"` // available to the templates as .Vars
+}
+
+// HTTPInventory is an Inventory backed by an HTTP endpoint: it GETs URL
+// with the ip, mac and file query parameters and expects a JSON
+// InventoryRecord, or a 404 for unknown clients. Answers are cached for
+// TTL by IP, MAC and filename.
+type HTTPInventory struct {
+	URL    string
+	Token  string // sent as a bearer token if set
+	TTL    time.Duration
+	Client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]inventoryEntry
+}
+
+type inventoryEntry struct {
+	record  *InventoryRecord
+	expires time.Time
+}
+
+func (h *HTTPInventory) Lookup(ctx context.Context, q InventoryQuery) (*InventoryRecord, error) {
+	key := q.IP.String() + "|" + q.MAC.String() + "|" + q.Filename
+	h.mu.Lock()
+	if e, ok := h.cache[key]; ok && time.Now().Before(e.expires) {
+		h.mu.Unlock()
+		return e.record, nil
+	}
+	h.mu.Unlock()
+
+	query := url.Values{"ip": {q.IP.String()}, "file": {q.Filename}}
+	if q.MAC != nil {
+		query.Set("mac", q.MAC.String())
+	}
+	u := h.URL
+	if strings.Contains(u, "?") {
+		u += "&" + query.Encode()
+	} else {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if h.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token)
+	}
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var record *InventoryRecord
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+	case resp.StatusCode/100 == 2:
+		record = new(InventoryRecord)
+		if err := json.NewDecoder(resp.Body).Decode(record); err != nil {
+			return nil, fmt.Errorf("inventory %s: %w", h.URL, err)
+		}
+	default:
+		return nil, fmt.Errorf("inventory %s: %s", h.URL, resp.Status)
+	}
+
+	if h.TTL > 0 {
+		h.mu.Lock()
+		if h.cache == nil {
+			h.cache = make(map[string]inventoryEntry)
+		}
+		now := time.Now()
+		for k, e := range h.cache {
+			if now.After(e.expires) {
+				delete(h.cache, k)
+			}
+		}
+		h.cache[key] = inventoryEntry{record: record, expires: now.Add(h.TTL)}
+		h.mu.Unlock()
+	}
+	return record, nil
+}
+
+// inventoryRecord queries the Inventory for a request, failures are logged
+// and treated like unknown clients so that provisioning degrades to the
+// static configuration. Only the refusals (errors wrapping an Err) are
+// returned.
+func (s *TFTPServer) inventoryRecord(logger *Logger, client net.Addr, filename string) (*InventoryRecord, error) {
+	if s.Inventory == nil {
+		return nil, nil
+	}
+	q := InventoryQuery{IP: net.ParseIP(clientHost(client)), Filename: strings.TrimLeft(filename, "/")}
+	if m := macInName.FindString(filename); m != "" {
+		q.MAC, _ = net.ParseMAC(strings.ReplaceAll(m, "-", ":"))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), inventoryTimeout)
+	defer cancel()
+	record, err := s.Inventory.Lookup(ctx, q)
+	if refusal := (Err{}); errors.As(err, &refusal) {
+		return nil, err
+	}
+	if err != nil {
+		logger.Warn("inventory lookup failed", "error", err)
+		return nil, nil
+	}
+	return record, nil
+}
+
+const inventoryTimeout = 3 * time.Second
@@ -0,0 +1,17 @@
+package server
+
+import "text/template"
+
+// IPXEScript renders an iPXE script for each request whose filename matches
+// Pattern, so boot logic can depend on the client (IP, MAC address in the
+// filename, architecture directory) without pre-generating a script per
+// machine.
+type IPXEScript = TemplateFile
+
+// IPXEVars are the client attributes available to IPXEScript templates.
+type IPXEVars = TemplateVars
+
+// ParseIPXETemplate parses the iPXE script template file name, see ParseTemplateFile.
+func ParseIPXETemplate(name string) (*template.Template, error) {
+	return ParseTemplateFile(name)
+}
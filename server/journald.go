@@ -0,0 +1,131 @@
+//go:build linux
+
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const journaldSocket = "/run/systemd/journal/socket"
+
+type journaldSink struct {
+	conn       *net.UnixConn
+	addr       *net.UnixAddr
+	identifier string
+}
+
+// NewJournaldSink returns a LogSink using the native journald protocol, every
+// event field is sent as a journal field (e.g. "client" becomes CLIENT,
+// CLIENT_IP and CLIENT_PORT) so it can be matched with journalctl.
+func NewJournaldSink(identifier string) (LogSink, error) {
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journaldSink{
+		conn:       conn,
+		addr:       &net.UnixAddr{Name: journaldSocket, Net: "unixgram"},
+		identifier: identifier,
+	}, nil
+}
+
+// JournaldAvailable reports whether stderr is connected to the journal,
+// which is the case for services started by systemd.
+func JournaldAvailable() bool {
+	stream := os.Getenv("JOURNAL_STREAM")
+	if stream == "" {
+		return false
+	}
+	var dev, ino uint64
+	if _, err := fmt.Sscanf(stream, "%d:%d", &dev, &ino); err != nil {
+		return false
+	}
+	var st syscall.Stat_t
+	if err := syscall.Fstat(int(os.Stderr.Fd()), &st); err != nil {
+		return false
+	}
+	return uint64(st.Dev) == dev && uint64(st.Ino) == ino
+}
+
+func (j *journaldSink) WriteEvent(e *LogEvent) error {
+	buf := new(bytes.Buffer)
+	writeJournalField(buf, "MESSAGE", e.Message)
+	writeJournalField(buf, "PRIORITY", strconv.Itoa(journalPriority(e.Level)))
+	writeJournalField(buf, "SYSLOG_IDENTIFIER", j.identifier)
+
+	for i := 0; i < len(e.Fields); i += 2 {
+		key, val := pair(e.Fields, i)
+		name := journalFieldName(key)
+		if name == "" {
+			continue
+		}
+		writeJournalField(buf, name, fmt.Sprint(val))
+
+		if addr, ok := val.(net.Addr); ok {
+			if host, port, err := net.SplitHostPort(addr.String()); err == nil {
+				writeJournalField(buf, name+"_IP", host)
+				writeJournalField(buf, name+"_PORT", port)
+			}
+		}
+	}
+
+	_, _, err := j.conn.WriteMsgUnix(buf.Bytes(), nil, j.addr)
+	return err
+}
+
+// journalPriority maps a level to the syslog severity used by journald.
+func journalPriority(level LogLevel) int {
+	switch level {
+	case LevelDebug:
+		return 7
+	case LevelInfo:
+		return 6
+	case LevelWarn:
+		return 4
+	default:
+		return 3
+	}
+}
+
+// journalFieldName converts a field key to a valid journal field name:
+// uppercase letters, digits and underscores, not starting with a digit or underscore.
+func journalFieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+	name = strings.TrimLeft(name, "_0123456789")
+	return name
+}
+
+func writeJournalField(buf *bytes.Buffer, name, value string) {
+	if !strings.ContainsRune(value, '\n') {
+		buf.WriteString(name)
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+
+	// values containing newlines use the binary form: NAME\n<little endian uint64 size><value>\n
+	buf.WriteString(name)
+	buf.WriteByte('\n')
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	buf.Write(size[:])
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
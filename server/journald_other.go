@@ -0,0 +1,15 @@
+//go:build !linux
+
+package server
+
+import "errors"
+
+// NewJournaldSink is only supported on linux.
+func NewJournaldSink(identifier string) (LogSink, error) {
+	return nil, errors.New("journald is only supported on linux")
+}
+
+// JournaldAvailable always reports false on this platform.
+func JournaldAvailable() bool {
+	return false
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LatestRule resolves the requests under Prefix to the newest build in Dir:
+// a request for Prefix + "<model>.bin" is served the file of Dir named
+// <model>-<version>.bin with the highest semantic version (an optional v
+// prefix is allowed, pre-releases are skipped). Devices then always pull
+// the newest approved build without mapping updates.
+type LatestRule struct {
+	Prefix string // e.g. "firmware/latest/"
+	Dir    string
+}
+
+// semver is a parsed MAJOR.MINOR.PATCH version, pre-release versions are
+// not parsed since they are never selected.
+type semver [3]int
+
+func parseSemver(s string) (semver, bool) {
+	var v semver
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i] // build metadata doesn't take part in the precedence
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return v, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || (len(p) > 1 && p[0] == '0') {
+			return v, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
+
+func (v semver) less(w semver) bool {
+	for i := range v {
+		if v[i] != w[i] {
+			return v[i] < w[i]
+		}
+	}
+	return false
+}
+
+// latestFile returns the newest build for filename if it is under the
+// prefix of one of the Latest rules, ok is false otherwise.
+func (s *TFTPServer) latestFile(filename string) (file string, ok bool, err error) {
+	name := strings.TrimLeft(filename, "/")
+	for _, rule := range s.Latest {
+		if !strings.HasPrefix(name, rule.Prefix) {
+			continue
+		}
+		requested := strings.TrimPrefix(name, rule.Prefix)
+		if requested == "" || strings.Contains(requested, "/") {
+			return "", true, errNoSuchFile
+		}
+		ext := path.Ext(requested)
+		model := strings.TrimSuffix(requested, ext)
+
+		entries, err := ioutil.ReadDir(rule.Dir)
+		if err != nil {
+			return "", true, err
+		}
+		var newest semver
+		for _, e := range entries {
+			n := e.Name()
+			if e.IsDir() || !strings.HasPrefix(n, model+"-") || !strings.HasSuffix(n, ext) {
+				continue
+			}
+			v, ok := parseSemver(strings.TrimSuffix(strings.TrimPrefix(n, model+"-"), ext))
+			if ok && (file == "" || newest.less(v)) {
+				file, newest = n, v
+			}
+		}
+		if file == "" {
+			return "", true, errNoSuchFile
+		}
+		return filepath.Join(rule.Dir, file), true, nil
+	}
+	return "", false, nil
+}
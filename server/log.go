@@ -0,0 +1,270 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel is the severity of a log message.
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	default:
+		return fmt.Sprintf("LEVEL(%d)", int(l))
+	}
+}
+
+// ParseLogLevel parses one of "debug", "info", "warn" or "error" (case insensitive).
+func ParseLogLevel(s string) (LogLevel, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level: %q", s)
+}
+
+// LogFormat selects how log events are encoded.
+type LogFormat int
+
+const (
+	FormatText LogFormat = iota // human readable: time LEVEL message key=value ...
+	FormatJSON                  // one JSON object per event
+)
+
+// ParseLogFormat parses "text" or "json" (case insensitive).
+func ParseLogFormat(s string) (LogFormat, error) {
+	switch strings.ToLower(s) {
+	case "text":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	}
+	return FormatText, fmt.Errorf("unknown log format: %q", s)
+}
+
+// LogEvent is a single log message with its structured fields.
+type LogEvent struct {
+	Time    time.Time
+	Level   LogLevel
+	Message string
+	Fields  []interface{} // alternating key/value pairs
+}
+
+// LogSink receives log events that passed the level check, it is used for
+// outputs that are not plain byte streams (syslog, journald, ...).
+type LogSink interface {
+	WriteEvent(e *LogEvent) error
+}
+
+// Logger is a leveled structured logger, events below Level are discarded.
+// Every event is a message followed by alternating key/value pairs.
+type Logger struct {
+	Level  LogLevel
+	Format LogFormat // encoding used when writing to the output writer
+
+	// Sink, if set, receives the events instead of the output writer, one
+	// at a time like the writer.
+	Sink LogSink
+
+	mu  sync.Mutex // serializes the writes to out and Sink
+	out io.Writer
+
+	// set for loggers derived with With, they use the settings and output of root
+	root   *Logger
+	fields []interface{}
+
+	// set for loggers derived with WithLevel, overrides the level of root
+	hasLevel bool
+}
+
+// NewLogger returns a text Logger writing to out (os.Stderr if nil).
+func NewLogger(out io.Writer, level LogLevel) *Logger {
+	if out == nil {
+		out = os.Stderr
+	}
+	return &Logger{Level: level, out: out}
+}
+
+// With returns a Logger adding kv to every event, it shares the settings and
+// the output of l (changing them on l affects the derived logger too).
+func (l *Logger) With(kv ...interface{}) *Logger {
+	root := l
+	if l.root != nil {
+		root = l.root
+	}
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	return &Logger{root: root, fields: fields, Level: l.Level, hasLevel: l.hasLevel}
+}
+
+// WithLevel returns a Logger like l but filtering events with level instead
+// of the level of the logger it was derived from.
+func (l *Logger) WithLevel(level LogLevel) *Logger {
+	derived := l.With()
+	derived.Level = level
+	derived.hasLevel = true
+	return derived
+}
+
+func (l *Logger) Enabled(level LogLevel) bool {
+	if l.root != nil && !l.hasLevel {
+		return l.root.Enabled(level)
+	}
+	return level >= l.Level
+}
+
+func (l *Logger) Debug(msg string, kv ...interface{}) { l.log(LevelDebug, msg, kv) }
+func (l *Logger) Info(msg string, kv ...interface{})  { l.log(LevelInfo, msg, kv) }
+func (l *Logger) Warn(msg string, kv ...interface{})  { l.log(LevelWarn, msg, kv) }
+func (l *Logger) Error(msg string, kv ...interface{}) { l.log(LevelError, msg, kv) }
+
+func (l *Logger) log(level LogLevel, msg string, kv []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	if l.root != nil {
+		l.root.log(level, msg, append(append([]interface{}{}, l.fields...), kv...))
+		return
+	}
+
+	now := time.Now()
+	if l.Sink != nil {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.Sink.WriteEvent(&LogEvent{Time: now, Level: level, Message: msg, Fields: kv})
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	if l.Format == FormatJSON {
+		appendJSON(buf, now, level, msg, kv)
+	} else {
+		buf.WriteString(now.Format("2006/01/02 15:04:05"))
+		buf.WriteByte(' ')
+		appendText(buf, level, msg, kv)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(buf.Bytes())
+}
+
+func appendText(buf *bytes.Buffer, level LogLevel, msg string, kv []interface{}) {
+	buf.WriteString(level.String())
+	buf.WriteByte(' ')
+	buf.WriteString(msg)
+	var multiline []string // keys and values written as indented blocks after the line
+	for i := 0; i < len(kv); i += 2 {
+		key, val := pair(kv, i)
+		s := fmt.Sprint(val)
+		if strings.Contains(s, "\n") {
+			multiline = append(multiline, key, s)
+			continue
+		}
+		buf.WriteByte(' ')
+		buf.WriteString(key)
+		buf.WriteByte('=')
+		if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+			s = strconv.Quote(s)
+		}
+		buf.WriteString(s)
+	}
+	buf.WriteByte('\n')
+	for i := 0; i < len(multiline); i += 2 {
+		buf.WriteString("  " + multiline[i] + ":\n")
+		for _, line := range strings.Split(strings.TrimSuffix(multiline[i+1], "\n"), "\n") {
+			buf.WriteString("    " + line + "\n")
+		}
+	}
+}
+
+func appendJSON(buf *bytes.Buffer, t time.Time, level LogLevel, msg string, kv []interface{}) {
+	buf.WriteString(`{"time":`)
+	writeJSON(buf, t.Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeJSON(buf, strings.ToLower(level.String()))
+	buf.WriteString(`,"msg":`)
+	writeJSON(buf, msg)
+	for i := 0; i < len(kv); i += 2 {
+		key, val := pair(kv, i)
+		buf.WriteByte(',')
+		writeJSON(buf, key)
+		buf.WriteByte(':')
+		switch v := val.(type) {
+		case error:
+			writeJSON(buf, v.Error())
+		case fmt.Stringer:
+			writeJSON(buf, v.String())
+		default:
+			writeJSON(buf, v)
+		}
+	}
+	buf.WriteString("}\n")
+}
+
+func writeJSON(buf *bytes.Buffer, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(b)
+}
+
+// pair returns the i-th key/value pair of kv, a dangling key gets a nil value.
+func pair(kv []interface{}, i int) (string, interface{}) {
+	key, ok := kv[i].(string)
+	if !ok {
+		key = fmt.Sprint(kv[i])
+	}
+	if i+1 >= len(kv) {
+		return key, nil
+	}
+	return key, kv[i+1]
+}
+
+// LogSampling emits the info (and debug) lines of only a fraction of the
+// transfers while at least Threshold transfers are active, so a boot storm
+// doesn't flood the logs. Warnings and errors are always logged.
+type LogSampling struct {
+	Rate      float64 // fraction of the transfers logged in full, between 0 and 1
+	Threshold int     // active transfers from which sampling applies, 0 samples always
+}
+
+// sampled reports whether a new transfer should be logged in full.
+func (ls *LogSampling) sampled(active int64) bool {
+	if ls == nil || active < int64(ls.Threshold) {
+		return true
+	}
+	return rand.Float64() < ls.Rate
+}
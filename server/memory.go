@@ -0,0 +1,34 @@
+package server
+
+// sessionMemory is the estimated memory held by an active transfer: the
+// stack of its goroutine (8 KiB for slow clients), its state and buffers
+// (4 KiB) and its socket.
+const sessionMemory = 16 << 10
+
+// memoryInUse estimates the memory held by the published slots, the cluster
+// cache, the files shared by the transfers and the active transfers. Files
+// held twice (by a slot and the cache for instance) are counted twice,
+// erring on the safe side.
+func (s *TFTPServer) memoryInUse() int64 {
+	var n int64
+	s.slots.mu.RLock()
+	for _, sl := range s.slots.s {
+		n += int64(len(sl.payload))
+	}
+	s.slots.mu.RUnlock()
+	if c := s.cluster; c != nil {
+		c.mu.Lock()
+		n += c.size
+		c.mu.Unlock()
+	}
+	n += s.shared.bytes()
+	return n + s.Metrics.activeTransfers.Load()*sessionMemory
+}
+
+// overMemoryBudget reports whether a new transfer would take the estimated
+// memory in use over MemoryBudget.
+func (s *TFTPServer) overMemoryBudget() bool {
+	used := s.memoryInUse()
+	s.Metrics.memoryEstimate.Set(used)
+	return s.MemoryBudget > 0 && used+sessionMemory > s.MemoryBudget
+}
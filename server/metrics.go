@@ -0,0 +1,336 @@
+package server
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type counter struct {
+	v int64
+}
+
+func (c *counter) Add(n int64) { atomic.AddInt64(&c.v, n) }
+func (c *counter) Inc()        { c.Add(1) }
+func (c *counter) Dec()        { c.Add(-1) }
+func (c *counter) Load() int64 { return atomic.LoadInt64(&c.v) }
+func (c *counter) Set(n int64) { atomic.StoreInt64(&c.v, n) }
+
+// counterVec is a set of counters partitioned by the value of a single
+// label. It is lock-free once a label has been seen: the transfers update
+// them for every packet.
+type counterVec struct {
+	m sync.Map // label to *counter
+}
+
+func (v *counterVec) With(label string) *counter {
+	if c, ok := v.m.Load(label); ok {
+		return c.(*counter)
+	}
+	c, _ := v.m.LoadOrStore(label, new(counter))
+	return c.(*counter)
+}
+
+// Values returns a copy of the counters keyed by label.
+func (v *counterVec) Values() map[string]int64 {
+	values := make(map[string]int64)
+	v.m.Range(func(label, c interface{}) bool {
+		values[label.(string)] = c.(*counter).Load()
+		return true
+	})
+	return values
+}
+
+// histogram counts observations in cumulative buckets with the given upper bounds.
+type histogram struct {
+	bounds  []float64
+	counts  []uint64 // one per bound plus +Inf
+	sumBits uint64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	atomic.AddUint64(&h.counts[i], 1)
+	for {
+		old := atomic.LoadUint64(&h.sumBits)
+		sum := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&h.sumBits, old, sum) {
+			return
+		}
+	}
+}
+
+// Metrics holds the server counters, it is safe for concurrent use.
+type Metrics struct {
+	activeTransfers  counter
+	queuedRequests   counter
+	transfers        counterVec // by TransferResult
+	bytesSent        counter
+	bytesReceived    counter // of the uploads
+	egressRate       counter // bytes sent during the last second
+	egressLimit      counter // TFTPServer.EgressRate, 0 for none
+	memoryEstimate   counter // see TFTPServer.memoryInUse, updated by each request
+	retransmits      counter
+	timeouts         counter
+	errorsSent       counterVec // by ErrCode
+	errorsReceived   counterVec // by ErrCode
+	parseFailures    counter
+	rejected         counterVec // requests refused before starting, by reason
+	securityEvents   counterVec // by SecurityCode
+	packetsReceived  counterVec // by packetKind
+	packetsSent      counterVec // by packetKind
+	transferDuration *histogram
+	transferGoodput  *histogram // payload bytes per second of successful transfers
+	blockRTT         *histogram // DATA to ACK round trip of blocks sent only once
+	retransmitsPer   *histogram // retransmits needed by each transfer
+	timeoutsPer      *histogram // timeouts hit by each transfer
+	queueWait        *histogram // time requests spent waiting for a transfer slot
+	files            fileStats
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		transferDuration: newHistogram(0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300),
+		transferGoodput:  newHistogram(1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9),
+		blockRTT:         newHistogram(0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5),
+		retransmitsPer:   newHistogram(0, 1, 2, 5, 10, 50, 100, 1000),
+		timeoutsPer:      newHistogram(0, 1, 2, 5, 10, 50, 100, 1000),
+		queueWait:        newHistogram(0.01, 0.05, 0.1, 0.5, 1, 2, 5, 10),
+	}
+}
+
+func (m *Metrics) transferStarted(info TransferInfo) {
+	m.activeTransfers.Inc()
+}
+
+// fileServed counts a request of filename in the popularity table, once its
+// file is opened: the requests of missing files aren't tracked.
+func (m *Metrics) fileServed(filename string) {
+	m.files.served(filename)
+}
+
+func (m *Metrics) transferFinished(summary *TransferSummary) {
+	m.activeTransfers.Dec()
+	m.transfers.With(string(summary.Result)).Inc()
+	m.transferDuration.Observe(summary.Duration.Seconds())
+	m.files.transferred(summary.Filename, summary.Bytes)
+	m.retransmitsPer.Observe(float64(summary.Retransmits))
+	m.timeoutsPer.Observe(float64(summary.Timeouts))
+	if summary.Result == ResultOK && summary.Duration > 0 {
+		m.transferGoodput.Observe(float64(summary.Bytes) / summary.Duration.Seconds())
+	}
+}
+
+func (m *Metrics) packetReceived(b []byte) {
+	m.packetsReceived.With(packetKind(b)).Inc()
+}
+
+func (m *Metrics) packetSent(b []byte) {
+	m.packetsSent.With(packetKind(b)).Inc()
+}
+
+// measureEgress updates the egress rate every second until stop is closed.
+func (m *Metrics) measureEgress(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	last := m.bytesSent.Load()
+	for {
+		select {
+		case <-ticker.C:
+			sent := m.bytesSent.Load()
+			m.egressRate.Set(sent - last)
+			last = sent
+		case <-stop:
+			m.egressRate.Set(0)
+			return
+		}
+	}
+}
+
+func (m *Metrics) blockAcknowledged(rtt time.Duration) {
+	m.blockRTT.Observe(rtt.Seconds())
+}
+
+// MetricsSnapshot is a point in time copy of the server counters.
+type MetricsSnapshot struct {
+	ActiveTransfers int64            `json:"active_transfers"`
+	QueuedRequests  int64            `json:"queued_requests"`
+	Transfers       map[string]int64 `json:"transfers"`
+	BytesSent       int64            `json:"bytes_sent"`
+	BytesReceived   int64            `json:"bytes_received"`
+	EgressRate      int64            `json:"egress_bytes_per_second"`
+	EgressLimit     int64            `json:"egress_limit_bytes_per_second"`
+	MemoryEstimate  int64            `json:"memory_estimate_bytes"`
+	Retransmits     int64            `json:"retransmits"`
+	Timeouts        int64            `json:"timeouts"`
+	ErrorsSent      map[string]int64 `json:"errors_sent"`
+	ErrorsReceived  map[string]int64 `json:"errors_received"`
+	ParseFailures   int64            `json:"parse_failures"`
+	Rejected        map[string]int64 `json:"rejected_requests"`
+	SecurityEvents  map[string]int64 `json:"security_events"`
+	PacketsReceived map[string]int64 `json:"packets_received"`
+	PacketsSent     map[string]int64 `json:"packets_sent"`
+
+	TransferDuration HistogramSnapshot `json:"transfer_duration_seconds"`
+	TransferGoodput  HistogramSnapshot `json:"transfer_goodput_bytes_per_second"`
+	BlockRTT         HistogramSnapshot `json:"block_rtt_seconds"`
+	RetransmitsPer   HistogramSnapshot `json:"transfer_retransmits"`
+	TimeoutsPer      HistogramSnapshot `json:"transfer_timeouts"`
+	QueueWait        HistogramSnapshot `json:"queue_wait_seconds"`
+}
+
+// HistogramSnapshot is a point in time copy of a histogram, Buckets maps the
+// upper bound of each bucket to the cumulative count of observations.
+type HistogramSnapshot struct {
+	Count   uint64            `json:"count"`
+	Sum     float64           `json:"sum"`
+	Buckets map[string]uint64 `json:"buckets"`
+}
+
+func (h *histogram) Snapshot() HistogramSnapshot {
+	snapshot := HistogramSnapshot{Buckets: make(map[string]uint64, len(h.bounds)+1)}
+	for i, bound := range h.bounds {
+		snapshot.Count += atomic.LoadUint64(&h.counts[i])
+		snapshot.Buckets[strconv.FormatFloat(bound, 'g', -1, 64)] = snapshot.Count
+	}
+	snapshot.Count += atomic.LoadUint64(&h.counts[len(h.bounds)])
+	snapshot.Buckets["+Inf"] = snapshot.Count
+	snapshot.Sum = math.Float64frombits(atomic.LoadUint64(&h.sumBits))
+	return snapshot
+}
+
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	return MetricsSnapshot{
+		ActiveTransfers: m.activeTransfers.Load(),
+		QueuedRequests:  m.queuedRequests.Load(),
+		Transfers:       m.transfers.Values(),
+		BytesSent:       m.bytesSent.Load(),
+		BytesReceived:   m.bytesReceived.Load(),
+		EgressRate:      m.egressRate.Load(),
+		EgressLimit:     m.egressLimit.Load(),
+		MemoryEstimate:  m.memoryEstimate.Load(),
+		Retransmits:     m.retransmits.Load(),
+		Timeouts:        m.timeouts.Load(),
+		ErrorsSent:      m.errorsSent.Values(),
+		ErrorsReceived:  m.errorsReceived.Values(),
+		ParseFailures:   m.parseFailures.Load(),
+		Rejected:        m.rejected.Values(),
+		SecurityEvents:  m.securityEvents.Values(),
+		PacketsReceived: m.packetsReceived.Values(),
+		PacketsSent:     m.packetsSent.Values(),
+
+		TransferDuration: m.transferDuration.Snapshot(),
+		TransferGoodput:  m.transferGoodput.Snapshot(),
+		BlockRTT:         m.blockRTT.Snapshot(),
+		RetransmitsPer:   m.retransmitsPer.Snapshot(),
+		TimeoutsPer:      m.timeoutsPer.Snapshot(),
+		QueueWait:        m.queueWait.Snapshot(),
+	}
+}
+
+// Publish exports the metrics snapshot as the expvar variable name,
+// it panics like expvar.Publish if the name is already registered.
+func (m *Metrics) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} { return m.Snapshot() }))
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) WritePrometheus(w io.Writer) {
+	writeMetric(w, "tftp_active_transfers", "gauge", "Number of transfers in progress.", m.activeTransfers.Load())
+	writeMetric(w, "tftp_queued_requests", "gauge", "Number of requests waiting for a transfer slot.", m.queuedRequests.Load())
+	writeMetricVec(w, "tftp_transfers_total", "counter", "Finished transfers by result.", "result", m.transfers.Values())
+	writeMetric(w, "tftp_bytes_sent_total", "counter", "Payload bytes sent and acknowledged.", m.bytesSent.Load())
+	writeMetric(w, "tftp_bytes_received_total", "counter", "Payload bytes of the uploads received.", m.bytesReceived.Load())
+	writeMetric(w, "tftp_egress_bytes_per_second", "gauge", "Payload bytes sent during the last second.", m.egressRate.Load())
+	writeMetric(w, "tftp_egress_limit_bytes_per_second", "gauge", "Configured cap on the payload bytes sent per second by all transfers (0 for none).", m.egressLimit.Load())
+	writeMetric(w, "tftp_memory_estimate_bytes", "gauge", "Estimated memory held by the served files, the cluster cache and the active transfers, as of the last request.", m.memoryEstimate.Load())
+	writeMetric(w, "tftp_retransmits_total", "counter", "Retransmitted DATA packets.", m.retransmits.Load())
+	writeMetric(w, "tftp_timeouts_total", "counter", "Timeouts waiting for a reply from the client.", m.timeouts.Load())
+	writeMetricVec(w, "tftp_errors_sent_total", "counter", "ERROR packets sent by code.", "code", m.errorsSent.Values())
+	writeMetricVec(w, "tftp_errors_received_total", "counter", "ERROR packets received by code.", "code", m.errorsReceived.Values())
+	writeMetric(w, "tftp_request_parse_failures_total", "counter", "Requests that could not be parsed.", m.parseFailures.Load())
+	writeMetricVec(w, "tftp_rejected_requests_total", "counter", "Requests refused before starting a transfer, by reason (busy, client_limit, memory, queue_timeout, maintenance, acl).", "reason", m.rejected.Values())
+	writeMetricVec(w, "tftp_packets_received_total", "counter", "Datagrams received by opcode (malformed and unknown included).", "opcode", m.packetsReceived.Values())
+	writeMetricVec(w, "tftp_packets_sent_total", "counter", "Datagrams sent by opcode.", "opcode", m.packetsSent.Values())
+	writeMetricVec(w, "tftp_security_events_total", "counter", "Security violations by event code.", "code", m.securityEvents.Values())
+	writeHistogram(w, "tftp_transfer_duration_seconds", "Duration of finished transfers.", m.transferDuration)
+	writeHistogram(w, "tftp_transfer_goodput_bytes_per_second", "Payload throughput of successful transfers.", m.transferGoodput)
+	writeHistogram(w, "tftp_block_rtt_seconds", "Round trip time between a DATA block and its ACK (blocks sent once only).", m.blockRTT)
+	writeHistogram(w, "tftp_transfer_retransmits", "Retransmits needed by each finished transfer.", m.retransmitsPer)
+	writeHistogram(w, "tftp_transfer_timeouts", "Timeouts hit by each finished transfer.", m.timeoutsPer)
+	writeHistogram(w, "tftp_queue_wait_seconds", "Time requests spent queued waiting for a transfer slot.", m.queueWait)
+	m.writeFiles(w)
+}
+
+// promTopFiles bounds the series of the per-file metrics: the filenames
+// come from the clients.
+const promTopFiles = 50
+
+// writeFiles writes the requests and bytes of the most requested files, a
+// file outranked by promTopFiles others has no series anymore.
+func (m *Metrics) writeFiles(w io.Writer) {
+	top := m.TopFiles(promTopFiles, false)
+	requests := make(map[string]int64, len(top))
+	bytes := make(map[string]int64, len(top))
+	for _, f := range top {
+		requests[f.Filename] = f.Requests
+		bytes[f.Filename] = f.Bytes
+	}
+	writeMetricVec(w, "tftp_file_requests_total", "counter", fmt.Sprintf("Requests of the %d most requested files.", promTopFiles), "file", requests)
+	writeMetricVec(w, "tftp_file_bytes_total", "counter", fmt.Sprintf("Payload bytes transferred of the %d most requested files.", promTopFiles), "file", bytes)
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WritePrometheus(w)
+}
+
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeMetric(w io.Writer, name, typ, help string, v int64) {
+	writeHeader(w, name, typ, help)
+	fmt.Fprintf(w, "%s %d\n", name, v)
+}
+
+func writeMetricVec(w io.Writer, name, typ, help, label string, values map[string]int64) {
+	writeHeader(w, name, typ, help)
+	labels := make([]string, 0, len(values))
+	for l := range values {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, label, labelEscaper.Replace(strings.ToValidUTF8(l, "\uFFFD")), values[l])
+	}
+}
+
+// labelEscaper escapes the label values like the text exposition format
+// requires, some come from the clients (the filenames).
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeHistogram(w io.Writer, name, help string, h *histogram) {
+	writeHeader(w, name, "histogram", help)
+	snapshot := h.Snapshot()
+	for _, bound := range h.bounds {
+		le := strconv.FormatFloat(bound, 'g', -1, 64)
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, le, snapshot.Buckets[le])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, snapshot.Count)
+	fmt.Fprintf(w, "%s_sum %g\n", name, snapshot.Sum)
+	fmt.Fprintf(w, "%s_count %d\n", name, snapshot.Count)
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// BenchmarkMetricsTransfer records the metrics of a transfer of a file of
+// two blocks, the packets included, from parallel goroutines like the
+// concurrent transfers do. At 10k transfers/s a transfer has 100µs of a
+// core: the metrics should only take a tiny fraction of it.
+func BenchmarkMetricsTransfer(b *testing.B) {
+	m := NewMetrics()
+	rrq, _ := ReadWriteRequest{Op: ReadOp, Filename: "pxelinux.0", Mode: "octet"}.MarshalBinary()
+	data, _ := Data{BlockNum: 1, Payload: make([]byte, BlockSize)}.MarshalBinary()
+	ack, _ := Acknowledgment{BlockNum: 1}.MarshalBinary()
+	var files [16]string
+	for i := range files {
+		files[i] = "file" + strconv.Itoa(i)
+	}
+	var n int64
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := atomic.AddInt64(&n, 1)
+			summary := &TransferSummary{
+				TransferInfo: TransferInfo{Filename: files[i%16], Direction: DirectionRead},
+				Result:       ResultOK,
+				Bytes:        BlockSize + 100,
+				Duration:     time.Millisecond,
+			}
+			m.packetReceived(rrq)
+			m.transferStarted(summary.TransferInfo)
+			m.fileServed(summary.Filename)
+			for block := 0; block < 2; block++ {
+				m.packetSent(data)
+				m.packetReceived(ack)
+				m.bytesSent.Add(BlockSize)
+				m.blockAcknowledged(100 * time.Microsecond)
+			}
+			m.transferFinished(summary)
+		}
+	})
+}
+
+// TestTopFilesEviction fills the popularity table with names requested once
+// and checks that the files served more often stay tracked.
+func TestTopFilesEviction(t *testing.T) {
+	m := NewMetrics()
+	for i := 0; i < 3; i++ {
+		m.fileServed("pxelinux.0")
+	}
+	m.files.transferred("pxelinux.0", 100)
+	m.files.transferred("missing.bin", 100) // never served
+	for i := 0; i < maxTrackedFiles+100; i++ {
+		m.fileServed("junk" + strconv.Itoa(i))
+	}
+	m.fileServed("pxelinux.0")
+
+	if n := len(m.files.m); n != maxTrackedFiles {
+		t.Errorf("%d files tracked, want %d", n, maxTrackedFiles)
+	}
+	top := m.TopFiles(1, false)
+	if len(top) != 1 || top[0] != (FileStat{Filename: "pxelinux.0", Requests: 4, Bytes: 100}) {
+		t.Errorf("top file %+v, want pxelinux.0 with 4 requests and 100 bytes", top)
+	}
+	if _, ok := m.files.m["missing.bin"]; ok {
+		t.Error("the bytes of a file never served created an entry")
+	}
+}
+
+// BenchmarkMetricsPacket counts a packet by kind, which every datagram of
+// every transfer does.
+func BenchmarkMetricsPacket(b *testing.B) {
+	m := NewMetrics()
+	ack, _ := Acknowledgment{BlockNum: 1}.MarshalBinary()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			m.packetReceived(ack)
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"strconv"
+	"time"
+)
+
+// The options of RFC 2348 and 2349 the server negotiates, the others are
+// ignored as RFC 2347 requires.
+const (
+	optBlockSize = "blksize"
+	optTimeout   = "timeout"
+	optSize      = "tsize"
+	optWindow    = "windowsize"
+)
+
+// defaultMaxWindowSize is the windowsize accepted at most if MaxWindowSize is 0.
+const defaultMaxWindowSize = 64
+
+// transferOptions are the parameters of a transfer, negotiated with the
+// client if it requested options.
+type transferOptions struct {
+	blockSize  int
+	windowSize int               // blocks sent per ACK (RFC 7440)
+	timeout    time.Duration     // retransmission timeout
+	retries    int               // transmissions of a packet before giving up
+	oack       map[string]string // the accepted options, nil if there are none
+}
+
+// negotiate returns the options of the transfer of request, size is the
+// size of the file read (ignored for the uploads) and listen the settings
+// of the listener it was received by. The invalid values are
+// ignored like unknown options, the transfer uses the defaults then:
+// blksize must be 8 to 65464 bytes (RFC 2348), timeout 1 to 255 seconds
+// (RFC 2349), windowsize 1 to 65535 blocks (RFC 7440), lowered to
+// MaxWindowSize. The tsize of a read request is answered with size, the one
+// of a write request is acknowledged as is.
+func (s *TFTPServer) negotiate(request ReadWriteRequest, size int64, listen ListenConfig) transferOptions {
+	o := transferOptions{blockSize: s.blockSize(), windowSize: 1, timeout: listen.Timeout, retries: listen.Retries}
+	if request.Op == WriteOp {
+		o.blockSize = BlockSize // DefaultBlockSize is for the blocks sent
+	}
+	accept := func(name, value string) {
+		if o.oack == nil {
+			o.oack = make(map[string]string)
+		}
+		o.oack[name] = value
+	}
+	if v, ok := request.Options[optBlockSize]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 8 {
+			if n > MaxBlockSize {
+				n = MaxBlockSize // the server may answer a smaller size
+			}
+			o.blockSize = n
+			accept(optBlockSize, strconv.Itoa(n))
+		}
+	}
+	if v, ok := request.Options[optTimeout]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 1 && n <= 255 {
+			o.timeout = time.Duration(n) * time.Second
+			accept(optTimeout, strconv.Itoa(n))
+		}
+	}
+	if v, ok := request.Options[optWindow]; ok {
+		if n, err := strconv.Atoi(v); err == nil && n >= 1 && n <= 65535 {
+			limit := s.MaxWindowSize
+			if limit <= 0 {
+				limit = defaultMaxWindowSize
+			}
+			if n > limit {
+				n = limit
+			}
+			o.windowSize = n
+			accept(optWindow, strconv.Itoa(n))
+		}
+	}
+	if v, ok := request.Options[optSize]; ok {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n >= 0 {
+			if request.Op == ReadOp {
+				n = size
+			}
+			accept(optSize, strconv.FormatInt(n, 10))
+		}
+	}
+	return o
+}
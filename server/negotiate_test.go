@@ -0,0 +1,74 @@
+package server_test
+
+import (
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+func TestNegotiate(t *testing.T) {
+	const size = 3*server.BlockSize + 10
+	addr := startServer(t, func(s *server.TFTPServer) {
+		s.FS = fstest.MapFS{"pxelinux.0": {Data: make([]byte, size)}}
+		s.MaxWindowSize = 8
+	})
+	for _, tt := range []struct {
+		name    string
+		options map[string]string
+		oack    map[string]string // nil if DATA 1 is expected instead
+	}{
+		{"none", nil, nil},
+		{"blksize", map[string]string{"blksize": "1428"}, map[string]string{"blksize": "1428"}},
+		{"blksize clamped", map[string]string{"blksize": "100000"}, map[string]string{"blksize": "65464"}},
+		{"blksize too small", map[string]string{"blksize": "4"}, nil},
+		{"blksize invalid", map[string]string{"blksize": "large"}, nil},
+		{"timeout", map[string]string{"timeout": "3"}, map[string]string{"timeout": "3"}},
+		{"timeout zero", map[string]string{"timeout": "0"}, nil},
+		{"timeout too long", map[string]string{"timeout": "256"}, nil},
+		{"windowsize", map[string]string{"windowsize": "4"}, map[string]string{"windowsize": "4"}},
+		{"windowsize clamped", map[string]string{"windowsize": "1000"}, map[string]string{"windowsize": "8"}},
+		{"windowsize zero", map[string]string{"windowsize": "0"}, nil},
+		{"tsize", map[string]string{"tsize": "0"}, map[string]string{"tsize": "1546"}},
+		{"tsize negative", map[string]string{"tsize": "-1"}, nil},
+		{"unknown", map[string]string{"multicast": ""}, nil},
+		{
+			"mixed",
+			map[string]string{"blksize": "2", "timeout": "5", "tsize": "0", "windowsize": "16"},
+			map[string]string{"timeout": "5", "tsize": "1546", "windowsize": "8"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, reply, from := sendRequest(t, addr, server.ReadOp, "pxelinux.0", tt.options)
+			defer abort(conn, from)
+			switch op := server.Opcode(binary.BigEndian.Uint16(reply)); {
+			case tt.oack == nil:
+				if op != server.DataOp || binary.BigEndian.Uint16(reply[2:]) != 1 {
+					t.Fatalf("got %v, want DATA 1", reply)
+				}
+				if len(reply) != 4+server.BlockSize {
+					t.Errorf("block of %d bytes, want %d", len(reply)-4, server.BlockSize)
+				}
+			case op != server.OptionAckOp:
+				t.Fatalf("got %v, want an OACK", reply)
+			default:
+				var oack server.OptionAcknowledgment
+				if err := oack.UnmarshalBinary(reply); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(oack.Options, tt.oack) {
+					t.Errorf("OACK %v, want %v", oack.Options, tt.oack)
+				}
+			}
+		})
+	}
+}
+
+// abort ends the transfer of conn with the server TID to.
+func abort(conn *net.UDPConn, to *net.UDPAddr) {
+	b, _ := server.Err{Code: server.ErrUnknown, Message: "test over"}.MarshalBinary()
+	conn.WriteToUDP(b, to)
+}
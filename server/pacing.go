@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// Pacing spaces the DATA packets of each transfer, so that the windows of
+// blocks sent without waiting for an ACK don't overflow the shallow
+// buffers of access-layer switches.
+type Pacing struct {
+	// Gap is the minimum time between two bursts of DATA packets.
+	Gap time.Duration
+
+	// MaxBurst is the number of packets sent back to back, less than Gap
+	// apart, before waiting for Gap (1 if 0: every packet waits).
+	MaxBurst int
+}
+
+// burstPacer paces the packets of a transfer, a nil one doesn't.
+type burstPacer struct {
+	p     Pacing
+	burst int // packets sent back to back so far
+	last  time.Time
+	sl    sleeper
+}
+
+func newBurstPacer(p *Pacing) *burstPacer {
+	if p == nil || p.Gap <= 0 {
+		return nil
+	}
+	b := &burstPacer{p: *p}
+	if b.p.MaxBurst < 1 {
+		b.p.MaxBurst = 1
+	}
+	return b
+}
+
+// wait returns when the next packet can be sent.
+func (b *burstPacer) wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	if time.Since(b.last) >= b.p.Gap {
+		b.burst = 0 // the previous burst is over
+	}
+	if b.burst >= b.p.MaxBurst {
+		if err := b.sl.sleep(ctx, time.Until(b.last.Add(b.p.Gap))); err != nil {
+			return err
+		}
+		b.burst = 0
+	}
+	b.burst++
+	b.last = time.Now()
+	return nil
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	pcapMagic      = 0xa1b2c3d4
+	pcapSnapLen    = 65535
+	pcapLinkTypeIP = 101 // LINKTYPE_RAW: packets start with an IPv4 or IPv6 header
+)
+
+// PcapWriter writes UDP datagrams to a pcap capture file (readable by Wireshark),
+// synthesizing the IP and UDP headers from the endpoint addresses.
+type PcapWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewPcapWriter writes the pcap file header to w and returns a PcapWriter appending to it.
+func NewPcapWriter(w io.Writer) (*PcapWriter, error) {
+	var hdr [24]byte
+	binary.LittleEndian.PutUint32(hdr[0:], pcapMagic)
+	binary.LittleEndian.PutUint16(hdr[4:], 2) // version 2.4
+	binary.LittleEndian.PutUint16(hdr[6:], 4)
+	binary.LittleEndian.PutUint32(hdr[16:], pcapSnapLen)
+	binary.LittleEndian.PutUint32(hdr[20:], pcapLinkTypeIP)
+	if _, err := w.Write(hdr[:]); err != nil {
+		return nil, err
+	}
+	return &PcapWriter{w: w}, nil
+}
+
+// WritePacket records a UDP datagram sent from src to dst at time t.
+func (p *PcapWriter) WritePacket(t time.Time, src, dst *net.UDPAddr, payload []byte) error {
+	var packet []byte
+	if src.IP.To4() != nil && dst.IP.To4() != nil {
+		packet = ipv4Packet(src, dst, payload)
+	} else {
+		packet = ipv6Packet(src, dst, payload)
+	}
+
+	var rec [16]byte
+	binary.LittleEndian.PutUint32(rec[0:], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:], uint32(t.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(rec[8:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(rec[12:], uint32(len(packet)))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.w.Write(rec[:]); err != nil {
+		return err
+	}
+	_, err := p.w.Write(packet)
+	return err
+}
+
+func ipv4Packet(src, dst *net.UDPAddr, payload []byte) []byte {
+	packet := make([]byte, 20+8+len(payload))
+	ip := packet[:20]
+	ip[0] = 0x45 // version 4, 5 words header
+	binary.BigEndian.PutUint16(ip[2:], uint16(len(packet)))
+	ip[8] = 64 // ttl
+	ip[9] = 17 // udp
+	copy(ip[12:16], src.IP.To4())
+	copy(ip[16:20], dst.IP.To4())
+	binary.BigEndian.PutUint16(ip[10:], checksum(ip))
+
+	pseudo := make([]byte, 12)
+	copy(pseudo[0:4], src.IP.To4())
+	copy(pseudo[4:8], dst.IP.To4())
+	pseudo[9] = 17
+	binary.BigEndian.PutUint16(pseudo[10:], uint16(8+len(payload)))
+	writeUDP(packet[20:], src, dst, payload, pseudo)
+	return packet
+}
+
+func ipv6Packet(src, dst *net.UDPAddr, payload []byte) []byte {
+	packet := make([]byte, 40+8+len(payload))
+	ip := packet[:40]
+	ip[0] = 0x60 // version 6
+	binary.BigEndian.PutUint16(ip[4:], uint16(8+len(payload)))
+	ip[6] = 17 // next header: udp
+	ip[7] = 64 // hop limit
+	copy(ip[8:24], src.IP.To16())
+	copy(ip[24:40], dst.IP.To16())
+
+	pseudo := make([]byte, 40)
+	copy(pseudo[0:16], src.IP.To16())
+	copy(pseudo[16:32], dst.IP.To16())
+	binary.BigEndian.PutUint32(pseudo[32:], uint32(8+len(payload)))
+	pseudo[39] = 17
+	writeUDP(packet[40:], src, dst, payload, pseudo)
+	return packet
+}
+
+func writeUDP(udp []byte, src, dst *net.UDPAddr, payload, pseudo []byte) {
+	binary.BigEndian.PutUint16(udp[0:], uint16(src.Port))
+	binary.BigEndian.PutUint16(udp[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+len(payload)))
+	copy(udp[8:], payload)
+	sum := checksum(pseudo, udp)
+	if sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:], sum)
+}
+
+// checksum computes the internet checksum of the concatenation of parts,
+// all of them but the last one must have an even length.
+func checksum(parts ...[]byte) uint16 {
+	var sum uint32
+	for _, b := range parts {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i:]))
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"errors"
+	"sync"
+)
+
+// readBufferSize is the size of the buffers datagrams are read into, one
+// byte more than the largest datagram expected: a read filling the buffer
+// was truncated (errTruncated), the rest of the datagram is lost.
+const readBufferSize = DatagramSize + 1
+
+var errTruncated = errors.New("truncated datagram")
+
+// The datagram buffers of the transfers are pooled: a boot storm starts
+// many short sessions, each needing one for the replies of its client and
+// one for the DATA packets it sends.
+var datagramPool = sync.Pool{New: func() interface{} { return new([readBufferSize]byte) }}
+
+// getDatagram returns a datagram buffer, to put back with putDatagram once
+// it's no longer referenced.
+func getDatagram() *[readBufferSize]byte {
+	return datagramPool.Get().(*[readBufferSize]byte)
+}
+
+func putDatagram(b *[readBufferSize]byte) {
+	datagramPool.Put(b)
+}
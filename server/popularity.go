@@ -0,0 +1,89 @@
+package server
+
+import (
+	"sort"
+	"sync"
+)
+
+// maxTrackedFiles bounds the popularity table, filenames come from the
+// clients so an unbounded table would be an easy memory exhaustion vector.
+// Only the files served are tracked, the least requested one is evicted
+// for a new file once the table is full.
+const maxTrackedFiles = 10000
+
+// FileStat is the popularity of a requested filename.
+type FileStat struct {
+	Filename string `json:"filename"`
+	Requests int64  `json:"requests"`
+	Bytes    int64  `json:"bytes"`
+}
+
+type fileStats struct {
+	mu sync.Mutex
+	m  map[string]*FileStat
+}
+
+// served counts a request of filename whose file was opened.
+func (f *fileStats) served(filename string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.m == nil {
+		f.m = make(map[string]*FileStat)
+	}
+	st, ok := f.m[filename]
+	if !ok {
+		if len(f.m) >= maxTrackedFiles {
+			f.evict()
+		}
+		st = &FileStat{Filename: filename}
+		f.m[filename] = st
+	}
+	st.Requests++
+}
+
+// evict removes the least requested entry.
+func (f *fileStats) evict() {
+	var least *FileStat
+	for _, st := range f.m {
+		if least == nil || st.Requests < least.Requests || st.Requests == least.Requests && st.Bytes < least.Bytes {
+			least = st
+		}
+	}
+	if least != nil {
+		delete(f.m, least.Filename)
+	}
+}
+
+// transferred adds n bytes to the entry of filename, if it is tracked.
+func (f *fileStats) transferred(filename string, n int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if st, ok := f.m[filename]; ok {
+		st.Bytes += n
+	}
+}
+
+// TopFiles returns the n most requested filenames (by bytes transferred if byBytes is set).
+func (m *Metrics) TopFiles(n int, byBytes bool) []FileStat {
+	m.files.mu.Lock()
+	stats := make([]FileStat, 0, len(m.files.m))
+	for _, st := range m.files.m {
+		stats = append(stats, *st)
+	}
+	m.files.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := stats[i].Requests, stats[j].Requests
+		if byBytes {
+			a, b = stats[i].Bytes, stats[j].Bytes
+		}
+		if a != b {
+			return a > b
+		}
+		return stats[i].Filename < stats[j].Filename
+	})
+	if n > 0 && n < len(stats) {
+		stats = stats[:n]
+	}
+	return stats
+}
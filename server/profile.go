@@ -0,0 +1,166 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile is a provisioning environment selected by client subnet, so one
+// daemon can serve several environments with different content. Its
+// settings replace the server ones for the clients in Networks.
+type Profile struct {
+	Name     string
+	Networks []*net.IPNet
+
+	// File, if set, is served instead of the slots.
+	File    string
+	content slot
+
+	// PXEMapper, if set, replaces the server PXEMapper.
+	PXEMapper PXEMapper
+
+	// Templates are tried before the server ones.
+	Templates []TemplateFile
+
+	// TransferRate, if not 0, replaces the server TransferRate.
+	TransferRate int64
+
+	// ErrorMessages override the server ErrorMessages, by code.
+	ErrorMessages map[ErrCode]string
+}
+
+// profileConfig is the YAML form of a Profile, relative paths are resolved
+// against Root.
+type profileConfig struct {
+	Name         string            `yaml:"name"`
+	Subnets      []string          `yaml:"subnets"`
+	Root         string            `yaml:"root"`
+	File         string            `yaml:"file"`
+	PXEMap       string            `yaml:"pxe_map"`
+	Templates    map[string]string `yaml:"templates"` // glob pattern: template file
+	TransferRate int64             `yaml:"transfer_rate"`
+
+	ErrorMessages map[ErrCode]string `yaml:"error_messages"`
+}
+
+// LoadProfiles reads the profiles of the YAML file name:
+//
+//	profiles:
+//	  - name: lab
+//	    subnets: [10.20.0.0/16]
+//	    root: /srv/lab
+//	    file: pxelinux.0
+//	    pxe_map: pxe.map
+//	    templates: {"cfg/*.conf": cfg.tmpl}
+//	    transfer_rate: 1000000
+//	    error_messages: {1: "Datei nicht gefunden"}
+//
+// A client gets the first profile whose subnets contain its address.
+func LoadProfiles(name string) ([]Profile, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Profiles []profileConfig `yaml:"profiles"`
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&config); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	profiles := make([]Profile, 0, len(config.Profiles))
+	for i, c := range config.Profiles {
+		p, err := c.profile()
+		if err != nil {
+			if c.Name == "" {
+				c.Name = fmt.Sprint("#", i+1)
+			}
+			return nil, fmt.Errorf("%s: profile %s: %w", name, c.Name, err)
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
+
+func (c profileConfig) profile() (Profile, error) {
+	p := Profile{Name: c.Name, TransferRate: c.TransferRate, ErrorMessages: c.ErrorMessages}
+	if c.Name == "" {
+		return p, fmt.Errorf("no name")
+	}
+	if len(c.Subnets) == 0 {
+		return p, fmt.Errorf("no subnets")
+	}
+	for _, s := range c.Subnets {
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return p, err
+		}
+		p.Networks = append(p.Networks, network)
+	}
+	if c.TransferRate < 0 {
+		return p, fmt.Errorf("transfer_rate: %d is negative", c.TransferRate)
+	}
+	resolve := func(file string) string {
+		if c.Root == "" || filepath.IsAbs(file) || isRemoteSource(file) {
+			return file
+		}
+		return filepath.Join(c.Root, file)
+	}
+	if c.File != "" {
+		p.File = resolve(c.File)
+		payload, err := ioutil.ReadFile(p.File)
+		if err != nil {
+			return p, err
+		}
+		p.content = slot{file: p.File, payload: payload, loaded: time.Now()}
+	}
+	if c.PXEMap != "" {
+		table, err := LoadPXETable(resolve(c.PXEMap))
+		if err != nil {
+			return p, err
+		}
+		// the files of the table are relative to the root too
+		p.PXEMapper = func(req PXERequest, client net.Addr) (string, bool) {
+			file, ok := table.Lookup(req, client)
+			return resolve(file), ok
+		}
+	}
+	patterns := make([]string, 0, len(c.Templates))
+	for pattern := range c.Templates {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		tmpl, err := ParseTemplateFile(resolve(c.Templates[pattern]))
+		if err != nil {
+			return p, err
+		}
+		p.Templates = append(p.Templates, TemplateFile{Pattern: pattern, Template: tmpl})
+	}
+	return p, nil
+}
+
+// profileFor returns the profile of client, nil if it has none.
+func (s *TFTPServer) profileFor(client net.Addr) *Profile {
+	ip := net.ParseIP(clientHost(client))
+	if ip == nil {
+		return nil
+	}
+	for i := range s.Profiles {
+		for _, n := range s.Profiles[i].Networks {
+			if n.Contains(ip) {
+				return &s.Profiles[i]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// DHCP message types and options used by the ProxyDHCP responder.
+const (
+	dhcpDiscover = 1
+	dhcpOffer    = 2
+	dhcpRequest  = 3
+	dhcpAck      = 5
+
+	dhcpOptPad          = 0
+	dhcpOptVendor       = 43
+	dhcpOptMessageType  = 53
+	dhcpOptServerID     = 54
+	dhcpOptClassID      = 60
+	dhcpOptTFTPServer   = 66
+	dhcpOptBootFile     = 67
+	dhcpOptClientArch   = 93
+	dhcpOptClientUUID   = 97
+	dhcpOptEnd          = 255
+	dhcpHeaderSize      = 236
+	dhcpFileSize        = 128 // of the file field of the header
+	pxeDiscoveryControl = 6
+)
+
+var (
+	dhcpMagic     = []byte{99, 130, 83, 99}
+	dhcpTypeNames = map[byte]string{dhcpOffer: "offer", dhcpAck: "ack"}
+)
+
+// ProxyDHCP answers the DHCP requests of PXE clients with the boot server
+// and filename only, leaving the address assignment to the DHCP server of
+// the network (the ProxyDHCP scheme of the PXE specification). Lab networks
+// can then boot from this server without changing their DHCP configuration.
+type ProxyDHCP struct {
+	// ServerIP is the address of the TFTP server sent to the clients (next-server).
+	ServerIP net.IP
+
+	// BootFile is the filename sent to the clients whose architecture (DHCP
+	// option 93: 0 BIOS, 6 EFI IA32, 7 and 9 EFI x86-64, 11 EFI ARM64, ...)
+	// isn't in BootFiles. The names are at most 127 bytes, to fit the file
+	// field of the header with its NUL terminator.
+	BootFile  string
+	BootFiles map[uint16]string
+
+	Logger *Logger
+}
+
+// dhcpPacket is the part of a DHCP message the responder needs.
+type dhcpPacket struct {
+	header  []byte // fixed part, up to the magic cookie
+	msgType byte
+	class   string
+	arch    uint16
+	hasArch bool
+	uuid    []byte
+}
+
+func parseDHCP(b []byte) (*dhcpPacket, error) {
+	if len(b) < dhcpHeaderSize+4 || b[0] != 1 || !bytes.Equal(b[dhcpHeaderSize:dhcpHeaderSize+4], dhcpMagic) {
+		return nil, errors.New("not a DHCP request")
+	}
+	p := &dhcpPacket{header: b[:dhcpHeaderSize]}
+	opts := b[dhcpHeaderSize+4:]
+	for len(opts) > 0 {
+		code := opts[0]
+		if code == dhcpOptEnd {
+			break
+		}
+		if code == dhcpOptPad {
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || len(opts) < 2+int(opts[1]) {
+			return nil, errors.New("truncated DHCP option")
+		}
+		value := opts[2 : 2+opts[1]]
+		switch code {
+		case dhcpOptMessageType:
+			if len(value) == 1 {
+				p.msgType = value[0]
+			}
+		case dhcpOptClassID:
+			p.class = string(value)
+		case dhcpOptClientArch:
+			if len(value) >= 2 {
+				p.arch = binary.BigEndian.Uint16(value)
+				p.hasArch = true
+			}
+		case dhcpOptClientUUID:
+			p.uuid = value
+		}
+		opts = opts[2+opts[1]:]
+	}
+	return p, nil
+}
+
+// Validate checks that ServerIP is an IPv4 address and that the boot files
+// fit in the replies.
+func (d *ProxyDHCP) Validate() error {
+	if d.ServerIP.To4() == nil {
+		return errors.New("proxy DHCP: the server IP must be an IPv4 address")
+	}
+	files := []string{d.BootFile}
+	for _, file := range d.BootFiles {
+		files = append(files, file)
+	}
+	for _, file := range files {
+		if len(file) >= dhcpFileSize {
+			return fmt.Errorf("proxy DHCP: boot file %q over %d bytes", file, dhcpFileSize-1)
+		}
+	}
+	return nil
+}
+
+func (p *dhcpPacket) xid() uint32              { return binary.BigEndian.Uint32(p.header[4:8]) }
+func (p *dhcpPacket) ciaddr() net.IP           { return net.IP(p.header[12:16]) }
+func (p *dhcpPacket) giaddr() net.IP           { return net.IP(p.header[24:28]) }
+func (p *dhcpPacket) chaddr() net.HardwareAddr { return net.HardwareAddr(p.header[28:34]) }
+
+func (d *ProxyDHCP) bootFile(p *dhcpPacket) string {
+	if file, ok := d.BootFiles[p.arch]; ok && p.hasArch {
+		return file
+	}
+	return d.BootFile
+}
+
+// reply builds the answer of type msgType to the request p.
+func (d *ProxyDHCP) reply(p *dhcpPacket, msgType byte) []byte {
+	b := make([]byte, dhcpHeaderSize, 300)
+	b[0] = 2                         // BOOTREPLY
+	copy(b[1:12], p.header[1:12])    // htype, hlen, hops, xid, secs, flags
+	copy(b[12:16], p.header[12:16])  // ciaddr
+	copy(b[20:24], d.ServerIP.To4()) // siaddr
+	copy(b[24:28], p.header[24:28])  // giaddr
+	copy(b[28:44], p.header[28:44])  // chaddr
+	file := d.bootFile(p)
+	copy(b[108:108+dhcpFileSize-1], file) // Validate keeps the NUL terminator
+	b = append(b, dhcpMagic...)
+
+	opt := func(code byte, value []byte) {
+		b = append(b, code, byte(len(value)))
+		b = append(b, value...)
+	}
+	opt(dhcpOptMessageType, []byte{msgType})
+	opt(dhcpOptServerID, d.ServerIP.To4())
+	opt(dhcpOptClassID, []byte("PXEClient"))
+	if p.uuid != nil {
+		opt(dhcpOptClientUUID, p.uuid)
+	}
+	// boot straight from the filename, without the PXE boot server discovery
+	opt(dhcpOptVendor, []byte{pxeDiscoveryControl, 1, 8, dhcpOptEnd})
+	opt(dhcpOptTFTPServer, []byte(d.ServerIP.String()))
+	opt(dhcpOptBootFile, []byte(file))
+	return append(b, dhcpOptEnd)
+}
+
+// ListenAndServe answers on the DHCP server port of host (":67" usually)
+// and on the PXE boot server port 4011.
+func (d *ProxyDHCP) ListenAndServe(host string) error {
+	if err := d.Validate(); err != nil {
+		return err
+	}
+	if d.Logger == nil {
+		d.Logger = NewLogger(nil, LevelInfo)
+	}
+	dhcp, err := net.ListenPacket("udp4", net.JoinHostPort(host, "67"))
+	if err != nil {
+		return err
+	}
+	defer dhcp.Close()
+	pxe, err := net.ListenPacket("udp4", net.JoinHostPort(host, "4011"))
+	if err != nil {
+		return err
+	}
+	defer pxe.Close()
+	d.Logger.Info("proxy DHCP listening", "addr", dhcp.LocalAddr(), "pxe_addr", pxe.LocalAddr(), "next_server", d.ServerIP)
+
+	errs := make(chan error, 2)
+	go func() { errs <- d.serve(dhcp, dhcpDiscover, dhcpOffer) }()
+	go func() { errs <- d.serve(pxe, dhcpRequest, dhcpAck) }()
+	return <-errs
+}
+
+// serve answers the PXE requests of type want received on conn with replies of type reply.
+func (d *ProxyDHCP) serve(conn net.PacketConn, want, reply byte) error {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		p, err := parseDHCP(buf[:n])
+		if err != nil {
+			d.Logger.Debug("ignoring datagram", "client", addr, "error", err)
+			continue
+		}
+		if p.msgType != want || len(p.class) < 9 || p.class[:9] != "PXEClient" {
+			continue
+		}
+		to := replyAddr(p, addr, want)
+		if _, err := conn.WriteTo(d.reply(p, reply), to); err != nil {
+			d.Logger.Warn("proxy DHCP reply", "client", p.chaddr(), "error", err)
+			continue
+		}
+		d.Logger.Info("proxy DHCP answer", "type", dhcpTypeNames[reply], "client", p.chaddr(), "xid", p.xid(), "arch", p.arch, "file", d.bootFile(p), "to", to)
+	}
+}
+
+// replyAddr returns the destination of the reply to the request p of type
+// want received from addr.
+func replyAddr(p *dhcpPacket, addr net.Addr, want byte) net.Addr {
+	if want != dhcpDiscover {
+		return addr
+	}
+	// the client has no address yet: broadcast, or go through the relay
+	switch {
+	case !p.giaddr().IsUnspecified():
+		return &net.UDPAddr{IP: p.giaddr(), Port: 67}
+	case !p.ciaddr().IsUnspecified():
+		return &net.UDPAddr{IP: p.ciaddr(), Port: 68}
+	default:
+		return &net.UDPAddr{IP: net.IPv4bcast, Port: 68}
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// dhcpPacketFrom returns a DHCP request from the MAC 52:54:00:12:34:56 with
+// the options opts, giaddr and ciaddr.
+func dhcpPacketFrom(giaddr, ciaddr net.IP, opts ...byte) []byte {
+	b := make([]byte, dhcpHeaderSize)
+	b[0], b[1], b[2] = 1, 1, 6 // BOOTREQUEST, Ethernet
+	copy(b[4:8], []byte{0xde, 0xad, 0xbe, 0xef})
+	copy(b[12:16], ciaddr.To4())
+	copy(b[24:28], giaddr.To4())
+	copy(b[28:34], []byte{0x52, 0x54, 0, 0x12, 0x34, 0x56})
+	b = append(b, dhcpMagic...)
+	return append(b, opts...)
+}
+
+func TestParseDHCP(t *testing.T) {
+	pxe := append([]byte{dhcpOptClassID, 9}, "PXEClient"...)
+	for _, tt := range []struct {
+		name    string
+		opts    []byte
+		want    dhcpPacket // without header
+		invalid bool
+	}{
+		{
+			name: "discover",
+			opts: append(append([]byte{dhcpOptMessageType, 1, dhcpDiscover}, pxe...), dhcpOptClientArch, 2, 0, 7, dhcpOptClientUUID, 3, 0, 1, 2, dhcpOptEnd),
+			want: dhcpPacket{msgType: dhcpDiscover, class: "PXEClient", arch: 7, hasArch: true, uuid: []byte{0, 1, 2}},
+		},
+		{
+			name: "pads",
+			opts: []byte{dhcpOptPad, dhcpOptPad, dhcpOptMessageType, 1, dhcpRequest, dhcpOptPad, dhcpOptEnd},
+			want: dhcpPacket{msgType: dhcpRequest},
+		},
+		{
+			name: "after the end",
+			opts: []byte{dhcpOptMessageType, 1, dhcpDiscover, dhcpOptEnd, dhcpOptMessageType, 1, dhcpRequest, 0xff, 0xff},
+			want: dhcpPacket{msgType: dhcpDiscover},
+		},
+		{
+			name: "without end",
+			opts: []byte{dhcpOptMessageType, 1, dhcpDiscover},
+			want: dhcpPacket{msgType: dhcpDiscover},
+		},
+		{
+			name: "short arch",
+			opts: []byte{dhcpOptClientArch, 1, 7, dhcpOptEnd},
+			want: dhcpPacket{},
+		},
+		{name: "truncated option", opts: []byte{dhcpOptMessageType, 1, dhcpDiscover, dhcpOptClassID, 9, 'P', 'X'}, invalid: true},
+		{name: "option without length", opts: []byte{dhcpOptMessageType}, invalid: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := parseDHCP(dhcpPacketFrom(net.IPv4zero, net.IPv4zero, tt.opts...))
+			if tt.invalid {
+				if err == nil {
+					t.Fatal("parsed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.header = nil
+			if !reflect.DeepEqual(*p, tt.want) {
+				t.Errorf("got %+v, want %+v", *p, tt.want)
+			}
+		})
+	}
+
+	for name, b := range map[string][]byte{
+		"short":       make([]byte, dhcpHeaderSize+3),
+		"reply":       append([]byte{2}, dhcpPacketFrom(net.IPv4zero, net.IPv4zero)[1:]...),
+		"no cookie":   append(make([]byte, dhcpHeaderSize), 1, 2, 3, 4),
+		"not BOOTP 1": append([]byte{0}, dhcpPacketFrom(net.IPv4zero, net.IPv4zero)[1:]...),
+	} {
+		if _, err := parseDHCP(b); err == nil {
+			t.Errorf("%s: parsed", name)
+		}
+	}
+}
+
+func TestProxyDHCPReply(t *testing.T) {
+	d := &ProxyDHCP{
+		ServerIP:  net.IPv4(192, 0, 2, 1),
+		BootFile:  "pxelinux.0",
+		BootFiles: map[uint16]string{7: "ipxe.efi", 11: "grubaa64.efi"},
+	}
+	if err := d.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name string
+		opts []byte
+		file string
+	}{
+		{"no arch", nil, "pxelinux.0"},
+		{"BIOS", []byte{dhcpOptClientArch, 2, 0, 0}, "pxelinux.0"},
+		{"EFI x86-64", []byte{dhcpOptClientArch, 2, 0, 7}, "ipxe.efi"},
+		{"EFI ARM64", []byte{dhcpOptClientArch, 2, 0, 11}, "grubaa64.efi"},
+		{"unknown arch", []byte{dhcpOptClientArch, 2, 0, 16}, "pxelinux.0"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]byte{dhcpOptMessageType, 1, dhcpDiscover}, tt.opts...)
+			opts = append(opts, dhcpOptClientUUID, 2, 0xaa, 0xbb, dhcpOptEnd)
+			p, err := parseDHCP(dhcpPacketFrom(net.IPv4zero, net.IPv4zero, opts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := d.reply(p, dhcpOffer)
+			if b[0] != 2 || !bytes.Equal(b[4:8], p.header[4:8]) || !bytes.Equal(b[28:34], p.header[28:34]) {
+				t.Errorf("header %x doesn't answer the request", b[:34])
+			}
+			if !net.IP(b[20:24]).Equal(d.ServerIP) {
+				t.Errorf("siaddr %v, want %v", net.IP(b[20:24]), d.ServerIP)
+			}
+			if file := string(bytes.TrimRight(b[108:236], "\x00")); file != tt.file {
+				t.Errorf("file field %q, want %q", file, tt.file)
+			}
+			// the reply parses with the options a client reads
+			b[0] = 1
+			reply, err := parseDHCP(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reply.msgType != dhcpOffer || reply.class != "PXEClient" || !bytes.Equal(reply.uuid, []byte{0xaa, 0xbb}) {
+				t.Errorf("reply options %+v", reply)
+			}
+			if i := bytes.Index(b, append([]byte{dhcpOptBootFile, byte(len(tt.file))}, tt.file...)); i < dhcpHeaderSize {
+				t.Error("no boot file option")
+			}
+		})
+	}
+
+	t.Run("longest file", func(t *testing.T) {
+		d := &ProxyDHCP{ServerIP: d.ServerIP, BootFile: strings.Repeat("a", dhcpFileSize-1)}
+		if err := d.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		p, _ := parseDHCP(dhcpPacketFrom(net.IPv4zero, net.IPv4zero, dhcpOptEnd))
+		if b := d.reply(p, dhcpOffer); b[108+dhcpFileSize-1] != 0 {
+			t.Error("file field without its NUL terminator")
+		}
+	})
+}
+
+func TestProxyDHCPValidate(t *testing.T) {
+	long := strings.Repeat("a", dhcpFileSize)
+	for _, d := range []*ProxyDHCP{
+		{ServerIP: net.ParseIP("2001:db8::1"), BootFile: "pxelinux.0"},
+		{ServerIP: nil, BootFile: "pxelinux.0"},
+		{ServerIP: net.IPv4(192, 0, 2, 1), BootFile: long},
+		{ServerIP: net.IPv4(192, 0, 2, 1), BootFile: "pxelinux.0", BootFiles: map[uint16]string{7: long}},
+		{ServerIP: net.IPv4(192, 0, 2, 1), BootFile: strings.Repeat("a", 300)},
+	} {
+		if err := d.Validate(); err == nil {
+			t.Errorf("%v with the files %d bytes %v accepted", d.ServerIP, len(d.BootFile), d.BootFiles)
+		}
+	}
+}
+
+func TestProxyDHCPReplyAddr(t *testing.T) {
+	from := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 5), Port: 68}
+	for _, tt := range []struct {
+		name           string
+		giaddr, ciaddr net.IP
+		want           byte
+		to             string
+	}{
+		{"broadcast", net.IPv4zero, net.IPv4zero, dhcpDiscover, "255.255.255.255:68"},
+		{"relay", net.IPv4(10, 1, 0, 1), net.IPv4zero, dhcpDiscover, "10.1.0.1:67"},
+		{"relay before ciaddr", net.IPv4(10, 1, 0, 1), net.IPv4(10, 2, 0, 9), dhcpDiscover, "10.1.0.1:67"},
+		{"ciaddr", net.IPv4zero, net.IPv4(10, 2, 0, 9), dhcpDiscover, "10.2.0.9:68"},
+		{"port 4011", net.IPv4(10, 1, 0, 1), net.IPv4zero, dhcpRequest, "10.0.0.5:68"},
+	} {
+		p, err := parseDHCP(dhcpPacketFrom(tt.giaddr, tt.ciaddr, dhcpOptEnd))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if to := replyAddr(p, from, tt.want).String(); to != tt.to {
+			t.Errorf("%s: reply to %s, want %s", tt.name, to, tt.to)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// PXERequest is a pxelinux config request. pxelinux asks for
+// pxelinux.cfg/01-<mac> (01 being the ARP hardware type of Ethernet), then
+// pxelinux.cfg/<uuid>, then a few names derived from the client IP and
+// finally pxelinux.cfg/default.
+type PXERequest struct {
+	Filename string
+	MAC      net.HardwareAddr // set for the 01-<mac> form
+	UUID     string           // set for the GUID form, lowercase
+	Default  bool             // pxelinux.cfg/default
+}
+
+var (
+	pxeMACName  = regexp.MustCompile(`^01-([0-9a-fA-F]{2}(-[0-9a-fA-F]{2}){5})$`)
+	pxeUUIDName = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// ParsePXERequest recognizes the pxelinux.cfg/ requests, ok is false for
+// other filenames.
+func ParsePXERequest(filename string) (req PXERequest, ok bool) {
+	dir, name := path.Split(strings.TrimLeft(strings.ReplaceAll(filename, "\\", "/"), "/"))
+	if !strings.HasSuffix(dir, "pxelinux.cfg/") {
+		return req, false
+	}
+	req.Filename = filename
+	switch {
+	case pxeMACName.MatchString(name):
+		req.MAC, _ = net.ParseMAC(strings.ReplaceAll(name[3:], "-", ":"))
+	case pxeUUIDName.MatchString(name):
+		req.UUID = strings.ToLower(name)
+	case name == "default":
+		req.Default = true
+	}
+	return req, true
+}
+
+// PXETable maps machines, by MAC address or UUID, to the config file they
+// receive. The "default" key is served for pxelinux.cfg/default.
+type PXETable map[string]string
+
+// LoadPXETable reads a table of "key file" lines, where key is a MAC
+// address (any of the net.ParseMAC forms), a UUID or default. Empty lines
+// and lines starting with # are ignored.
+func LoadPXETable(name string) (PXETable, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	table := make(PXETable)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"key file\"", name, line)
+		}
+		key := strings.ToLower(fields[0])
+		switch {
+		case key == "default", pxeUUIDName.MatchString(key):
+		default:
+			mac, err := net.ParseMAC(key)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %q is neither a MAC address, a UUID nor default", name, line, fields[0])
+			}
+			key = mac.String()
+		}
+		table[key] = fields[1]
+	}
+	return table, scanner.Err()
+}
+
+// Lookup is a PXEMapper returning the file of the machine of req.
+func (t PXETable) Lookup(req PXERequest, client net.Addr) (string, bool) {
+	var key string
+	switch {
+	case req.MAC != nil:
+		key = req.MAC.String()
+	case req.UUID != "":
+		key = req.UUID
+	case req.Default:
+		key = "default"
+	default:
+		return "", false
+	}
+	file, ok := t[key]
+	return file, ok
+}
+
+// PXEMapper decides which file (a path or a URL, see readSource) the client
+// of a pxelinux config request receives, ok is false if it has none: the
+// client is then told the file doesn't exist and moves on to its next
+// candidate name.
+type PXEMapper func(req PXERequest, client net.Addr) (file string, ok bool)
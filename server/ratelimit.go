@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket paces a byte stream at rate bytes per second, allowing bursts
+// of up to burst bytes. It is safe for concurrent use.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a bucket for rate bytes per second, its burst is a
+// tenth of a second of traffic but no less than a datagram.
+func newTokenBucket(rate int64) *tokenBucket {
+	burst := float64(rate) / 10
+	if burst < DatagramSize {
+		burst = DatagramSize
+	}
+	return &tokenBucket{rate: float64(rate), burst: burst, tokens: burst, last: time.Now()}
+}
+
+// reserve takes n bytes out of the bucket and returns how long to wait
+// before sending them, the bucket goes into debt rather than refusing.
+func (b *tokenBucket) reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// egressBucket returns the bucket shared by all the transfers to enforce
+// EgressRate, nil if there is no limit.
+func (s *TFTPServer) egressBucket() *tokenBucket {
+	s.egressOnce.Do(func() {
+		if s.EgressRate > 0 {
+			s.egress = newTokenBucket(s.EgressRate)
+			s.Metrics.egressLimit.Set(s.EgressRate)
+		}
+	})
+	return s.egress
+}
+
+// wait blocks on sl until n bytes may be sent, it returns early with the
+// error of ctx if it is done first. A nil bucket never waits.
+func (b *tokenBucket) wait(ctx context.Context, n int, sl *sleeper) error {
+	if b == nil {
+		return nil
+	}
+	return sl.sleep(ctx, b.reserve(n))
+}
+
+// sleeper waits with a single timer, reused by a transfer for all its
+// throttled packets rather than allocating one for each.
+type sleeper struct {
+	timer *time.Timer
+}
+
+// sleep waits for d, it returns early with the error of ctx if it is done first.
+func (sl *sleeper) sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	if sl.timer == nil {
+		sl.timer = time.NewTimer(d)
+	} else {
+		sl.timer.Reset(d)
+	}
+	select {
+	case <-sl.timer.C:
+		return nil
+	case <-ctx.Done():
+		if !sl.timer.Stop() {
+			<-sl.timer.C
+		}
+		return ctx.Err()
+	}
+}
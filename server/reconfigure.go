@@ -0,0 +1,46 @@
+package server
+
+import "time"
+
+// Settings are the settings of a TFTPServer that can be changed while it
+// serves, with Reconfigure: the fields of the same name.
+type Settings struct {
+	Authorizer            Authorizer
+	Aliases               map[string]string
+	MaxTransfers          int
+	MaxTransfersPerClient int
+	QueueSize             int
+	QueueTimeout          time.Duration
+}
+
+// Reconfigure replaces the Settings of a serving server all at once, the
+// requests received afterwards get the new ones. The transfers already
+// admitted are left running, even if the new limits are lower, and the
+// queued requests start at once if MaxTransfers is raised.
+func (s *TFTPServer) Reconfigure(c Settings) {
+	s.settingsMu.Lock()
+	s.Authorizer = c.Authorizer
+	s.Aliases = c.Aliases
+	s.MaxTransfers = c.MaxTransfers
+	s.MaxTransfersPerClient = c.MaxTransfersPerClient
+	s.QueueSize = c.QueueSize
+	s.QueueTimeout = c.QueueTimeout
+	s.settingsMu.Unlock()
+	s.admission.admit(c.MaxTransfers)
+	s.Logger.Info("settings changed", "acl", c.Authorizer != nil, "aliases", len(c.Aliases), "max_transfers", c.MaxTransfers, "max_client_transfers", c.MaxTransfersPerClient, "queue_size", c.QueueSize, "queue_timeout", c.QueueTimeout)
+}
+
+// settings returns the current Settings, the fields must not be read
+// directly once serving.
+func (s *TFTPServer) settings() Settings {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return Settings{
+		Authorizer:            s.Authorizer,
+		Aliases:               s.Aliases,
+		MaxTransfers:          s.MaxTransfers,
+		MaxTransfersPerClient: s.MaxTransfersPerClient,
+		QueueSize:             s.QueueSize,
+		QueueTimeout:          s.QueueTimeout,
+	}
+}
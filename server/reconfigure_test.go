@@ -0,0 +1,42 @@
+package server_test
+
+import (
+	"encoding/binary"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+func TestReconfigureAdmitsQueued(t *testing.T) {
+	var s *server.TFTPServer
+	addr := startServer(t, func(srv *server.TFTPServer) {
+		s = srv
+		s.FS = fstest.MapFS{"pxelinux.0": {Data: make([]byte, 4*server.BlockSize)}}
+		s.MaxTransfers = 1
+		s.QueueSize = 4
+		s.QueueTimeout = time.Minute
+		s.Timeout = time.Minute // the first transfer waits for its ACK meanwhile
+	})
+	if _, reply, _ := sendRequest(t, addr, server.ReadOp, "pxelinux.0", nil); server.Opcode(binary.BigEndian.Uint16(reply)) != server.DataOp {
+		t.Fatalf("got %v, want DATA 1", reply)
+	}
+	queued := requestConn(t, addr, server.ReadOp, "pxelinux.0", nil)
+	for deadline := time.Now().Add(5 * time.Second); s.ActiveHandlers() < 2; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the second request wasn't queued")
+		}
+	}
+
+	s.Reconfigure(server.Settings{MaxTransfers: 2, QueueSize: 4, QueueTimeout: time.Minute})
+	queued.SetReadDeadline(time.Now().Add(time.Second)) // well before the queue timeout
+	buf := make([]byte, 1024)
+	n, _, err := queued.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("the queued request didn't start once the limit was raised: %v", err)
+	}
+	if n < 4 || server.Opcode(binary.BigEndian.Uint16(buf)) != server.DataOp {
+		t.Fatalf("got %v, want DATA 1", buf[:n])
+	}
+}
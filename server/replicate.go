@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Replicator copies every successfully uploaded file to peer servers, so that
+// uploads (typically device configuration backups) are stored redundantly.
+// Targets are URLs the uploaded filename is appended to:
+//
+//	tftp://host[:port][/dir]  written with a TFTP write request
+//	http(s)://host/dir        written with an HTTP PUT
+//
+// Replication runs in the background, failures are logged.
+type Replicator struct {
+	Targets []string
+
+	// Timeout bounds each replication (each TFTP reply wait, or each HTTP request).
+	Timeout time.Duration
+}
+
+// replicate copies the file written by the upload described by summary.
+func (r *Replicator) replicate(summary TransferSummary, logger *Logger) {
+	if summary.Path == "" || len(r.Targets) == 0 {
+		return
+	}
+	go func() {
+		content, err := ioutil.ReadFile(summary.Path)
+		if err != nil {
+			logger.Warn("replicating upload", "file", summary.Filename, "error", err)
+			return
+		}
+		for _, target := range r.Targets {
+			start := time.Now()
+			if err := r.replicateTo(target, summary.Filename, content); err != nil {
+				logger.Warn("replicating upload", "file", summary.Filename, "target", target, "error", err)
+				continue
+			}
+			logger.Info("upload replicated", "file", summary.Filename, "target", target, "duration", time.Since(start))
+		}
+	}()
+}
+
+func (r *Replicator) replicateTo(target, filename string, content []byte) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	name := path.Join(u.Path, filename)
+	switch u.Scheme {
+	case "tftp":
+		addr := u.Host
+		if u.Port() == "" {
+			addr = net.JoinHostPort(u.Hostname(), "69")
+		}
+		return tftpPut(addr, strings.TrimPrefix(name, "/"), content, timeout)
+	case "http", "https":
+		u.Path = name
+		req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(content))
+		if err != nil {
+			return err
+		}
+		resp, err := (&http.Client{Timeout: timeout}).Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("PUT %s: %s", u, resp.Status)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported replication target %q", target)
+	}
+}
+
+// tftpPut writes content to filename on the TFTP server at addr (RFC 1350
+// octet mode, 512 bytes blocks). Each reply is waited for timeout and packets
+// are retransmitted up to 5 times.
+func tftpPut(addr, filename string, content []byte, timeout time.Duration) error {
+	const retries = 5
+
+	server, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	wrq := make([]byte, 0, 2+len(filename)+1+len("octet")+1)
+	wrq = append(wrq, 0, byte(WriteOp))
+	wrq = append(append(wrq, filename...), 0)
+	wrq = append(append(wrq, "octet"...), 0)
+
+	// the server replies from a new port (its transfer ID), which becomes the peer
+	var peer *net.UDPAddr
+	buf := make([]byte, DatagramSize)
+	packet, dst := wrq, server
+	for block := uint16(0); ; block++ {
+		acked := false
+		for i := 0; i < retries && !acked; i++ {
+			if _, err := conn.WriteToUDP(packet, dst); err != nil {
+				return err
+			}
+			conn.SetReadDeadline(time.Now().Add(timeout))
+			for {
+				n, from, err := conn.ReadFromUDP(buf)
+				if err != nil {
+					if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+						break
+					}
+					return err
+				}
+				if peer != nil && !(from.IP.Equal(peer.IP) && from.Port == peer.Port) {
+					continue // stray packet
+				}
+				if n < 4 {
+					continue
+				}
+				switch Opcode(binary.BigEndian.Uint16(buf[:2])) {
+				case ErrorOp:
+					var e Err
+					if err := e.UnmarshalBinary(buf[:n]); err != nil {
+						return errors.New("malformed ERROR packet")
+					}
+					return e
+				case AcknowledgmentOp:
+					if binary.BigEndian.Uint16(buf[2:4]) != block {
+						continue // duplicate ACK of a previous block
+					}
+					if peer == nil {
+						peer = from
+					}
+					acked = true
+				}
+				if acked {
+					break
+				}
+			}
+		}
+		if !acked {
+			return fmt.Errorf("no reply from %s", addr)
+		}
+
+		start := int(block) * BlockSize
+		if start > len(content) {
+			return nil // the last (short) block was acknowledged
+		}
+		end := start + BlockSize
+		if end > len(content) {
+			end = len(content)
+		}
+		packet = make([]byte, 4, 4+end-start)
+		binary.BigEndian.PutUint16(packet[0:], uint16(DataOp))
+		binary.BigEndian.PutUint16(packet[2:], block+1)
+		packet = append(packet, content[start:end]...)
+		dst = peer
+	}
+}
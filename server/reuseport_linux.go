@@ -0,0 +1,44 @@
+//go:build linux
+
+package server
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenReusePort binds n UDP sockets to address with SO_REUSEPORT, the
+// kernel spreads the datagrams over them by hashing their source.
+func listenReusePort(address string, n int) ([]net.PacketConn, error) {
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		var serr error
+		err := c.Control(func(fd uintptr) {
+			serr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+		})
+		if err != nil {
+			return err
+		}
+		return serr
+	}}
+	var conns []net.PacketConn
+	for len(conns) < n {
+		conn, err := lc.ListenPacket(context.Background(), "udp", address)
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, err
+		}
+		if len(conns) == 0 {
+			// the other sockets join the port picked for the first one
+			host, _, _ := net.SplitHostPort(address)
+			address = net.JoinHostPort(host, strconv.Itoa(conn.LocalAddr().(*net.UDPAddr).Port))
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
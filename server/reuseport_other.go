@@ -0,0 +1,12 @@
+//go:build !linux
+
+package server
+
+import (
+	"errors"
+	"net"
+)
+
+func listenReusePort(address string, n int) ([]net.PacketConn, error) {
+	return nil, errors.New("several listeners need SO_REUSEPORT load balancing, only available on Linux")
+}
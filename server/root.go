@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// rootFile resolves filename against the Root directory tree. A leading
+// slash is allowed (the filenames are relative to Root whatever their
+// form), the files reached through a symbolic link pointing out of Root
+// are refused with an access violation and the missing files, like the
+// directories, get file not found.
+func (s *TFTPServer) rootFile(logger *Logger, client net.Addr, filename string) (string, error) {
+	name := path.Clean("/" + filename)
+	file, err := filepath.EvalSymlinks(filepath.Join(s.Root, filepath.FromSlash(name)))
+	if err != nil {
+		return "", rootError(err)
+	}
+	if escapes(s.Root, file) {
+		s.securityEvent(logger, SecurityPathTraversal, client, filename, "symbolic link escapes the root directory")
+		return "", ErrorAccessViolation(msgAccess)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", rootError(err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", errNoSuchFile
+	}
+	return file, nil
+}
+
+// rootError maps the error looking up a file of Root to the error sent to
+// the client.
+func rootError(err error) error {
+	if os.IsPermission(err) {
+		return ErrorAccessViolation(msgAccess)
+	}
+	return errNoSuchFile
+}
+
+// escapes reports whether the path file, once its symbolic links are
+// resolved, is out of the directory tree root.
+func escapes(root, file string) bool {
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return true
+	}
+	if file, err = filepath.EvalSymlinks(file); err != nil {
+		return true
+	}
+	rel, err := filepath.Rel(root, file)
+	return err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,66 @@
+package server_test
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+func TestRootEscapes(t *testing.T) {
+	dir := t.TempDir()
+	root, outside := filepath.Join(dir, "root"), filepath.Join(dir, "outside")
+	for _, d := range []string{filepath.Join(root, "pxe"), outside} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range map[string]string{
+		filepath.Join(root, "pxe", "pxelinux.0"): "boot",
+		filepath.Join(outside, "secret"):         "secret",
+	} {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret"), filepath.Join(root, "link")); err != nil {
+		t.Skipf("symbolic links: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "pxe", "dir")); err != nil {
+		t.Fatal(err)
+	}
+	addr := startServer(t, func(s *server.TFTPServer) {
+		s.Root = root
+	})
+
+	for _, tt := range []struct {
+		name string
+		file string
+		code server.ErrCode
+	}{
+		{"parent", "../outside/secret", server.ErrAccessViolation},
+		{"nested parent", "pxe/../../outside/secret", server.ErrAccessViolation},
+		{"backslashes", `pxe\..\..\outside\secret`, server.ErrAccessViolation},
+		{"absolute parent", "/../outside/secret", server.ErrAccessViolation},
+		{"absolute nested parent", "/pxe/../../outside/secret", server.ErrAccessViolation},
+		{"file symlink", "link", server.ErrAccessViolation},
+		{"directory symlink", "pxe/dir/secret", server.ErrAccessViolation},
+		// an absolute filename is relative to the root like with a leading
+		// slash, it never names a file of the host
+		{"absolute host path", filepath.ToSlash(filepath.Join(outside, "secret")), server.ErrNotFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, reply, _ := sendRequest(t, addr, server.ReadOp, tt.file, nil)
+			if code := errorCode(t, reply); code != tt.code {
+				t.Errorf("%s: error code %d, want %d", tt.file, code, tt.code)
+			}
+		})
+	}
+
+	_, reply, _ := sendRequest(t, addr, server.ReadOp, "/pxe/pxelinux.0", nil)
+	if server.Opcode(binary.BigEndian.Uint16(reply)) != server.DataOp || string(reply[4:]) != "boot" {
+		t.Errorf("/pxe/pxelinux.0: got %v, want DATA 1 of the file", reply)
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RotatingFile is an io.WriteCloser appending to a file that is rotated once it
+// grows beyond MaxSize bytes or gets older than MaxAge. Rotated files are renamed
+// to <Filename>.<timestamp> and only the newest MaxBackups of them are kept.
+// A zero value for any limit disables it.
+type RotatingFile struct {
+	Filename   string
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	created time.Time
+}
+
+const rotateTimeFormat = "20060102T150405.000"
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if r.shouldRotate(int64(len(p))) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+func (r *RotatingFile) shouldRotate(n int64) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.MaxSize > 0 && r.size+n > r.MaxSize {
+		return true
+	}
+	return r.MaxAge > 0 && time.Since(r.created) > r.MaxAge
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.Filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file = f
+	r.size = info.Size()
+	// the creation time isn't portable, the modification time of a reopened file is a good enough estimate
+	r.created = time.Now()
+	if r.size > 0 {
+		r.created = info.ModTime()
+	}
+	return nil
+}
+
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+
+	backup := r.Filename + "." + time.Now().Format(rotateTimeFormat)
+	if err := os.Rename(r.Filename, backup); err != nil {
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	r.created = time.Now()
+	return r.prune()
+}
+
+// prune removes the oldest backups beyond MaxBackups.
+func (r *RotatingFile) prune() error {
+	if r.MaxBackups <= 0 {
+		return nil
+	}
+
+	dir := filepath.Dir(r.Filename)
+	prefix := filepath.Base(r.Filename) + "."
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var backups []string
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if _, err := time.Parse(rotateTimeFormat, name[len(prefix):]); err != nil {
+			continue
+		}
+		backups = append(backups, name)
+	}
+	if len(backups) <= r.MaxBackups {
+		return nil
+	}
+
+	// the timestamp format sorts chronologically
+	sort.Strings(backups)
+	for _, name := range backups[:len(backups)-r.MaxBackups] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("removing old log file: %w", err)
+		}
+	}
+	return nil
+}
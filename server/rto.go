@@ -0,0 +1,70 @@
+package server
+
+import "time"
+
+// The bounds of the adaptive retransmission timeout. RFC 6298 floors it at
+// 1s for TCP, a LAN transfer would stall on every lost block with that.
+const (
+	minRTO = 100 * time.Millisecond
+	maxRTO = 60 * time.Second
+)
+
+// rtoEstimator is the retransmission timeout of a transfer. With
+// TFTPServer.AdaptiveTimeout it follows the round trip times measured like
+// TCP does (RFC 6298): it starts at the configured timeout, moves to the
+// smoothed RTT plus four times its variation and doubles on every timeout.
+// Otherwise, or if the client negotiated a timeout (RFC 2349), it stays
+// fixed.
+type rtoEstimator struct {
+	rto          time.Duration
+	srtt, rttvar time.Duration
+	sampled      bool
+	fixed        bool
+}
+
+func (s *TFTPServer) newRTO(opts transferOptions) *rtoEstimator {
+	_, negotiated := opts.oack[optTimeout]
+	return &rtoEstimator{rto: opts.timeout, fixed: !s.AdaptiveTimeout || negotiated}
+}
+
+// timeout returns the time to wait for the answer to a packet.
+func (e *rtoEstimator) timeout() time.Duration {
+	return e.rto
+}
+
+// sample updates the timeout with the round trip time of a packet. The ones
+// of retransmitted packets are ambiguous and must not be sampled (Karn's
+// algorithm).
+func (e *rtoEstimator) sample(rtt time.Duration) {
+	if e.fixed {
+		return
+	}
+	if !e.sampled {
+		e.srtt, e.rttvar, e.sampled = rtt, rtt/2, true
+	} else {
+		delta := e.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		e.rttvar = (3*e.rttvar + delta) / 4
+		e.srtt = (7*e.srtt + rtt) / 8
+	}
+	e.set(e.srtt + 4*e.rttvar)
+}
+
+// backoff doubles the timeout after a timeout.
+func (e *rtoEstimator) backoff() {
+	if !e.fixed {
+		e.set(2 * e.rto)
+	}
+}
+
+func (e *rtoEstimator) set(rto time.Duration) {
+	switch {
+	case rto < minRTO:
+		rto = minRTO
+	case rto > maxRTO:
+		rto = maxRTO
+	}
+	e.rto = rto
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"path"
+	"strings"
+	"time"
+)
+
+// SecurityCode identifies a kind of security violation, the codes are stable
+// so that external tooling (SIEM rules, fail2ban filters) can match on them.
+type SecurityCode string
+
+const (
+	SecurityPathTraversal   SecurityCode = "TFTP-SEC-001" // requested filename escapes the served directory
+	SecurityACLDenied       SecurityCode = "TFTP-SEC-002" // request refused by an access control rule
+	SecurityQuotaExceeded   SecurityCode = "TFTP-SEC-003" // client went over a rate or volume quota
+	SecurityMalformedPacket SecurityCode = "TFTP-SEC-004" // datagram that isn't a valid TFTP packet
+)
+
+var securityNames = map[SecurityCode]string{
+	SecurityPathTraversal:   "path_traversal",
+	SecurityACLDenied:       "acl_denied",
+	SecurityQuotaExceeded:   "quota_exceeded",
+	SecurityMalformedPacket: "malformed_packet",
+}
+
+// Name returns the short snake_case name of the violation.
+func (c SecurityCode) Name() string {
+	if name, ok := securityNames[c]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// SecurityEvent is a security violation attributed to a client.
+type SecurityEvent struct {
+	Time     time.Time    `json:"time"`
+	Code     SecurityCode `json:"code"`
+	Name     string       `json:"event"`
+	Client   string       `json:"client"`
+	ClientIP string       `json:"client_ip"`
+	Filename string       `json:"filename,omitempty"`
+	Detail   string       `json:"detail"`
+}
+
+// securityEvent reports a violation: it is logged as a warning with stable
+// "event" and "code" fields, counted and, if SecurityLog is set, written
+// there as a single JSON line.
+func (s *TFTPServer) securityEvent(logger *Logger, code SecurityCode, client net.Addr, filename, detail string) {
+	event := SecurityEvent{
+		Time:     time.Now(),
+		Code:     code,
+		Name:     code.Name(),
+		Client:   client.String(),
+		ClientIP: clientHost(client),
+		Filename: filename,
+		Detail:   detail,
+	}
+	s.Metrics.securityEvents.With(string(code)).Inc()
+	logger.Warn("security violation", "event", event.Name, "code", code, "client", client, "file", filename, "detail", detail)
+
+	if s.SecurityLog == nil {
+		return
+	}
+	line, _ := json.Marshal(event)
+	s.securityMu.Lock()
+	defer s.securityMu.Unlock()
+	s.SecurityLog.Write(append(line, '\n'))
+}
+
+// traversesPath reports whether filename climbs above the directory it is
+// resolved against (a leading slash is common with PXE clients and allowed).
+func traversesPath(filename string) bool {
+	name := strings.TrimLeft(strings.ReplaceAll(filename, "\\", "/"), "/")
+	clean := path.Clean(name)
+	return clean == ".." || strings.HasPrefix(clean, "../")
+}
@@ -0,0 +1,114 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+// securityLog collects the security events of a server.
+type securityLog struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *securityLog) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(b)
+}
+
+// wait waits for an event of code to be logged, the server reports the
+// events after its reply.
+func (l *securityLog) wait(t *testing.T, code server.SecurityCode) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		l.mu.Lock()
+		logged := strings.Contains(l.buf.String(), `"code":"`+string(code)+`"`)
+		l.mu.Unlock()
+		if logged {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no %s event logged", code)
+		}
+	}
+}
+
+// sendRequest sends a request for filename to the server at addr from a
+// port of its own and returns the socket and the first reply.
+func sendRequest(t *testing.T, addr string, op server.Opcode, filename string, options map[string]string) (*net.UDPConn, []byte, *net.UDPAddr) {
+	t.Helper()
+	conn := requestConn(t, addr, op, filename, options)
+	reply, from := readPacket(t, conn)
+	return conn, reply, from
+}
+
+// requestConn sends a request for filename to the server at addr from a
+// port of its own and returns the socket, closed at the end of the test.
+func requestConn(t *testing.T, addr string, op server.Opcode, filename string, options map[string]string) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	serverAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := server.ReadWriteRequest{Op: op, Filename: filename, Mode: "octet", Options: options}.MarshalBinary()
+	if _, err := conn.WriteToUDP(req, serverAddr); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// readPacket reads the next datagram of conn, within 5 seconds.
+func readPacket(t *testing.T, conn *net.UDPConn) ([]byte, *net.UDPAddr) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 65536)
+	n, from, err := conn.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf[:n], from
+}
+
+// errorCode returns the code of the ERROR packet b, it fails the test if b
+// is another packet.
+func errorCode(t *testing.T, b []byte) server.ErrCode {
+	t.Helper()
+	var e server.Err
+	if len(b) < 4 || server.Opcode(binary.BigEndian.Uint16(b)) != server.ErrorOp || e.UnmarshalBinary(b) != nil {
+		t.Fatalf("got %v, want an ERROR packet", b)
+	}
+	return e.Code
+}
+
+func TestClientLimitSecurityEvent(t *testing.T) {
+	events := &securityLog{}
+	addr := startServer(t, func(s *server.TFTPServer) {
+		s.FS = fstest.MapFS{"pxelinux.0": {Data: make([]byte, 4*server.BlockSize)}}
+		s.MaxTransfersPerClient = 1
+		s.Timeout = time.Minute // the first transfer waits for its ACK meanwhile
+		s.SecurityLog = events
+	})
+	// the first transfer holds the only slot of the client
+	if _, reply, _ := sendRequest(t, addr, server.ReadOp, "pxelinux.0", nil); server.Opcode(binary.BigEndian.Uint16(reply)) != server.DataOp {
+		t.Fatalf("got %v, want DATA 1", reply)
+	}
+	_, reply, _ := sendRequest(t, addr, server.ReadOp, "pxelinux.0", nil)
+	if code := errorCode(t, reply); code != server.ErrUnknown {
+		t.Errorf("error code %d, want %d", code, server.ErrUnknown)
+	}
+	events.wait(t, server.SecurityQuotaExceeded)
+}
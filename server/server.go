@@ -1,138 +1,1241 @@
 package server
 
 import (
-	"bytes"
+	"context"
 	"encoding/binary"
-	"io/ioutil"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"net"
+	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type TFTPServer struct {
 	address string
-	payload []byte
-	retries uint8
-	timeout time.Duration
+	slots   slots
+
+	// Logger receives the server diagnostics, it defaults to a text logger on stderr at LevelInfo.
+	Logger *Logger
+
+	// AccessLog, if set, receives one line per finished transfer (see TransferSummary.String).
+	AccessLog io.Writer
+	accessMu  sync.Mutex
+
+	// SecurityLog, if set, receives one JSON line per security violation (see SecurityEvent).
+	SecurityLog io.Writer
+	securityMu  sync.Mutex
+
+	// Metrics collects the server counters, serve it over HTTP to expose them to Prometheus.
+	Metrics *Metrics
+
+	// Tracer, if set, creates a span per transfer.
+	Tracer       Tracer
+	traceParents traceParents
+
+	// Hooks are the transfer lifecycle callbacks.
+	Hooks Hooks
+
+	// DebugPackets logs a decoded summary and a hexdump of every datagram,
+	// restricted to DebugPacketsClients if it is not empty.
+	DebugPackets        bool
+	DebugPacketsClients []net.IP
+
+	// Capture, if set, records the datagrams of the transfers accepted by CaptureFilter (all if nil).
+	Capture       *PcapWriter
+	CaptureFilter func(info TransferInfo) bool
+
+	// Webhooks, if set, are notified of every finished transfer.
+	Webhooks *Webhooks
+
+	// UploadDir is the directory the uploads are stored in, they are refused if it is empty.
+	UploadDir string
+
+	// UploadSync is the fsync policy of the uploads.
+	UploadSync SyncPolicy
+
+	// UploadOverwrite lets the uploads replace the existing files.
+	UploadOverwrite bool
+
+	// SparseUploads writes the blocks of zeros of the uploads as holes.
+	SparseUploads bool
+
+	// PostUploadHook, if set, runs after every successful upload.
+	PostUploadHook *ExecHook
+
+	// Replicator, if set, copies every successful upload to peer servers.
+	Replicator *Replicator
+
+	// LogSampling, if set, limits the info lines logged during load peaks.
+	LogSampling *LogSampling
+
+	// DetailedTraceEvery logs one in every DetailedTraceEvery transfers at LevelDebug (0 disables it).
+	DetailedTraceEvery int
+	tracedTransfers    uint64
+
+	// MaxTransfers limits the transfers running at once (0 for no limit), up
+	// to QueueSize more requests wait at most QueueTimeout for one to finish.
+	MaxTransfers int
+	QueueSize    int
+	QueueTimeout time.Duration
+	admission    admission
+	settingsMu   sync.RWMutex // guards the Settings, see Reconfigure
+
+	// MaxTransfersPerClient limits the transfers of a client IP (0 for no limit).
+	MaxTransfersPerClient int
+
+	// MaxTransferDuration aborts the transfers running longer (0 for no limit).
+	MaxTransferDuration time.Duration
+
+	// TransferRate and EgressRate limit the bytes per second of each transfer
+	// and of all of them together (0 for no limit).
+	TransferRate int64
+	EgressRate   int64
+	egress       *tokenBucket
+	egressOnce   sync.Once
+
+	// Listeners is the number of SO_REUSEPORT sockets bound to the TFTP port on Linux.
+	Listeners int
+
+	// SinglePort sends the transfers from the TFTP port instead of an
+	// ephemeral port each, for the firewalls only letting port 69 through.
+	SinglePort bool
+
+	// Listen are the addresses to listen on instead of the one given to NewTFTPServer.
+	Listen []ListenConfig
+
+	// Timeout and Retries are the retransmission settings of the transfers.
+	Timeout time.Duration
+	Retries int
+
+	// AdaptiveTimeout adapts the retransmission timeout of each transfer to
+	// its measured round trip times, starting from Timeout.
+	AdaptiveTimeout bool
+
+	// DefaultBlockSize is the size of the blocks sent without a blksize
+	// option, BlockSize if 0: only controlled networks suit a larger one.
+	DefaultBlockSize int
+
+	// BlockRollover is the block number following 65535, 0 or 1, -1 refuses
+	// the transfers of more than MaxBlocks blocks.
+	BlockRollover int
+
+	// StreamThreshold is the size from which the files are streamed from
+	// disk instead of read in memory (negative to read them all in memory).
+	StreamThreshold int64
+
+	// ReloadChanged loads the served file again before a transfer if it changed on disk.
+	ReloadChanged bool
+
+	// MaxWindowSize is the largest windowsize option (RFC 7440) accepted, 64 if 0.
+	MaxWindowSize int
+
+	// ReadBatch is the number of requests read per system call on Linux (recvmmsg).
+	ReadBatch int
+
+	// Pacing, if set, spaces the DATA packets of each transfer.
+	Pacing *Pacing
+
+	// Faults, if set, drops, delays or duplicates DATA packets, for testing clients.
+	Faults *Faults
+
+	// MemoryBudget refuses new transfers while the estimated memory in use is
+	// over this many bytes (0 for no limit).
+	MemoryBudget int64
+
+	// NormalizeBackslashes turns the backslashes of the requested filenames
+	// into slashes, for WDS and other Windows clients.
+	NormalizeBackslashes bool
+
+	// Rewrites rename the requested files, the first matching rule applies.
+	Rewrites []Rewrite
+
+	// Aliases map requested filenames to the file, path or URL, served for them.
+	Aliases map[string]string
+
+	// ArchRoots are the directories of the well-known bootloaders by architecture.
+	ArchRoots map[string]string
+
+	// Latest rules serve the newest build of a directory, see LatestRule.
+	Latest []LatestRule
+
+	// Fallbacks serve the first existing file of a list of candidates.
+	Fallbacks []FallbackChain
+
+	// Root, if set, is the directory tree the requested files are read from,
+	// the paths escaping it are refused.
+	Root string
+
+	// ReadHandler, then FS, if set, serve the requested files instead of Root.
+	ReadHandler ReadHandler
+	FS          fs.FS
+
+	// Profiles override the content and rate settings for client subnets.
+	Profiles []Profile
+
+	// Inventory, if set, is queried for every request, see InventoryRecord.
+	Inventory Inventory
+
+	// BootMenu, if set, is served as pxelinux and GRUB menus.
+	BootMenu *BootMenu
+
+	// PXEMapper, if set, picks the file of each pxelinux.cfg/ request.
+	PXEMapper PXEMapper
+
+	// IPXE, if set, renders the iPXE scripts of the matching requests.
+	IPXE *IPXEScript
+
+	// Templates render the matching requests, the first match wins.
+	Templates []TemplateFile
+
+	// ErrorMessages override the text of the ERROR packets by code.
+	ErrorMessages map[ErrCode]string
+
+	// PriorityClasses order the admission queue and set per-class transfer rates.
+	PriorityClasses []PriorityClass
+
+	// Authorizer, if set, refuses the requests it returns an error for,
+	// reads by their rewritten name.
+	Authorizer Authorizer
+
+	cluster *Cluster
+
+	wrapUpload func(f *os.File) uploadFile // if set, the uploads are written through it (tests)
+
+	// SelfTest, if set, checks the server once it listens, it isn't Ready
+	// until SelfTest returns nil.
+	SelfTest   func(addr net.Addr) error
+	selfTested int32
+
+	// AdminToken, if set, is the bearer token of the admin API, see AdminAuth.
+	AdminToken string
+
+	maintenance  int32
+	handlers     int64 // see ActiveHandlers
+	handlersDone sync.WaitGroup
+	aborting     int32 // set once Shutdown cancels the transfers
+	serving      int32 // set once listenAddr and started are
+	started      time.Time
+
+	listenAddr net.Addr
+	closeMu    sync.Mutex
+	closed     bool
+	closedCh   chan struct{} // closed by Close, see closedChan
+	listeners  []net.PacketConn
+	listenCfgs map[net.PacketConn]ListenConfig // of the listeners, set before serving
+	transfers  transferRegistry
+	wheel      *timerWheel // of the retransmission timeouts
+	ports      portMux     // of the transfers in single-port mode
+	shared     sharedFiles
 }
 
-func NewTFTPServer(host string, port int, file string) *TFTPServer {
-	p, err := ioutil.ReadFile(file)
+// NewTFTPServer returns a server listening on host:port and serving file,
+// which may be empty for a server of the Root directory, a ReadHandler or
+// an FS. The error is the one reading file.
+func NewTFTPServer(host string, port int, file string) (*TFTPServer, error) {
+	s := &TFTPServer{address: net.JoinHostPort(host, strconv.Itoa(port)), Timeout: defaultTimeout, Retries: defaultRetries, StreamThreshold: DefaultStreamThreshold, Logger: NewLogger(nil, LevelInfo), Metrics: NewMetrics()}
+	if file == "" {
+		return s, nil
+	}
+	sl, err := s.loadSlot(file)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return &TFTPServer{address: net.JoinHostPort(host, strconv.Itoa(port)), payload: p, retries: 10, timeout: 5 * time.Second}
+	s.slots.s[0] = sl
+	return s, nil
 }
 
 const (
 	DatagramSize = 516
 	BlockSize    = DatagramSize - 4 // DatagramSize - 4-byte tftp header
+	MaxBlockSize = 65464            // largest block of RFC 2348
+	MaxBlocks    = 1<<16 - 1        // numbered before the block numbers, 16 bits starting at 1, roll over
 )
 
+// errTooManyBlocks fails the transfers of the files over MaxBlocks blocks
+// if BlockRollover is -1.
+var errTooManyBlocks = errors.New("file too large for the block numbers")
+
+// blockNumber returns the number sent for the block n of a transfer, n
+// starting at 1: the numbers roll over to BlockRollover after 65535.
+func (s *TFTPServer) blockNumber(n int) uint16 {
+	if n <= MaxBlocks || s.BlockRollover != 1 {
+		return uint16(n)
+	}
+	return uint16((n-1)%MaxBlocks + 1)
+}
+
+// blocksAcked returns the number of blocks past the block acked (see
+// blockNumber) an ACK of number acknowledges, modulo the block numbers,
+// -1 for a number no block has.
+func (s *TFTPServer) blocksAcked(acked int, number uint16) int {
+	if s.BlockRollover != 1 || acked == 0 {
+		return int(number - uint16(acked))
+	}
+	if number == 0 {
+		return -1
+	}
+	return (int(number) - int(s.blockNumber(acked)) + MaxBlocks) % MaxBlocks
+}
+
+// blockSize returns the size of the DATA blocks, see DefaultBlockSize.
+func (s *TFTPServer) blockSize() int {
+	if s.DefaultBlockSize > 0 {
+		return s.DefaultBlockSize
+	}
+	return BlockSize
+}
+
+// ErrServerClosed is returned by ListenAndServe after a call to Close.
+var ErrServerClosed = errors.New("tftp: server closed")
+
+// ListenAndServe listens on the TFTP port and serves the requests until
+// Close is called, it returns ErrServerClosed then. The errors reading the
+// requests are logged and retried, only a listener closed by something
+// else stops the server.
 func (s *TFTPServer) ListenAndServe() error {
-	listener, err := net.ListenPacket("udp", s.address)
+	if s.closing() {
+		return ErrServerClosed
+	}
+	listeners, err := s.listen()
 	if err != nil {
 		return err
 	}
-	defer listener.Close()
-	log.Printf("Listening on: %v", listener.LocalAddr())
+	s.closeMu.Lock()
+	s.listeners = listeners
+	closed := s.closed
+	s.closeMu.Unlock()
+	if closed {
+		for _, l := range listeners {
+			l.Close()
+		}
+		return ErrServerClosed
+	}
+	s.listenAddr = listeners[0].LocalAddr()
+	s.started = time.Now()
+	atomic.StoreInt32(&s.serving, 1)
+	defer atomic.StoreInt32(&s.serving, 0)
+	stop := make(chan struct{})
+	if s.SelfTest != nil {
+		go s.selfTest(stop)
+	}
+	defer func() {
+		// the transfers still running need the timer wheel
+		go func() {
+			s.handlersDone.Wait()
+			close(stop)
+		}()
+	}()
+	go s.Metrics.measureEgress(stop)
+	s.wheel = newTimerWheel(wheelTick)
+	go s.wheel.run(stop)
+	s.egressBucket()
+	build := ReadBuildInfo()
+	s.Logger.Info("listening", "addr", s.listenAddr, "listeners", len(listeners), "version", build.Version, "commit", build.Commit)
 
-	var rwRequest ReadWriteRequest
+	// the first listener to fail stops them all
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.PacketConn) {
+			errs <- s.serveListener(l)
+		}(l)
+	}
+	pending := len(listeners)
+	select {
+	case err = <-errs:
+		pending--
+	case <-s.closedChan():
+		err = ErrServerClosed
+	}
+	stopListeners := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+		for ; pending > 0; pending-- {
+			<-errs
+		}
+	}
+	if err == ErrServerClosed && s.SinglePort {
+		// the transfers in progress send from the listeners, which
+		// still dispatch the datagrams to them
+		go func() {
+			s.handlersDone.Wait()
+			stopListeners()
+		}()
+		return err
+	}
+	stopListeners()
+	return err
+}
 
-	for {
-		var buf [DatagramSize]byte
-		_, senderAddr, err := listener.ReadFrom(buf[:])
+// listen opens the sockets of the TFTP port, see Listen and Listeners.
+func (s *TFTPServer) listen() ([]net.PacketConn, error) {
+	configs := s.Listen
+	if len(configs) == 0 {
+		configs = []ListenConfig{{Address: s.address}}
+	}
+	var listeners []net.PacketConn
+	cfgs := make(map[net.PacketConn]ListenConfig)
+	for _, c := range configs {
+		ls, err := s.listenAddress(c.Address)
 		if err != nil {
-			return err
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		for _, l := range ls {
+			cfgs[l] = c
+		}
+		listeners = append(listeners, ls...)
+	}
+	s.listenCfgs = cfgs
+	return listeners, nil
+}
+
+// listenAddress opens the sockets of address, see Listeners.
+func (s *TFTPServer) listenAddress(address string) ([]net.PacketConn, error) {
+	if s.Listeners > 1 {
+		return listenReusePort(address, s.Listeners)
+	}
+	listener, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return []net.PacketConn{listener}, nil
+}
+
+// The retransmission settings used if Timeout or Retries isn't set.
+const (
+	defaultTimeout = 5 * time.Second
+	defaultRetries = 10
+)
+
+// ListenConfig is an address of Listen.
+type ListenConfig struct {
+	Address string        // host:port
+	Timeout time.Duration // of the transfers requested to Address, TFTPServer.Timeout if 0
+	Retries int           // of the transfers requested to Address, TFTPServer.Retries if 0
+}
+
+// listenConfig returns the settings of the transfers requested to
+// listener, the defaults of the server filled in.
+func (s *TFTPServer) listenConfig(listener net.PacketConn) ListenConfig {
+	c := s.listenCfgs[listener]
+	if c.Timeout <= 0 {
+		c.Timeout = s.Timeout
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultTimeout
+	}
+	if c.Retries <= 0 {
+		c.Retries = s.Retries
+	}
+	if c.Retries <= 0 {
+		c.Retries = defaultRetries
+	}
+	return c
+}
+
+// ListenAndServeContext is ListenAndServe stopped by ctx: the server is
+// closed once ctx is done and ErrServerClosed returned. Like Close, it
+// doesn't wait for the transfers in progress, see Shutdown.
+func (s *TFTPServer) ListenAndServeContext(ctx context.Context) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Close()
+		case <-stop:
 		}
+	}()
+	return s.ListenAndServe()
+}
+
+// Close stops the server: ListenAndServe closes its listeners and returns
+// ErrServerClosed. The transfers in progress run to completion, see Shutdown
+// to wait for them. In single-port mode, the listeners are closed once they
+// are over.
+func (s *TFTPServer) Close() error {
+	s.closeMu.Lock()
+	defer s.closeMu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.closedCh == nil {
+		s.closedCh = make(chan struct{})
+	}
+	close(s.closedCh)
+	if s.SinglePort {
+		return nil
+	}
+	var err error
+	for _, l := range s.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Shutdown stops the server gracefully, like net/http's: it closes the
+// listeners, so no request is accepted anymore, and waits for the requests
+// in progress to be served. If ctx is done first, the transfers still in
+// progress are cancelled (the clients receive an ERROR packet) and Shutdown
+// returns ctx.Err() once they are over. ListenAndServe returns
+// ErrServerClosed right away, the program should wait for Shutdown to
+// return before exiting.
+func (s *TFTPServer) Shutdown(ctx context.Context) error {
+	err := s.Close()
+	done := make(chan struct{})
+	go func() {
+		s.handlersDone.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+	}
+	s.Logger.Warn("shutdown timed out, cancelling the transfers in progress", "handlers", s.ActiveHandlers())
+	atomic.StoreInt32(&s.aborting, 1) // the queued requests must not start
+	for i := range s.transfers.shards {
+		sh := &s.transfers.shards[i]
+		sh.mu.RLock()
+		for _, t := range sh.m {
+			t.cancel()
+		}
+		sh.mu.RUnlock()
+	}
+	<-done
+	return ctx.Err()
+}
+
+// closedChan returns a channel closed by Close.
+func (s *TFTPServer) closedChan() <-chan struct{} {
+	s.closeMu.Lock()
+	defer s.closeMu.Unlock()
+	if s.closedCh == nil {
+		s.closedCh = make(chan struct{})
+	}
+	return s.closedCh
+}
+
+// closing reports whether Close was called.
+func (s *TFTPServer) closing() bool {
+	s.closeMu.Lock()
+	defer s.closeMu.Unlock()
+	return s.closed
+}
 
-		err = rwRequest.UnmarshalBinary(buf[:])
+// serveListener reads the requests sent to listener until the server is
+// closed or listener fails for good.
+func (s *TFTPServer) serveListener(listener net.PacketConn) error {
+	batch := newBatchReader(listener, s.ReadBatch)
+	var delay time.Duration // before reading again after an error
+	for {
+		datagrams, err := batch.read()
 		if err != nil {
-			listener.WriteTo([]byte{byte(ErrorOp), byte(ErrIllegalOp), 0}, senderAddr)
-			log.Printf("invalid request from %v: %v", senderAddr, err)
+			if s.closing() {
+				return ErrServerClosed
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			// transient (ICMP errors reported on the socket, ENOBUFS,
+			// ...): back off like net/http does for Accept
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > time.Second {
+				delay = time.Second
+			}
+			s.Logger.Warn("reading requests", "error", err, "retry", delay)
+			time.Sleep(delay)
 			continue
 		}
+		delay = 0
+		for i := range datagrams {
+			if s.SinglePort && s.dispatch(datagrams[i].addr, datagrams[i].buf[:datagrams[i].n]) {
+				continue
+			}
+			s.serveRequest(listener, datagrams[i].addr, datagrams[i].buf[:datagrams[i].n])
+		}
+	}
+}
+
+// serveRequest handles the request b received by the listener from
+// senderAddr, b is only valid during the call.
+func (s *TFTPServer) serveRequest(listener net.PacketConn, senderAddr net.Addr, b []byte) {
+	var rwRequest ReadWriteRequest
+	s.Metrics.packetReceived(b)
+	s.debugPacket(s.Logger, "received", senderAddr, b, false)
+
+	err := rwRequest.UnmarshalBinary(b)
+	if modeErr, ok := err.(*ModeError); ok {
+		// a client asking for netascii isn't an attack
+		s.reject(listener, senderAddr, ErrIllegalOp, msgBadMode)
+		s.Logger.Info("request refused, unsupported transfer mode", "client", senderAddr, "file", rwRequest.Filename, "mode", modeErr.Mode)
+		return
+	}
+	if err != nil {
+		s.reject(listener, senderAddr, ErrIllegalOp, "")
+		s.Metrics.parseFailures.Inc()
+		s.securityEvent(s.Logger, SecurityMalformedPacket, senderAddr, "", "invalid request: "+err.Error())
+		return
+	}
+	if s.NormalizeBackslashes {
+		rwRequest.Filename = strings.ReplaceAll(rwRequest.Filename, "\\", "/")
+	}
+	if traversesPath(rwRequest.Filename) {
+		s.reject(listener, senderAddr, ErrAccessViolation, msgAccess)
+		s.securityEvent(s.Logger, SecurityPathTraversal, senderAddr, rwRequest.Filename, "filename escapes the served directory")
+		return
+	}
+	if rwRequest.Op == WriteOp && s.UploadDir == "" {
+		s.reject(listener, senderAddr, ErrAccessViolation, msgNoUploads)
+		s.Logger.Info("upload refused, uploads are disabled", "client", senderAddr, "file", rwRequest.Filename)
+		return
+	}
+	if name, err := s.authorize(senderAddr, rwRequest.Op, rwRequest.Filename); err != nil {
+		s.reject(listener, senderAddr, ErrAccessViolation, msgAccess)
+		s.securityEvent(s.Logger, SecurityACLDenied, senderAddr, name, err.Error())
+		s.Metrics.rejected.With("acl").Inc()
+		return
+	}
+	if s.Maintenance() {
+		s.reject(listener, senderAddr, ErrUnknown, msgMaintenance)
+		s.Logger.Info("request rejected during maintenance", "client", senderAddr, "file", rwRequest.Filename)
+		s.Metrics.rejected.With("maintenance").Inc()
+		return
+	}
+
+	if !s.handlerStarted() {
+		return // closed meanwhile
+	}
+	t, err := s.enterAdmission(senderAddr, senderAddr.String(), rwRequest.Filename)
+	switch err {
+	case errInProgress:
+		s.Logger.Debug("dropping retransmitted request, already in progress", "client", senderAddr, "file", rwRequest.Filename)
+		s.handlerFinished()
+		return
+	case errBusy, errClientLimit, errMemory:
+		s.reject(listener, senderAddr, ErrUnknown, msgBusy)
+		s.Logger.Warn("request rejected", "client", senderAddr, "file", rwRequest.Filename, "reason", err)
+		s.Metrics.rejected.With(rejectReason(err)).Inc()
+		if err == errClientLimit {
+			s.securityEvent(s.Logger, SecurityQuotaExceeded, senderAddr, rwRequest.Filename, err.Error())
+		}
+		s.handlerFinished()
+		return
+	}
+	go s.serveAdmitted(listener, t, senderAddr, rwRequest, append([]byte(nil), b...))
+}
 
-		go s.handle(senderAddr, rwRequest)
+// handlerStarted and handlerFinished account the goroutines serving the
+// requests, queued ones included, see ActiveHandlers. No handler starts
+// once the server is closed, handlerStarted reports false then: the ones
+// waiting for handlersDone don't miss any.
+func (s *TFTPServer) handlerStarted() bool {
+	s.closeMu.Lock()
+	defer s.closeMu.Unlock()
+	if s.closed {
+		return false
 	}
+	s.handlersDone.Add(1)
+	atomic.AddInt64(&s.handlers, 1)
+	return true
+}
+
+func (s *TFTPServer) handlerFinished() {
+	atomic.AddInt64(&s.handlers, -1)
+	s.handlersDone.Done()
+}
 
+// ActiveHandlers returns the number of goroutines serving TFTP requests,
+// queued or transferring. Every one of them ends with its request, after
+// the last retry at worst, so it drops to 0 once the server is closed and
+// the transfers in progress are over.
+func (s *TFTPServer) ActiveHandlers() int64 {
+	return atomic.LoadInt64(&s.handlers)
 }
 
-func (s *TFTPServer) handle(clientAddr net.Addr, request ReadWriteRequest) {
-	log.Printf("[%s] requested file: %s\n", clientAddr.String(), request.Filename)
+// serveAdmitted handles a request once it is admitted, t is its ticket if
+// it was queued (see admission). It is rejected if it waits more than QueueTimeout.
+func (s *TFTPServer) serveAdmitted(listener net.PacketConn, t *ticket, clientAddr net.Addr, request ReadWriteRequest, rawRequest []byte) {
+	defer s.handlerFinished()
+	if t != nil && !s.waitAdmission(t) {
+		s.reject(listener, clientAddr, ErrUnknown, msgBusy)
+		s.Logger.Warn("request rejected, queued for too long", "client", clientAddr, "file", request.Filename, "timeout", s.settings().QueueTimeout)
+		s.Metrics.rejected.With(rejectReason(errQueueTimeout)).Inc()
+		return
+	}
+	defer s.admission.leave(clientAddr.String(), clientHost(clientAddr))
+	if atomic.LoadInt32(&s.aborting) != 0 {
+		s.reject(listener, clientAddr, ErrUnknown, msgCancelled)
+		return
+	}
+	s.handle(listener, clientAddr, request, rawRequest)
+}
+
+func (s *TFTPServer) handle(listener net.PacketConn, clientAddr net.Addr, request ReadWriteRequest, rawRequest []byte) {
+	summary := &TransferSummary{
+		TransferInfo: TransferInfo{
+			ID:        newTransferID(),
+			Client:    clientAddr,
+			Filename:  request.Filename,
+			Direction: DirectionRead,
+			Start:     time.Now(),
+		},
+		Result: ResultError,
+	}
+	logger := s.Logger.With("id", summary.ID, "client", clientAddr)
+	detailed := s.detailedTrace()
+	if detailed {
+		logger = logger.WithLevel(LevelDebug)
+		logger.Info("detailed trace enabled for this transfer")
+		s.debugPacket(logger, "received", clientAddr, rawRequest, true)
+	} else if !s.LogSampling.sampled(s.Metrics.activeTransfers.Load()) && logger.Enabled(LevelInfo) {
+		logger = logger.WithLevel(LevelWarn)
+	}
+	class := s.classify(clientAddr, request.Filename)
+	if class != nil {
+		logger = logger.With("class", class.Name)
+	}
+	profile := s.profileFor(clientAddr)
+	if profile != nil {
+		logger = logger.With("profile", profile.Name)
+	}
+	var (
+		served     slot
+		slotName   string
+		contentErr error
+	)
+	if request.Op == WriteOp {
+		summary.Direction = DirectionWrite
+		logger.Info("upload", "file", request.Filename)
+	} else {
+		served, slotName, contentErr = s.contentFor(logger, clientAddr, request.Filename, request.Options)
+		defer served.done()
+		if slotName != "" {
+			logger.Info("requested file", "file", request.Filename, "slot", slotName)
+		} else if contentErr != nil {
+			logger.Info("requested file", "file", request.Filename)
+		} else {
+			logger.Info("requested file", "file", request.Filename, "source", served.file)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if s.MaxTransferDuration > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), s.MaxTransferDuration)
+	}
+	defer cancel()
+	if tp, ok := s.traceParents.get(clientHost(clientAddr)); ok {
+		ctx = WithTraceParent(ctx, tp)
+	}
+	tracer := s.Tracer
+	if tracer == nil {
+		tracer = noopTracer{}
+	}
+	_, span := tracer.Start(ctx, "tftp.transfer", "id", summary.ID, "client", clientAddr.String(), "file", request.Filename, "direction", string(summary.Direction))
+
+	conn, dialErr := s.dial(listener, clientAddr)
+	if dialErr == nil {
+		defer conn.Close()
+	}
+	// A pending read is woken up by a read deadline in the past, set by
+	// the retransmission timeout or to cancel the transfer: no goroutine
+	// waits for the context.
+	timeout := &wheelTimer{fire: func() { conn.SetReadDeadline(time.Unix(1, 0)) }}
+	defer s.wheel.stop(timeout)
+	if s.MaxTransferDuration > 0 && dialErr == nil {
+		expiry := &wheelTimer{fire: timeout.fire} // after the deadline of ctx
+		s.wheel.schedule(expiry, s.MaxTransferDuration)
+		defer s.wheel.stop(expiry)
+	}
+	interrupt := func() {
+		cancel()
+		if dialErr == nil {
+			s.wheel.schedule(timeout, 0)
+		}
+	}
 
-	conn, err := net.Dial("udp", clientAddr.String())
+	t := &transfer{TransferInfo: summary.TransferInfo, cancel: interrupt}
+	s.transfers.add(t)
+	s.Metrics.transferStarted(summary.TransferInfo)
+	s.Hooks.transferStart(summary.TransferInfo)
+	defer func() {
+		s.transfers.remove(t)
+		summary.Bytes = t.bytes
+		summary.Blocks = int(t.blocks)
+		summary.Retransmits = int(t.retransmits)
+		summary.Timeouts = int(t.timeouts)
+		s.transferFinished(summary, logger)
+		span.SetAttributes("bytes", summary.Bytes, "blocks", summary.Blocks, "retransmits", summary.Retransmits, "timeouts", summary.Timeouts, "result", string(summary.Result))
+		span.AddEvent("complete")
+		span.End(summary.Err)
+	}()
+
+	if dialErr != nil {
+		logger.Error("dial", "error", dialErr)
+		summary.Err = dialErr
+		return
+	}
+	if contentErr != nil {
+		code, message := errorReply(contentErr)
+		if code == ErrNotFound {
+			logger.Warn("file not found", "file", request.Filename, "error", contentErr)
+		} else {
+			logger.Warn("request refused", "file", request.Filename, "code", code, "error", contentErr)
+		}
+		s.sendError(conn, code, message)
+		summary.Err = contentErr
+		return
+	}
+	content, err := served.open()
 	if err != nil {
-		log.Printf("[%s] dial: %v\n", clientAddr.String(), err)
+		logger.Warn("opening file", "file", served.file, "error", err)
+		if errors.Is(err, errFileChanged) {
+			s.sendError(conn, ErrUnknown, msgChanged)
+		} else {
+			s.sendError(conn, ErrNotFound, msgNotFound)
+		}
+		summary.Err = err
+		return
+	}
+	defer content.Close()
+	s.Metrics.fileServed(request.Filename)
+	size := served.len()
+	opts := s.negotiate(request, size, s.listenConfig(listener))
+	if opts.oack != nil {
+		logger.Debug("options negotiated", "requested", request.Options, "accepted", opts.oack)
+		span.AddEvent("oack", "options", opts.oack)
+	}
+	if blocks := size/int64(opts.blockSize) + 1; blocks > MaxBlocks && s.BlockRollover < 0 {
+		logger.Warn("file too large for the block numbers", "file", request.Filename, "size", size, "blksize", opts.blockSize, "blocks", blocks)
+		s.sendError(conn, ErrUnknown, fmt.Sprintf("file too large: over %d blocks of %d bytes", MaxBlocks, opts.blockSize))
+		summary.Err = errTooManyBlocks
+		return
+	}
+
+	capture := s.Capture != nil && (s.CaptureFilter == nil || s.CaptureFilter(summary.TransferInfo))
+	localAddr := conn.LocalAddr().(*net.UDPAddr)
+	clientUDPAddr, _ := clientAddr.(*net.UDPAddr)
+	capture = capture && clientUDPAddr != nil
+	sent := func(b []byte) {
+		s.Metrics.packetSent(b)
+		s.debugPacket(logger, "sent", clientAddr, b, detailed)
+		if capture {
+			s.Capture.WritePacket(time.Now(), localAddr, clientUDPAddr, b)
+		}
+	}
+	received := func(b []byte) {
+		s.Metrics.packetReceived(b)
+		s.debugPacket(logger, "received", clientAddr, b, detailed)
+		if capture {
+			s.Capture.WritePacket(time.Now(), clientUDPAddr, localAddr, b)
+		}
+	}
+	if capture {
+		listenPort := localAddr.Port
+		if addr, ok := listener.LocalAddr().(*net.UDPAddr); ok {
+			listenPort = addr.Port
+		}
+		s.Capture.WritePacket(summary.Start, clientUDPAddr, &net.UDPAddr{IP: localAddr.IP, Port: listenPort}, rawRequest)
+	}
+	// read waits for the next datagram from the client, a timeout error
+	// is returned at deadline: the retransmission timeout after the last
+	// packet sent, the datagrams ignored meanwhile don't extend it.
+	read := func(b []byte, deadline time.Time) (int, error) {
+		s.wheel.schedule(timeout, time.Until(deadline))
+		if ctx.Err() != nil {
+			// cancelled before the schedule, which replaced the wake-up
+			return 0, ctx.Err()
+		}
+		m, err := conn.Read(b)
+		if fired := !s.wheel.stop(timeout); (fired || err != nil) && ctx.Err() == nil {
+			// woken up by a timer: clear the deadline for the next read
+			conn.SetReadDeadline(time.Time{})
+		}
+		if err == nil {
+			received(b[:m])
+			if m == len(b) {
+				err = errTruncated
+			}
+		}
+		return m, err
+	}
+
+	if request.Op == WriteOp {
+		s.receive(ctx, &upload{request: request, opts: opts, conn: conn, logger: logger, summary: summary, t: t, read: read, sent: sent, rto: s.newRTO(opts)})
 		return
 	}
-	defer conn.Close()
 
 	var (
-		code  Opcode
-		ackM  Acknowledgment
-		errM  Err
-		dataM = Data{Payload: bytes.NewReader(s.payload)}
-		buf   = make([]byte, DatagramSize) // for replies (Ack / Error) from the client
+		ackM   Acknowledgment
+		errM   Err
+		reply  = getDatagram() // for replies (Ack / Error) from the client
+		buf    = reply[:]
+		packet = getDatagram() // for the DATA packets
+		block  = opts.blockSize
+		window = opts.windowSize
+		out    = packet[:]
+		oack   []byte // sent first, as block 0, if options were negotiated
+		// The transfer ends with the first block shorter than block, an
+		// empty one if the size of the file is a multiple of block (RFC 1350).
+		blocks = int(size/int64(block)) + 1
+		acked  int   // blocks acknowledged
+		offset int64 // of content
 	)
+	defer putDatagram(reply)
+	defer putDatagram(packet)
+	if block > BlockSize {
+		out = make([]byte, 4+block)
+	}
 
-	n := DatagramSize
+	var pacer *tokenBucket
+	rate := s.TransferRate
+	if profile != nil && profile.TransferRate > 0 {
+		rate = profile.TransferRate
+	}
+	if class != nil && class.Rate > 0 {
+		rate = class.Rate
+	}
+	if rate > 0 {
+		pacer = newTokenBucket(rate)
+	}
+	egress := s.egressBucket()
+	bursts := newBurstPacer(s.Pacing)
+	rto := s.newRTO(opts)
+	var sl sleeper
+	if opts.oack != nil {
+		oack, _ = OptionAcknowledgment{Options: opts.oack}.MarshalBinary()
+	}
+	// Unless they are paced or faults are injected, the blocks of a window
+	// are sent by batches: several per system call where the platform can.
+	var (
+		batch   batchWriter
+		packets [][]byte // the DATA packets of the batch, in outs
+		outs    [][]byte
+	)
+	if window > 1 && pacer == nil && egress == nil && bursts == nil && s.Faults == nil {
+		n := window
+		if n > sendBatch {
+			n = sendBatch
+		}
+		batch = newBatchWriter(conn, n)
+		packets = make([][]byte, 0, n)
+		outs = make([][]byte, n)
+		for i := range outs {
+			outs[i] = make([]byte, 4+block)
+		}
+	}
 
-NEXT_PACKET:
-	for n == DatagramSize {
-		data, err := dataM.MarshalBinary()
-		if err != nil {
-			log.Printf("[%s] preparing data packet: %v", clientAddr.String(), err)
-			return
+	// The blocks are sent by windows of up to window blocks (RFC 7440), one
+	// block at a time without the option. A window is sent again from its
+	// first block until the client acknowledges some of its blocks, the
+	// next window starts after the last one acknowledged.
+NEXT_WINDOW:
+	for acked < blocks {
+		first, end := acked+1, acked+window // the blocks of the window
+		if end > blocks {
+			end = blocks
 		}
 
 	RETRIES:
-		for i := 0; i < int(s.retries); i++ {
-			n, err = conn.Write(data)
-			if err != nil {
-				log.Printf("[%s] write: %v", clientAddr.String(), err)
+		for i := 0; i < opts.retries; i++ {
+			if ctx.Err() != nil {
+				s.cancelled(ctx, conn, logger, summary)
 				return
 			}
+			if i > 0 {
+				atomic.AddInt64(&t.retransmits, 1)
+				s.Metrics.retransmits.Inc()
+				span.AddEvent("retransmit", "block", first, "attempt", i+1)
+			}
+			sentAt := time.Now()
+			if oack != nil {
+				if _, err := s.Faults.write(conn, oack, logger); err != nil {
+					socketFailed(logger, summary, "write", err)
+					return
+				}
+				sent(oack)
+			}
+			if start := int64(first-1) * int64(block); oack == nil && offset != start {
+				// retransmitting
+				if offset, err = content.Seek(start, io.SeekStart); err != nil {
+					s.readFailed(conn, logger, summary, served.file, err)
+					return
+				}
+			}
+			for n := first; oack == nil && n <= end; n++ {
+				k := blockLen(size, block, n)
+				out := out
+				if batch != nil {
+					out = outs[len(packets)]
+				}
+				if _, err := io.ReadFull(content, out[4:4+k]); err != nil {
+					s.readFailed(conn, logger, summary, served.file, err)
+					return
+				}
+				offset += int64(k)
+				// the payload is read in place, behind the header
+				data, _ := Data{BlockNum: s.blockNumber(n), Payload: out[4 : 4+k]}.AppendBinary(out[:0])
+				if batch != nil {
+					packets = append(packets, data)
+					if len(packets) < cap(packets) && n < end {
+						continue
+					}
+					if err := batch.write(packets); err != nil {
+						socketFailed(logger, summary, "write", err)
+						return
+					}
+					for _, p := range packets {
+						sent(p)
+					}
+					packets = packets[:0]
+					continue
+				}
+				if pacer.wait(ctx, len(data)-4, &sl) != nil || egress.wait(ctx, len(data)-4, &sl) != nil || bursts.wait(ctx) != nil {
+					s.cancelled(ctx, conn, logger, summary)
+					return
+				}
+				if _, err := s.Faults.write(conn, data, logger); err != nil {
+					socketFailed(logger, summary, "write", err)
+					return
+				}
+				sent(data)
+			}
 
-			conn.SetReadDeadline(time.Now().Add(s.timeout))
-			_, err = conn.Read(buf)
+			deadline := time.Now().Add(rto.timeout())
+		WAIT:
+			m, err := read(buf, deadline)
 			if err != nil {
+				if ctx.Err() != nil {
+					s.cancelled(ctx, conn, logger, summary)
+					return
+				}
 				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					s.Metrics.timeouts.Inc()
+					atomic.AddInt64(&t.timeouts, 1)
+					rto.backoff()
+					logger.Debug("timeout waiting for ACK, retransmitting", "block", first, "attempt", i+1, "retries", opts.retries, "timeout", rto.timeout())
+					continue RETRIES
+				}
+				if err == errTruncated {
+					logger.Debug("truncated datagram dropped", "size", m)
 					continue RETRIES
 				}
-				log.Printf("[%s] waiting for ACK: %v", clientAddr.String(), err)
+				socketFailed(logger, summary, "waiting for ACK", err)
 				return
 			}
 
-			code = Opcode(binary.BigEndian.Uint16(buf[:2]))
-
-			switch code {
+			switch code := Opcode(binary.BigEndian.Uint16(buf[:2])); code {
 			case AcknowledgmentOp:
-				err = ackM.UnmarshalBinary(buf)
-				if err != nil {
+				if ackM.UnmarshalBinary(buf[:m]) != nil {
 					continue RETRIES
 				}
-				if ackM.BlockNum == dataM.BlockNum {
-					continue NEXT_PACKET
+				if oack != nil {
+					if ackM.BlockNum != 0 {
+						goto WAIT
+					}
+					oack = nil // the options are acknowledged like a block 0 (RFC 2347)
+					continue NEXT_WINDOW
+				}
+				// the number of blocks of the window acknowledged, the
+				// block numbers are 16 bits
+				n := s.blocksAcked(acked, ackM.BlockNum)
+				if n >= 1 && n <= end-acked {
+					if i == 0 && acked+n == end {
+						// like Karn's algorithm, the RTT of retransmitted blocks is ambiguous
+						rtt := time.Since(sentAt)
+						s.Metrics.blockAcknowledged(rtt)
+						rto.sample(rtt)
+					}
+					for b := acked + 1; b <= acked+n; b++ {
+						k := int64(blockLen(size, block, b))
+						atomic.AddInt64(&t.blocks, 1)
+						total := atomic.AddInt64(&t.bytes, k)
+						s.Metrics.bytesSent.Add(k)
+						s.Hooks.blockSent(summary.TransferInfo, s.blockNumber(b), total)
+					}
+					acked += n
+					continue NEXT_WINDOW
 				}
+				if n == 0 && window > 1 {
+					// the client missed the first block of the window
+					continue RETRIES
+				}
+				// a duplicate ACK of the previous block: retransmitting on
+				// it would double every packet from then on (the Sorcerer's
+				// Apprentice Syndrome, RFC 1123 section 4.2.3.1)
+				logger.Debug("duplicate ACK ignored", "block", ackM.BlockNum)
+				goto WAIT
 			case ErrorOp:
-				err = errM.UnmarshalBinary(buf)
-				if err != nil {
+				if errM.UnmarshalBinary(buf[:m]) != nil {
 					continue RETRIES
 				}
-				log.Printf("[%s] received error: %v", clientAddr.String(), err)
+				logger.Warn("received error", "code", errM.Code, "message", errM.Message)
+				s.Metrics.errorsReceived.With(strconv.Itoa(int(errM.Code))).Inc()
+				summary.Result = ResultAborted
+				summary.Err = errM
 				return
 			default:
-				log.Printf("[%s] bad packet", clientAddr.String())
+				logger.Debug("bad packet", "opcode", code)
 			}
 		}
 
 		// execution comes here only when we exhauste retries
-		log.Printf("[%s] exhausted retries", clientAddr.String())
+		logger.Warn("exhausted retries", "block", first)
+		summary.Result = ResultTimeout
 		return
 	}
 
 	// well done ... the file has been sent successfully
-	log.Printf("[%s] sent %d blocks", clientAddr.String(), dataM.BlockNum)
+	summary.Result = ResultOK
+	logger.Info("transfer complete", "file", request.Filename, "blocks", blocks, "bytes", size, "duration", time.Since(summary.Start))
+}
+
+// blockLen returns the length of the block n of a file of size bytes.
+func blockLen(size int64, block, n int) int {
+	if rest := size - int64(n-1)*int64(block); rest < int64(block) {
+		return int(rest)
+	}
+	return block
+}
+
+// readFailed aborts a download on the error err reading file.
+func (s *TFTPServer) readFailed(conn net.Conn, logger *Logger, summary *TransferSummary, file string, err error) {
+	logger.Error("reading file", "file", file, "error", err)
+	s.sendError(conn, ErrUnknown, msgReadFailed)
+	summary.Err = err
+}
+
+// transferFinished records a finished transfer (metrics, access log,
+// webhooks and hooks), the summary must be complete but for its Duration.
+func (s *TFTPServer) transferFinished(summary *TransferSummary, logger *Logger) {
+	summary.Duration = time.Since(summary.Start)
+	s.Metrics.transferFinished(summary)
+	s.logAccess(summary)
+	if s.Webhooks != nil && !s.Webhooks.Notify(*summary) {
+		logger.Warn("webhook queue full, dropping event")
+	}
+	if s.PostUploadHook != nil && summary.Direction == DirectionWrite && summary.Result == ResultOK {
+		s.PostUploadHook.run(*summary, logger)
+	}
+	if s.Replicator != nil && summary.Direction == DirectionWrite && summary.Result == ResultOK {
+		s.Replicator.replicate(*summary, logger)
+	}
+	s.Hooks.transferEnd(*summary)
+}
+
+// reject replies to a request with an ERROR packet sent from the listening socket.
+func (s *TFTPServer) reject(listener net.PacketConn, addr net.Addr, code ErrCode, message string) {
+	reply := errorPacket(code, s.errorMessage(addr, code, message))
+	if _, err := listener.WriteTo(reply, addr); err != nil {
+		return
+	}
+	s.Metrics.packetSent(reply)
+	s.debugPacket(s.Logger, "sent", addr, reply, false)
+	s.Metrics.errorsSent.With(strconv.Itoa(int(code))).Inc()
+}
+
+// SetMaintenance toggles the maintenance mode: new requests are refused with
+// an ERROR packet while transfers in progress run to completion.
+func (s *TFTPServer) SetMaintenance(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	if atomic.SwapInt32(&s.maintenance, v) != v {
+		s.Logger.Info("maintenance mode changed", "enabled", on)
+	}
+}
+
+// Ready reports whether the server accepts requests: it is listening, its
+// SelfTest passed and it is not in maintenance mode.
+func (s *TFTPServer) Ready() bool {
+	return atomic.LoadInt32(&s.serving) == 1 && (s.SelfTest == nil || atomic.LoadInt32(&s.selfTested) == 1) && !s.Maintenance()
+}
+
+// selfTestRetry is the delay between the attempts of a failing SelfTest.
+const selfTestRetry = 10 * time.Second
+
+// selfTest runs the SelfTest until it passes or stop is closed.
+func (s *TFTPServer) selfTest(stop <-chan struct{}) {
+	for {
+		err := s.SelfTest(s.listenAddr)
+		if err == nil {
+			atomic.StoreInt32(&s.selfTested, 1)
+			s.Logger.Info("self-test passed, ready")
+			return
+		}
+		s.Logger.Error("self-test failed, not ready", "error", err, "retry", selfTestRetry)
+		select {
+		case <-stop:
+			return
+		case <-time.After(selfTestRetry):
+		}
+	}
+}
+
+// Maintenance reports whether the maintenance mode is on.
+func (s *TFTPServer) Maintenance() bool {
+	return atomic.LoadInt32(&s.maintenance) == 1
+}
+
+// cancelled notifies the client of a transfer cancelled by CancelTransfer
+// or for running longer than MaxTransferDuration.
+func (s *TFTPServer) cancelled(ctx context.Context, conn net.Conn, logger *Logger, summary *TransferSummary) {
+	if ctx.Err() == context.DeadlineExceeded {
+		logger.Warn("transfer exceeded its maximum duration", "max", s.MaxTransferDuration)
+		s.sendError(conn, ErrUnknown, msgTooLong)
+		summary.Result = ResultDeadline
+		return
+	}
+	logger.Warn("transfer cancelled")
+	s.sendError(conn, ErrUnknown, msgCancelled)
+	summary.Result = ResultCancelled
+}
+
+// sendError sends an ERROR packet on a connected transfer socket.
+func (s *TFTPServer) sendError(conn net.Conn, code ErrCode, message string) {
+	b := errorPacket(code, s.errorMessage(conn.RemoteAddr(), code, message))
+	if _, err := conn.Write(b); err == nil {
+		s.Metrics.packetSent(b)
+		s.Metrics.errorsSent.With(strconv.Itoa(int(code))).Inc()
+	}
+}
+
+// socketFailed records the error err of the transfer socket, op is what
+// failed. The ICMP port unreachable errors (the client went away) end the
+// transfer as ResultUnreachable rather than as a local failure: they show
+// up at the first retransmission, instead of all of them timing out.
+func socketFailed(logger *Logger, summary *TransferSummary, op string, err error) {
+	summary.Err = err
+	if isUnreachable(err) {
+		logger.Warn("client unreachable, transfer aborted", "error", err)
+		summary.Result = ResultUnreachable
+		return
+	}
+	logger.Error(op, "error", err)
+}
+
+// clientHost returns the IP part of a client address.
+func clientHost(addr net.Addr) string {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		return udpAddr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
 }
@@ -0,0 +1,340 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/OmarTariq612/tftp-server/client"
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+// startServer runs a server on a random loopback port until the end of the
+// test and returns its address, configure sets it up before it listens.
+func startServer(t testing.TB, configure func(s *server.TFTPServer)) string {
+	t.Helper()
+	s, err := server.NewTFTPServer("127.0.0.1", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Logger = server.NewLogger(io.Discard, server.LevelError)
+	listening := make(chan net.Addr, 1)
+	s.SelfTest = func(addr net.Addr) error {
+		listening <- addr
+		return nil
+	}
+	if configure != nil {
+		configure(s)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ListenAndServe()
+	}()
+	select {
+	case addr := <-listening:
+		t.Cleanup(func() {
+			s.Close()
+			<-done
+		})
+		return addr.String()
+	case err := <-done:
+		t.Fatal(err)
+		return ""
+	}
+}
+
+// blockLog records the block numbers of a transfer, to check how they
+// roll over.
+type blockLog struct {
+	mu    sync.Mutex
+	last  uint16
+	count int
+	after []uint16 // the block numbers following 65535
+	ended chan struct{}
+}
+
+func newBlockLog() *blockLog {
+	return &blockLog{ended: make(chan struct{})}
+}
+
+// hook records the blocks of the transfers of s.
+func (l *blockLog) hook(s *server.TFTPServer) {
+	s.Hooks.OnBlockSent = l.record
+	s.Hooks.OnBlockReceived = l.record
+	s.Hooks.OnTransferEnd = func(server.TransferSummary) { close(l.ended) }
+}
+
+// wait waits for the end of the transfer on the server side, the client
+// may be done before the server handles its last ACK.
+func (l *blockLog) wait(t *testing.T) {
+	select {
+	case <-l.ended:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the transfer didn't end on the server")
+	}
+}
+
+func (l *blockLog) record(info server.TransferInfo, block uint16, total int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.count > 0 && l.last == server.MaxBlocks {
+		l.after = append(l.after, block)
+	}
+	l.last = block
+	l.count++
+}
+
+func randomContent(size int) []byte {
+	b := make([]byte, size)
+	rand.New(rand.NewSource(int64(size))).Read(b)
+	return b
+}
+
+func TestBlockRollover(t *testing.T) {
+	if testing.Short() {
+		t.Skip("transfers files of more than 65535 blocks")
+	}
+	// the block numbers wrap twice
+	content := randomContent(2*server.MaxBlocks*server.BlockSize + 1000)
+	c := &client.Client{WindowSize: 16}
+
+	for _, rollover := range []int{0, 1} {
+		rollover := rollover
+		t.Run("get/rollover="+strconv.Itoa(rollover), func(t *testing.T) {
+			blocks := newBlockLog()
+			addr := startServer(t, func(s *server.TFTPServer) {
+				s.FS = fstest.MapFS{"big.bin": {Data: content}}
+				s.BlockRollover = rollover
+				blocks.hook(s)
+			})
+			var got bytes.Buffer
+			if err := c.Get(context.Background(), addr, "big.bin", &got); err != nil {
+				t.Fatal(err)
+			}
+			blocks.wait(t)
+			if !bytes.Equal(got.Bytes(), content) {
+				t.Fatalf("got %d bytes differing from the %d bytes served", got.Len(), len(content))
+			}
+			if want := (len(content) + server.BlockSize) / server.BlockSize; blocks.count != want {
+				t.Errorf("%d blocks sent, want %d", blocks.count, want)
+			}
+			if len(blocks.after) != 2 || blocks.after[0] != uint16(rollover) || blocks.after[1] != uint16(rollover) {
+				t.Errorf("blocks following 65535: %v, want twice %d", blocks.after, rollover)
+			}
+		})
+	}
+
+	t.Run("get/refused", func(t *testing.T) {
+		addr := startServer(t, func(s *server.TFTPServer) {
+			s.FS = fstest.MapFS{"big.bin": {Data: content}}
+			s.BlockRollover = -1
+		})
+		if err := c.Get(context.Background(), addr, "big.bin", ioutil.Discard); err == nil {
+			t.Fatal("the download of more than 65535 blocks succeeded without rollover")
+		}
+	})
+
+	t.Run("put", func(t *testing.T) {
+		blocks := newBlockLog()
+		dir := t.TempDir()
+		addr := startServer(t, func(s *server.TFTPServer) {
+			s.UploadDir = dir
+			blocks.hook(s)
+		})
+		if err := c.Put(context.Background(), addr, "big.bin", bytes.NewReader(content), int64(len(content))); err != nil {
+			t.Fatal(err)
+		}
+		blocks.wait(t)
+		got, err := ioutil.ReadFile(filepath.Join(dir, "big.bin"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("stored %d bytes differing from the %d bytes uploaded", len(got), len(content))
+		}
+		if len(blocks.after) != 2 {
+			t.Errorf("blocks following 65535: %v, want 2 wraps", blocks.after)
+		}
+	})
+}
+
+// rawDownload reads name from the server at addr with blocks of 512 bytes
+// through buf, from a port of its own (TID), and returns the number of
+// blocks. Unlike the client package it doesn't allocate per block, so that
+// the allocations of the server can be measured.
+func rawDownload(t testing.TB, addr netip.AddrPort, name string, buf []byte) int {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	rrq, _ := server.ReadWriteRequest{Op: server.ReadOp, Filename: name, Mode: "octet"}.MarshalBinary()
+	if _, err := conn.WriteToUDPAddrPort(rrq, addr); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var ack [4]byte
+	for blocks := 1; ; blocks++ {
+		n, from, err := conn.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n < 4 || server.Opcode(binary.BigEndian.Uint16(buf)) != server.DataOp || binary.BigEndian.Uint16(buf[2:]) != uint16(blocks) {
+			t.Fatalf("got %v, want DATA %d", buf[:n], blocks)
+		}
+		a, _ := server.Acknowledgment{BlockNum: uint16(blocks)}.AppendBinary(ack[:0])
+		if _, err := conn.WriteToUDPAddrPort(a, from); err != nil {
+			t.Fatal(err)
+		}
+		if n-4 < server.BlockSize {
+			return blocks
+		}
+	}
+}
+
+// TestDownloadAllocations enforces the allocation budget of the DATA
+// blocks: a transfer allocates when it starts and ends, not per block,
+// rate limiting included.
+func TestDownloadAllocations(t *testing.T) {
+	const small, large = 10, 2010 // blocks
+	addr := startServer(t, func(s *server.TFTPServer) {
+		s.FS = fstest.MapFS{
+			"small.bin": {Data: make([]byte, (small-1)*server.BlockSize)},
+			"large.bin": {Data: make([]byte, (large-1)*server.BlockSize)},
+		}
+		s.TransferRate = 1 << 30
+	})
+	server := netip.MustParseAddrPort(addr)
+	buf := make([]byte, 1024)
+
+	allocs := func(name string, blocks int) float64 {
+		return testing.AllocsPerRun(10, func() {
+			if n := rawDownload(t, server, name, buf); n != blocks {
+				t.Fatalf("%s: %d blocks, want %d", name, n, blocks)
+			}
+		})
+	}
+	perTransfer := allocs("small.bin", small)
+	perBlock := (allocs("large.bin", large) - perTransfer) / (large - small)
+	t.Logf("%.0f allocations per transfer, %.3f per block", perTransfer, perBlock)
+	if perBlock > 0.01 {
+		t.Errorf("%.3f allocations per DATA block, want none", perBlock)
+	}
+}
+
+// BenchmarkSlowSessions holds sessions open at once, each stalled after
+// its first DATA block like a slow client, and reports the memory and the
+// goroutines of the server per session: the figures to size a server for
+// 100k simultaneous clients, as seen in large IoT fleets. Each iteration
+// opens the sessions and aborts them, -benchtime=1x is enough.
+func BenchmarkSlowSessions(b *testing.B) {
+	for _, bb := range []struct {
+		sessions   int
+		singlePort bool
+	}{{1000, false}, {5000, false}, {5000, true}} {
+		name := "sessions=" + strconv.Itoa(bb.sessions)
+		if bb.singlePort {
+			name = "single-port/" + name
+		}
+		b.Run(name, func(b *testing.B) {
+			benchmarkSlowSessions(b, bb.sessions, bb.singlePort)
+		})
+	}
+}
+
+func benchmarkSlowSessions(b *testing.B, sessions int, singlePort bool) {
+	var s *server.TFTPServer
+	addr := startServer(b, func(srv *server.TFTPServer) {
+		s = srv
+		s.FS = fstest.MapFS{"firmware.bin": {Data: make([]byte, 64<<10)}}
+		s.SinglePort = singlePort
+		s.Timeout = time.Minute // no retransmission during the measure
+	})
+	serverAddr := netip.MustParseAddrPort(addr)
+	rrq, _ := server.ReadWriteRequest{Op: server.ReadOp, Filename: "firmware.bin", Mode: "octet"}.MarshalBinary()
+	abort, _ := server.Err{Code: server.ErrUnknown, Message: "benchmark over"}.MarshalBinary()
+	buf := make([]byte, 1024)
+
+	// the sockets of the clients exist before the first measure, only
+	// the server side of the sessions is counted
+	conns := make([]*net.UDPConn, sessions)
+	for i := range conns {
+		c, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			b.Skipf("%d client sockets: %v", sessions, err)
+		}
+		defer c.Close()
+		conns[i] = c
+	}
+	tids := make([]netip.AddrPort, sessions)
+
+	var bytesPerSession, goroutinesPerSession float64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		heap, goroutines := memoryInUse()
+		b.StartTimer()
+		// one request at a time, a burst of them would overflow the
+		// receive buffer of the listener
+		for j, c := range conns {
+			if _, err := c.WriteToUDPAddrPort(rrq, serverAddr); err != nil {
+				b.Fatal(err)
+			}
+			c.SetReadDeadline(time.Now().Add(10 * time.Second))
+			n, from, err := c.ReadFromUDPAddrPort(buf)
+			if err != nil {
+				b.Fatalf("session %d: %v", j, err)
+			}
+			if n < 4 || server.Opcode(binary.BigEndian.Uint16(buf)) != server.DataOp {
+				b.Fatalf("session %d: got %v, want DATA 1", j, buf[:n])
+			}
+			tids[j] = from
+		}
+		b.StopTimer()
+		heapOpen, goroutinesOpen := memoryInUse()
+		bytesPerSession = float64(heapOpen-heap) / float64(sessions)
+		goroutinesPerSession = float64(goroutinesOpen-goroutines) / float64(sessions)
+		// the aborts go by batches for the same reason, a single port
+		// server receives them all on its listener
+		const batch = 100
+		for j, c := range conns {
+			c.WriteToUDPAddrPort(abort, tids[j])
+			if j%batch == batch-1 || j == sessions-1 {
+				waitHandlers(b, s, int64(sessions-j-1))
+			}
+		}
+		b.StartTimer()
+	}
+	b.ReportMetric(bytesPerSession, "B/session")
+	b.ReportMetric(goroutinesPerSession, "goroutines/session")
+}
+
+// memoryInUse returns the heap and stack bytes in use after a collection,
+// and the number of goroutines.
+func memoryInUse() (int64, int) {
+	runtime.GC()
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return int64(ms.HeapInuse + ms.StackInuse), runtime.NumGoroutine()
+}
+
+// waitHandlers waits for the transfers of s to be down to n.
+func waitHandlers(b *testing.B, s *server.TFTPServer, n int64) {
+	for deadline := time.Now().Add(10 * time.Second); s.ActiveHandlers() > n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			b.Fatalf("%d sessions open, want %d", s.ActiveHandlers(), n)
+		}
+	}
+}
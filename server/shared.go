@@ -0,0 +1,92 @@
+package server
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// sharedFiles lets the concurrent transfers of a file read from a single
+// immutable copy of it: the first request loads it, the others wait for
+// that load and share its payload, and the last transfer to finish drops
+// it. 500 clients fetching a 300 MB image hold 300 MB, not 150 GB.
+type sharedFiles struct {
+	mu    sync.Mutex
+	files map[string]*sharedFile
+	size  int64 // of the loaded payloads
+}
+
+type sharedFile struct {
+	ready   chan struct{} // closed once payload or err is set
+	payload []byte
+	err     error
+	refs    int
+
+	// local files are loaded again once changed on disk
+	modTime time.Time
+	fsize   int64
+}
+
+// load returns the content of file read by read, shared with the transfers
+// holding it, and the function to call when done with it.
+func (f *sharedFiles) load(file string, read func(string) ([]byte, error)) ([]byte, func(), error) {
+	var (
+		modTime time.Time
+		fsize   int64 = -1
+	)
+	if !isRemoteSource(file) {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		modTime, fsize = info.ModTime(), info.Size()
+	}
+
+	f.mu.Lock()
+	if f.files == nil {
+		f.files = make(map[string]*sharedFile)
+	}
+	sf, ok := f.files[file]
+	if ok && (!sf.modTime.Equal(modTime) || sf.fsize != fsize) {
+		ok = false // the transfers holding the old version keep it
+	}
+	if ok {
+		sf.refs++
+		f.mu.Unlock()
+		<-sf.ready
+	} else {
+		sf = &sharedFile{ready: make(chan struct{}), refs: 1, modTime: modTime, fsize: fsize}
+		f.files[file] = sf
+		f.mu.Unlock()
+
+		sf.payload, sf.err = read(file)
+		f.mu.Lock()
+		f.size += int64(len(sf.payload))
+		f.mu.Unlock()
+		close(sf.ready)
+	}
+
+	release := func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		sf.refs--
+		if sf.refs == 0 {
+			f.size -= int64(len(sf.payload))
+			if f.files[file] == sf {
+				delete(f.files, file)
+			}
+		}
+	}
+	if sf.err != nil {
+		release()
+		return nil, nil, sf.err
+	}
+	return sf.payload, release, nil
+}
+
+// bytes returns the size of the payloads held by transfers.
+func (f *sharedFiles) bytes() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.size
+}
@@ -0,0 +1,71 @@
+package server_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+func TestShutdown(t *testing.T) {
+	start := func(t *testing.T) (*server.TFTPServer, *net.UDPConn, *net.UDPAddr) {
+		var s *server.TFTPServer
+		addr := startServer(t, func(srv *server.TFTPServer) {
+			s = srv
+			s.FS = fstest.MapFS{"pxelinux.0": {Data: make([]byte, server.BlockSize+10)}}
+			s.Timeout = time.Minute // the transfer waits for its ACKs
+		})
+		conn, reply, from := sendRequest(t, addr, server.ReadOp, "pxelinux.0", nil)
+		if server.Opcode(binary.BigEndian.Uint16(reply)) != server.DataOp {
+			t.Fatalf("got %v, want DATA 1", reply)
+		}
+		return s, conn, from
+	}
+
+	t.Run("drain", func(t *testing.T) {
+		s, conn, from := start(t)
+		done := make(chan error, 1)
+		go func() {
+			done <- s.Shutdown(context.Background())
+		}()
+		select {
+		case err := <-done:
+			t.Fatalf("Shutdown returned %v with a transfer in progress", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+		// the transfer in progress runs to completion
+		ack(t, conn, from, 1)
+		expectBlocks(t, conn, 2, 2)
+		ack(t, conn, from, 2)
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Shutdown didn't return once the transfer was over")
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		s, conn, _ := start(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Shutdown returned %v, want %v", err, context.DeadlineExceeded)
+		}
+		// the transfer still in progress is cancelled
+		reply, _ := readPacket(t, conn)
+		if code := errorCode(t, reply); code != server.ErrUnknown {
+			t.Errorf("error code %d, want %d", code, server.ErrUnknown)
+		}
+		if n := s.ActiveHandlers(); n != 0 {
+			t.Errorf("%d handlers left after Shutdown", n)
+		}
+	})
+}
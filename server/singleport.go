@@ -0,0 +1,197 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// errPortInUse is returned by portMux.open when a transfer with the client
+// address is already in progress.
+var errPortInUse = errors.New("a transfer with the client is already in progress")
+
+// portQueue is the number of datagrams queued for a transfer in single-port
+// mode, the ones arriving past it are dropped like by a full socket buffer.
+const portQueue = 64
+
+// portMux dispatches the datagrams received by the listeners in single-port
+// mode (see SinglePort) to the transfers, by client address.
+type portMux struct {
+	mu    sync.RWMutex
+	conns map[string]*portConn
+}
+
+// open returns the connection of the transfer with client, its packets are
+// sent from listener.
+func (m *portMux) open(listener net.PacketConn, client net.Addr) (*portConn, error) {
+	key := client.String()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.conns[key]; ok {
+		return nil, errPortInUse
+	}
+	if m.conns == nil {
+		m.conns = make(map[string]*portConn)
+	}
+	c := &portConn{
+		mux:      m,
+		key:      key,
+		listener: listener,
+		remote:   client,
+		in:       make(chan []byte, portQueue),
+		changed:  make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	m.conns[key] = c
+	return c, nil
+}
+
+// deliver queues b, received from client, for its transfer and reports
+// whether there is one. b is copied.
+func (m *portMux) deliver(client net.Addr, b []byte) bool {
+	m.mu.RLock()
+	c, ok := m.conns[client.String()]
+	m.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	select {
+	case c.in <- append([]byte(nil), b...):
+	default:
+	}
+	return true
+}
+
+// portConn is the net.Conn of a transfer in single-port mode: it writes to
+// the client from the listener and reads the datagrams the listener
+// received from it.
+type portConn struct {
+	mux      *portMux
+	key      string
+	listener net.PacketConn
+	remote   net.Addr
+	in       chan []byte
+
+	mu       sync.Mutex
+	deadline time.Time
+	changed  chan struct{} // closed, and replaced, when deadline changes
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// Read returns the next datagram, truncated to b like a UDP socket does.
+func (c *portConn) Read(b []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		deadline, changed := c.deadline, c.changed
+		c.mu.Unlock()
+		var (
+			timer   *time.Timer
+			expired <-chan time.Time
+		)
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return 0, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(d)
+			expired = timer.C
+		}
+		select {
+		case p := <-c.in:
+			if timer != nil {
+				timer.Stop()
+			}
+			return copy(b, p), nil
+		case <-expired:
+			return 0, os.ErrDeadlineExceeded
+		case <-changed:
+			if timer != nil {
+				timer.Stop()
+			}
+		case <-c.closed:
+			if timer != nil {
+				timer.Stop()
+			}
+			return 0, net.ErrClosed
+		}
+	}
+}
+
+func (c *portConn) Write(b []byte) (int, error) {
+	return c.listener.WriteTo(b, c.remote)
+}
+
+// Close ends the transfer, the datagrams of the client aren't dispatched
+// to it anymore. The listener stays open.
+func (c *portConn) Close() error {
+	c.once.Do(func() {
+		c.mux.mu.Lock()
+		if c.mux.conns[c.key] == c {
+			delete(c.mux.conns, c.key)
+		}
+		c.mux.mu.Unlock()
+		close(c.closed)
+	})
+	return nil
+}
+
+func (c *portConn) LocalAddr() net.Addr  { return c.listener.LocalAddr() }
+func (c *portConn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *portConn) SetDeadline(t time.Time) error { return c.SetReadDeadline(t) }
+
+// SetReadDeadline wakes up a pending Read to apply t.
+func (c *portConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	close(c.changed)
+	c.changed = make(chan struct{})
+	return nil
+}
+
+// SetWriteDeadline is a no-op, the writes to the listener don't block.
+func (c *portConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// transferPacket reports whether b is a DATA, ACK, ERROR or OACK packet,
+// which belong to a transfer.
+func transferPacket(b []byte) bool {
+	if len(b) < 2 {
+		return false
+	}
+	op := Opcode(binary.BigEndian.Uint16(b))
+	return op >= DataOp && op <= OptionAckOp
+}
+
+// dispatch handles the datagram b received by a listener from client in
+// single-port mode and reports whether it was: the packets of the
+// transfers are queued for them, the ones of no transfer in progress
+// dropped like by a closed port, and so are the requests once the server
+// is closed. The requests retransmitted during their transfer are dropped
+// by the admission, like in the other mode.
+func (s *TFTPServer) dispatch(client net.Addr, b []byte) bool {
+	if transferPacket(b) {
+		if !s.ports.deliver(client, b) {
+			s.Logger.Debug("dropping packet of no transfer", "client", client)
+		}
+		return true
+	}
+	return s.closing()
+}
+
+// dial returns the connection of a transfer with client: a socket of its
+// own, or listener in single-port mode.
+func (s *TFTPServer) dial(listener net.PacketConn, client net.Addr) (net.Conn, error) {
+	if !s.SinglePort {
+		return net.Dial("udp", client.String())
+	}
+	c, err := s.ports.open(listener, client)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,327 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// slotNames are the names of the published file slots, the served file is
+// loaded in slot A by NewTFTPServer.
+var slotNames = [2]string{"A", "B"}
+
+type slot struct {
+	file    string
+	payload []byte
+	loaded  time.Time
+	release func() // if set, called when the transfer is done with payload
+
+	// stream, if set, is the local file each transfer reads the content
+	// from instead of payload (see StreamThreshold): size bytes modified
+	// at modTime, a later change fails the transfers. The size and
+	// modTime of a local file read in memory are the ones it was read
+	// with, see ReloadChanged.
+	stream  string
+	size    int64
+	modTime time.Time
+
+	// reader, if set, is the content of size bytes of a single transfer
+	// (see ReadHandler), closed by release.
+	reader io.ReadSeeker
+}
+
+// len returns the size of the content of sl.
+func (sl slot) len() int64 {
+	if sl.stream != "" || sl.reader != nil {
+		return sl.size
+	}
+	return int64(len(sl.payload))
+}
+
+// open returns a reader of the content of sl, the file of a streamed slot
+// is opened for every transfer.
+func (sl slot) open() (io.ReadSeekCloser, error) {
+	if sl.reader != nil {
+		return nopCloser{sl.reader}, nil
+	}
+	if sl.stream == "" {
+		return nopCloser{bytes.NewReader(sl.payload)}, nil
+	}
+	f, err := os.Open(sl.stream)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err != nil || info.Size() != sl.size || !info.ModTime().Equal(sl.modTime) {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", sl.stream, errFileChanged)
+	}
+	return f, nil
+}
+
+// errFileChanged fails the transfers of a streamed file modified since it
+// was loaded, until it is loaded again.
+var errFileChanged = errors.New("file changed since it was loaded")
+
+// changed reports whether the local file of sl was modified since it was
+// loaded. A file removed meanwhile isn't: the slot keeps its content.
+func (sl slot) changed() bool {
+	if sl.file == "" || sl.modTime.IsZero() {
+		return false
+	}
+	info, err := os.Stat(sl.file)
+	return err == nil && (info.Size() != sl.size || !info.ModTime().Equal(sl.modTime))
+}
+
+type nopCloser struct{ io.ReadSeeker }
+
+func (nopCloser) Close() error { return nil }
+
+// DefaultStreamThreshold is the StreamThreshold set by NewTFTPServer.
+const DefaultStreamThreshold = 32 << 20
+
+// loadSlot returns the slot serving file (a path or an http(s) URL): a
+// local file of at least StreamThreshold bytes is streamed from disk, the
+// other files are read in memory.
+func (s *TFTPServer) loadSlot(file string) (slot, error) {
+	if sl, ok, err := s.streamed(file); ok {
+		return sl, err
+	}
+	var info os.FileInfo
+	if !isRemoteSource(file) {
+		// before the read: a change during it is seen by the next check
+		info, _ = os.Stat(file)
+	}
+	p, err := s.readSource(file)
+	if err != nil {
+		return slot{}, err
+	}
+	sl := slot{file: file, payload: p, loaded: time.Now()}
+	if info != nil {
+		sl.size, sl.modTime = info.Size(), info.ModTime()
+	}
+	return sl, nil
+}
+
+// streamed returns the slot streaming file from disk, ok is false if file
+// must be read in memory instead (see StreamThreshold).
+func (s *TFTPServer) streamed(file string) (sl slot, ok bool, err error) {
+	if s.StreamThreshold < 0 || isRemoteSource(file) {
+		return slot{}, false, nil
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return slot{}, true, err
+	}
+	if info.Size() < s.StreamThreshold {
+		return slot{}, false, nil
+	}
+	if info.IsDir() {
+		return slot{}, true, fmt.Errorf("%s is a directory", file)
+	}
+	return slot{file: file, loaded: time.Now(), stream: file, size: info.Size(), modTime: info.ModTime()}, true, nil
+}
+
+// done releases the payload of s, see sharedFiles.
+func (s slot) done() {
+	if s.release != nil {
+		s.release()
+	}
+}
+
+// slots holds two published versions of the served file, one of them is
+// active. A slot is only replaced once its file was read completely so
+// switching never exposes a partial copy.
+type slots struct {
+	mu     sync.RWMutex
+	s      [2]slot
+	active int
+	canary int        // percentage of clients served the inactive slot
+	reload sync.Mutex // held while a changed file is loaded again, see ReloadChanged
+}
+
+// SlotStatus describes a published file slot.
+type SlotStatus struct {
+	Name   string    `json:"name"`
+	Active bool      `json:"active"`
+	File   string    `json:"file,omitempty"`
+	Size   int64     `json:"size"`
+	SHA256 string    `json:"sha256,omitempty"` // of the slots loaded in memory
+	Loaded time.Time `json:"loaded,omitempty"`
+	Canary int       `json:"canary_percent"` // share of clients served this slot while it isn't active
+}
+
+func slotIndex(name string) (int, error) {
+	for i, n := range slotNames {
+		if strings.EqualFold(name, n) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown slot %q (want A or B)", name)
+}
+
+// payloadFor returns the content served to client and the name of its slot:
+// the canary percentage of clients (chosen by a hash of their IP, so a client
+// always gets the same variant) receive the inactive slot. With
+// ReloadChanged its file is loaded again first if it changed on disk.
+func (s *TFTPServer) payloadFor(client net.Addr) (slot, string) {
+	s.slots.mu.RLock()
+	i := s.slots.active
+	if s.slots.canary > 0 && canaryBucket(clientHost(client)) < s.slots.canary {
+		i = 1 - i
+	}
+	sl := s.slots.s[i]
+	s.slots.mu.RUnlock()
+	if s.ReloadChanged && sl.changed() {
+		sl = s.reloadChanged(i, sl)
+	}
+	return sl, slotNames[i]
+}
+
+// reloadChanged loads the changed file of sl, the content of slot i, again
+// and returns the slot to serve: sl if it can't be read. The transfers
+// finding the file changed at once wait for a single reload.
+func (s *TFTPServer) reloadChanged(i int, sl slot) slot {
+	s.slots.reload.Lock()
+	defer s.slots.reload.Unlock()
+	s.slots.mu.RLock()
+	current := s.slots.s[i]
+	s.slots.mu.RUnlock()
+	if current.file != sl.file || !current.changed() {
+		return current // reloaded, or published, meanwhile
+	}
+	fresh, err := s.loadSlot(sl.file)
+	if err != nil {
+		s.Logger.Warn("reloading changed file", "slot", slotNames[i], "file", sl.file, "error", err)
+		return current
+	}
+	s.slots.mu.Lock()
+	if s.slots.s[i].file == sl.file {
+		s.slots.s[i] = fresh
+	}
+	s.slots.mu.Unlock()
+	s.Logger.Info("file changed on disk, reloaded", "slot", slotNames[i], "file", sl.file, "size", fresh.len(), "streamed", fresh.stream != "")
+	return fresh
+}
+
+// canaryBucket maps a client IP to [0, 100).
+func canaryBucket(ip string) int {
+	h := fnv.New32a()
+	h.Write([]byte(ip))
+	return int(h.Sum32() % 100)
+}
+
+func (s *TFTPServer) currentSize() int64 {
+	s.slots.mu.RLock()
+	defer s.slots.mu.RUnlock()
+	return s.slots.s[s.slots.active].len()
+}
+
+func (s *TFTPServer) currentFile() string {
+	s.slots.mu.RLock()
+	defer s.slots.mu.RUnlock()
+	return s.slots.s[s.slots.active].file
+}
+
+// Reload reads the file of the active slot from disk again, transfers already
+// in progress keep sending the previous content.
+func (s *TFTPServer) Reload() error {
+	s.slots.mu.RLock()
+	name := slotNames[s.slots.active]
+	s.slots.mu.RUnlock()
+	return s.PublishSlot(name, "")
+}
+
+// PublishSlot loads file (a path or an http(s) URL) into the named slot ("A"
+// or "B"), an empty file reloads the file already published there. If the slot is the active one the
+// new content is served to the following transfers.
+func (s *TFTPServer) PublishSlot(name, file string) error {
+	i, err := slotIndex(name)
+	if err != nil {
+		return err
+	}
+	if file == "" {
+		s.slots.mu.RLock()
+		file = s.slots.s[i].file
+		s.slots.mu.RUnlock()
+		if file == "" {
+			return fmt.Errorf("nothing published in slot %s", slotNames[i])
+		}
+	}
+	sl, err := s.loadSlot(file)
+	if err != nil {
+		return err
+	}
+
+	s.slots.mu.Lock()
+	s.slots.s[i] = sl
+	s.slots.mu.Unlock()
+	s.Logger.Info("slot published", "slot", slotNames[i], "file", file, "size", sl.len(), "streamed", sl.stream != "")
+	return nil
+}
+
+// ActivateSlot atomically switches the served file to the named slot,
+// transfers in progress finish with the content they started with.
+func (s *TFTPServer) ActivateSlot(name string) error {
+	i, err := slotIndex(name)
+	if err != nil {
+		return err
+	}
+	s.slots.mu.Lock()
+	if s.slots.s[i].file == "" {
+		s.slots.mu.Unlock()
+		return fmt.Errorf("nothing published in slot %s", slotNames[i])
+	}
+	previous := s.slots.active
+	s.slots.active = i
+	s.slots.canary = 0
+	s.slots.mu.Unlock()
+	if previous != i {
+		s.Logger.Info("slot activated", "slot", slotNames[i], "previous", slotNames[previous])
+	}
+	return nil
+}
+
+// SetCanary serves the inactive slot to percent of the clients (0 to 100),
+// 0 stops the canary. Activating a slot resets it to 0.
+func (s *TFTPServer) SetCanary(percent int) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("canary percentage %d out of range [0, 100]", percent)
+	}
+	s.slots.mu.Lock()
+	inactive := 1 - s.slots.active
+	if percent > 0 && s.slots.s[inactive].file == "" {
+		s.slots.mu.Unlock()
+		return fmt.Errorf("nothing published in slot %s", slotNames[inactive])
+	}
+	s.slots.canary = percent
+	s.slots.mu.Unlock()
+	s.Logger.Info("canary changed", "slot", slotNames[inactive], "percent", percent)
+	return nil
+}
+
+// Slots returns the status of the published file slots.
+func (s *TFTPServer) Slots() []SlotStatus {
+	s.slots.mu.RLock()
+	defer s.slots.mu.RUnlock()
+	statuses := make([]SlotStatus, len(s.slots.s))
+	for i, sl := range s.slots.s {
+		statuses[i] = SlotStatus{Name: slotNames[i], Active: i == s.slots.active, File: sl.file, Size: sl.len(), Loaded: sl.loaded}
+		if i != s.slots.active {
+			statuses[i].Canary = s.slots.canary
+		}
+		if sl.file != "" && sl.stream == "" {
+			sum := sha256.Sum256(sl.payload)
+			statuses[i].SHA256 = hex.EncodeToString(sum[:])
+		}
+	}
+	return statuses
+}
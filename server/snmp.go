@@ -0,0 +1,530 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SNMPAgent answers SNMP v1 and v2c GET, GETNEXT and GETBULK requests for the
+// server counters, SET requests are refused. The variables are scalars under
+// BaseOID:
+//
+//	.1.0   active transfers (Gauge32)
+//	.2.0   finished transfers
+//	.3.0   successful transfers
+//	.4.0   failed transfers (timeout, aborted, error or cancelled)
+//	.5.0   payload bytes sent
+//	.6.0   retransmitted DATA packets
+//	.7.0   timeouts
+//	.8.0   ERROR packets sent
+//	.9.0   ERROR packets received
+//	.10.0  unparsable requests
+//	.11.0  datagrams received
+//	.12.0  datagrams sent
+//	.13.0  security violations
+//
+// The counters are Counter64, or Counter32 (wrapping) for v1 requests which
+// don't have 64 bits counters.
+type SNMPAgent struct {
+	// Community is the community string requests must carry, others are ignored.
+	Community string
+
+	// BaseOID is the subtree of the variables, it defaults to DefaultSNMPBaseOID.
+	BaseOID string
+
+	Metrics *Metrics
+	Logger  *Logger
+}
+
+// DefaultSNMPBaseOID is under NET-SNMP-MIB::netSnmpPlaceholder, an OID set
+// aside for unregistered MIBs. Set SNMPAgent.BaseOID to an OID of your own
+// enterprise if it matters.
+const DefaultSNMPBaseOID = "1.3.6.1.4.1.8072.9999.9999.69"
+
+// snmpMaxRepetitions bounds the repetitions of a GETBULK request and
+// snmpMaxVarBinds the variables of any response, snmpMaxMessageSize the
+// size of the responses: they fit in an Ethernet frame. A GETBULK response
+// over it is truncated, as RFC 3416 allows, the other ones are refused
+// with tooBig.
+const (
+	snmpMaxRepetitions = 64
+	snmpMaxVarBinds    = 128
+	snmpMaxMessageSize = 1472
+)
+
+// BER types and PDU tags used by SNMP.
+const (
+	berInteger      = 0x02
+	berOctetString  = 0x04
+	berNull         = 0x05
+	berOID          = 0x06
+	berSequence     = 0x30
+	berCounter32    = 0x41
+	berGauge32      = 0x42
+	berCounter64    = 0x46
+	berNoSuchObject = 0x80
+	berEndOfMibView = 0x82
+
+	pduGet      = 0xa0
+	pduGetNext  = 0xa1
+	pduResponse = 0xa2
+	pduSet      = 0xa3
+	pduGetBulk  = 0xa5
+
+	snmpV1  = 0
+	snmpV2c = 1
+
+	snmpTooBig      = 1
+	snmpNoSuchName  = 2
+	snmpReadOnly    = 4
+	snmpNotWritable = 17
+)
+
+type snmpVar struct {
+	oid   []uint32
+	gauge bool
+	value func(m *Metrics) uint64
+}
+
+func (a *SNMPAgent) variables() ([]snmpVar, error) {
+	baseOID := a.BaseOID
+	if baseOID == "" {
+		baseOID = DefaultSNMPBaseOID
+	}
+	base, err := parseOID(baseOID)
+	if err != nil {
+		return nil, err
+	}
+	sum := func(values map[string]int64) uint64 {
+		var n int64
+		for _, v := range values {
+			n += v
+		}
+		return uint64(n)
+	}
+	values := []func(m *Metrics) uint64{
+		func(m *Metrics) uint64 { return uint64(m.activeTransfers.Load()) },
+		func(m *Metrics) uint64 { return sum(m.transfers.Values()) },
+		func(m *Metrics) uint64 { return uint64(m.transfers.With(string(ResultOK)).Load()) },
+		func(m *Metrics) uint64 {
+			return sum(m.transfers.Values()) - uint64(m.transfers.With(string(ResultOK)).Load())
+		},
+		func(m *Metrics) uint64 { return uint64(m.bytesSent.Load()) },
+		func(m *Metrics) uint64 { return uint64(m.retransmits.Load()) },
+		func(m *Metrics) uint64 { return uint64(m.timeouts.Load()) },
+		func(m *Metrics) uint64 { return sum(m.errorsSent.Values()) },
+		func(m *Metrics) uint64 { return sum(m.errorsReceived.Values()) },
+		func(m *Metrics) uint64 { return uint64(m.parseFailures.Load()) },
+		func(m *Metrics) uint64 { return sum(m.packetsReceived.Values()) },
+		func(m *Metrics) uint64 { return sum(m.packetsSent.Values()) },
+		func(m *Metrics) uint64 { return sum(m.securityEvents.Values()) },
+	}
+	vars := make([]snmpVar, len(values))
+	for i, v := range values {
+		oid := append(append([]uint32(nil), base...), uint32(i+1), 0)
+		vars[i] = snmpVar{oid: oid, gauge: i == 0, value: v}
+	}
+	return vars, nil
+}
+
+// ListenAndServe answers the SNMP requests received on the UDP address addr
+// (usually ":161").
+func (a *SNMPAgent) ListenAndServe(addr string) error {
+	vars, err := a.variables()
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	a.Logger.Info("snmp agent listening", "addr", conn.LocalAddr())
+
+	buf := make([]byte, 65535)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		reply, err := a.handle(vars, buf[:n])
+		if err != nil {
+			a.Logger.Debug("invalid snmp request", "peer", peer, "error", err)
+			continue
+		}
+		if reply != nil {
+			conn.WriteTo(reply, peer)
+		}
+	}
+}
+
+type snmpVarBind struct {
+	oid   []uint32
+	value []byte // encoded TLV
+}
+
+// handle returns the response to a request, nil if it must be ignored.
+func (a *SNMPAgent) handle(vars []snmpVar, b []byte) ([]byte, error) {
+	msg, err := berContents(b, berSequence)
+	if err != nil {
+		return nil, err
+	}
+	version, msg, err := berReadInt(msg)
+	if err != nil {
+		return nil, err
+	}
+	if version != snmpV1 && version != snmpV2c {
+		return nil, fmt.Errorf("unsupported snmp version %d", version)
+	}
+	community, msg, err := berRead(msg, berOctetString)
+	if err != nil {
+		return nil, err
+	}
+	if string(community) != a.Community {
+		return nil, errors.New("wrong community")
+	}
+	if len(msg) < 2 {
+		return nil, errors.New("missing pdu")
+	}
+	pduType := msg[0]
+	pdu, _, err := berRead(msg, pduType)
+	if err != nil {
+		return nil, err
+	}
+	requestID, pdu, err := berReadInt(pdu)
+	if err != nil {
+		return nil, err
+	}
+	nonRepeaters, pdu, err := berReadInt(pdu)
+	if err != nil {
+		return nil, err
+	}
+	maxRepetitions, pdu, err := berReadInt(pdu)
+	if err != nil {
+		return nil, err
+	}
+	list, _, err := berRead(pdu, berSequence)
+	if err != nil {
+		return nil, err
+	}
+	var oids [][]uint32
+	for len(list) > 0 {
+		var vb []byte
+		vb, list, err = berRead(list, berSequence)
+		if err != nil {
+			return nil, err
+		}
+		raw, _, err := berRead(vb, berOID)
+		if err != nil {
+			return nil, err
+		}
+		oid, err := decodeOID(raw)
+		if err != nil {
+			return nil, err
+		}
+		oids = append(oids, oid)
+	}
+
+	var (
+		binds       []snmpVarBind
+		errorStatus int
+		errorIndex  int
+	)
+	get := func(i int, oid []uint32, next bool) []uint32 {
+		j := sort.Search(len(vars), func(j int) bool { return compareOID(vars[j].oid, oid) >= 0 })
+		if next && j < len(vars) && compareOID(vars[j].oid, oid) == 0 {
+			j++
+		}
+		if j == len(vars) || (!next && compareOID(vars[j].oid, oid) != 0) {
+			tag := byte(berNoSuchObject)
+			if next {
+				tag = berEndOfMibView
+			}
+			if version == snmpV1 && errorStatus == 0 {
+				errorStatus, errorIndex = snmpNoSuchName, i+1
+			}
+			binds = append(binds, snmpVarBind{oid: oid, value: []byte{tag, 0}})
+			return nil
+		}
+		binds = append(binds, snmpVarBind{oid: vars[j].oid, value: vars[j].encode(a.Metrics, version)})
+		return vars[j].oid
+	}
+
+	switch pduType {
+	case pduGet, pduGetNext:
+		for i, oid := range oids {
+			get(i, oid, pduType == pduGetNext)
+		}
+	case pduGetBulk:
+		if version == snmpV1 {
+			return nil, errors.New("getbulk in a v1 request")
+		}
+		if nonRepeaters < 0 {
+			nonRepeaters = 0
+		}
+		if maxRepetitions > snmpMaxRepetitions {
+			maxRepetitions = snmpMaxRepetitions
+		}
+		for i, oid := range oids {
+			if i < nonRepeaters {
+				get(i, oid, true)
+			}
+		}
+		repeaters := oids
+		if nonRepeaters < len(oids) {
+			repeaters = oids[nonRepeaters:]
+		} else {
+			repeaters = nil
+		}
+		for r := 0; r < maxRepetitions && len(repeaters) > 0; r++ {
+			ended := true
+			for i, oid := range repeaters {
+				if len(binds) == snmpMaxVarBinds {
+					ended = true
+					break
+				}
+				if next := get(nonRepeaters+i, oid, true); next != nil {
+					repeaters[i] = next
+					ended = false
+				}
+			}
+			if ended {
+				break
+			}
+		}
+	case pduSet:
+		errorStatus, errorIndex = snmpNotWritable, 1
+		if version == snmpV1 {
+			errorStatus = snmpReadOnly
+		}
+		for _, oid := range oids {
+			binds = append(binds, snmpVarBind{oid: oid, value: []byte{berNull, 0}})
+		}
+	default:
+		return nil, fmt.Errorf("unsupported pdu type %#x", pduType)
+	}
+
+	if errorStatus != 0 && version == snmpV1 {
+		// v1 errors echo the request variables
+		binds = binds[:0]
+		for _, oid := range oids {
+			binds = append(binds, snmpVarBind{oid: oid, value: []byte{berNull, 0}})
+		}
+	}
+
+	if len(binds) > snmpMaxVarBinds {
+		errorStatus, errorIndex, binds = snmpTooBig, 0, nil
+	}
+	encode := func(binds []snmpVarBind) []byte {
+		var varBinds []byte
+		for _, vb := range binds {
+			varBinds = append(varBinds, berTLV(berSequence, append(berTLV(berOID, encodeOID(vb.oid)), vb.value...))...)
+		}
+		response := berInt(requestID)
+		response = append(response, berInt(errorStatus)...)
+		response = append(response, berInt(errorIndex)...)
+		response = append(response, berTLV(berSequence, varBinds)...)
+
+		reply := berInt(version)
+		reply = append(reply, berTLV(berOctetString, community)...)
+		reply = append(reply, berTLV(pduResponse, response)...)
+		return berTLV(berSequence, reply)
+	}
+	reply := encode(binds)
+	for len(reply) > snmpMaxMessageSize && len(binds) > 0 {
+		if pduType == pduGetBulk && len(binds) > 1 {
+			binds = binds[:len(binds)-1]
+		} else {
+			errorStatus, errorIndex, binds = snmpTooBig, 0, nil
+		}
+		reply = encode(binds)
+	}
+	return reply, nil
+}
+
+func (v snmpVar) encode(m *Metrics, version int) []byte {
+	n := v.value(m)
+	switch {
+	case v.gauge:
+		return berTLV(berGauge32, berUint(uint64(uint32(n))))
+	case version == snmpV1:
+		return berTLV(berCounter32, berUint(uint64(uint32(n))))
+	default:
+		return berTLV(berCounter64, berUint(n))
+	}
+}
+
+func parseOID(s string) ([]uint32, error) {
+	var oid []uint32
+	for _, part := range strings.Split(strings.Trim(s, "."), ".") {
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %q", s)
+		}
+		oid = append(oid, uint32(n))
+	}
+	if len(oid) < 2 {
+		return nil, fmt.Errorf("invalid OID %q", s)
+	}
+	return oid, nil
+}
+
+func compareOID(a, b []uint32) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+
+// decodeOID decodes the contents of an OID TLV. Its first subidentifier
+// packs the first two arcs, X*40+Y with X = 2 from 80 on (X.690 8.19).
+func decodeOID(b []byte) ([]uint32, error) {
+	if len(b) == 0 {
+		return nil, errors.New("empty OID")
+	}
+	var (
+		oid     []uint32
+		n       uint64
+		pending bool // a subidentifier is being read
+	)
+	for _, c := range b {
+		if !pending && c == 0x80 {
+			return nil, errors.New("OID subidentifier not minimally encoded")
+		}
+		n = n<<7 | uint64(c&0x7f)
+		if n > math.MaxUint32 {
+			return nil, errors.New("OID subidentifier over 32 bits")
+		}
+		if pending = c&0x80 != 0; pending {
+			continue
+		}
+		switch {
+		case oid != nil:
+			oid = append(oid, uint32(n))
+		case n < 40:
+			oid = []uint32{0, uint32(n)}
+		case n < 80:
+			oid = []uint32{1, uint32(n - 40)}
+		default:
+			oid = []uint32{2, uint32(n - 80)}
+		}
+		n = 0
+	}
+	if pending {
+		return nil, errors.New("truncated OID")
+	}
+	return oid, nil
+}
+
+func encodeOID(oid []uint32) []byte {
+	b := appendSubidentifier(nil, uint64(oid[0])*40+uint64(oid[1]))
+	for _, n := range oid[2:] {
+		b = appendSubidentifier(b, uint64(n))
+	}
+	return b
+}
+
+// appendSubidentifier appends n in base 128, the high bit set on all the
+// bytes but the last one.
+func appendSubidentifier(b []byte, n uint64) []byte {
+	var tmp [10]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(n & 0x7f)
+	for n >>= 7; n > 0; n >>= 7 {
+		i--
+		tmp[i] = byte(n&0x7f) | 0x80
+	}
+	return append(b, tmp[i:]...)
+}
+
+func berTLV(tag byte, contents []byte) []byte {
+	b := []byte{tag}
+	n := len(contents)
+	if n < 0x80 {
+		b = append(b, byte(n))
+		return append(b, contents...)
+	}
+	// the long form: the number of length bytes, then the length
+	var length []byte
+	for ; n > 0; n >>= 8 {
+		length = append([]byte{byte(n)}, length...)
+	}
+	b = append(append(b, 0x80|byte(len(length))), length...)
+	return append(b, contents...)
+}
+
+func berInt(n int) []byte {
+	v := int64(n)
+	b := []byte{byte(v)}
+	for (v > 0x7f || v < -0x80) && len(b) < 8 {
+		v >>= 8
+		b = append([]byte{byte(v)}, b...)
+	}
+	return berTLV(berInteger, b)
+}
+
+// berUint encodes the contents of an unsigned integer (Counter, Gauge).
+func berUint(n uint64) []byte {
+	b := []byte{byte(n)}
+	for n >>= 8; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	if b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
+
+// berRead reads a TLV with the given tag at the start of b, it returns its
+// contents and what follows it.
+func berRead(b []byte, tag byte) (contents, rest []byte, err error) {
+	if len(b) < 2 || b[0] != tag {
+		return nil, nil, fmt.Errorf("expected tag %#x", tag)
+	}
+	n, i := int(b[1]), 2
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 3 || len(b) < 2+size {
+			return nil, nil, errors.New("invalid length")
+		}
+		n = 0
+		for _, c := range b[2 : 2+size] {
+			n = n<<8 | int(c)
+		}
+		i += size
+	}
+	if len(b) < i+n {
+		return nil, nil, errors.New("truncated")
+	}
+	return b[i : i+n], b[i+n:], nil
+}
+
+func berContents(b []byte, tag byte) ([]byte, error) {
+	contents, _, err := berRead(b, tag)
+	return contents, err
+}
+
+func berReadInt(b []byte) (int, []byte, error) {
+	contents, rest, err := berRead(b, berInteger)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(contents) == 0 || len(contents) > 4 {
+		return 0, nil, errors.New("invalid integer")
+	}
+	n := int64(int8(contents[0]))
+	for _, c := range contents[1:] {
+		n = n<<8 | int64(c)
+	}
+	return int(n), rest, nil
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// snmpRequest encodes a request, a and b are the error status and index
+// fields, or the non-repeaters and max-repetitions of GETBULK.
+func snmpRequest(version int, community string, pdu byte, a, b int, oids ...[]uint32) []byte {
+	var list []byte
+	for _, oid := range oids {
+		list = append(list, berTLV(berSequence, append(berTLV(berOID, encodeOID(oid)), berNull, 0))...)
+	}
+	contents := berInt(42)
+	contents = append(contents, berInt(a)...)
+	contents = append(contents, berInt(b)...)
+	contents = append(contents, berTLV(berSequence, list)...)
+	msg := berInt(version)
+	msg = append(msg, berTLV(berOctetString, []byte(community))...)
+	msg = append(msg, berTLV(pdu, contents)...)
+	return berTLV(berSequence, msg)
+}
+
+type snmpResponse struct {
+	errorStatus, errorIndex int
+	oids                    [][]uint32
+	tags                    []byte
+}
+
+func parseSNMPResponse(t *testing.T, b []byte) snmpResponse {
+	t.Helper()
+	fail := func(err error) {
+		if err != nil {
+			t.Fatalf("invalid response %x: %v", b, err)
+		}
+	}
+	msg, err := berContents(b, berSequence)
+	fail(err)
+	_, msg, err = berReadInt(msg)
+	fail(err)
+	_, msg, err = berRead(msg, berOctetString)
+	fail(err)
+	pdu, _, err := berRead(msg, pduResponse)
+	fail(err)
+	var r snmpResponse
+	_, pdu, err = berReadInt(pdu)
+	fail(err)
+	r.errorStatus, pdu, err = berReadInt(pdu)
+	fail(err)
+	r.errorIndex, pdu, err = berReadInt(pdu)
+	fail(err)
+	list, _, err := berRead(pdu, berSequence)
+	fail(err)
+	for len(list) > 0 {
+		var vb []byte
+		vb, list, err = berRead(list, berSequence)
+		fail(err)
+		raw, value, err := berRead(vb, berOID)
+		fail(err)
+		oid, err := decodeOID(raw)
+		fail(err)
+		r.oids = append(r.oids, oid)
+		r.tags = append(r.tags, value[0])
+	}
+	return r
+}
+
+func TestSNMPAgent(t *testing.T) {
+	a := &SNMPAgent{Community: "public", Metrics: NewMetrics()}
+	vars, err := a.variables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	base, _ := parseOID(DefaultSNMPBaseOID)
+	oid := func(arcs ...uint32) []uint32 { return append(append([]uint32(nil), base...), arcs...) }
+	many := make([][]uint32, snmpMaxVarBinds+1)
+	for i := range many {
+		many[i] = oid(5, 0)
+	}
+	repeaters := make([][]uint32, 20)
+	for i := range repeaters {
+		repeaters[i] = base
+	}
+
+	for _, tt := range []struct {
+		name        string
+		request     []byte
+		ignored     bool // no response
+		errorStatus int
+		errorIndex  int
+		oids        [][]uint32 // nil to skip the check
+		tags        []byte
+	}{
+		{
+			name:    "get",
+			request: snmpRequest(snmpV2c, "public", pduGet, 0, 0, oid(1, 0), oid(5, 0)),
+			oids:    [][]uint32{oid(1, 0), oid(5, 0)},
+			tags:    []byte{berGauge32, berCounter64},
+		},
+		{
+			name:    "get v1",
+			request: snmpRequest(snmpV1, "public", pduGet, 0, 0, oid(5, 0)),
+			oids:    [][]uint32{oid(5, 0)},
+			tags:    []byte{berCounter32},
+		},
+		{
+			name:    "get unknown",
+			request: snmpRequest(snmpV2c, "public", pduGet, 0, 0, oid(5, 0), oid(99, 0)),
+			oids:    [][]uint32{oid(5, 0), oid(99, 0)},
+			tags:    []byte{berCounter64, berNoSuchObject},
+		},
+		{
+			name:        "get unknown v1",
+			request:     snmpRequest(snmpV1, "public", pduGet, 0, 0, oid(5, 0), oid(99, 0)),
+			errorStatus: snmpNoSuchName,
+			errorIndex:  2,
+			oids:        [][]uint32{oid(5, 0), oid(99, 0)},
+			tags:        []byte{berNull, berNull},
+		},
+		{
+			name:    "getnext",
+			request: snmpRequest(snmpV2c, "public", pduGetNext, 0, 0, base, oid(1, 0), oid(13, 0)),
+			oids:    [][]uint32{oid(1, 0), oid(2, 0), oid(13, 0)},
+			tags:    []byte{berGauge32, berCounter64, berEndOfMibView},
+		},
+		{
+			name:        "getnext v1 end",
+			request:     snmpRequest(snmpV1, "public", pduGetNext, 0, 0, oid(13, 0)),
+			errorStatus: snmpNoSuchName,
+			errorIndex:  1,
+			oids:        [][]uint32{oid(13, 0)},
+			tags:        []byte{berNull},
+		},
+		{
+			name:    "getbulk",
+			request: snmpRequest(snmpV2c, "public", pduGetBulk, 1, 2, base, oid(11, 0)),
+			oids:    [][]uint32{oid(1, 0), oid(12, 0), oid(13, 0)},
+			tags:    []byte{berGauge32, berCounter64, berCounter64},
+		},
+		{
+			name:    "getbulk to the end",
+			request: snmpRequest(snmpV2c, "public", pduGetBulk, 0, 1000, oid(11, 0)),
+			oids:    [][]uint32{oid(12, 0), oid(13, 0), oid(13, 0)},
+			tags:    []byte{berCounter64, berCounter64, berEndOfMibView},
+		},
+		{
+			name:    "getbulk v1",
+			request: snmpRequest(snmpV1, "public", pduGetBulk, 0, 10, base),
+			ignored: true,
+		},
+		{
+			name:        "set",
+			request:     snmpRequest(snmpV2c, "public", pduSet, 0, 0, oid(5, 0)),
+			errorStatus: snmpNotWritable,
+			errorIndex:  1,
+			oids:        [][]uint32{oid(5, 0)},
+			tags:        []byte{berNull},
+		},
+		{
+			name:        "too many variables",
+			request:     snmpRequest(snmpV2c, "public", pduGet, 0, 0, many...),
+			errorStatus: snmpTooBig,
+			oids:        [][]uint32{},
+			tags:        []byte{},
+		},
+		{
+			name:    "wrong community",
+			request: snmpRequest(snmpV2c, "private", pduGet, 0, 0, oid(5, 0)),
+			ignored: true,
+		},
+		{
+			name:    "v3",
+			request: snmpRequest(3, "public", pduGet, 0, 0, oid(5, 0)),
+			ignored: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, err := a.handle(vars, tt.request)
+			if tt.ignored {
+				if err == nil || reply != nil {
+					t.Fatalf("got a response, err %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := parseSNMPResponse(t, reply)
+			if r.errorStatus != tt.errorStatus || r.errorIndex != tt.errorIndex {
+				t.Errorf("error %d at %d, want %d at %d", r.errorStatus, r.errorIndex, tt.errorStatus, tt.errorIndex)
+			}
+			if len(r.oids) != len(tt.oids) || len(r.oids) > 0 && !reflect.DeepEqual(r.oids, tt.oids) {
+				t.Errorf("variables %v, want %v", r.oids, tt.oids)
+			}
+			if !bytes.Equal(r.tags, tt.tags) {
+				t.Errorf("types %x, want %x", r.tags, tt.tags)
+			}
+		})
+	}
+
+	t.Run("getbulk clamped", func(t *testing.T) {
+		// 20 repeaters walking the 13 variables would get 280 variables
+		reply, err := a.handle(vars, snmpRequest(snmpV2c, "public", pduGetBulk, 0, 1000, repeaters...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reply) > snmpMaxMessageSize {
+			t.Errorf("response of %d bytes, over %d", len(reply), snmpMaxMessageSize)
+		}
+		r := parseSNMPResponse(t, reply)
+		if r.errorStatus != 0 || len(r.oids) == 0 || len(r.oids) > snmpMaxVarBinds {
+			t.Errorf("error %d with %d variables, want a truncated response", r.errorStatus, len(r.oids))
+		}
+		for i, got := range r.oids {
+			if want := oid(uint32(i/len(repeaters)+1), 0); !reflect.DeepEqual(got, want) {
+				t.Fatalf("variable %d is %v, want %v", i, got, want)
+			}
+		}
+	})
+}
+
+func TestSNMPMalformed(t *testing.T) {
+	a := &SNMPAgent{Community: "public", Metrics: NewMetrics()}
+	vars, _ := a.variables()
+	request := snmpRequest(snmpV2c, "public", pduGet, 0, 0, []uint32{1, 3, 6, 1, 4, 1, 8072})
+	// every truncation of a valid request
+	for n := 0; n < len(request); n++ {
+		if reply, err := a.handle(vars, request[:n]); err == nil || reply != nil {
+			t.Errorf("request truncated to %d bytes answered", n)
+		}
+	}
+	for _, tt := range []struct {
+		name    string
+		request []byte
+	}{
+		{"not a sequence", append([]byte{berOctetString}, request[1:]...)},
+		{"length of 4 bytes", []byte{berSequence, 0x84, 0, 0, 0, 3, berInteger, 1, 1}},
+		{"indefinite length", []byte{berSequence, 0x80, berInteger, 1, 1, 0, 0}},
+		{"integer of 5 bytes", berTLV(berSequence, berTLV(berInteger, []byte{1, 2, 3, 4, 5}))},
+		{"empty integer", berTLV(berSequence, berTLV(berInteger, nil))},
+		{"truncated OID", snmpRequestRaw([]byte{0x2b, 0x86})},
+		{"empty OID", snmpRequestRaw(nil)},
+		{"OID over 32 bits", snmpRequestRaw([]byte{0x2b, 0x90, 0x80, 0x80, 0x80, 0x00})},
+		{"varbind without OID", snmpRequestList(berTLV(berSequence, []byte{berNull, 0}))},
+		{"unknown pdu", snmpRequest(snmpV2c, "public", 0xa8, 0, 0, []uint32{1, 3})},
+	} {
+		if reply, err := a.handle(vars, tt.request); err == nil || reply != nil {
+			t.Errorf("%s: answered", tt.name)
+		}
+	}
+}
+
+// snmpRequestRaw is a GET request of the OID of contents raw.
+func snmpRequestRaw(raw []byte) []byte {
+	return snmpRequestList(berTLV(berSequence, append(berTLV(berOID, raw), berNull, 0)))
+}
+
+// snmpRequestList is a GET request of the variable bindings list.
+func snmpRequestList(list []byte) []byte {
+	contents := append(append(append(berInt(1), berInt(0)...), berInt(0)...), berTLV(berSequence, list)...)
+	msg := append(append(berInt(snmpV2c), berTLV(berOctetString, []byte("public"))...), berTLV(pduGet, contents)...)
+	return berTLV(berSequence, msg)
+}
+
+func TestOIDCodec(t *testing.T) {
+	for _, tt := range []struct {
+		raw []byte
+		oid []uint32
+	}{
+		{[]byte{0x2b, 6, 1}, []uint32{1, 3, 6, 1}},
+		{[]byte{0x00}, []uint32{0, 0}},
+		{[]byte{0x27}, []uint32{0, 39}},
+		{[]byte{0x50, 1}, []uint32{2, 0, 1}},
+		{[]byte{0x88, 0x37, 3}, []uint32{2, 999, 3}}, // 2*40+999 = 1079 needs two bytes
+		{[]byte{0x2b, 0x8f, 0xff, 0xff, 0xff, 0x7f}, []uint32{1, 3, 0xffffffff}},
+		{[]byte{0x2b, 0xbf, 0x08}, []uint32{1, 3, 8072}},
+	} {
+		oid, err := decodeOID(tt.raw)
+		if err != nil || !reflect.DeepEqual(oid, tt.oid) {
+			t.Errorf("decodeOID(%x) = %v, %v, want %v", tt.raw, oid, err, tt.oid)
+		}
+		if raw := encodeOID(tt.oid); !bytes.Equal(raw, tt.raw) {
+			t.Errorf("encodeOID(%v) = %x, want %x", tt.oid, raw, tt.raw)
+		}
+	}
+	for _, raw := range [][]byte{
+		nil,
+		{0x2b, 0x86},                         // truncated
+		{0x2b, 0x80, 0x01},                   // leading zero
+		{0x2b, 0x90, 0x80, 0x80, 0x80, 0x00}, // 2^32
+		{0x2b, 0x81, 0x80, 0x80, 0x80, 0x80, 0x00},
+	} {
+		if oid, err := decodeOID(raw); err == nil {
+			t.Errorf("decodeOID(%x) = %v, want an error", raw, oid)
+		}
+	}
+}
+
+func FuzzSNMPHandle(f *testing.F) {
+	a := &SNMPAgent{Community: "public", Metrics: NewMetrics()}
+	vars, err := a.variables()
+	if err != nil {
+		f.Fatal(err)
+	}
+	base, _ := parseOID(DefaultSNMPBaseOID)
+	f.Add(snmpRequest(snmpV2c, "public", pduGet, 0, 0, base))
+	f.Add(snmpRequest(snmpV1, "public", pduGetNext, 0, 0, base))
+	f.Add(snmpRequest(snmpV2c, "public", pduGetBulk, 1, 64, base, base))
+	f.Add(snmpRequest(snmpV2c, "public", pduSet, 0, 0, base))
+	f.Fuzz(func(t *testing.T, b []byte) {
+		reply, err := a.handle(vars, b)
+		if err != nil {
+			return
+		}
+		if len(reply) > snmpMaxMessageSize {
+			t.Fatalf("response of %d bytes", len(reply))
+		}
+		parseSNMPResponse(t, reply)
+	})
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Stats is a snapshot of the server state for diagnostics.
+type Stats struct {
+	Time            time.Time        `json:"time"`
+	Metrics         MetricsSnapshot  `json:"metrics"`
+	ActiveTransfers []TransferStatus `json:"active_transfers"`
+	TopFiles        []FileStat       `json:"top_files"`
+}
+
+func (s *TFTPServer) Stats() Stats {
+	return Stats{
+		Time:            time.Now(),
+		Metrics:         s.Metrics.Snapshot(),
+		ActiveTransfers: s.ActiveTransfers(),
+		TopFiles:        s.Metrics.TopFiles(10, false),
+	}
+}
+
+// String formats the snapshot as a human readable multi-line report.
+func (st Stats) String() string {
+	b := new(strings.Builder)
+	m := st.Metrics
+	fmt.Fprintf(b, "active transfers: %d\n", m.ActiveTransfers)
+	fmt.Fprintf(b, "finished transfers: %s\n", formatCounts(m.Transfers))
+	fmt.Fprintf(b, "bytes sent: %d, retransmits: %d, timeouts: %d, parse failures: %d\n", m.BytesSent, m.Retransmits, m.Timeouts, m.ParseFailures)
+	fmt.Fprintf(b, "errors sent: %s, received: %s\n", formatCounts(m.ErrorsSent), formatCounts(m.ErrorsReceived))
+
+	fmt.Fprintf(b, "sessions:\n")
+	if len(st.ActiveTransfers) == 0 {
+		fmt.Fprintf(b, "  (none)\n")
+	}
+	for _, t := range st.ActiveTransfers {
+		fmt.Fprintf(b, "  %s %s %s %q %d bytes in %.1fs (%.0f B/s), %d retransmits\n",
+			t.ID, t.Client, t.Direction, t.Filename, t.Bytes, t.Elapsed, t.Throughput, t.Retransmits)
+	}
+
+	fmt.Fprintf(b, "top files:\n")
+	if len(st.TopFiles) == 0 {
+		fmt.Fprintf(b, "  (none)\n")
+	}
+	for _, f := range st.TopFiles {
+		fmt.Fprintf(b, "  %q %d requests, %d bytes\n", f.Filename, f.Requests, f.Bytes)
+	}
+	return b.String()
+}
+
+func formatCounts(counts map[string]int64) string {
+	if len(counts) == 0 {
+		return "none"
+	}
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s=%d", k, counts[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+// LogStats writes a human readable and a JSON stats snapshot to the logger.
+func (s *TFTPServer) LogStats() {
+	st := s.Stats()
+	b, err := json.Marshal(st)
+	if err != nil {
+		s.Logger.Error("encoding stats", "error", err)
+		return
+	}
+	s.Logger.Info("stats snapshot", "report", st.String(), "json", string(b))
+}
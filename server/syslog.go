@@ -0,0 +1,79 @@
+//go:build !windows && !plan9
+
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"log/syslog"
+	"strings"
+)
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+// NewSyslogSink connects to syslog and returns a LogSink writing to it.
+// addr is either "local" for the local syslog daemon or a "network://host:port"
+// URL (e.g. "udp://10.0.0.1:514"), facility is a name like "daemon" or "local0".
+func NewSyslogSink(addr, facility, tag string) (LogSink, error) {
+	f, ok := syslogFacilities[strings.ToLower(facility)]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility: %q", facility)
+	}
+
+	var network, raddr string
+	if addr != "local" {
+		parts := strings.SplitN(addr, "://", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid syslog address %q, expected local or network://host:port", addr)
+		}
+		network, raddr = parts[0], parts[1]
+	}
+
+	w, err := syslog.Dial(network, raddr, f|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) WriteEvent(e *LogEvent) error {
+	buf := new(bytes.Buffer)
+	appendText(buf, e.Level, e.Message, e.Fields)
+	line := strings.TrimSuffix(buf.String(), "\n")
+
+	switch e.Level {
+	case LevelDebug:
+		return s.w.Debug(line)
+	case LevelInfo:
+		return s.w.Info(line)
+	case LevelWarn:
+		return s.w.Warning(line)
+	default:
+		return s.w.Err(line)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build windows || plan9
+
+package server
+
+import "errors"
+
+// NewSyslogSink is not supported on this platform.
+func NewSyslogSink(addr, facility, tag string) (LogSink, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
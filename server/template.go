@@ -0,0 +1,66 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// TemplateFile renders the requests whose filename matches Pattern with a
+// Go text/template executed with TemplateVars, for serving per-device files
+// without generating them in advance.
+type TemplateFile struct {
+	Pattern  string // path.Match pattern of the rendered filenames, e.g. "*.ipxe" or "cfg/*.conf"
+	Template *template.Template
+}
+
+// TemplateVars are the request attributes available to TemplateFile templates.
+type TemplateVars struct {
+	Filename string            // as requested, without leading slash
+	ClientIP string            // IP address of the client
+	MAC      string            // first MAC address found in the filename (aa:bb:cc:dd:ee:ff), if any
+	Arch     string            // first directory of the filename (e.g. x86_64 in x86_64/boot.ipxe), if any
+	Options  map[string]string // options of the request (RFC 2347 names, or the query of HTTP requests)
+	Vars     map[string]string // variables of the client in the Inventory
+}
+
+var macInName = regexp.MustCompile(`[0-9a-fA-F]{2}([:-][0-9a-fA-F]{2}){5}`)
+
+// ParseTemplateFile parses the template file name, executing it with a
+// missing key is an error.
+func ParseTemplateFile(name string) (*template.Template, error) {
+	return template.New(path.Base(name)).Option("missingkey=error").ParseFiles(name)
+}
+
+func (f *TemplateFile) matches(filename string) bool {
+	ok, _ := path.Match(f.Pattern, strings.TrimLeft(filename, "/"))
+	return ok
+}
+
+// render executes the template for a request of client for filename.
+func (f *TemplateFile) render(client net.Addr, filename string, options, inventory map[string]string) ([]byte, error) {
+	name := strings.TrimLeft(filename, "/")
+	vars := TemplateVars{Filename: name, ClientIP: clientHost(client), Options: options, Vars: inventory}
+	if m := macInName.FindString(name); m != "" {
+		if mac, err := net.ParseMAC(strings.ReplaceAll(m, "-", ":")); err == nil {
+			vars.MAC = mac.String()
+		}
+	}
+	if dir := path.Dir(name); dir != "." {
+		vars.Arch = strings.SplitN(dir, "/", 2)[0]
+	}
+	if vars.Options == nil {
+		vars.Options = map[string]string{}
+	}
+	if vars.Vars == nil {
+		vars.Vars = map[string]string{}
+	}
+	var buf bytes.Buffer
+	if err := f.Template.Execute(&buf, vars); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
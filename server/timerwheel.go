@@ -0,0 +1,153 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// The retransmission timeouts of the transfers are scheduled on a
+// hierarchical timer wheel rather than with a read deadline per block: with
+// thousands of concurrent transfers, scheduling and cancelling a timeout is
+// O(1) and allocation-free, and only the timeouts that expire touch the
+// sockets. Level 0 has a slot per tick, each level above a slot per round
+// of the level below it.
+const (
+	wheelBits   = 6
+	wheelSlots  = 1 << wheelBits
+	wheelLevels = 4 // 64^4 ticks, over 46 hours of 10ms ticks
+	wheelTick   = 10 * time.Millisecond
+)
+
+// wheelTimer is a timer of a timerWheel, linked in the slot it waits in.
+type wheelTimer struct {
+	expires    uint64 // tick
+	prev, next *wheelTimer
+	fire       func() // called with the wheel locked, it must not block
+}
+
+type timerWheel struct {
+	mu    sync.Mutex
+	tick  time.Duration
+	start time.Time
+	now   uint64                              // ticks since start
+	slots [wheelLevels][wheelSlots]wheelTimer // list heads
+}
+
+func newTimerWheel(tick time.Duration) *timerWheel {
+	w := &timerWheel{tick: tick, start: time.Now()}
+	for l := range w.slots {
+		for i := range w.slots[l] {
+			head := &w.slots[l][i]
+			head.prev, head.next = head, head
+		}
+	}
+	return w
+}
+
+// run advances the wheel until stop is closed.
+func (w *timerWheel) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			w.advance(uint64(now.Sub(w.start) / w.tick))
+		case <-stop:
+			return
+		}
+	}
+}
+
+// schedule fires t after d, rounded up to a tick and one more for the
+// current tick already started (a d of 0 fires on the next tick),
+// cancelling its pending expiry if any.
+func (w *timerWheel) schedule(t *wheelTimer, d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if t.next != nil {
+		t.unlink()
+	}
+	ticks := uint64(1)
+	if d > 0 {
+		ticks += uint64((d + w.tick - 1) / w.tick)
+	}
+	t.expires = w.now + ticks
+	w.insert(t)
+}
+
+// stop cancels t, it reports false if t has fired (or was never scheduled).
+func (w *timerWheel) stop(t *wheelTimer) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if t.next == nil {
+		return false
+	}
+	t.unlink()
+	return true
+}
+
+// insert links t in the slot of the lowest level whose round covers its
+// expiry, w.mu must be held.
+func (w *timerWheel) insert(t *wheelTimer) {
+	expires := t.expires
+	if expires <= w.now {
+		expires = w.now + 1
+	}
+	delta := expires - w.now
+	level := 0
+	for level < wheelLevels-1 && delta >= 1<<(wheelBits*(level+1)) {
+		level++
+	}
+	if limit := uint64(1)<<(wheelBits*wheelLevels) - 1; delta > limit {
+		expires = w.now + limit // parked at the top level, cascaded again later
+	}
+	head := &w.slots[level][(expires>>(wheelBits*level))&(wheelSlots-1)]
+	t.prev, t.next = head.prev, head
+	head.prev.next = t
+	head.prev = t
+}
+
+func (t *wheelTimer) unlink() {
+	t.prev.next = t.next
+	t.next.prev = t.prev
+	t.prev, t.next = nil, nil
+}
+
+// advance moves the wheel to the tick now, firing the expired timers.
+func (w *timerWheel) advance(now uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.now < now {
+		w.now++
+		// entering a new round of a level: its timers now expire within a
+		// round of the level below, move them down
+		for level := 1; level < wheelLevels; level++ {
+			if w.now&(1<<(wheelBits*level)-1) != 0 {
+				break
+			}
+			w.cascade(&w.slots[level][(w.now>>(wheelBits*level))&(wheelSlots-1)])
+		}
+		head := &w.slots[0][w.now&(wheelSlots-1)]
+		for head.next != head {
+			t := head.next
+			t.unlink()
+			if t.expires > w.now {
+				w.insert(t) // parked beyond the range of the wheel
+				continue
+			}
+			t.fire()
+		}
+	}
+}
+
+func (w *timerWheel) cascade(head *wheelTimer) {
+	for head.next != head {
+		t := head.next
+		t.unlink()
+		if t.expires <= w.now {
+			t.fire() // due at the start of the round
+			continue
+		}
+		w.insert(t)
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracer creates a span per transfer. It is deliberately small so it can be
+// backed by OpenTelemetry (or any other tracing system) with a thin adapter,
+// attributes are alternating key/value pairs like the Logger fields.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...interface{}) (context.Context, Span)
+}
+
+// Span is a single traced operation.
+type Span interface {
+	AddEvent(name string, attrs ...interface{})
+	SetAttributes(attrs ...interface{})
+	End(err error)
+}
+
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, name string, attrs ...interface{}) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) AddEvent(name string, attrs ...interface{}) {}
+func (noopSpan) SetAttributes(attrs ...interface{})         {}
+func (noopSpan) End(err error)                              {}
+
+type traceParentKey struct{}
+
+// WithTraceParent returns a copy of ctx carrying a W3C traceparent header value.
+func WithTraceParent(ctx context.Context, traceparent string) context.Context {
+	return context.WithValue(ctx, traceParentKey{}, traceparent)
+}
+
+// TraceParentFromContext returns the W3C traceparent carried by ctx (if any),
+// Tracer implementations use it to parent transfer spans to a remote trace.
+func TraceParentFromContext(ctx context.Context) (string, bool) {
+	tp, ok := ctx.Value(traceParentKey{}).(string)
+	return tp, ok
+}
+
+// ValidTraceParent reports whether s is a version 00 W3C traceparent:
+// 00-<32 hex trace id>-<16 hex parent id>-<2 hex flags>.
+func ValidTraceParent(s string) bool {
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return false
+	}
+	for _, p := range parts[1:] {
+		if _, err := hex.DecodeString(p); err != nil {
+			return false
+		}
+	}
+	return strings.Trim(parts[1], "0") != "" && strings.Trim(parts[2], "0") != ""
+}
+
+// traceParents remembers the remote trace context announced for client addresses,
+// so the transfers of a booting machine join the provisioning pipeline trace.
+type traceParents struct {
+	mu sync.Mutex
+	m  map[string]traceParent
+}
+
+type traceParent struct {
+	value   string
+	expires time.Time
+}
+
+func (t *traceParents) set(ip, traceparent string, ttl time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.m == nil {
+		t.m = make(map[string]traceParent)
+	}
+	now := time.Now()
+	for k, v := range t.m {
+		if now.After(v.expires) {
+			delete(t.m, k)
+		}
+	}
+	t.m[ip] = traceParent{value: traceparent, expires: now.Add(ttl)}
+}
+
+func (t *traceParents) get(ip string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tp, ok := t.m[ip]
+	if !ok || time.Now().After(tp.expires) {
+		return "", false
+	}
+	return tp.value, true
+}
+
+// SetTraceParent announces that transfers from the client ip during the next ttl
+// belong to the remote trace identified by the W3C traceparent value.
+func (s *TFTPServer) SetTraceParent(ip, traceparent string, ttl time.Duration) error {
+	if !ValidTraceParent(traceparent) {
+		return fmt.Errorf("invalid traceparent: %q", traceparent)
+	}
+	s.traceParents.set(ip, traceparent, ttl)
+	return nil
+}
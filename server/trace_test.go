@@ -0,0 +1,101 @@
+package server_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/OmarTariq612/tftp-server/client"
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+// fakeTracer records the spans it starts.
+type fakeTracer struct {
+	mu    sync.Mutex
+	spans []*fakeSpan
+}
+
+type fakeSpan struct {
+	mu          sync.Mutex
+	traceparent string
+	events      []string
+	ended       chan struct{}
+}
+
+func (t *fakeTracer) Start(ctx context.Context, name string, attrs ...interface{}) (context.Context, server.Span) {
+	span := &fakeSpan{ended: make(chan struct{})}
+	span.traceparent, _ = server.TraceParentFromContext(ctx)
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	t.mu.Unlock()
+	return ctx, span
+}
+
+func (s *fakeSpan) AddEvent(name string, attrs ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, name)
+}
+
+func (s *fakeSpan) SetAttributes(attrs ...interface{}) {}
+
+func (s *fakeSpan) End(err error) { close(s.ended) }
+
+func TestTraceParent(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	tracer := &fakeTracer{}
+	var admin http.Handler
+	addr := startServer(t, func(s *server.TFTPServer) {
+		s.FS = fstest.MapFS{"pxelinux.0": {Data: []byte("boot")}}
+		s.Tracer = tracer
+		s.AdminToken = "secret"
+		admin = s.AdminHandler()
+	})
+
+	for _, tt := range []struct {
+		query string
+		code  int
+	}{
+		{"ip=127.0.0.1&traceparent=invalid", http.StatusBadRequest},
+		{"ip=nowhere&traceparent=" + traceparent, http.StatusBadRequest},
+		{"ip=127.0.0.1&traceparent=" + traceparent + "&ttl=-1s", http.StatusBadRequest},
+		{"ip=127.0.0.1&traceparent=" + traceparent, http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/trace?"+tt.query, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, req)
+		if rec.Code != tt.code {
+			t.Errorf("POST /trace?%s: status %d, want %d", tt.query, rec.Code, tt.code)
+		}
+	}
+
+	// the client asks for tsize, the server answers with an OACK
+	if err := (&client.Client{}).Get(context.Background(), addr, "pxelinux.0", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	tracer.mu.Lock()
+	spans := tracer.spans
+	tracer.mu.Unlock()
+	if len(spans) != 1 {
+		t.Fatalf("%d spans, want 1", len(spans))
+	}
+	span := spans[0]
+	select {
+	case <-span.ended:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the span didn't end")
+	}
+	if span.traceparent != traceparent {
+		t.Errorf("traceparent %q, want %q", span.traceparent, traceparent)
+	}
+	if events := strings.Join(span.events, ","); events != "oack,complete" {
+		t.Errorf("events %s, want oack,complete", events)
+	}
+}
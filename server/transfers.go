@@ -0,0 +1,129 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// transfer is the progress of an in-flight transfer, the counters are updated
+// by the transfer goroutine and read concurrently by the admin API.
+type transfer struct {
+	bytes       int64
+	blocks      int64
+	retransmits int64
+	timeouts    int64
+
+	TransferInfo
+	cancel func()
+}
+
+func (t *transfer) Status() TransferStatus {
+	elapsed := time.Since(t.Start)
+	status := TransferStatus{
+		ID:          t.ID,
+		Client:      t.Client.String(),
+		Filename:    t.Filename,
+		Direction:   t.Direction,
+		Start:       t.Start,
+		Elapsed:     elapsed.Seconds(),
+		Bytes:       atomic.LoadInt64(&t.bytes),
+		Blocks:      atomic.LoadInt64(&t.blocks),
+		Retransmits: atomic.LoadInt64(&t.retransmits),
+		Timeouts:    atomic.LoadInt64(&t.timeouts),
+	}
+	if elapsed > 0 {
+		status.Throughput = float64(status.Bytes) / elapsed.Seconds()
+	}
+	return status
+}
+
+// TransferStatus is a snapshot of an in-flight transfer.
+type TransferStatus struct {
+	ID          string    `json:"id"`
+	Client      string    `json:"client"`
+	Filename    string    `json:"filename"`
+	Direction   Direction `json:"direction"`
+	Start       time.Time `json:"start"`
+	Elapsed     float64   `json:"elapsed_seconds"`
+	Bytes       int64     `json:"bytes"`
+	Blocks      int64     `json:"blocks"`
+	Retransmits int64     `json:"retransmits"`
+	Timeouts    int64     `json:"timeouts"`
+	Throughput  float64   `json:"throughput_bytes_per_second"`
+}
+
+// transferRegistry tracks the in-flight transfers by ID. It is sharded so
+// that the transfers starting and ending at once, by the thousand during a
+// boot storm, don't contend on a single lock.
+type transferRegistry struct {
+	shards [registryShards]registryShard
+}
+
+const registryShards = 64
+
+type registryShard struct {
+	mu sync.RWMutex
+	m  map[string]*transfer
+}
+
+func (r *transferRegistry) shard(id string) *registryShard {
+	h := uint32(2166136261) // FNV-1a
+	for i := 0; i < len(id); i++ {
+		h ^= uint32(id[i])
+		h *= 16777619
+	}
+	return &r.shards[h%registryShards]
+}
+
+func (r *transferRegistry) add(t *transfer) {
+	sh := r.shard(t.ID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sh.m == nil {
+		sh.m = make(map[string]*transfer)
+	}
+	sh.m[t.ID] = t
+}
+
+func (r *transferRegistry) remove(t *transfer) {
+	sh := r.shard(t.ID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	delete(sh.m, t.ID)
+}
+
+func (r *transferRegistry) get(id string) (*transfer, bool) {
+	sh := r.shard(id)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	t, ok := sh.m[id]
+	return t, ok
+}
+
+// ActiveTransfers returns the status of the in-flight transfers, oldest first.
+func (s *TFTPServer) ActiveTransfers() []TransferStatus {
+	statuses := make([]TransferStatus, 0)
+	for i := range s.transfers.shards {
+		sh := &s.transfers.shards[i]
+		sh.mu.RLock()
+		for _, t := range sh.m {
+			statuses = append(statuses, t.Status())
+		}
+		sh.mu.RUnlock()
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Start.Before(statuses[j].Start) })
+	return statuses
+}
+
+// CancelTransfer aborts the in-flight transfer with the given ID, the client
+// receives an ERROR packet. It reports whether such a transfer was found.
+func (s *TFTPServer) CancelTransfer(id string) bool {
+	t, ok := s.transfers.get(id)
+	if ok {
+		t.cancel()
+	}
+	return ok
+}
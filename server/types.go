@@ -17,130 +17,189 @@ const (
 	DataOp           Opcode = 3
 	AcknowledgmentOp Opcode = 4
 	ErrorOp          Opcode = 5
+	OptionAckOp      Opcode = 6 // RFC 2347
 )
 
 type ReadWriteRequest struct {
+	Op       Opcode // ReadOp or WriteOp, ReadOp if 0
 	Filename string
 	Mode     string
+	Options  map[string]string // option names are lowercase (RFC 2347)
 }
 
 func (r ReadWriteRequest) MarshalBinary() ([]byte, error) {
-	var mode string
-	if r.Mode != "" {
-		mode = r.Mode
-	} else {
+	return r.AppendBinary(nil)
+}
+
+// AppendBinary appends the request to b, like the other wire types it lets
+// the hot paths reuse a datagram buffer instead of allocating packets.
+func (r ReadWriteRequest) AppendBinary(b []byte) ([]byte, error) {
+	mode := r.Mode
+	if mode == "" {
 		mode = "octet"
 	}
+	op := r.Op
+	if op == 0 {
+		op = ReadOp
+	}
 
-	buf := new(bytes.Buffer)
-	buf.Grow(6 + len(r.Filename) + len(mode)) // 2 (OpCode) + n (len(Filename)) + 1-byte (0) + m (len(mode)) + 1-byte (0)
+	b = appendUint16(b, uint16(op))
+	b = append(append(b, r.Filename...), 0)
+	b = append(append(b, mode...), 0)
+	return appendOptions(b, r.Options), nil
+}
 
-	err := binary.Write(buf, binary.BigEndian, ReadOp)
-	if err != nil {
-		return nil, err
-	}
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
 
-	_, err = buf.WriteString(r.Filename)
-	if err != nil {
-		return nil, err
+// appendOptions appends the name and value pairs of options (RFC 2347) to b.
+func appendOptions(b []byte, options map[string]string) []byte {
+	for name, value := range options {
+		b = append(append(b, name...), 0)
+		b = append(append(b, value...), 0)
 	}
+	return b
+}
 
-	err = buf.WriteByte(0)
-	if err != nil {
-		return nil, err
-	}
+// The limits of the request fields, UnmarshalBinary refuses the requests
+// over them with a *LimitError before allocating anything for the field, so
+// that crafted packets can't make the server allocate or log much.
+const (
+	MaxRequestSize    = 512 // of a whole request (RFC 2347)
+	MaxFilenameLength = 255
+	MaxModeLength     = 8  // "netascii"
+	MaxOptionLength   = 64 // of the option names and values
+	MaxOptions        = 16
+)
 
-	_, err = buf.WriteString(mode)
-	if err != nil {
-		return nil, err
-	}
+// LimitError reports a request field over its limit, see MaxRequestSize.
+type LimitError struct {
+	Field string // request, filename, mode, option name, option value or options (their number)
+	Size  int
+	Limit int
+}
 
-	err = buf.WriteByte(0)
-	if err != nil {
-		return nil, err
-	}
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s over the limit: %d > %d", e.Field, e.Size, e.Limit)
+}
+
+// ModeError reports a well formed request for a transfer mode other than
+// octet, netascii for instance: the server doesn't support it.
+type ModeError struct {
+	Mode string
+}
 
-	return buf.Bytes(), nil
+func (e *ModeError) Error() string {
+	return fmt.Sprintf("unsupported transfer mode %q, binary (octet) is the only supported one", e.Mode)
 }
 
 func (r *ReadWriteRequest) UnmarshalBinary(buf []byte) error {
-	reader := bytes.NewBuffer(buf)
-	var code Opcode
-	err := binary.Read(reader, binary.BigEndian, &code)
+	code, err := opcode(buf)
 	if err != nil {
 		return err
 	}
 	if code != ReadOp && code != WriteOp {
 		return fmt.Errorf("invalid Read/Write request")
 	}
+	if len(buf) > MaxRequestSize {
+		return &LimitError{Field: "request", Size: len(buf), Limit: MaxRequestSize}
+	}
+	r.Op = code
 
-	r.Filename, err = reader.ReadString(0)
+	filename, rest, err := field(buf[2:], "filename", MaxFilenameLength)
 	if err != nil {
-		return fmt.Errorf("invalid Read/Write request")
+		return err
 	}
-	r.Filename = strings.TrimRight(r.Filename, "\x00")
-	if len(r.Filename) == 0 {
+	if len(filename) == 0 {
 		return fmt.Errorf("invalid Read/Write request")
 	}
-
-	r.Mode, err = reader.ReadString(0)
+	mode, rest, err := field(rest, "mode", MaxModeLength)
 	if err != nil {
-		return fmt.Errorf("invalid Read/Write request")
+		return err
 	}
-	r.Mode = strings.TrimRight(r.Mode, "\x00")
-	r.Mode = strings.ToLower(r.Mode)
+	r.Filename = string(filename)
+	r.Mode = strings.ToLower(string(mode))
 	if r.Mode != "octet" {
-		return fmt.Errorf("binary (octet) is the only supported transfer")
+		return &ModeError{Mode: r.Mode}
 	}
 
-	return nil
+	r.Options, err = parseOptions(rest)
+	return err
 }
 
-type Data struct {
-	BlockNum uint16
-	Payload  io.Reader
+// field returns the zero terminated field at the start of buf, without the
+// zero, and the rest of buf. It fails if the field is longer than limit.
+func field(buf []byte, name string, limit int) (value, rest []byte, err error) {
+	i := bytes.IndexByte(buf, 0)
+	if i < 0 {
+		if len(buf) > limit {
+			return nil, nil, &LimitError{Field: name, Size: len(buf), Limit: limit}
+		}
+		return nil, nil, fmt.Errorf("invalid Read/Write request: unterminated %s", name)
+	}
+	if i > limit {
+		return nil, nil, &LimitError{Field: name, Size: i, Limit: limit}
+	}
+	return buf[:i], buf[i+1:], nil
 }
 
-func (d *Data) MarshalBinary() ([]byte, error) {
-	buf := new(bytes.Buffer)
-	buf.Grow(DatagramSize)
-
-	err := binary.Write(buf, binary.BigEndian, DataOp)
-	if err != nil {
-		return nil, err
+// parseOptions decodes the option name and value pairs of buf (RFC 2347),
+// nil if there are none. It stops at an unterminated or empty name: the
+// end of the packet, which may be zero padded.
+func parseOptions(buf []byte) (map[string]string, error) {
+	var options map[string]string
+	for {
+		name, rest, err := field(buf, "option name", MaxOptionLength)
+		if _, ok := err.(*LimitError); ok {
+			return nil, err
+		}
+		if err != nil || len(name) == 0 {
+			return options, nil
+		}
+		value, rest, err := field(rest, "option value", MaxOptionLength)
+		if _, ok := err.(*LimitError); ok {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid option %q", name)
+		}
+		if len(options) == MaxOptions {
+			return nil, &LimitError{Field: "options", Size: len(options) + 1, Limit: MaxOptions}
+		}
+		if options == nil {
+			options = make(map[string]string)
+		}
+		options[strings.ToLower(string(name))] = string(value)
+		buf = rest
 	}
+}
 
-	d.BlockNum++
-	err = binary.Write(buf, binary.BigEndian, d.BlockNum)
-	if err != nil {
-		return nil, err
-	}
+// Data is the DATA packet carrying the block BlockNum of a file, Payload
+// is shorter than the block size for the last one. Marshaling it has no
+// side effect, a retransmission marshals the same packet again.
+type Data struct {
+	BlockNum uint16
+	Payload  []byte
+}
 
-	_, err = io.CopyN(buf, d.Payload, BlockSize)
-	if err != nil && err != io.EOF {
-		return nil, err
-	}
+func (d Data) MarshalBinary() ([]byte, error) {
+	return d.AppendBinary(make([]byte, 0, 4+len(d.Payload)))
+}
 
-	return buf.Bytes(), nil
+func (d Data) AppendBinary(b []byte) ([]byte, error) {
+	b = appendUint16(b, uint16(DataOp))
+	b = appendUint16(b, d.BlockNum)
+	return append(b, d.Payload...), nil
 }
 
+// UnmarshalBinary decodes the DATA packet buf, Payload refers to it.
 func (d *Data) UnmarshalBinary(buf []byte) error {
-	var code Opcode
-	err := binary.Read(bytes.NewReader(buf[:2]), binary.BigEndian, &code)
-	if err != nil {
-		return err
-	}
-	if code != DataOp {
+	if len(buf) < 4 || Opcode(binary.BigEndian.Uint16(buf)) != DataOp {
 		return fmt.Errorf("invalid Data")
 	}
-
-	err = binary.Read(bytes.NewReader(buf[2:4]), binary.BigEndian, &d.BlockNum)
-	if err != nil {
-		return err
-	}
-
-	d.Payload = bytes.NewBuffer(buf[4:])
-
+	d.BlockNum = binary.BigEndian.Uint16(buf[2:4])
+	d.Payload = buf[4:]
 	return nil
 }
 
@@ -149,34 +208,55 @@ type Acknowledgment struct {
 }
 
 func (a Acknowledgment) MarshalBinary() ([]byte, error) {
-	b := new(bytes.Buffer)
-	b.Grow(4) // 2 (Opcode) + 2 (BlockNum)
+	return a.AppendBinary(make([]byte, 0, 4)) // 2 (Opcode) + 2 (BlockNum)
+}
 
-	err := binary.Write(b, binary.BigEndian, AcknowledgmentOp)
-	if err != nil {
-		return nil, err
-	}
+func (a Acknowledgment) AppendBinary(b []byte) ([]byte, error) {
+	b = appendUint16(b, uint16(AcknowledgmentOp))
+	return appendUint16(b, a.BlockNum), nil
+}
 
-	err = binary.Write(b, binary.BigEndian, a.BlockNum)
-	if err != nil {
-		return nil, err
+// UnmarshalBinary decodes the ACK packet buf without allocating, it is
+// called for every block sent.
+func (a *Acknowledgment) UnmarshalBinary(buf []byte) error {
+	if len(buf) < 4 || Opcode(binary.BigEndian.Uint16(buf)) != AcknowledgmentOp {
+		return fmt.Errorf("invalid Acknowledgment")
 	}
+	a.BlockNum = binary.BigEndian.Uint16(buf[2:4])
+	return nil
+}
 
-	return b.Bytes(), nil
+// OptionAcknowledgment is the OACK answer to a request with options, it
+// holds the options accepted by the server (RFC 2347).
+type OptionAcknowledgment struct {
+	Options map[string]string
 }
 
-func (a *Acknowledgment) UnmarshalBinary(buf []byte) error {
-	reader := bytes.NewReader(buf)
-	var code Opcode
-	err := binary.Read(reader, binary.BigEndian, &code)
+func (o OptionAcknowledgment) MarshalBinary() ([]byte, error) {
+	return o.AppendBinary(nil)
+}
+
+func (o OptionAcknowledgment) AppendBinary(b []byte) ([]byte, error) {
+	b = appendUint16(b, uint16(OptionAckOp))
+	return appendOptions(b, o.Options), nil
+}
+
+func (o *OptionAcknowledgment) UnmarshalBinary(buf []byte) error {
+	code, err := opcode(buf)
 	if err != nil {
 		return err
 	}
-	if code != AcknowledgmentOp {
-		return fmt.Errorf("invalid Acknowledgment")
+	if code != OptionAckOp {
+		return fmt.Errorf("invalid Option Acknowledgment")
 	}
-
-	return binary.Read(reader, binary.BigEndian, &a.BlockNum)
+	o.Options, err = parseOptions(buf[2:])
+	if err != nil {
+		return err
+	}
+	if o.Options == nil {
+		o.Options = make(map[string]string)
+	}
+	return nil
 }
 
 type ErrCode uint16
@@ -190,6 +270,7 @@ const (
 	ErrUnknownID       ErrCode = 5
 	ErrFileExists      ErrCode = 6
 	ErrNoUser          ErrCode = 7
+	ErrOptions         ErrCode = 8 // option negotiation failed (RFC 2347)
 )
 
 type Err struct {
@@ -197,56 +278,53 @@ type Err struct {
 	Message string
 }
 
-func (e Err) MarshalBinary() ([]byte, error) {
-	b := new(bytes.Buffer)
-	b.Grow(5 + len(e.Message)) // 2 (OpCode) + 2 (ErrCode) + n (Message) + 1-byte (0)
-
-	err := binary.Write(b, binary.BigEndian, ErrorOp)
-	if err != nil {
-		return nil, err
-	}
-
-	err = binary.Write(b, binary.BigEndian, e.Code)
-	if err != nil {
-		return nil, err
-	}
-
-	_, err = b.WriteString(e.Message)
-	if err != nil {
-		return nil, err
-	}
+func (e Err) Error() string {
+	return fmt.Sprintf("tftp error %d: %s", e.Code, e.Message)
+}
 
-	err = b.WriteByte(0)
-	if err != nil {
-		return nil, err
-	}
+func (e Err) MarshalBinary() ([]byte, error) {
+	return e.AppendBinary(make([]byte, 0, 5+len(e.Message))) // 2 (OpCode) + 2 (ErrCode) + n (Message) + 1-byte (0)
+}
 
-	return b.Bytes(), nil
+func (e Err) AppendBinary(b []byte) ([]byte, error) {
+	b = appendUint16(b, uint16(ErrorOp))
+	b = appendUint16(b, uint16(e.Code))
+	return append(append(b, e.Message...), 0), nil
 }
 
 func (e *Err) UnmarshalBinary(buf []byte) error {
-	reader := bytes.NewBuffer(buf)
-	var code Opcode
-	err := binary.Read(reader, binary.BigEndian, &code)
+	code, err := opcode(buf)
 	if err != nil {
 		return err
 	}
 	if code != ErrorOp {
 		return fmt.Errorf("invalid Error")
 	}
-
-	err = binary.Read(reader, binary.BigEndian, &e.Code)
-	if err != nil {
-		return err
+	if len(buf) < 4 {
+		return io.ErrUnexpectedEOF
 	}
+	e.Code = ErrCode(binary.BigEndian.Uint16(buf[2:4]))
 
-	e.Message, err = reader.ReadString(0)
-	e.Message = strings.TrimRight(e.Message, "\x00")
+	message := buf[4:]
+	if i := bytes.IndexByte(message, 0); i >= 0 {
+		message = message[:i]
+	} else {
+		err = io.EOF // not terminated, the message is kept anyway
+	}
+	e.Message = string(message)
 
 	return err
 }
 
+// opcode returns the opcode of the packet buf.
+func opcode(buf []byte) (Opcode, error) {
+	if len(buf) < 2 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return Opcode(binary.BigEndian.Uint16(buf)), nil
+}
+
 var (
-	_ []encoding.BinaryMarshaler   = []encoding.BinaryMarshaler{ReadWriteRequest{}, &Data{}, Acknowledgment{}, Err{}}
-	_ []encoding.BinaryUnmarshaler = []encoding.BinaryUnmarshaler{&ReadWriteRequest{}, &Data{}, &Acknowledgment{}, &Err{}}
+	_ []encoding.BinaryMarshaler   = []encoding.BinaryMarshaler{ReadWriteRequest{}, &Data{}, Acknowledgment{}, OptionAcknowledgment{}, Err{}}
+	_ []encoding.BinaryUnmarshaler = []encoding.BinaryUnmarshaler{&ReadWriteRequest{}, &Data{}, &Acknowledgment{}, &OptionAcknowledgment{}, &Err{}}
 )
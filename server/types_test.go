@@ -0,0 +1,197 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// request builds a request packet of opcode from its zero terminated fields.
+func request(op Opcode, fields ...string) []byte {
+	b := appendUint16(nil, uint16(op))
+	for _, f := range fields {
+		b = append(append(b, f...), 0)
+	}
+	return b
+}
+
+// manyOptions returns the fields of n options.
+func manyOptions(n int) []string {
+	var fields []string
+	for i := 0; i < n; i++ {
+		fields = append(fields, "opt"+strconv.Itoa(i), "1")
+	}
+	return fields
+}
+
+func TestReadWriteRequestUnmarshalBinary(t *testing.T) {
+	long := func(n int) string { return strings.Repeat("a", n) }
+	tests := []struct {
+		name   string
+		packet []byte
+		limit  string // the Field of the LimitError expected, "" for another error
+		mode   bool   // a ModeError is expected
+		ok     bool
+	}{
+		{name: "empty", packet: nil},
+		{name: "truncated opcode", packet: []byte{0}},
+		{name: "opcode only", packet: request(ReadOp)},
+		{name: "not a request", packet: request(DataOp, "f", "octet")},
+		{name: "unterminated filename", packet: append(request(ReadOp), "file"...)},
+		{name: "empty filename", packet: request(ReadOp, "", "octet")},
+		{name: "missing mode", packet: request(ReadOp, "file")},
+		{name: "unterminated mode", packet: append(request(ReadOp, "file"), "oct"...)},
+		{name: "unknown mode", packet: request(ReadOp, "file", "binary"), mode: true},
+		{name: "netascii", packet: request(ReadOp, "file", "NetASCII"), mode: true},
+		{name: "option without value", packet: append(request(ReadOp, "file", "octet", "blksize"), "1024"...)},
+		{name: "oversized request", packet: request(ReadOp, "file", "octet", long(MaxRequestSize)), limit: "request"},
+		{name: "oversized filename", packet: request(ReadOp, long(MaxFilenameLength+1), "octet"), limit: "filename"},
+		{name: "oversized unterminated filename", packet: append(request(ReadOp), long(MaxFilenameLength+1)...), limit: "filename"},
+		{name: "oversized mode", packet: request(ReadOp, "file", long(MaxModeLength+1)), limit: "mode"},
+		{name: "oversized option name", packet: request(ReadOp, "file", "octet", long(MaxOptionLength+1), "1"), limit: "option name"},
+		{name: "oversized option value", packet: request(ReadOp, "file", "octet", "blksize", long(MaxOptionLength+1)), limit: "option value"},
+		{name: "too many options", packet: request(ReadOp, append([]string{"file", "octet"}, manyOptions(MaxOptions+1)...)...), limit: "options"},
+
+		{name: "read", packet: request(ReadOp, "file", "octet"), ok: true},
+		{name: "write", packet: request(WriteOp, "file", "OCTET"), ok: true},
+		{name: "options", packet: request(ReadOp, append([]string{"file", "octet"}, manyOptions(MaxOptions)...)...), ok: true},
+		{name: "zero padded", packet: append(request(ReadOp, "file", "octet", "blksize", "1024"), 0, 0, 0), ok: true},
+		{name: "longest filename", packet: request(ReadOp, long(MaxFilenameLength), "octet"), ok: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r ReadWriteRequest
+			err := r.UnmarshalBinary(tt.packet)
+			if tt.ok {
+				if err != nil {
+					t.Fatalf("UnmarshalBinary: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("UnmarshalBinary accepted %q", tt.packet)
+			}
+			if _, isMode := err.(*ModeError); isMode != tt.mode {
+				t.Errorf("UnmarshalBinary: %v, want a mode error: %v", err, tt.mode)
+			}
+			limitErr, isLimit := err.(*LimitError)
+			switch {
+			case tt.limit == "" && isLimit:
+				t.Errorf("UnmarshalBinary: %v, want an error other than a limit", err)
+			case tt.limit != "" && !isLimit:
+				t.Errorf("UnmarshalBinary: %v, want a %s limit error", err, tt.limit)
+			case tt.limit != "" && limitErr.Field != tt.limit:
+				t.Errorf("UnmarshalBinary: %v, want a %s limit error", err, tt.limit)
+			}
+		})
+	}
+}
+
+func TestReadWriteRequestOptions(t *testing.T) {
+	var r ReadWriteRequest
+	if err := r.UnmarshalBinary(request(ReadOp, "pxelinux.0", "octet", "BLKSIZE", "1428", "tsize", "0")); err != nil {
+		t.Fatal(err)
+	}
+	if r.Op != ReadOp || r.Filename != "pxelinux.0" || r.Mode != "octet" {
+		t.Errorf("got %+v", r)
+	}
+	if len(r.Options) != 2 || r.Options["blksize"] != "1428" || r.Options["tsize"] != "0" {
+		t.Errorf("options %v, want blksize=1428 and tsize=0 (names are case insensitive)", r.Options)
+	}
+}
+
+func TestPacketUnmarshalBinary(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet []byte
+		into   interface{ UnmarshalBinary([]byte) error }
+	}{
+		{"truncated DATA", []byte{0, 3, 0}, &Data{}},
+		{"DATA of another opcode", []byte{0, 4, 0, 1}, &Data{}},
+		{"truncated ACK", []byte{0, 4, 0}, &Acknowledgment{}},
+		{"truncated ERROR", []byte{0, 5, 0}, &Err{}},
+		{"unterminated ERROR", []byte{0, 5, 0, 1, 'x'}, &Err{}},
+	}
+	for _, tt := range tests {
+		if err := tt.into.UnmarshalBinary(tt.packet); err == nil {
+			t.Errorf("%s: UnmarshalBinary accepted %v", tt.name, tt.packet)
+		}
+	}
+}
+
+// The codec benchmarks decode the packets of a transfer, and encode them
+// in place like the transfers do.
+
+func BenchmarkReadWriteRequestUnmarshal(b *testing.B) {
+	packet := request(ReadOp, "pxelinux.0", "octet", "blksize", "1428", "tsize", "0", "windowsize", "16")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var r ReadWriteRequest
+		if err := r.UnmarshalBinary(packet); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkOptionAcknowledgmentUnmarshal(b *testing.B) {
+	packet, _ := OptionAcknowledgment{Options: map[string]string{"blksize": "1428", "tsize": "1048576"}}.MarshalBinary()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var o OptionAcknowledgment
+		if err := o.UnmarshalBinary(packet); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkErrUnmarshal(b *testing.B) {
+	packet, _ := Err{Code: ErrNotFound, Message: msgNotFound}.MarshalBinary()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var e Err
+		if err := e.UnmarshalBinary(packet); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkAcknowledgmentUnmarshal(b *testing.B) {
+	packet, _ := Acknowledgment{BlockNum: 42}.MarshalBinary()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var a Acknowledgment
+		if err := a.UnmarshalBinary(packet); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkAcknowledgmentBinaryRead decodes the ACKs like the codec did
+// before it read its fields with binary.BigEndian, for comparison with
+// BenchmarkAcknowledgmentUnmarshal: through an io.Reader and reflection.
+func BenchmarkAcknowledgmentBinaryRead(b *testing.B) {
+	packet, _ := Acknowledgment{BlockNum: 42}.MarshalBinary()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var op, block uint16
+		r := bytes.NewReader(packet)
+		if err := binary.Read(r, binary.BigEndian, &op); err != nil {
+			b.Fatal(err)
+		}
+		if err := binary.Read(r, binary.BigEndian, &block); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDataAppendBinary(b *testing.B) {
+	buf := make([]byte, 4+BlockSize)
+	b.ReportAllocs()
+	b.SetBytes(BlockSize)
+	for i := 0; i < b.N; i++ {
+		// the payload is read in place, behind the header
+		Data{BlockNum: uint16(i), Payload: buf[4:]}.AppendBinary(buf[:0])
+	}
+}
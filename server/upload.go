@@ -0,0 +1,400 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// upload is a WRQ transfer, set up by handle.
+type upload struct {
+	request ReadWriteRequest
+	opts    transferOptions
+	conn    net.Conn
+	logger  *Logger
+	summary *TransferSummary
+	t       *transfer
+	read    func(b []byte, deadline time.Time) (int, error) // the next datagram from the client, until deadline
+	sent    func(b []byte)
+	rto     *rtoEstimator
+}
+
+// uploadFile is the temporary file an upload is written to, an *os.File.
+type uploadFile interface {
+	io.WriteSeeker
+	Name() string
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+}
+
+// SyncPolicy tells when the uploads are flushed to stable storage, trading
+// throughput for crash safety. The zero value never syncs: a crash may
+// lose uploads the clients saw acknowledged.
+type SyncPolicy struct {
+	// Every syncs the file every Every blocks during the upload (0 for never).
+	Every int
+	// OnClose syncs the file before it replaces the destination, and the
+	// directory after, so that a complete upload survives a crash.
+	OnClose bool
+}
+
+// ParseSyncPolicy parses "never", "close" or a number of blocks N: sync
+// every N blocks and on close.
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch s {
+	case "", "never":
+		return SyncPolicy{}, nil
+	case "close":
+		return SyncPolicy{OnClose: true}, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return SyncPolicy{}, fmt.Errorf("invalid sync policy %q: never, close or a number of blocks", s)
+	}
+	return SyncPolicy{Every: n, OnClose: true}, nil
+}
+
+// uploadPath returns the file of UploadDir an upload of filename is stored as.
+func (s *TFTPServer) uploadPath(filename string) string {
+	name := path.Clean("/" + strings.TrimLeft(filename, "/"))
+	return filepath.Join(s.UploadDir, filepath.FromSlash(name))
+}
+
+// receive stores the upload u in UploadDir. Every DATA block is written to
+// a temporary file next to the destination as soon as it arrives, so that
+// uploads of any size never sit in memory, and the temporary file replaces
+// the destination once the last block is received. It is removed if the
+// upload fails.
+func (s *TFTPServer) receive(ctx context.Context, u *upload) {
+	dest := s.uploadPath(u.request.Filename)
+	if info, err := os.Lstat(dest); err == nil && (!s.UploadOverwrite || info.IsDir()) {
+		u.logger.Info("upload refused, the file exists", "file", dest)
+		s.sendError(u.conn, ErrFileExists, msgFileExists)
+		u.summary.Err = os.ErrExist
+		return
+	}
+	if escapes(s.UploadDir, existingAncestor(filepath.Dir(dest))) {
+		s.securityEvent(u.logger, SecurityPathTraversal, u.summary.Client, u.request.Filename, "symbolic link escapes the upload directory")
+		s.sendError(u.conn, ErrAccessViolation, msgAccess)
+		u.summary.Err = os.ErrPermission
+		return
+	}
+	temp, err := createUpload(dest)
+	if err != nil {
+		s.uploadFailed(u, "creating upload", dest, err)
+		return
+	}
+	var f uploadFile = temp
+	if s.wrapUpload != nil {
+		f = s.wrapUpload(temp)
+	}
+	defer func() {
+		if u.summary.Result != ResultOK {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	var (
+		ackM   Acknowledgment // of the last block received
+		ackBuf [4]byte
+		dataM  Data
+		errM   Err
+		reply  = getDatagram()
+		buf    = reply[:]
+		last   bool
+		oack   []byte // sent instead of ACK 0 if options were negotiated
+		// With a window (RFC 7440) the client sends up to window blocks
+		// per ACK: the blocks received since the last ACK, and whether
+		// an ACK was sent for the current gap in the blocks.
+		window   = u.opts.windowSize
+		unacked  int
+		gapAcked bool
+		ackedAt  time.Time // when the ACK the next block answers was sent, zero if it was retransmitted
+	)
+	defer putDatagram(reply)
+	if u.opts.blockSize > BlockSize {
+		buf = make([]byte, 4+u.opts.blockSize+1) // see readBufferSize
+	}
+	if u.opts.oack != nil {
+		oack, _ = OptionAcknowledgment{Options: u.opts.oack}.MarshalBinary()
+	}
+
+NEXT_BLOCK:
+	for !last {
+		ack, _ := ackM.AppendBinary(ackBuf[:0])
+		if ackM.BlockNum == 0 && oack != nil {
+			ack = oack
+		}
+
+	RETRIES:
+		for i := 0; i < u.opts.retries; i++ {
+			if ctx.Err() != nil {
+				s.cancelled(ctx, u.conn, u.logger, u.summary)
+				return
+			}
+			if i > 0 {
+				atomic.AddInt64(&u.t.retransmits, 1)
+				s.Metrics.retransmits.Inc()
+			}
+			deadline := time.Now().Add(u.rto.timeout())
+			if i > 0 || unacked == 0 {
+				if _, err := u.conn.Write(ack); err != nil {
+					socketFailed(u.logger, u.summary, "write", err)
+					return
+				}
+				u.sent(ack)
+				// the RTT is up to the next block, unless the ACK was
+				// retransmitted (Karn's algorithm)
+				ackedAt = time.Time{}
+				if i == 0 {
+					ackedAt = time.Now()
+				}
+			}
+
+		WAIT:
+			m, err := u.read(buf, deadline)
+			if err != nil {
+				if ctx.Err() != nil {
+					s.cancelled(ctx, u.conn, u.logger, u.summary)
+					return
+				}
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					s.Metrics.timeouts.Inc()
+					atomic.AddInt64(&u.t.timeouts, 1)
+					u.rto.backoff()
+					u.logger.Debug("timeout waiting for DATA, acknowledging again", "block", ackM.BlockNum, "attempt", i+1, "retries", u.opts.retries, "timeout", u.rto.timeout())
+					continue RETRIES
+				}
+				if err == errTruncated {
+					u.logger.Debug("truncated datagram dropped", "size", m)
+					continue RETRIES
+				}
+				socketFailed(u.logger, u.summary, "waiting for DATA", err)
+				return
+			}
+
+			switch code := Opcode(binary.BigEndian.Uint16(buf[:2])); code {
+			case DataOp:
+				// a retransmission of the last block is acknowledged again,
+				// like the first block out of order of a window
+				if dataM.UnmarshalBinary(buf[:m]) != nil {
+					continue RETRIES
+				}
+				// the client chooses the block number after 65535
+				wraps := ackM.BlockNum == MaxBlocks
+				if dataM.BlockNum != ackM.BlockNum+1 && !(wraps && dataM.BlockNum == 1) {
+					if window > 1 && gapAcked {
+						goto WAIT // the rest of the window
+					}
+					gapAcked, unacked = true, 0
+					continue RETRIES
+				}
+				gapAcked = false
+				if wraps && s.BlockRollover < 0 {
+					u.logger.Warn("upload too large for the block numbers", "file", dest, "blocks", MaxBlocks)
+					s.sendError(u.conn, ErrUnknown, fmt.Sprintf("file too large: over %d blocks of %d bytes", MaxBlocks, u.opts.blockSize))
+					u.summary.Err = errTooManyBlocks
+					return
+				}
+				if err := s.writeBlock(f, dataM.Payload); err != nil {
+					s.uploadFailed(u, "writing upload", dest, err)
+					return
+				}
+				if !ackedAt.IsZero() {
+					u.rto.sample(time.Since(ackedAt))
+					ackedAt = time.Time{}
+				}
+				ackM.BlockNum = dataM.BlockNum
+				oack = nil // block 0 is acknowledged like the others after a rollover
+				last = len(dataM.Payload) < u.opts.blockSize
+				unacked = (unacked + 1) % window
+				blocks := atomic.AddInt64(&u.t.blocks, 1)
+				if every := s.UploadSync.Every; every > 0 && !last && blocks%int64(every) == 0 {
+					if err := f.Sync(); err != nil {
+						s.uploadFailed(u, "syncing upload", dest, err)
+						return
+					}
+				}
+				total := atomic.AddInt64(&u.t.bytes, int64(len(dataM.Payload)))
+				s.Metrics.bytesReceived.Add(int64(len(dataM.Payload)))
+				s.Hooks.blockReceived(u.summary.TransferInfo, dataM.BlockNum, total)
+				continue NEXT_BLOCK
+			case ErrorOp:
+				if errM.UnmarshalBinary(buf[:m]) != nil {
+					continue RETRIES
+				}
+				u.logger.Warn("received error", "code", errM.Code, "message", errM.Message)
+				s.Metrics.errorsReceived.With(strconv.Itoa(int(errM.Code))).Inc()
+				u.summary.Result = ResultAborted
+				u.summary.Err = errM
+				return
+			default:
+				u.logger.Debug("bad packet", "opcode", code)
+			}
+		}
+
+		// execution comes here only when we exhauste retries
+		u.logger.Warn("exhausted retries", "block", ackM.BlockNum+1)
+		u.summary.Result = ResultTimeout
+		return
+	}
+
+	if s.SparseUploads {
+		// the file ends at the last block written, extend it over the
+		// trailing hole if any
+		if err := f.Truncate(atomic.LoadInt64(&u.t.bytes)); err != nil {
+			s.uploadFailed(u, "writing upload", dest, err)
+			return
+		}
+	}
+	if s.UploadSync.OnClose {
+		if err := f.Sync(); err != nil {
+			s.uploadFailed(u, "syncing upload", dest, err)
+			return
+		}
+	}
+	if err := f.Close(); err != nil {
+		s.uploadFailed(u, "writing upload", dest, err)
+		return
+	}
+	if err := s.storeUpload(f.Name(), dest); err != nil {
+		s.uploadFailed(u, "storing upload", dest, err)
+		return
+	}
+	if s.UploadSync.OnClose {
+		// the rename itself is only durable once the directory is synced
+		if err := syncDir(filepath.Dir(dest)); err != nil {
+			u.logger.Warn("syncing upload directory", "file", dest, "error", err)
+		}
+	}
+	u.summary.Path = dest
+
+	final, _ := ackM.AppendBinary(ackBuf[:0])
+	if _, err := u.conn.Write(final); err == nil {
+		u.sent(final)
+	}
+	u.summary.Result = ResultOK
+	u.logger.Info("upload complete", "file", dest, "blocks", atomic.LoadInt64(&u.t.blocks), "bytes", atomic.LoadInt64(&u.t.bytes), "duration", time.Since(u.summary.Start))
+	s.dally(u, final, ackM.BlockNum, buf)
+}
+
+// zeroBlock is compared to the blocks of the sparse uploads.
+var zeroBlock [MaxBlockSize]byte
+
+// writeBlock writes the payload of a DATA block at the offset of f. With
+// SparseUploads a block of zeros is skipped instead, leaving a hole the
+// file system doesn't allocate (when it spans whole file system blocks).
+func (s *TFTPServer) writeBlock(f uploadFile, payload []byte) error {
+	if s.SparseUploads && len(payload) > 0 && bytes.Equal(payload, zeroBlock[:len(payload)]) {
+		_, err := f.Seek(int64(len(payload)), io.SeekCurrent)
+		return err
+	}
+	_, err := f.Write(payload)
+	return err
+}
+
+// uploadFailed aborts the upload u on the error err of the file system
+// operation op on dest, with the ERROR matching err: disk full (ENOSPC
+// and EDQUOT), access violation (permissions and read-only file systems),
+// file exists (a destination created during the upload, unless
+// UploadOverwrite) or undefined otherwise. The temporary file is removed
+// by receive.
+func (s *TFTPServer) uploadFailed(u *upload, op, dest string, err error) {
+	u.logger.Error(op, "file", dest, "error", err)
+	u.summary.Err = err
+	switch {
+	case errors.Is(err, os.ErrExist):
+		s.sendError(u.conn, ErrFileExists, msgFileExists)
+	case isDiskFull(err):
+		s.sendError(u.conn, ErrDiskFull, msgDiskFull)
+	case errors.Is(err, os.ErrPermission) || isReadOnly(err):
+		s.sendError(u.conn, ErrAccessViolation, msgAccess)
+	default:
+		s.sendError(u.conn, ErrUnknown, msgWriteFailed)
+	}
+}
+
+// dally waits for a timeout after the final ACK and repeats it if the last
+// block is retransmitted, in case the ACK was lost (RFC 1350 section 6).
+func (s *TFTPServer) dally(u *upload, final []byte, block uint16, buf []byte) {
+	for {
+		m, err := u.read(buf, time.Now().Add(u.opts.timeout))
+		if err == errTruncated {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if m >= 4 && Opcode(binary.BigEndian.Uint16(buf)) == DataOp && binary.BigEndian.Uint16(buf[2:4]) == block {
+			if _, err := u.conn.Write(final); err == nil {
+				u.sent(final)
+			}
+		}
+	}
+}
+
+// syncDir flushes the entries of the directory dir to stable storage, a
+// no-op on Windows where directories can't be opened for it.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// storeUpload moves the complete upload temp to dest. Unless
+// UploadOverwrite it is linked instead, which fails if dest was created
+// during the upload.
+func (s *TFTPServer) storeUpload(temp, dest string) error {
+	if s.UploadOverwrite {
+		return os.Rename(temp, dest)
+	}
+	if err := os.Link(temp, dest); err != nil {
+		return err
+	}
+	return os.Remove(temp)
+}
+
+// existingAncestor returns dir or its closest parent that exists, where
+// createUpload starts creating directories.
+func existingAncestor(dir string) string {
+	for {
+		if _, err := os.Lstat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// createUpload creates the temporary file an upload of dest is written to,
+// in the directory of dest so that it can be renamed over it.
+func createUpload(dest string) (*os.File, error) {
+	dir := filepath.Dir(dest)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(dir, "."+filepath.Base(dest)+".*.part")
+}
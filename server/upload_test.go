@@ -0,0 +1,218 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/OmarTariq612/tftp-server/client"
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+func TestUploadRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		size      int
+		blockSize int
+	}{
+		{0, 0},
+		{100, 0},
+		{server.BlockSize, 0}, // ends with an empty block
+		{10*server.BlockSize + 7, 0},
+		{3*1428 + 1, 1428},
+	} {
+		tt := tt
+		t.Run(strconv.Itoa(tt.size)+"/blksize="+strconv.Itoa(tt.blockSize), func(t *testing.T) {
+			dir := t.TempDir()
+			addr := startServer(t, func(s *server.TFTPServer) {
+				s.UploadDir = dir
+				s.Root = dir
+			})
+			content := randomContent(tt.size)
+			c := &client.Client{BlockSize: tt.blockSize}
+			if err := c.Put(context.Background(), addr, "images/disk.img", bytes.NewReader(content), int64(len(content))); err != nil {
+				t.Fatal(err)
+			}
+			stored, err := ioutil.ReadFile(filepath.Join(dir, "images", "disk.img"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(stored, content) {
+				t.Fatalf("stored %d bytes differing from the %d bytes uploaded", len(stored), len(content))
+			}
+			var got bytes.Buffer
+			if err := c.Get(context.Background(), addr, "images/disk.img", &got); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Bytes(), content) {
+				t.Fatalf("downloaded %d bytes differing from the %d bytes uploaded", got.Len(), len(content))
+			}
+		})
+	}
+}
+
+func TestUploadEscapes(t *testing.T) {
+	dir := t.TempDir()
+	uploads, outside := filepath.Join(dir, "uploads"), filepath.Join(dir, "outside")
+	for _, d := range []string{uploads, outside} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(uploads, "link")); err != nil {
+		t.Skipf("symbolic links: %v", err)
+	}
+	addr := startServer(t, func(s *server.TFTPServer) {
+		s.UploadDir = uploads
+	})
+	for _, file := range []string{"../outside/evil", "/../outside/evil", `..\outside\evil`, "link/evil"} {
+		err := (&client.Client{}).Put(context.Background(), addr, file, bytes.NewReader([]byte("evil")), 4)
+		var e server.Err
+		if !errors.As(err, &e) || e.Code != server.ErrAccessViolation {
+			t.Errorf("%s: got %v, want an access violation", file, err)
+		}
+	}
+	if entries, _ := os.ReadDir(outside); len(entries) > 0 {
+		t.Errorf("%d files written out of the upload directory", len(entries))
+	}
+}
+
+func TestUploadOverwrite(t *testing.T) {
+	for _, overwrite := range []bool{false, true} {
+		overwrite := overwrite
+		t.Run("overwrite="+strconv.FormatBool(overwrite), func(t *testing.T) {
+			dir := t.TempDir()
+			file := filepath.Join(dir, "config.txt")
+			if err := ioutil.WriteFile(file, []byte("original"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			addr := startServer(t, func(s *server.TFTPServer) {
+				s.UploadDir = dir
+				s.UploadOverwrite = overwrite
+			})
+			err := (&client.Client{}).Put(context.Background(), addr, "config.txt", bytes.NewReader([]byte("replaced")), 8)
+			want := "replaced"
+			if !overwrite {
+				var e server.Err
+				if !errors.As(err, &e) || e.Code != server.ErrFileExists {
+					t.Errorf("got %v, want a file already exists error", err)
+				}
+				want = "original"
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got, _ := ioutil.ReadFile(file); string(got) != want {
+				t.Errorf("file content %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// recordedUpload records the syncs and the close of an upload file.
+type recordedUpload struct {
+	server.UploadFile
+	mu  *sync.Mutex
+	ops *[]string
+}
+
+func (f recordedUpload) Sync() error {
+	f.record("sync")
+	return f.UploadFile.Sync()
+}
+
+func (f recordedUpload) Close() error {
+	f.record("close")
+	return f.UploadFile.Close()
+}
+
+func (f recordedUpload) record(op string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	*f.ops = append(*f.ops, op)
+}
+
+func TestUploadSync(t *testing.T) {
+	// 6 blocks, the last one of 10 bytes
+	content := randomContent(5*server.BlockSize + 10)
+	for _, tt := range []struct {
+		policy string
+		ops    []string
+	}{
+		{"never", []string{"close"}},
+		{"close", []string{"sync", "close"}},
+		{"2", []string{"sync", "sync", "sync", "close"}}, // after blocks 2 and 4, and before closing
+	} {
+		tt := tt
+		t.Run(tt.policy, func(t *testing.T) {
+			policy, err := server.ParseSyncPolicy(tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var (
+				mu  sync.Mutex
+				ops []string
+			)
+			dir := t.TempDir()
+			addr := startServer(t, func(s *server.TFTPServer) {
+				s.UploadDir = dir
+				s.UploadSync = policy
+				server.WrapUploads(s, func(f *os.File) server.UploadFile {
+					return recordedUpload{f, &mu, &ops}
+				})
+			})
+			if err := (&client.Client{}).Put(context.Background(), addr, "disk.img", bytes.NewReader(content), int64(len(content))); err != nil {
+				t.Fatal(err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(ops, tt.ops) {
+				t.Errorf("got %v, want %v", ops, tt.ops)
+			}
+			if stored, _ := ioutil.ReadFile(filepath.Join(dir, "disk.img")); !bytes.Equal(stored, content) {
+				t.Errorf("stored %d bytes differing from the %d bytes uploaded", len(stored), len(content))
+			}
+		})
+	}
+}
+
+func TestSparseUpload(t *testing.T) {
+	data := randomContent(server.BlockSize)
+	zeros := func(n int) []byte { return make([]byte, n) }
+	for name, content := range map[string][]byte{
+		"empty":              nil,
+		"zero blocks":        zeros(3 * server.BlockSize), // ends with an empty block
+		"short zero block":   zeros(3*server.BlockSize + 100),
+		"trailing zeros":     append(append([]byte{}, data...), zeros(2*server.BlockSize)...),
+		"leading zeros":      append(zeros(2*server.BlockSize), data...),
+		"zeros in the block": append(zeros(server.BlockSize), append(zeros(server.BlockSize-1), 1)...),
+	} {
+		content := content
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			addr := startServer(t, func(s *server.TFTPServer) {
+				s.UploadDir = dir
+				s.SparseUploads = true
+			})
+			if err := (&client.Client{}).Put(context.Background(), addr, "disk.img", bytes.NewReader(content), int64(len(content))); err != nil {
+				t.Fatal(err)
+			}
+			file := filepath.Join(dir, "disk.img")
+			info, err := os.Stat(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != int64(len(content)) {
+				t.Fatalf("stored file of %d bytes, want %d", info.Size(), len(content))
+			}
+			if stored, _ := ioutil.ReadFile(file); !bytes.Equal(stored, content) {
+				t.Errorf("stored content differs from the %d bytes uploaded", len(content))
+			}
+		})
+	}
+}
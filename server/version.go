@@ -0,0 +1,66 @@
+package server
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Version is the release of the server, set at link time with
+// -ldflags "-X github.com/OmarTariq612/tftp-server/server.Version=v1.2.3".
+// When empty the module version recorded in the build info is used.
+var Version = ""
+
+// BuildInfo identifies the running build.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	Time      string `json:"commit_time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"` // built from a tree with uncommitted changes
+	GoVersion string `json:"go_version"`
+}
+
+// ReadBuildInfo returns the version and VCS information embedded in the binary.
+func ReadBuildInfo() BuildInfo {
+	info := BuildInfo{Version: Version, GoVersion: runtime.Version()}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		if info.Version == "" {
+			info.Version = "unknown"
+		}
+		return info
+	}
+	if info.Version == "" {
+		info.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Commit = s.Value
+		case "vcs.time":
+			info.Time = s.Value
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	return info
+}
+
+func (b BuildInfo) String() string {
+	s := "tftp-server " + b.Version
+	if b.Commit != "" {
+		commit := b.Commit
+		if len(commit) > 12 {
+			commit = commit[:12]
+		}
+		s += fmt.Sprintf(" (commit %s", commit)
+		if b.Modified {
+			s += ", modified"
+		}
+		if b.Time != "" {
+			s += ", " + b.Time
+		}
+		s += ")"
+	}
+	return s + " " + b.GoVersion
+}
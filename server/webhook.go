@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Webhooks POSTs a JSON WebhookEvent to every URL when a transfer finishes.
+// Deliveries happen on a background goroutine, transfers never wait for them.
+type Webhooks struct {
+	urls      []string
+	client    *http.Client
+	logger    *Logger
+	events    chan WebhookEvent
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// WebhookEvent is the body of a webhook notification.
+type WebhookEvent struct {
+	Event     string         `json:"event"`
+	ID        string         `json:"id"`
+	Client    string         `json:"client"`
+	ClientIP  string         `json:"client_ip"`
+	Filename  string         `json:"file"`
+	Direction Direction      `json:"direction"`
+	Result    TransferResult `json:"result"`
+	Bytes     int64          `json:"bytes"`
+	Start     time.Time      `json:"start"`
+	Duration  float64        `json:"duration_seconds"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// webhookQueueSize bounds the events waiting to be delivered, events are
+// dropped when the endpoints can't keep up.
+const webhookQueueSize = 1024
+
+// NewWebhooks returns a Webhooks delivering to urls, each request is given
+// timeout to complete. Delivery failures are logged to logger.
+func NewWebhooks(urls []string, timeout time.Duration, logger *Logger) *Webhooks {
+	w := &Webhooks{
+		urls:   urls,
+		client: &http.Client{Timeout: timeout},
+		logger: logger,
+		events: make(chan WebhookEvent, webhookQueueSize),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Notify queues the notification of a finished transfer, it never blocks.
+func (w *Webhooks) Notify(summary TransferSummary) bool {
+	event := WebhookEvent{
+		Event:     "transfer.finished",
+		ID:        summary.ID,
+		Client:    summary.Client.String(),
+		ClientIP:  clientHost(summary.Client),
+		Filename:  summary.Filename,
+		Direction: summary.Direction,
+		Result:    summary.Result,
+		Bytes:     summary.Bytes,
+		Start:     summary.Start,
+		Duration:  summary.Duration.Seconds(),
+	}
+	if summary.Err != nil {
+		event.Error = summary.Err.Error()
+	}
+	select {
+	case w.events <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close delivers the queued events and stops the background goroutine.
+func (w *Webhooks) Close() {
+	w.closeOnce.Do(func() { close(w.events) })
+	<-w.done
+}
+
+func (w *Webhooks) run() {
+	defer close(w.done)
+	for event := range w.events {
+		body, err := json.Marshal(event)
+		if err != nil {
+			continue
+		}
+		for _, url := range w.urls {
+			if err := w.post(url, body); err != nil {
+				w.logger.Warn("webhook delivery failed", "url", url, "id", event.ID, "error", err)
+			}
+		}
+	}
+}
+
+func (w *Webhooks) post(url string, body []byte) error {
+	resp, err := w.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
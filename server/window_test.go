@@ -0,0 +1,81 @@
+package server_test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+// expectBlocks reads the DATA blocks first to last from conn.
+func expectBlocks(t *testing.T, conn *net.UDPConn, first, last uint16) {
+	t.Helper()
+	for n := first; n <= last; n++ {
+		b, _ := readPacket(t, conn)
+		if server.Opcode(binary.BigEndian.Uint16(b)) != server.DataOp || binary.BigEndian.Uint16(b[2:]) != n {
+			t.Fatalf("got %v, want DATA %d", b[:4], n)
+		}
+	}
+}
+
+func ack(t *testing.T, conn *net.UDPConn, to *net.UDPAddr, block uint16) {
+	t.Helper()
+	b, _ := server.Acknowledgment{BlockNum: block}.MarshalBinary()
+	if _, err := conn.WriteToUDP(b, to); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWindowRetransmission(t *testing.T) {
+	addr := startServer(t, func(s *server.TFTPServer) {
+		// 11 blocks, the last one of 100 bytes
+		s.FS = fstest.MapFS{"pxelinux.0": {Data: make([]byte, 10*server.BlockSize+100)}}
+		s.Timeout = 200 * time.Millisecond
+	})
+	conn, reply, from := sendRequest(t, addr, server.ReadOp, "pxelinux.0", map[string]string{"windowsize": "4"})
+	if server.Opcode(binary.BigEndian.Uint16(reply)) != server.OptionAckOp {
+		t.Fatalf("got %v, want an OACK", reply)
+	}
+	ack(t, conn, from, 0)
+	expectBlocks(t, conn, 1, 4)
+	// no ACK: the whole window is sent again after the timeout
+	expectBlocks(t, conn, 1, 4)
+	// the blocks after 2 were lost: the next window starts at 3
+	ack(t, conn, from, 2)
+	expectBlocks(t, conn, 3, 6)
+	ack(t, conn, from, 6)
+	expectBlocks(t, conn, 7, 10)
+	ack(t, conn, from, 10)
+	expectBlocks(t, conn, 11, 11)
+	ack(t, conn, from, 11)
+}
+
+func TestTruncatedClientError(t *testing.T) {
+	ended := make(chan server.TransferSummary, 1)
+	addr := startServer(t, func(s *server.TFTPServer) {
+		s.FS = fstest.MapFS{"pxelinux.0": {Data: make([]byte, server.BlockSize+100)}}
+		s.Timeout = 200 * time.Millisecond
+		s.Hooks.OnTransferEnd = func(summary server.TransferSummary) { ended <- summary }
+	})
+	conn, _, from := sendRequest(t, addr, server.ReadOp, "pxelinux.0", nil)
+	ack(t, conn, from, 1)
+	expectBlocks(t, conn, 2, 2)
+	// an ERROR packet without its error code is ignored, not read with
+	// the bytes of the previous ACK
+	if _, err := conn.WriteToUDP([]byte{0, byte(server.ErrorOp), 0}, from); err != nil {
+		t.Fatal(err)
+	}
+	expectBlocks(t, conn, 2, 2)
+	ack(t, conn, from, 2)
+	select {
+	case summary := <-ended:
+		if summary.Result != server.ResultOK {
+			t.Errorf("transfer %s (%v), want %s", summary.Result, summary.Err, server.ResultOK)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the transfer didn't end on the server")
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"log"
+)
+
+func serviceCommand(args []string) {
+	log.Fatal("service: Windows services are only supported on Windows, use the init system of this platform (see -daemon and -pidfile)")
+}
+
+// runService reports false, there is no service control manager here.
+func runService(serve func(ctx context.Context)) bool { return false }
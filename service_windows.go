@@ -0,0 +1,161 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const (
+	serviceName        = "tftpd"
+	serviceDisplayName = "TFTP Server"
+	serviceDescription = "Serves files over TFTP (RFC 1350)."
+)
+
+// serviceCommand implements "tftp-server service install|uninstall|start|stop".
+// The arguments following "install" are the server flags the service runs with.
+func serviceCommand(args []string) {
+	if len(args) == 0 {
+		log.Fatal("usage: tftp-server service install [flags...] | uninstall | start | stop")
+	}
+	var err error
+	switch args[0] {
+	case "install":
+		err = installService(args[1:])
+	case "uninstall":
+		err = uninstallService()
+	case "start":
+		err = controlService(func(s *mgr.Service) error { return s.Start() })
+	case "stop":
+		err = controlService(func(s *mgr.Service) error {
+			_, err := s.Control(svc.Stop)
+			return err
+		})
+	default:
+		err = fmt.Errorf("unknown service command %q", args[0])
+	}
+	if err != nil {
+		log.Fatalf("service %s: %v", args[0], err)
+	}
+}
+
+func installService(args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", serviceName)
+	}
+
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: serviceDisplayName,
+		Description: serviceDescription,
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	// register the source used by -eventlog, it may already exist
+	eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info)
+	return nil
+}
+
+func uninstallService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return err
+	}
+	eventlog.Remove(serviceName)
+	return nil
+}
+
+func controlService(f func(s *mgr.Service) error) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return f(s)
+}
+
+// runService runs serve under the service control manager when the process
+// was started by it and reports whether it did.
+func runService(serve func(ctx context.Context)) bool {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return false
+	}
+	if err := svc.Run(serviceName, &service{serve: serve}); err != nil {
+		log.Fatal(err)
+	}
+	return true
+}
+
+type service struct {
+	serve func(ctx context.Context)
+}
+
+func (s *service) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	const accepted = svc.AcceptStop | svc.AcceptShutdown
+	status <- svc.Status{State: svc.StartPending}
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.serve(ctx)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: accepted}
+
+	for {
+		select {
+		case <-done:
+			// the server stopped on its own (e.g. the socket couldn't be bound)
+			return true, 1
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+				// the documentation asks for the status to be sent twice
+				time.Sleep(100 * time.Millisecond)
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				stop() // serve returns once the transfers are over
+				<-done
+				return false, 0
+			}
+		}
+	}
+}
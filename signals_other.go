@@ -0,0 +1,19 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"os"
+
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+// shutdownSignals stop the server gracefully.
+var shutdownSignals = []os.Signal{os.Interrupt}
+
+// handleStatsSignal is a no-op, there is no SIGUSR1 on this platform.
+func handleStatsSignal(s *server.TFTPServer) {}
+
+// handleReloadSignal is a no-op, there is no SIGHUP on this platform: the
+// served file can be reloaded through the admin API.
+func handleReloadSignal(reload func()) {}
@@ -0,0 +1,36 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+// shutdownSignals stop the server gracefully.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// handleStatsSignal logs a stats snapshot every time SIGUSR1 is received.
+func handleStatsSignal(s *server.TFTPServer) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR1)
+	go func() {
+		for range ch {
+			s.LogStats()
+		}
+	}()
+}
+
+// handleReloadSignal calls reload every time SIGHUP is received.
+func handleReloadSignal(reload func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	go func() {
+		for range ch {
+			reload()
+		}
+	}()
+}
@@ -0,0 +1,150 @@
+// Package tftpconformance checks a TFTP server against scripted scenarios:
+// the RFC 1350 basics, option negotiation (RFC 2347, 2348 and 2349), block
+// number rollover, duplicate packets and malformed requests. The scenarios
+// speak the protocol datagram by datagram, they run against this server or
+// any other implementation:
+//
+//	results := tftpconformance.Run(ctx, tftpconformance.Target{Addr: "10.0.0.1:69", File: "pxelinux.0"}, tftpconformance.Scenarios())
+//	for _, r := range results {
+//		fmt.Println(r)
+//	}
+//
+// The tftp command runs them with "tftp conformance".
+package tftpconformance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Target is the server under test and what the scenarios may assume of it.
+type Target struct {
+	// Addr is the address of the server, host:port.
+	Addr string
+
+	// File is a file the server serves, Content its content if known: the
+	// downloads are compared to it.
+	File    string
+	Content []byte
+
+	// Missing is a file the server doesn't have, "tftpconformance-missing" by default.
+	Missing string
+
+	// Large, if set, is a file over 65535 blocks of 512 bytes (32 MiB), for
+	// the rollover scenario; it is skipped otherwise.
+	Large string
+
+	// Upload, if set, is the name the upload scenarios write to, more than
+	// once: the server must let the uploads replace it (-upload-overwrite
+	// of tftp-server). They are skipped otherwise.
+	Upload string
+
+	// Timeout is how long to wait for a datagram, 2s by default.
+	// RetransmitTimeout is the retransmission timeout of the server, 5s by
+	// default: the retransmission scenario waits for it. It must be fixed,
+	// a timeout adapted to the round trip times (like with the
+	// -adaptive-timeout of tftp-server) drops below it during a transfer.
+	Timeout           time.Duration
+	RetransmitTimeout time.Duration
+}
+
+func (t *Target) timeout() time.Duration {
+	if t.Timeout > 0 {
+		return t.Timeout
+	}
+	return 2 * time.Second
+}
+
+func (t *Target) missing() string {
+	if t.Missing != "" {
+		return t.Missing
+	}
+	return "tftpconformance-missing"
+}
+
+func (t *Target) retransmitTimeout() time.Duration {
+	if t.RetransmitTimeout > 0 {
+		return t.RetransmitTimeout
+	}
+	return 5 * time.Second
+}
+
+// Scenario is a scripted exchange with the server.
+type Scenario struct {
+	Name string
+	Spec string // the specification it checks, "RFC 1350 section 6" for instance
+	Run  func(ctx context.Context, t *Target) error
+}
+
+// Skip is returned (wrapped) by the scenarios that don't apply to the
+// target: an optional feature it lacks or a setting of Target left empty.
+var Skip = errors.New("skipped")
+
+func skipf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{Skip}, args...)...)
+}
+
+// Result is the outcome of a scenario.
+type Result struct {
+	Scenario Scenario
+	Err      error // nil if it passed, wrapping Skip if it was skipped
+	Duration time.Duration
+}
+
+// Passed reports whether the scenario passed.
+func (r Result) Passed() bool { return r.Err == nil }
+
+// Skipped reports whether the scenario didn't apply.
+func (r Result) Skipped() bool { return errors.Is(r.Err, Skip) }
+
+// String formats the result as a report line: PASS, FAIL or SKIP, the
+// scenario and the error.
+func (r Result) String() string {
+	status := "PASS"
+	if r.Skipped() {
+		status = "SKIP"
+	} else if !r.Passed() {
+		status = "FAIL"
+	}
+	line := fmt.Sprintf("%s %s (%s) %s", status, r.Scenario.Name, r.Scenario.Spec, r.Duration.Round(time.Millisecond))
+	if r.Err != nil {
+		line += ": " + r.Err.Error()
+	}
+	return line
+}
+
+// Run runs the scenarios against target one after the other, it stops
+// early if ctx is done.
+func Run(ctx context.Context, target Target, scenarios []Scenario) []Result {
+	results := make([]Result, 0, len(scenarios))
+	for _, s := range scenarios {
+		if ctx.Err() != nil {
+			break
+		}
+		start := time.Now()
+		err := s.Run(ctx, &target)
+		results = append(results, Result{Scenario: s, Err: err, Duration: time.Since(start)})
+	}
+	return results
+}
+
+// Scenarios returns all the scenarios, in the order they are best run.
+func Scenarios() []Scenario {
+	return []Scenario{
+		{Name: "read", Spec: "RFC 1350", Run: readFile},
+		{Name: "read-not-found", Spec: "RFC 1350 section 7", Run: readNotFound},
+		{Name: "retransmit-data", Spec: "RFC 1350 section 6", Run: retransmitData},
+		{Name: "duplicate-ack", Spec: "RFC 1123 section 4.2.3.1", Run: duplicateACK},
+		{Name: "client-error", Spec: "RFC 1350 section 7", Run: clientError},
+		{Name: "malformed-opcode", Spec: "RFC 1350 section 5", Run: malformedOpcode},
+		{Name: "malformed-request", Spec: "RFC 1350 section 5", Run: malformedRequest},
+		{Name: "option-blksize", Spec: "RFC 2348", Run: optionBlksize},
+		{Name: "option-tsize", Spec: "RFC 2349", Run: optionTsize},
+		{Name: "option-unknown", Spec: "RFC 2347", Run: optionUnknown},
+		{Name: "rollover", Spec: "block number rollover", Run: rollover},
+		{Name: "write", Spec: "RFC 1350", Run: writeFile},
+		{Name: "write-duplicate-data", Spec: "RFC 1350 section 6", Run: writeDuplicateData},
+	}
+}
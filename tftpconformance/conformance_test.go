@@ -0,0 +1,70 @@
+package tftpconformance_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/OmarTariq612/tftp-server/server"
+	"github.com/OmarTariq612/tftp-server/tftpconformance"
+)
+
+// TestServer runs the scenarios against this server, with a short
+// retransmission timeout for the retransmission scenario not to take long.
+func TestServer(t *testing.T) {
+	content := make([]byte, 3*server.BlockSize+100)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	files := fstest.MapFS{"pxelinux.0": {Data: content}}
+	target := tftpconformance.Target{File: "pxelinux.0", Content: content, Upload: "upload.bin", RetransmitTimeout: time.Second}
+	if !testing.Short() {
+		files["large.bin"] = &fstest.MapFile{Data: make([]byte, (server.MaxBlocks+1)*server.BlockSize+100)}
+		target.Large = "large.bin"
+	}
+
+	s, err := server.NewTFTPServer("127.0.0.1", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Logger = server.NewLogger(io.Discard, server.LevelError)
+	s.FS = files
+	s.UploadDir = t.TempDir()
+	s.UploadOverwrite = true // the scenarios write Upload more than once
+	s.Timeout = target.RetransmitTimeout
+	listening := make(chan net.Addr, 1)
+	s.SelfTest = func(addr net.Addr) error {
+		listening <- addr
+		return nil
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.ListenAndServe()
+	}()
+	select {
+	case addr := <-listening:
+		target.Addr = addr.String()
+	case err := <-done:
+		t.Fatal(err)
+	}
+	defer func() {
+		s.Close()
+		<-done
+	}()
+
+	for _, scenario := range tftpconformance.Scenarios() {
+		scenario := scenario
+		t.Run(scenario.Name, func(t *testing.T) {
+			r := tftpconformance.Run(context.Background(), target, []tftpconformance.Scenario{scenario})[0]
+			switch {
+			case r.Skipped():
+				t.Skip(r.Err)
+			case !r.Passed():
+				t.Errorf("%s: %v", scenario.Spec, r.Err)
+			}
+		})
+	}
+}
@@ -0,0 +1,484 @@
+package tftpconformance
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+// download reads the rest of a download whose request was sent, blocks of
+// size bytes starting at block, and returns its content.
+func download(s *session, block uint16, size int) ([]byte, error) {
+	var content []byte
+	for {
+		b, err := s.receive(0)
+		if err != nil {
+			return content, fmt.Errorf("block %d: %w", block, err)
+		}
+		payload, err := expectData(b, block)
+		if err != nil {
+			return content, err
+		}
+		if len(payload) > size {
+			return content, fmt.Errorf("DATA %d of %d bytes, over the block size %d", block, len(payload), size)
+		}
+		content = append(content, payload...)
+		if err := s.ack(block); err != nil {
+			return content, err
+		}
+		if len(payload) < size {
+			return content, nil
+		}
+		block++
+	}
+}
+
+// abort ends a transfer of s with an ERROR, like a client giving up.
+func abort(s *session) {
+	b, _ := server.Err{Code: server.ErrUnknown, Message: "tftpconformance: done"}.MarshalBinary()
+	s.send(b)
+}
+
+// checkContent compares a download of the target file to its content, if known.
+func checkContent(t *Target, content []byte) error {
+	if t.Content != nil && !bytes.Equal(content, t.Content) {
+		return fmt.Errorf("downloaded %d bytes differing from the %d bytes of %s", len(content), len(t.Content), t.File)
+	}
+	return nil
+}
+
+// readFile downloads the target file without options.
+func readFile(ctx context.Context, t *Target) error {
+	s, err := newSession(ctx, t)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	if err := s.request(server.ReadOp, t.File, nil); err != nil {
+		return err
+	}
+	content, err := download(s, 1, server.BlockSize)
+	if err != nil {
+		return err
+	}
+	return checkContent(t, content)
+}
+
+// readNotFound asks for a missing file, the server must answer file not
+// found (unless it serves every filename).
+func readNotFound(ctx context.Context, t *Target) error {
+	s, err := newSession(ctx, t)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	if err := s.request(server.ReadOp, t.missing(), nil); err != nil {
+		return err
+	}
+	b, err := s.receive(0)
+	if err != nil {
+		return err
+	}
+	if opcode(b) == server.DataOp {
+		abort(s)
+		return skipf("%s served, the server answers any filename", t.missing())
+	}
+	e, err := expectError(b)
+	if err != nil {
+		return err
+	}
+	if e.Code != server.ErrNotFound {
+		return fmt.Errorf("expected ERROR %d (file not found), got ERROR %d %q", server.ErrNotFound, e.Code, e.Message)
+	}
+	return nil
+}
+
+// retransmitData leaves the first block unacknowledged, the server must
+// send it again once its timeout expires.
+func retransmitData(ctx context.Context, t *Target) error {
+	s, err := newSession(ctx, t)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	if err := s.request(server.ReadOp, t.File, nil); err != nil {
+		return err
+	}
+	b, err := s.receive(0)
+	if err != nil {
+		return err
+	}
+	first, err := expectData(b, 1)
+	if err != nil {
+		return err
+	}
+	first = append([]byte(nil), first...)
+	defer abort(s)
+	b, err = s.receive(t.retransmitTimeout() + s.timeout)
+	if err != nil {
+		return fmt.Errorf("no retransmission of DATA 1: %w", err)
+	}
+	again, err := expectData(b, 1)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(first, again) {
+		return fmt.Errorf("the retransmission of DATA 1 differs from the original")
+	}
+	return nil
+}
+
+// duplicateACK acknowledges the first block twice: the server must not
+// send the second one again (the Sorcerer's Apprentice Syndrome).
+func duplicateACK(ctx context.Context, t *Target) error {
+	s, err := newSession(ctx, t)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	if err := s.request(server.ReadOp, t.File, nil); err != nil {
+		return err
+	}
+	b, err := s.receive(0)
+	if err != nil {
+		return err
+	}
+	payload, err := expectData(b, 1)
+	if err != nil {
+		return err
+	}
+	if len(payload) < server.BlockSize {
+		s.ack(1)
+		return skipf("%s fits in one block", t.File)
+	}
+	defer abort(s)
+	if err := s.ack(1); err != nil {
+		return err
+	}
+	if b, err = s.receive(0); err != nil {
+		return err
+	}
+	if _, err := expectData(b, 2); err != nil {
+		return err
+	}
+	if err := s.ack(1); err != nil {
+		return err
+	}
+	wait := t.retransmitTimeout() / 2
+	if b, err := s.receive(wait); err == nil {
+		return fmt.Errorf("the duplicate ACK 1 was answered with %s", describe(b))
+	}
+	return nil
+}
+
+// clientError aborts a download with an ERROR, the server must stop
+// sending, retransmissions included.
+func clientError(ctx context.Context, t *Target) error {
+	s, err := newSession(ctx, t)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	if err := s.request(server.ReadOp, t.File, nil); err != nil {
+		return err
+	}
+	b, err := s.receive(0)
+	if err != nil {
+		return err
+	}
+	if _, err := expectData(b, 1); err != nil {
+		return err
+	}
+	abort(s)
+	return s.silent(t.retransmitTimeout() + s.timeout)
+}
+
+// malformedOpcode sends a datagram of an unknown opcode: the server may
+// answer an illegal operation ERROR, nothing else, and must keep serving.
+func malformedOpcode(ctx context.Context, t *Target) error {
+	return malformed(ctx, t, []byte{0, 9, 'x', 0})
+}
+
+// malformedRequest sends a read request without the terminating zeros.
+func malformedRequest(ctx context.Context, t *Target) error {
+	return malformed(ctx, t, append([]byte{0, byte(server.ReadOp)}, t.File...))
+}
+
+func malformed(ctx context.Context, t *Target, packet []byte) error {
+	s, err := newSession(ctx, t)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	if err := s.send(packet); err != nil {
+		return err
+	}
+	b, err := s.receive(0)
+	if err == nil && opcode(b) != server.ErrorOp {
+		return fmt.Errorf("answered with %s", describe(b))
+	}
+	if err != nil && err != errTimeout {
+		return err
+	}
+	if err := readFile(ctx, t); err != nil {
+		return fmt.Errorf("not serving anymore: %w", err)
+	}
+	return nil
+}
+
+// negotiate requests the target file with options and returns the OACK
+// options, or skips if the server ignores or refuses options. The
+// transfer is left waiting for ACK 0.
+func negotiate(s *session, t *Target, options map[string]string) (map[string]string, error) {
+	if err := s.request(server.ReadOp, t.File, options); err != nil {
+		return nil, err
+	}
+	b, err := s.receive(0)
+	if err != nil {
+		return nil, err
+	}
+	switch opcode(b) {
+	case server.DataOp:
+		abort(s)
+		return nil, skipf("options ignored")
+	case server.ErrorOp:
+		if e, _ := expectError(b); e.Code == server.ErrOptions {
+			return nil, skipf("options refused: %s", e.Message)
+		}
+		return nil, fmt.Errorf("answered with %s", describe(b))
+	case server.OptionAckOp:
+		var oack server.OptionAcknowledgment
+		if err := oack.UnmarshalBinary(b); err != nil {
+			return nil, fmt.Errorf("malformed OACK: %w", err)
+		}
+		for name := range oack.Options {
+			if _, ok := options[name]; !ok {
+				abort(s)
+				return nil, fmt.Errorf("OACK of the option %q, not requested", name)
+			}
+		}
+		return oack.Options, nil
+	}
+	return nil, fmt.Errorf("answered with %s", describe(b))
+}
+
+// optionBlksize downloads the target file with 1024 byte blocks.
+func optionBlksize(ctx context.Context, t *Target) error {
+	s, err := newSession(ctx, t)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	oack, err := negotiate(s, t, map[string]string{"blksize": "1024"})
+	if err != nil {
+		return err
+	}
+	size := server.BlockSize
+	if v, ok := oack["blksize"]; ok {
+		if size, err = strconv.Atoi(v); err != nil || size < 8 || size > 1024 {
+			abort(s)
+			return fmt.Errorf("OACK blksize %q, expected 8 to 1024", v)
+		}
+	}
+	if err := s.ack(0); err != nil {
+		return err
+	}
+	content, err := download(s, 1, size)
+	if err != nil {
+		return err
+	}
+	return checkContent(t, content)
+}
+
+// optionTsize asks for the size of the target file.
+func optionTsize(ctx context.Context, t *Target) error {
+	s, err := newSession(ctx, t)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	oack, err := negotiate(s, t, map[string]string{"tsize": "0"})
+	if err != nil {
+		return err
+	}
+	defer abort(s)
+	v, ok := oack["tsize"]
+	if !ok {
+		return skipf("tsize not acknowledged")
+	}
+	size, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("OACK tsize %q is not a size", v)
+	}
+	if t.Content != nil && size != int64(len(t.Content)) {
+		return fmt.Errorf("OACK tsize %d, %s has %d bytes", size, t.File, len(t.Content))
+	}
+	return nil
+}
+
+// optionUnknown sends an unknown option, the server must ignore it.
+func optionUnknown(ctx context.Context, t *Target) error {
+	s, err := newSession(ctx, t)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	if err := s.request(server.ReadOp, t.File, map[string]string{"tftpconformance": "1"}); err != nil {
+		return err
+	}
+	b, err := s.receive(0)
+	if err != nil {
+		return err
+	}
+	defer abort(s)
+	switch opcode(b) {
+	case server.DataOp:
+		_, err := expectData(b, 1)
+		return err
+	case server.OptionAckOp:
+		return fmt.Errorf("unknown option acknowledged: %s", describe(b))
+	}
+	return fmt.Errorf("answered with %s", describe(b))
+}
+
+// rollover downloads the Large file, the block after 65535 must be 0 (or
+// 1 for the servers rolling over to 1).
+func rollover(ctx context.Context, t *Target) error {
+	if t.Large == "" {
+		return skipf("no Large file")
+	}
+	s, err := newSession(ctx, t)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	if err := s.request(server.ReadOp, t.Large, nil); err != nil {
+		return err
+	}
+	for block := 1; block <= 1<<16; block++ {
+		if ctx.Err() != nil {
+			abort(s)
+			return ctx.Err()
+		}
+		b, err := s.receive(0)
+		if err != nil {
+			return fmt.Errorf("block %d: %w", block, err)
+		}
+		if block == 1 && opcode(b) == server.ErrorOp {
+			return skipf("%s refused: %s", t.Large, describe(b))
+		}
+		want := uint16(block)
+		if block == 1<<16 && opcode(b) == server.DataOp && b[2] == 0 && b[3] == 1 {
+			want = 1
+		}
+		payload, err := expectData(b, want)
+		if err != nil {
+			return err
+		}
+		if len(payload) < server.BlockSize {
+			s.ack(want)
+			return fmt.Errorf("%s ended at block %d, it must be over 65535 blocks", t.Large, block)
+		}
+		if err := s.ack(want); err != nil {
+			return err
+		}
+	}
+	abort(s)
+	return nil
+}
+
+// testPayload returns the content of the uploads: size bytes of a pattern.
+func testPayload(size int) []byte {
+	p := make([]byte, size)
+	for i := range p {
+		p[i] = byte(i * 7)
+	}
+	return p
+}
+
+// upload sends a write request for the Upload file and waits for ACK 0.
+func upload(ctx context.Context, t *Target) (*session, error) {
+	if t.Upload == "" {
+		return nil, skipf("no Upload file")
+	}
+	s, err := newSession(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.request(server.WriteOp, t.Upload, nil); err != nil {
+		s.close()
+		return nil, err
+	}
+	b, err := s.receive(0)
+	if err == nil {
+		err = expectAck(b, 0)
+	}
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// sendData sends DATA block and checks that it is acknowledged.
+func sendData(s *session, block uint16, payload []byte) error {
+	b, _ := server.Data{BlockNum: block, Payload: payload}.MarshalBinary()
+	if err := s.send(b); err != nil {
+		return err
+	}
+	reply, err := s.receive(0)
+	if err != nil {
+		return fmt.Errorf("DATA %d: %w", block, err)
+	}
+	return expectAck(reply, block)
+}
+
+// writeFile uploads two and a half blocks.
+func writeFile(ctx context.Context, t *Target) error {
+	s, err := upload(ctx, t)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	content := testPayload(2*server.BlockSize + server.BlockSize/2)
+	for block := uint16(1); len(content) > 0 || block == 1; block++ {
+		n := len(content)
+		if n > server.BlockSize {
+			n = server.BlockSize
+		}
+		if err := sendData(s, block, content[:n]); err != nil {
+			return err
+		}
+		content = content[n:]
+		if n < server.BlockSize {
+			break
+		}
+	}
+	return nil
+}
+
+// writeDuplicateData sends the first block twice, the server must
+// acknowledge it again and carry on.
+func writeDuplicateData(ctx context.Context, t *Target) error {
+	s, err := upload(ctx, t)
+	if err != nil {
+		return err
+	}
+	defer s.close()
+	content := testPayload(server.BlockSize + 10)
+	if err := sendData(s, 1, content[:server.BlockSize]); err != nil {
+		return err
+	}
+	if err := sendData(s, 1, content[:server.BlockSize]); err != nil {
+		return fmt.Errorf("duplicate: %w", err)
+	}
+	if err := sendData(s, 2, content[server.BlockSize:]); err != nil {
+		return err
+	}
+	// the final ACK must not be followed by anything
+	return s.silent(time.Second)
+}
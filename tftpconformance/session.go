@@ -0,0 +1,167 @@
+package tftpconformance
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/OmarTariq612/tftp-server/server"
+)
+
+// session is the client side of a transfer, from its own port: the first
+// reply tells the transfer ID (port) of the server.
+type session struct {
+	conn    net.PacketConn
+	server  *net.UDPAddr // the request address, then the transfer address of the server
+	tid     bool         // server is the transfer address
+	timeout time.Duration
+	buf     []byte
+}
+
+func newSession(ctx context.Context, t *Target) (*session, error) {
+	addr, err := net.ResolveUDPAddr("udp", t.Addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, err
+	}
+	s := &session{conn: conn, server: addr, timeout: t.timeout(), buf: make([]byte, 65536)}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < s.timeout {
+		s.timeout = time.Until(deadline)
+	}
+	return s, nil
+}
+
+func (s *session) close() { s.conn.Close() }
+
+// send sends the datagram b to the server.
+func (s *session) send(b []byte) error {
+	_, err := s.conn.WriteTo(b, s.server)
+	return err
+}
+
+// request sends a request for filename with options.
+func (s *session) request(op server.Opcode, filename string, options map[string]string) error {
+	b, err := server.ReadWriteRequest{Op: op, Filename: filename, Mode: "octet", Options: options}.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return s.send(b)
+}
+
+// ack acknowledges block.
+func (s *session) ack(block uint16) error {
+	b, _ := server.Acknowledgment{BlockNum: block}.MarshalBinary()
+	return s.send(b)
+}
+
+// errTimeout is returned by receive when no datagram arrives in time.
+var errTimeout = fmt.Errorf("no answer from the server")
+
+// receive returns the next datagram of the server within wait (the session
+// timeout if 0). The first one sets the transfer address, the datagrams
+// from other addresses are ignored.
+func (s *session) receive(wait time.Duration) ([]byte, error) {
+	if wait == 0 {
+		wait = s.timeout
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		s.conn.SetReadDeadline(deadline)
+		n, from, err := s.conn.ReadFrom(s.buf)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return nil, errTimeout
+			}
+			return nil, err
+		}
+		addr, ok := from.(*net.UDPAddr)
+		if !ok || !s.fromServer(addr) {
+			continue
+		}
+		if n < 4 {
+			return nil, fmt.Errorf("datagram of %d bytes", n)
+		}
+		if !s.tid {
+			s.server, s.tid = addr, true
+		}
+		return s.buf[:n], nil
+	}
+}
+
+// fromServer reports whether a datagram from addr belongs to the session.
+func (s *session) fromServer(addr *net.UDPAddr) bool {
+	if s.tid {
+		return addr.IP.Equal(s.server.IP) && addr.Port == s.server.Port
+	}
+	// a server on the loopback interface may answer from any loopback address
+	return addr.IP.Equal(s.server.IP) || s.server.IP.IsLoopback() && addr.IP.IsLoopback()
+}
+
+// silent checks that the server sends nothing during wait.
+func (s *session) silent(wait time.Duration) error {
+	b, err := s.receive(wait)
+	if err == errTimeout {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("unexpected %s", describe(b))
+}
+
+func opcode(b []byte) server.Opcode { return server.Opcode(binary.BigEndian.Uint16(b)) }
+
+// expectData returns the payload of b, which must be the DATA block.
+func expectData(b []byte, block uint16) ([]byte, error) {
+	var d server.Data
+	if opcode(b) != server.DataOp || d.UnmarshalBinary(b) != nil {
+		return nil, fmt.Errorf("expected DATA %d, got %s", block, describe(b))
+	}
+	if d.BlockNum != block {
+		return nil, fmt.Errorf("expected DATA %d, got DATA %d", block, d.BlockNum)
+	}
+	return d.Payload, nil
+}
+
+// expectAck checks that b acknowledges block.
+func expectAck(b []byte, block uint16) error {
+	var a server.Acknowledgment
+	if opcode(b) != server.AcknowledgmentOp || a.UnmarshalBinary(b) != nil || a.BlockNum != block {
+		return fmt.Errorf("expected ACK %d, got %s", block, describe(b))
+	}
+	return nil
+}
+
+// expectError returns the ERROR b.
+func expectError(b []byte) (server.Err, error) {
+	var e server.Err
+	if opcode(b) != server.ErrorOp {
+		return e, fmt.Errorf("expected ERROR, got %s", describe(b))
+	}
+	e.UnmarshalBinary(b)
+	return e, nil
+}
+
+// describe summarizes the datagram b for the failure messages.
+func describe(b []byte) string {
+	switch opcode(b) {
+	case server.DataOp:
+		return fmt.Sprintf("DATA %d (%d bytes)", binary.BigEndian.Uint16(b[2:]), len(b)-4)
+	case server.AcknowledgmentOp:
+		return fmt.Sprintf("ACK %d", binary.BigEndian.Uint16(b[2:]))
+	case server.ErrorOp:
+		var e server.Err
+		e.UnmarshalBinary(b)
+		return fmt.Sprintf("ERROR %d %q", e.Code, e.Message)
+	case server.OptionAckOp:
+		var o server.OptionAcknowledgment
+		o.UnmarshalBinary(b)
+		return fmt.Sprintf("OACK %v", o.Options)
+	}
+	return fmt.Sprintf("opcode %d", opcode(b))
+}